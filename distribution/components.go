@@ -53,6 +53,7 @@ import (
 	pitbullprocessor "github.com/cardinalhq/cardinalhq-otel-collector/processor/pitbullprocessor"
 	fingerprintprocessor "github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor"
 	summarysplitprocessor "github.com/cardinalhq/cardinalhq-otel-collector/processor/summarysplitprocessor"
+	shapesamplingprocessor "github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor"
 	extractmetricsprocessor "github.com/cardinalhq/cardinalhq-otel-collector/processor/extractmetricsprocessor"
 	awsfirehosereceiver "github.com/open-telemetry/opentelemetry-collector-contrib/receiver/awsfirehosereceiver"
 	fluentforwardreceiver "github.com/open-telemetry/opentelemetry-collector-contrib/receiver/fluentforwardreceiver"
@@ -192,6 +193,7 @@ func components() (otelcol.Factories, error) {
 		pitbullprocessor.NewFactory(),
 		fingerprintprocessor.NewFactory(),
 		summarysplitprocessor.NewFactory(),
+		shapesamplingprocessor.NewFactory(),
 		extractmetricsprocessor.NewFactory(),
 	)
 	if err != nil {
@@ -217,6 +219,7 @@ func components() (otelcol.Factories, error) {
 	factories.ProcessorModules[pitbullprocessor.NewFactory().Type()] = "github.com/cardinalhq/cardinalhq-otel-collector/processor/pitbullprocessor v0.114.0"
 	factories.ProcessorModules[fingerprintprocessor.NewFactory().Type()] = "github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor v0.114.0"
 	factories.ProcessorModules[summarysplitprocessor.NewFactory().Type()] = "github.com/cardinalhq/cardinalhq-otel-collector/processor/summarysplitprocessor v0.114.0"
+	factories.ProcessorModules[shapesamplingprocessor.NewFactory().Type()] = "github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor v0.114.0"
 	factories.ProcessorModules[extractmetricsprocessor.NewFactory().Type()] = "github.com/cardinalhq/cardinalhq-otel-collector/processor/extractmetricsprocessor v0.114.0"
 
 	factories.Connectors, err = connector.MakeFactoryMap(
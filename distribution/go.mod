@@ -19,6 +19,7 @@ require (
 	github.com/cardinalhq/cardinalhq-otel-collector/processor/extractmetricsprocessor v0.114.0
 	github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor v0.114.0
 	github.com/cardinalhq/cardinalhq-otel-collector/processor/pitbullprocessor v0.114.0
+	github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor v0.114.0
 	github.com/cardinalhq/cardinalhq-otel-collector/processor/summarysplitprocessor v0.114.0
 	github.com/cardinalhq/cardinalhq-otel-collector/receiver/chqdatadogreceiver v0.114.0
 	github.com/cardinalhq/cardinalhq-otel-collector/receiver/githubeventsreceiver v0.114.0
@@ -561,6 +562,8 @@ replace github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintpro
 
 replace github.com/cardinalhq/cardinalhq-otel-collector/processor/summarysplitprocessor v0.114.0 => ../processor/summarysplitprocessor
 
+replace github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor v0.114.0 => ../processor/shapesamplingprocessor
+
 replace github.com/cardinalhq/cardinalhq-otel-collector/processor/extractmetricsprocessor v0.114.0 => ../processor/extractmetricsprocessor
 
 replace github.com/cardinalhq/cardinalhq-otel-collector/internal => ../internal
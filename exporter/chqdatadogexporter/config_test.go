@@ -69,10 +69,17 @@ func TestConfig(t *testing.T) {
 	require.NotNil(t, cfg)
 
 	e := cfg.Exporters[component.MustNewID("chqdatadog")].(*Config)
+	storageID := component.MustNewIDWithName("file_storage", "dd")
+	queueConfig := exporterhelper.NewDefaultQueueConfig()
+	queueConfig.QueueSize = 5000
+	queueConfig.StorageID = &storageID
+	retryConfig := configretry.NewDefaultBackOffConfig()
+	retryConfig.InitialInterval = time.Second
+	retryConfig.MaxElapsedTime = 10 * time.Minute
 	expected := &Config{
 		TimeoutConfig: exporterhelper.NewDefaultTimeoutConfig(),
-		RetryConfig:   configretry.NewDefaultBackOffConfig(),
-		QueueConfig:   exporterhelper.NewDefaultQueueConfig(),
+		RetryConfig:   retryConfig,
+		QueueConfig:   queueConfig,
 		APIKey:        configopaque.String("1234567890abcdef1234567890abcdef"),
 		Metrics: MetricsConfig{
 			ClientConfig: confighttp.ClientConfig{
@@ -0,0 +1,84 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqdatadogexporter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/collector/consumer/consumererror"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+)
+
+// maxRetryAfter caps how long a Retry-After header can ask us to wait,
+// so a misbehaving endpoint cannot stall the sending queue indefinitely.
+const maxRetryAfter = 5 * time.Minute
+
+// responseError converts a non-successful Datadog response into an error
+// the exporterhelper retry sender understands.  Requests that can never
+// succeed are marked permanent so they are dropped instead of retried,
+// and throttled requests honour any Retry-After header the endpoint sent.
+func responseError(ttype string, resp *http.Response) error {
+	err := fmt.Errorf("failed to send %s, status code: %d", ttype, resp.StatusCode)
+	if !isRetryableStatus(resp.StatusCode) {
+		return consumererror.NewPermanent(err)
+	}
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return exporterhelper.NewThrottleRetry(err, delay)
+	}
+	return err
+}
+
+// isRetryableStatus returns true if the status code indicates a
+// transient condition on the Datadog side.  Everything else in the
+// 4xx range (bad payload, bad API key, payload too large) will fail
+// the same way no matter how many times it is sent.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number
+// of seconds or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			return maxRetryAfter, true
+		}
+		delay = time.Duration(seconds) * time.Second
+	} else if when, err := http.ParseTime(value); err == nil {
+		delay = when.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+	return min(delay, maxRetryAfter), true
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqdatadogexporter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/collector/consumer/consumererror"
+)
+
+func TestIsRetryableStatus(t *testing.T) {
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusRequestEntityTooLarge, false},
+		{http.StatusRequestTimeout, true},
+		{http.StatusTooManyRequests, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.code), func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryableStatus(tt.code))
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"empty", "", 0, false},
+		{"seconds", "30", 30 * time.Second, true},
+		{"zero seconds", "0", 0, true},
+		{"negative seconds", "-5", 0, false},
+		{"capped seconds", "86400", maxRetryAfter, true},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{"http date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"garbage", "soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	t.Run("permanent", func(t *testing.T) {
+		err := responseError("logs", &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}})
+		assert.True(t, consumererror.IsPermanent(err))
+		assert.ErrorContains(t, err, "status code: 403")
+	})
+
+	t.Run("retryable", func(t *testing.T) {
+		err := responseError("metrics", &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}})
+		assert.False(t, consumererror.IsPermanent(err))
+		assert.ErrorContains(t, err, "status code: 503")
+	})
+
+	t.Run("throttled", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("Retry-After", "7")
+		err := responseError("logs", &http.Response{StatusCode: http.StatusTooManyRequests, Header: header})
+		assert.False(t, consumererror.IsPermanent(err))
+		assert.ErrorContains(t, err, "Throttle (7s)")
+	})
+}
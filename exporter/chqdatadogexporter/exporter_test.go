@@ -0,0 +1,328 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqdatadogexporter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/exporter/exportertest"
+	"go.opentelemetry.io/collector/extension/experimental/storage"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// logsServer is a fake Datadog logs intake that records every message
+// it accepts, and fails requests on demand.
+type logsServer struct {
+	*httptest.Server
+
+	sync.Mutex
+	seen     map[string]int
+	requests atomic.Int64
+	fail     func(n int64) int
+}
+
+func newLogsServer(t *testing.T, fail func(n int64) int) *logsServer {
+	s := &logsServer{
+		seen: map[string]int{},
+		fail: fail,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := s.requests.Add(1)
+		if code := s.fail(n); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		var ddlogs []DDLog
+		if err := json.NewDecoder(r.Body).Decode(&ddlogs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.Lock()
+		for _, l := range ddlogs {
+			s.seen[l.Message]++
+		}
+		s.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *logsServer) delivered() map[string]int {
+	s.Lock()
+	defer s.Unlock()
+	ret := make(map[string]int, len(s.seen))
+	for k, v := range s.seen {
+		ret[k] = v
+	}
+	return ret
+}
+
+func testLogsConfig(endpoint string) *Config {
+	cfg := createDefaultConfig().(*Config)
+	cfg.APIKey = "test-api-key"
+	cfg.Logs.Endpoint = endpoint
+	cfg.Logs.Compression = ""
+	cfg.RetryConfig.InitialInterval = time.Millisecond
+	cfg.RetryConfig.MaxInterval = 10 * time.Millisecond
+	cfg.RetryConfig.MaxElapsedTime = 10 * time.Second
+	return cfg
+}
+
+func makeLogBatch(batch, count int) plog.Logs {
+	logs := plog.NewLogs()
+	rl := logs.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "test-service")
+	sl := rl.ScopeLogs().AppendEmpty()
+	for i := 0; i < count; i++ {
+		sl.LogRecords().AppendEmpty().Body().SetStr(fmt.Sprintf("batch %d message %d", batch, i))
+	}
+	return logs
+}
+
+func expectedMessages(batches, count int) []string {
+	var ret []string
+	for b := 0; b < batches; b++ {
+		for i := 0; i < count; i++ {
+			ret = append(ret, fmt.Sprintf("batch %d message %d", b, i))
+		}
+	}
+	return ret
+}
+
+func assertAllDelivered(t *testing.T, expected []string, delivered map[string]int) {
+	t.Helper()
+	for _, msg := range expected {
+		assert.GreaterOrEqual(t, delivered[msg], 1, "message %q was not delivered", msg)
+	}
+}
+
+func TestLogsRetryIntermittentFailures(t *testing.T) {
+	// Fail two out of every three requests, alternating between error types.
+	server := newLogsServer(t, func(n int64) int {
+		switch n % 3 {
+		case 1:
+			return http.StatusServiceUnavailable
+		case 2:
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+
+	cfg := testLogsConfig(server.URL)
+	cfg.QueueConfig.Enabled = false
+	require.NoError(t, cfg.Validate())
+
+	ctx := context.Background()
+	exp, err := NewFactory().CreateLogs(ctx, exportertest.NewNopSettings(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+
+	for b := 0; b < 10; b++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, makeLogBatch(b, 5)))
+	}
+	require.NoError(t, exp.Shutdown(ctx))
+
+	assertAllDelivered(t, expectedMessages(10, 5), server.delivered())
+	assert.Equal(t, int64(30), server.requests.Load())
+}
+
+func TestLogsPermanentFailureIsNotRetried(t *testing.T) {
+	server := newLogsServer(t, func(int64) int {
+		return http.StatusForbidden
+	})
+
+	cfg := testLogsConfig(server.URL)
+	cfg.QueueConfig.Enabled = false
+	require.NoError(t, cfg.Validate())
+
+	ctx := context.Background()
+	exp, err := NewFactory().CreateLogs(ctx, exportertest.NewNopSettings(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+
+	err = exp.ConsumeLogs(ctx, makeLogBatch(0, 1))
+	assert.ErrorContains(t, err, "status code: 403")
+	require.NoError(t, exp.Shutdown(ctx))
+
+	assert.Equal(t, int64(1), server.requests.Load())
+}
+
+func TestLogsQueuedIntermittentFailures(t *testing.T) {
+	server := newLogsServer(t, func(n int64) int {
+		if n%2 == 1 {
+			return http.StatusBadGateway
+		}
+		return 0
+	})
+
+	cfg := testLogsConfig(server.URL)
+	cfg.QueueConfig.NumConsumers = 2
+	require.NoError(t, cfg.Validate())
+
+	ctx := context.Background()
+	exp, err := NewFactory().CreateLogs(ctx, exportertest.NewNopSettings(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(ctx, componenttest.NewNopHost()))
+
+	for b := 0; b < 20; b++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, makeLogBatch(b, 3)))
+	}
+
+	expected := expectedMessages(20, 3)
+	assert.Eventually(t, func() bool {
+		return len(server.delivered()) == len(expected)
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, exp.Shutdown(ctx))
+
+	assertAllDelivered(t, expected, server.delivered())
+}
+
+func TestLogsPersistentQueueSurvivesRestart(t *testing.T) {
+	var healthy atomic.Bool
+	server := newLogsServer(t, func(int64) int {
+		if !healthy.Load() {
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	})
+
+	storageID := component.MustNewID("file_storage")
+	host := &storageHost{
+		extensions: map[component.ID]component.Component{
+			storageID: newMemoryStorage(),
+		},
+	}
+
+	cfg := testLogsConfig(server.URL)
+	cfg.QueueConfig.StorageID = &storageID
+	cfg.QueueConfig.NumConsumers = 1
+	require.NoError(t, cfg.Validate())
+
+	ctx := context.Background()
+	settings := exportertest.NewNopSettings()
+
+	// First run: the endpoint is down, so everything stays in the queue
+	// until the exporter is shut down.
+	exp, err := NewFactory().CreateLogs(ctx, settings, cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(ctx, host))
+	for b := 0; b < 5; b++ {
+		require.NoError(t, exp.ConsumeLogs(ctx, makeLogBatch(b, 2)))
+	}
+	assert.Eventually(t, func() bool {
+		return server.requests.Load() > 0
+	}, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, exp.Shutdown(ctx))
+	assert.Empty(t, server.delivered())
+
+	// Second run: the endpoint recovers and the persisted batches are
+	// delivered without being consumed again.
+	healthy.Store(true)
+	exp, err = NewFactory().CreateLogs(ctx, settings, cfg)
+	require.NoError(t, err)
+	require.NoError(t, exp.Start(ctx, host))
+
+	expected := expectedMessages(5, 2)
+	assert.Eventually(t, func() bool {
+		return len(server.delivered()) == len(expected)
+	}, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, exp.Shutdown(ctx))
+
+	assertAllDelivered(t, expected, server.delivered())
+}
+
+type storageHost struct {
+	extensions map[component.ID]component.Component
+}
+
+func (h *storageHost) GetExtensions() map[component.ID]component.Component {
+	return h.extensions
+}
+
+// memoryStorage is a storage extension whose data outlives the clients
+// it hands out, standing in for file_storage across a restart.
+type memoryStorage struct {
+	component.StartFunc
+	component.ShutdownFunc
+
+	sync.Mutex
+	data map[string][]byte
+}
+
+var _ storage.Extension = (*memoryStorage)(nil)
+
+func newMemoryStorage() *memoryStorage {
+	return &memoryStorage{data: map[string][]byte{}}
+}
+
+func (m *memoryStorage) GetClient(_ context.Context, kind component.Kind, id component.ID, name string) (storage.Client, error) {
+	return &memoryStorageClient{
+		storage: m,
+		prefix:  fmt.Sprintf("%s/%s/%s/", kind, id, name),
+	}, nil
+}
+
+type memoryStorageClient struct {
+	storage *memoryStorage
+	prefix  string
+}
+
+func (c *memoryStorageClient) Get(ctx context.Context, key string) ([]byte, error) {
+	op := storage.GetOperation(key)
+	err := c.Batch(ctx, op)
+	return op.Value, err
+}
+
+func (c *memoryStorageClient) Set(ctx context.Context, key string, value []byte) error {
+	return c.Batch(ctx, storage.SetOperation(key, value))
+}
+
+func (c *memoryStorageClient) Delete(ctx context.Context, key string) error {
+	return c.Batch(ctx, storage.DeleteOperation(key))
+}
+
+func (c *memoryStorageClient) Batch(_ context.Context, ops ...storage.Operation) error {
+	c.storage.Lock()
+	defer c.storage.Unlock()
+	for _, op := range ops {
+		key := c.prefix + op.Key
+		switch op.Type {
+		case storage.Get:
+			op.Value = c.storage.data[key]
+		case storage.Set:
+			c.storage.data[key] = append([]byte(nil), op.Value...)
+		case storage.Delete:
+			delete(c.storage.data, key)
+		}
+	}
+	return nil
+}
+
+func (c *memoryStorageClient) Close(context.Context) error {
+	return nil
+}
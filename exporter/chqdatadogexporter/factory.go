@@ -97,6 +97,7 @@ func createLogsExporter(ctx context.Context, params exporter.Settings, config co
 	}
 	e.apiKey = string(e.config.Logs.APIKey)
 	e.endpoint = e.config.Logs.Endpoint
+	e.httpClientSettings = e.config.Logs.ClientConfig
 	return exp, nil
 }
 
@@ -116,6 +117,7 @@ func createMetricsExporter(ctx context.Context, params exporter.Settings, config
 	}
 	e.apiKey = string(e.config.Metrics.APIKey)
 	e.endpoint = e.config.Metrics.Endpoint
+	e.httpClientSettings = e.config.Metrics.ClientConfig
 	return exp, nil
 }
 
@@ -135,5 +137,6 @@ func createTracesExporter(ctx context.Context, params exporter.Settings, config
 	}
 	e.apiKey = string(e.config.Traces.APIKey)
 	e.endpoint = e.config.Traces.Endpoint
+	e.httpClientSettings = e.config.Traces.ClientConfig
 	return exp, nil
 }
@@ -97,7 +97,7 @@ func (e *datadogExporter) ConsumeLogs(ctx context.Context, logs plog.Logs) error
 	}
 	e.messagesReceived.Add(ctx, int64(len(ddlogs)), metric.WithAttributeSet(e.commonAttributes))
 	if len(ddlogs) > 0 {
-		if err := e.send(context.Background(), ddlogs); err != nil {
+		if err := e.send(ctx, ddlogs); err != nil {
 			return err
 		}
 	}
@@ -128,8 +128,8 @@ func (e *datadogExporter) send(ctx context.Context, ddlogs []DDLog) error {
 		_ = resp.Body.Close()
 	}()
 	e.messagesSubmitted.Add(ctx, int64(len(ddlogs)), metric.WithAttributeSet(e.commonAttributes), metric.WithAttributes(attribute.Int("http.code", resp.StatusCode)))
-	if resp.StatusCode != 200 && resp.StatusCode != 202 {
-		return fmt.Errorf("failed to send logs, status code: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return responseError("logs", resp)
 	}
 	return nil
 }
@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -67,7 +66,7 @@ func (e *datadogExporter) ConsumeMetrics(ctx context.Context, md pmetric.Metrics
 
 	e.messagesReceived.Add(ctx, int64(len(msg.Series)), metric.WithAttributeSet(e.commonAttributes))
 	if len(msg.Series) > 0 {
-		if err := e.sendMetrics(context.Background(), msg); err != nil {
+		if err := e.sendMetrics(ctx, msg); err != nil {
 			return err
 		}
 	}
@@ -124,7 +123,8 @@ func (e *datadogExporter) convertSumMetric(_ context.Context, metric pmetric.Met
 		}
 		dp := s.DataPoints().At(i)
 		value := valueAsFloat64(dp)
-		lAttr := dp.Attributes()
+		lAttr := pcommon.NewMap()
+		dp.Attributes().CopyTo(lAttr)
 		interval, hasInterval := getInterval(lAttr)
 		if hasInterval {
 			value = value / float64(interval)
@@ -132,7 +132,7 @@ func (e *datadogExporter) convertSumMetric(_ context.Context, metric pmetric.Met
 			m.Interval = interval
 		}
 		lAttr.Remove("_dd.rateInterval")
-		tags, resources := tagStrings(rAttr, sAttr, dp.Attributes())
+		tags, resources := tagStrings(rAttr, sAttr, lAttr)
 		m.Tags = append(m.Tags, tags...)
 		if len(resources) > 0 {
 			m.Tags = append(m.Tags, resources...) // TODO this should not be needed but datadog does not seem to add resources to the metric
@@ -216,7 +216,7 @@ func (e *datadogExporter) sendMetrics(ctx context.Context, msg *ddpb.MetricPaylo
 	}()
 	e.messagesSubmitted.Add(ctx, int64(len(msg.Series)), metric.WithAttributeSet(e.commonAttributes), metric.WithAttributes(attribute.Int("http.code", resp.StatusCode)))
 	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to send metrics, status code: %d", resp.StatusCode)
+		return responseError("metrics", resp)
 	}
 	return nil
 }
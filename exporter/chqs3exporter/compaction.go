@@ -0,0 +1,356 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqs3exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/exporter/chqs3exporter/internal/tagwriter"
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/trigram"
+)
+
+const (
+	parquetContentType = "application/vnd.apache.parquet"
+
+	compactedMarker     = "compacted"
+	compactionBatchSize = 1000
+)
+
+// compactor merges the small files this collector wrote into a partition
+// into a single larger file once the partition's time window has closed.
+// Only objects that this exporter uploaded itself are merged or deleted,
+// so several collectors can share a bucket and prefix without two of
+// them compacting, or deleting, the same source objects.
+type compactor struct {
+	config        *Config
+	sink          objectSink
+	logger        *zap.Logger
+	telemetryType string
+	metadata      map[string]string
+
+	sync.Mutex
+	pending map[string]*pendingPartition
+}
+
+// pendingPartition is a partition waiting to be compacted, and the keys
+// of the objects this collector has written to it.
+type pendingPartition struct {
+	prefix  string
+	readyAt time.Time
+	keys    map[string]struct{}
+}
+
+func newCompactor(config *Config, sink objectSink, logger *zap.Logger, telemetryType string, metadata map[string]string) *compactor {
+	return &compactor{
+		config:        config,
+		sink:          sink,
+		logger:        logger,
+		telemetryType: telemetryType,
+		metadata:      metadata,
+		pending:       map[string]*pendingPartition{},
+	}
+}
+
+// partitionPrefix returns the key prefix of the partition that a file
+// for the given time and customer is written to.
+func partitionPrefix(config *Config, t time.Time, ids string) string {
+	return config.S3Uploader.S3Prefix + "/" + getTimeKey(t, config.S3Uploader.S3Partition, ids) + "/"
+}
+
+// partitionEnd returns the end of the time partition containing t.
+func partitionEnd(t time.Time, partition string) time.Time {
+	if partition == "hour" {
+		return t.Truncate(time.Hour).Add(time.Hour)
+	}
+	return t.Truncate(time.Minute).Add(time.Minute)
+}
+
+// track records that the object key was written to the partition for
+// the given time and customer, so it will be compacted once it closes.
+func (c *compactor) track(t time.Time, ids string, key string) {
+	prefix := partitionPrefix(c.config, t, ids)
+
+	c.Lock()
+	defer c.Unlock()
+	p, ok := c.pending[prefix]
+	if !ok {
+		p = &pendingPartition{
+			prefix:  prefix,
+			readyAt: partitionEnd(t, c.config.S3Uploader.S3Partition).Add(c.config.Compaction.Delay),
+			keys:    map[string]struct{}{},
+		}
+		c.pending[prefix] = p
+	}
+	p.keys[key] = struct{}{}
+}
+
+// ready removes and returns the partitions that can be compacted at now,
+// ordered by prefix.
+func (c *compactor) ready(now time.Time) []*pendingPartition {
+	c.Lock()
+	defer c.Unlock()
+	var ret []*pendingPartition
+	for prefix, p := range c.pending {
+		if !now.Before(p.readyAt) {
+			ret = append(ret, p)
+			delete(c.pending, prefix)
+		}
+	}
+	slices.SortFunc(ret, func(a, b *pendingPartition) int { return strings.Compare(a.prefix, b.prefix) })
+	return ret
+}
+
+// run compacts partitions as they become ready until stop is closed.
+// A compaction in progress is allowed to finish, so a shutdown never
+// leaves a merged object behind without its sources removed.
+func (c *compactor) run(stop <-chan struct{}, closedChan chan struct{}) {
+	ticker := time.NewTicker(c.config.Compaction.Interval)
+	defer ticker.Stop()
+	defer close(closedChan)
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, p := range c.ready(now) {
+				if err := c.compactPartition(context.Background(), p.prefix, p.keys); err != nil {
+					c.logger.Error("Failed to compact partition", zap.String("prefix", p.prefix), zap.Error(err))
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// compactPartition merges the parquet files of this telemetry type in
+// the partition whose keys are in owned into one file.  Files written by
+// other collectors are left alone.  The merged object is uploaded in a
+// single put, so it is never visible half-written, and is read back and
+// checked before any of the source objects are deleted.
+func (c *compactor) compactPartition(ctx context.Context, prefix string, owned map[string]struct{}) error {
+	namePrefix := prefix + c.config.S3Uploader.FilePrefix + c.telemetryType + "_"
+	objects, err := c.sink.listObjects(ctx, c.config, namePrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	var sources []objectInfo
+	for _, obj := range objects {
+		if _, ok := owned[obj.Key]; ok && strings.HasSuffix(obj.Key, "."+parquetFormat) {
+			sources = append(sources, obj)
+		}
+	}
+
+	logger := c.logger.With(zap.String("prefix", prefix), zap.Int("objects", len(sources)))
+	if len(sources) < c.config.Compaction.MinObjects {
+		logger.Debug("Not enough objects to compact")
+		return nil
+	}
+
+	var tempFiles []*os.File
+	defer func() {
+		for _, f := range tempFiles {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	createTemp := func() (*os.File, error) {
+		f, err := os.CreateTemp(c.config.Buffering.Directory, "compact-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create temp file: %w", err)
+		}
+		tempFiles = append(tempFiles, f)
+		return f, nil
+	}
+
+	files := make([]*parquet.File, 0, len(sources))
+	schemas := make([]*parquet.Schema, 0, len(sources))
+	for _, src := range sources {
+		f, err := createTemp()
+		if err != nil {
+			return err
+		}
+		pf, err := c.downloadParquet(ctx, src.Key, f)
+		if err != nil {
+			return err
+		}
+		files = append(files, pf)
+		schemas = append(schemas, pf.Schema())
+	}
+
+	schema, err := tagwriter.ParquetSchemaUnion("schema", schemas...)
+	if err != nil {
+		return fmt.Errorf("failed to create union schema: %w", err)
+	}
+
+	out, err := createTemp()
+	if err != nil {
+		return err
+	}
+	writer, err := tagwriter.NewParquetMapWriter(out, schema)
+	if err != nil {
+		return fmt.Errorf("failed to create parquet writer: %w", err)
+	}
+	var logIndex *trigram.IndexBuilder
+	if c.telemetryType == logFilePrefix && c.config.LogIndex.Enabled {
+		logIndex = trigram.NewIndexBuilder(c.config.LogIndex.FalsePositiveRate)
+	}
+	rowCount := int64(0)
+	for i, pf := range files {
+		n, err := copyParquetRows(writer, pf, logIndex)
+		if err != nil {
+			_ = writer.Abort()
+			return fmt.Errorf("failed to copy rows from %s: %w", sources[i].Key, err)
+		}
+		rowCount += n
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	key := compactedKey(namePrefix, sources)
+	md := maps.Clone(c.metadata)
+	if md == nil {
+		md = map[string]string{}
+	}
+	md["cardinalhq-compacted-objects"] = strconv.Itoa(len(sources))
+	md["cardinalhq-row-count"] = strconv.FormatInt(rowCount, 10)
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to start of file: %w", err)
+	}
+	if err := c.sink.putObject(ctx, c.config, key, out, parquetContentType, md); err != nil {
+		return fmt.Errorf("failed to upload compacted object: %w", err)
+	}
+
+	verify, err := createTemp()
+	if err != nil {
+		return err
+	}
+	if err := c.verify(ctx, key, verify, rowCount); err != nil {
+		if derr := c.sink.deleteObjects(ctx, c.config, []string{key}); derr != nil {
+			logger.Error("Failed to remove unverified compacted object", zap.String("key", key), zap.Error(derr))
+		}
+		return err
+	}
+
+	if logIndex != nil {
+		if err := uploadLogIndex(ctx, c.sink, c.config, key, logIndex, c.metadata); err != nil {
+			logger.Error("Failed to upload log index", zap.String("key", key), zap.Error(err))
+		}
+	}
+
+	keys := make([]string, 0, len(sources)*2)
+	for _, src := range sources {
+		keys = append(keys, src.Key, logIndexKey(src.Key))
+	}
+	if err := c.sink.deleteObjects(ctx, c.config, keys); err != nil {
+		return fmt.Errorf("failed to delete compacted source objects: %w", err)
+	}
+
+	logger.Info("Compacted partition", zap.String("key", key), zap.Int64("rows", rowCount))
+	return nil
+}
+
+func (c *compactor) downloadParquet(ctx context.Context, key string, f *os.File) (*parquet.File, error) {
+	size, err := c.sink.downloadObject(ctx, c.config, key, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	pf, err := parquet.OpenFile(f, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	return pf, nil
+}
+
+// verify reads back a compacted object and checks it holds every row.
+func (c *compactor) verify(ctx context.Context, key string, f *os.File, rowCount int64) error {
+	pf, err := c.downloadParquet(ctx, key, f)
+	if err != nil {
+		return fmt.Errorf("failed to verify compacted object: %w", err)
+	}
+	if pf.NumRows() != rowCount {
+		return fmt.Errorf("compacted object %s has %d rows, expected %d", key, pf.NumRows(), rowCount)
+	}
+	return nil
+}
+
+// copyParquetRows copies every row of pf to w, adding each row to
+// logIndex if it is not nil.
+func copyParquetRows(w tagwriter.MapWriter, pf *parquet.File, logIndex *trigram.IndexBuilder) (int64, error) {
+	reader := parquet.NewGenericReader[map[string]any](pf, pf.Schema())
+	defer reader.Close()
+
+	rows := make([]map[string]any, compactionBatchSize)
+	total := int64(0)
+	for {
+		for i := range rows {
+			rows[i] = map[string]any{}
+		}
+		n, err := reader.Read(rows)
+		if n > 0 {
+			if _, werr := w.WriteRows(rows[:n]); werr != nil {
+				return total, werr
+			}
+			if logIndex != nil {
+				for _, row := range rows[:n] {
+					indexLogRow(logIndex, row)
+				}
+			}
+			total += int64(n)
+		}
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// compactedKey names the merged object after the earliest of its sources,
+// keeping the same name prefix as the files it replaces.
+func compactedKey(namePrefix string, sources []objectInfo) string {
+	var earliest int64
+	for _, src := range sources {
+		name := strings.TrimPrefix(src.Key, namePrefix)
+		ts, _, _ := strings.Cut(name, "_")
+		millis, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			continue
+		}
+		if earliest == 0 || millis < earliest {
+			earliest = millis
+		}
+	}
+	randomID := randomInRange(100000000, 999999999)
+	return namePrefix + strconv.FormatInt(earliest, 10) + "_" + compactedMarker + "_" + strconv.Itoa(randomID) + "." + parquetFormat
+}
@@ -0,0 +1,370 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqs3exporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/exporter/chqs3exporter/internal/tagwriter"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+}
+
+// memorySink is an in-memory objectSink.
+type memorySink struct {
+	sync.Mutex
+	objects map[string]memoryObject
+	// mangle, if set, changes the data stored by putObject.
+	mangle func(data []byte) []byte
+}
+
+var _ objectSink = (*memorySink)(nil)
+
+func newMemorySink() *memorySink {
+	return &memorySink{objects: map[string]memoryObject{}}
+}
+
+func (m *memorySink) writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, metadata string, format string, kv map[string]string, customerID string) (string, error) {
+	key := getS3Key(now,
+		config.S3Uploader.S3Prefix, config.S3Uploader.S3Partition,
+		config.S3Uploader.FilePrefix, metadata, format, customerID)
+	return key, m.putObject(ctx, config, key, buf, formatContentTypes[format], kv)
+}
+
+func (m *memorySink) putObject(_ context.Context, _ *Config, key string, buf io.Reader, contentType string, kv map[string]string) error {
+	data, err := io.ReadAll(buf)
+	if err != nil {
+		return err
+	}
+	if m.mangle != nil {
+		data = m.mangle(data)
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.objects[key] = memoryObject{data: data, contentType: contentType, metadata: kv}
+	return nil
+}
+
+func (m *memorySink) listObjects(_ context.Context, _ *Config, prefix string) ([]objectInfo, error) {
+	m.Lock()
+	defer m.Unlock()
+	var ret []objectInfo
+	for key, obj := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			ret = append(ret, objectInfo{Key: key, Size: int64(len(obj.data))})
+		}
+	}
+	slices.SortFunc(ret, func(a, b objectInfo) int { return strings.Compare(a.Key, b.Key) })
+	return ret, nil
+}
+
+func (m *memorySink) downloadObject(_ context.Context, _ *Config, key string, w io.WriterAt) (int64, error) {
+	m.Lock()
+	obj, ok := m.objects[key]
+	m.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("no such key: %s", key)
+	}
+	n, err := w.WriteAt(obj.data, 0)
+	return int64(n), err
+}
+
+func (m *memorySink) deleteObjects(_ context.Context, _ *Config, keys []string) error {
+	m.Lock()
+	defer m.Unlock()
+	for _, key := range keys {
+		delete(m.objects, key)
+	}
+	return nil
+}
+
+func (m *memorySink) keys() []string {
+	m.Lock()
+	defer m.Unlock()
+	ret := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		ret = append(ret, key)
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+func writeParquetObject(t *testing.T, sink *memorySink, key string, rows []map[string]any) {
+	t.Helper()
+	schema, err := tagwriter.ParquetSchemaFromMap("schema", rows[0])
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	writer, err := tagwriter.NewParquetMapWriter(&buf, schema)
+	require.NoError(t, err)
+	_, err = writer.WriteRows(rows)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	require.NoError(t, sink.putObject(context.Background(), nil, key, &buf, parquetContentType, nil))
+}
+
+func readParquetObject(t *testing.T, sink *memorySink, key string) []map[string]any {
+	t.Helper()
+	sink.Lock()
+	data := sink.objects[key].data
+	sink.Unlock()
+	pf, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	reader := parquet.NewGenericReader[map[string]any](pf, pf.Schema())
+	rows := make([]map[string]any, pf.NumRows())
+	for i := range rows {
+		rows[i] = map[string]any{}
+	}
+	n, err := reader.Read(rows)
+	if err != io.EOF {
+		require.NoError(t, err)
+	}
+	require.Equal(t, len(rows), n)
+	return rows
+}
+
+func ownedKeys(keys ...string) map[string]struct{} {
+	ret := map[string]struct{}{}
+	for _, key := range keys {
+		ret[key] = struct{}{}
+	}
+	return ret
+}
+
+func testCompactionConfig(t *testing.T) *Config {
+	cfg := createDefaultConfig().(*Config)
+	cfg.S3Uploader.S3Prefix = "prefix"
+	cfg.Buffering.Directory = t.TempDir()
+	cfg.Compaction.Enabled = true
+	return cfg
+}
+
+func TestCompactPartition(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+
+	writeParquetObject(t, sink, partition+"logs_1717245000000_111111111.parquet", []map[string]any{
+		{"_cardinalhq.message": "one", "_cardinalhq.timestamp": int64(1)},
+		{"_cardinalhq.message": "two", "_cardinalhq.timestamp": int64(2)},
+	})
+	writeParquetObject(t, sink, partition+"logs_1717245010000_222222222.parquet", []map[string]any{
+		{"_cardinalhq.message": "three", "_cardinalhq.timestamp": int64(3), "resource.pod": "pod-a"},
+	})
+	writeParquetObject(t, sink, partition+"logs_1717245020000_333333333.parquet", []map[string]any{
+		{"_cardinalhq.message": "four", "_cardinalhq.timestamp": int64(4), "log.count": float64(1)},
+	})
+	// Other telemetry types, non-parquet files, and other partitions are left alone.
+	writeParquetObject(t, sink, partition+"metrics_1717245000000_444444444.parquet", []map[string]any{
+		{"_cardinalhq.name": "m", "_cardinalhq.value": float64(1)},
+	})
+	require.NoError(t, sink.putObject(context.Background(), cfg, partition+"logs_1717245000000_555555555.json", strings.NewReader("{}"), "", nil))
+	otherPartition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=31/"
+	writeParquetObject(t, sink, otherPartition+"logs_1717245060000_666666666.parquet", []map[string]any{
+		{"_cardinalhq.message": "five", "_cardinalhq.timestamp": int64(5)},
+	})
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, map[string]string{"cardinalhq-exporter": "chqs3"})
+	require.NoError(t, c.compactPartition(context.Background(), partition, ownedKeys(sink.keys()...)))
+
+	keys := sink.keys()
+	require.Len(t, keys, 4)
+	var compacted string
+	for _, key := range keys {
+		if strings.Contains(key, "_"+compactedMarker+"_") {
+			compacted = key
+		}
+	}
+	require.NotEmpty(t, compacted)
+	assert.True(t, strings.HasPrefix(compacted, partition+"logs_1717245000000_compacted_"))
+	assert.Contains(t, keys, partition+"metrics_1717245000000_444444444.parquet")
+	assert.Contains(t, keys, partition+"logs_1717245000000_555555555.json")
+	assert.Contains(t, keys, otherPartition+"logs_1717245060000_666666666.parquet")
+
+	obj := sink.objects[compacted]
+	assert.Equal(t, parquetContentType, obj.contentType)
+	assert.Equal(t, map[string]string{
+		"cardinalhq-exporter":          "chqs3",
+		"cardinalhq-compacted-objects": "3",
+		"cardinalhq-row-count":         "4",
+	}, obj.metadata)
+
+	rows := readParquetObject(t, sink, compacted)
+	require.Len(t, rows, 4)
+	messages := map[string]map[string]any{}
+	for _, row := range rows {
+		messages[row["_cardinalhq.message"].(string)] = row
+	}
+	assert.Equal(t, int64(1), messages["one"]["_cardinalhq.timestamp"])
+	assert.Nil(t, messages["one"]["resource.pod"])
+	assert.Equal(t, "pod-a", messages["three"]["resource.pod"])
+	assert.Equal(t, float64(1), messages["four"]["log.count"])
+	assert.Nil(t, messages["three"]["log.count"])
+}
+
+func TestCompactPartitionTooFewObjects(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	cfg.Compaction.MinObjects = 3
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+	writeParquetObject(t, sink, partition+"logs_1717245000000_111111111.parquet", []map[string]any{{"a": "1"}})
+	writeParquetObject(t, sink, partition+"logs_1717245000000_222222222.parquet", []map[string]any{{"a": "2"}})
+	before := sink.keys()
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, nil)
+	require.NoError(t, c.compactPartition(context.Background(), partition, ownedKeys(before...)))
+	assert.Equal(t, before, sink.keys())
+}
+
+func TestCompactPartitionMismatchedSchemas(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+	writeParquetObject(t, sink, partition+"logs_1717245000000_111111111.parquet", []map[string]any{{"a": int64(1)}})
+	writeParquetObject(t, sink, partition+"logs_1717245000000_222222222.parquet", []map[string]any{{"a": "1"}})
+	before := sink.keys()
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, nil)
+	assert.Error(t, c.compactPartition(context.Background(), partition, ownedKeys(before...)))
+	assert.Equal(t, before, sink.keys())
+}
+
+func TestCompactPartitionVerifyFailureKeepsSources(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+	writeParquetObject(t, sink, partition+"logs_1717245000000_111111111.parquet", []map[string]any{{"a": "1"}})
+	writeParquetObject(t, sink, partition+"logs_1717245000000_222222222.parquet", []map[string]any{{"a": "2"}})
+	before := sink.keys()
+
+	// Simulate a corrupted upload of the merged object.
+	sink.mangle = func(data []byte) []byte {
+		return data[:len(data)/2]
+	}
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, nil)
+	assert.Error(t, c.compactPartition(context.Background(), partition, ownedKeys(before...)))
+	assert.Equal(t, before, sink.keys())
+}
+
+func TestCompactorTrackAndReady(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	cfg.Compaction.Delay = 2 * time.Minute
+	c := newCompactor(cfg, newMemorySink(), zap.NewNop(), logFilePrefix, nil)
+
+	written := time.Date(2024, 6, 1, 12, 30, 15, 0, time.UTC)
+	c.track(written, "cust/clus", "a")
+	c.track(written.Add(10*time.Second), "cust/clus", "b")
+	c.track(written.Add(time.Minute), "cust/clus", "c")
+
+	assert.Empty(t, c.ready(time.Date(2024, 6, 1, 12, 32, 59, 0, time.UTC)))
+	ready := c.ready(time.Date(2024, 6, 1, 12, 33, 0, 0, time.UTC))
+	require.Len(t, ready, 1)
+	assert.Equal(t, "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/", ready[0].prefix)
+	assert.Equal(t, ownedKeys("a", "b"), ready[0].keys)
+	assert.Empty(t, c.ready(time.Date(2024, 6, 1, 12, 33, 30, 0, time.UTC)))
+	ready = c.ready(time.Date(2024, 6, 1, 12, 34, 0, 0, time.UTC))
+	require.Len(t, ready, 1)
+	assert.Equal(t, "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=31/", ready[0].prefix)
+	assert.Equal(t, ownedKeys("c"), ready[0].keys)
+}
+
+func TestCompactPartitionSkipsOtherCollectors(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+	mine := []string{
+		partition + "logs_1717245000000_111111111.parquet",
+		partition + "logs_1717245010000_222222222.parquet",
+	}
+	theirs := partition + "logs_1717245005000_333333333.parquet"
+	writeParquetObject(t, sink, mine[0], []map[string]any{{"a": "1"}})
+	writeParquetObject(t, sink, mine[1], []map[string]any{{"a": "2"}})
+	writeParquetObject(t, sink, theirs, []map[string]any{{"a": "3"}})
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, nil)
+	require.NoError(t, c.compactPartition(context.Background(), partition, ownedKeys(mine...)))
+
+	keys := sink.keys()
+	require.Len(t, keys, 2)
+	assert.Contains(t, keys, theirs)
+	assert.Equal(t, []map[string]any{{"a": "3"}}, readParquetObject(t, sink, theirs))
+}
+
+func TestCompactPartitionSharedBucket(t *testing.T) {
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+
+	compactors := make([]*compactor, 2)
+	owned := make([]map[string]struct{}, 2)
+	for i := range compactors {
+		compactors[i] = newCompactor(testCompactionConfig(t), sink, zap.NewNop(), logFilePrefix, nil)
+		owned[i] = map[string]struct{}{}
+		for j := 0; j < 3; j++ {
+			key := fmt.Sprintf("%slogs_17172450%d0000_%d%08d.parquet", partition, j, i+1, j)
+			writeParquetObject(t, sink, key, []map[string]any{
+				{"_cardinalhq.message": fmt.Sprintf("collector %d row %d", i, j)},
+				{"_cardinalhq.message": fmt.Sprintf("collector %d row %d again", i, j)},
+			})
+			owned[i][key] = struct{}{}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range compactors {
+		wg.Add(1)
+		go func(c *compactor, owned map[string]struct{}) {
+			defer wg.Done()
+			assert.NoError(t, c.compactPartition(context.Background(), partition, owned))
+		}(c, owned[i])
+	}
+	wg.Wait()
+
+	keys := sink.keys()
+	require.Len(t, keys, 2)
+	messages := map[string]int{}
+	for _, key := range keys {
+		assert.Contains(t, key, "_"+compactedMarker+"_")
+		for _, row := range readParquetObject(t, sink, key) {
+			messages[row["_cardinalhq.message"].(string)]++
+		}
+	}
+	assert.Len(t, messages, 12)
+	for msg, n := range messages {
+		assert.Equal(t, 1, n, msg)
+	}
+}
+
+func TestPartitionEnd(t *testing.T) {
+	tm := time.Date(2024, 6, 1, 12, 30, 15, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 6, 1, 12, 31, 0, 0, time.UTC), partitionEnd(tm, "minute"))
+	assert.Equal(t, time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC), partitionEnd(tm, "hour"))
+}
@@ -5,9 +5,12 @@ package chqs3exporter
 
 import (
 	"errors"
+	"fmt"
 	"os"
+	"slices"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3"
 	"go.uber.org/multierr"
 )
 
@@ -24,6 +27,15 @@ type S3UploaderConfig struct {
 	RoleArn          string `mapstructure:"role_arn"`
 	S3ForcePathStyle bool   `mapstructure:"s3_force_path_style"`
 	DisableSSL       bool   `mapstructure:"disable_ssl"`
+
+	// ServerSideEncryption is "AES256" for SSE-S3 or "aws:kms" for SSE-KMS.
+	ServerSideEncryption string `mapstructure:"server_side_encryption"`
+	// SSEKMSKeyID is the KMS key used with "aws:kms".  If empty, the
+	// bucket's default key is used.
+	SSEKMSKeyID  string            `mapstructure:"sse_kms_key_id"`
+	StorageClass string            `mapstructure:"storage_class"`
+	ACL          string            `mapstructure:"acl"`
+	ObjectTags   map[string]string `mapstructure:"object_tags"`
 }
 
 type TimeboxConfig struct {
@@ -49,13 +61,39 @@ const (
 	bufferTypeMemory = "memory"
 )
 
+// LogIndexConfig controls writing a searchable index file alongside
+// each uploaded log file.
+type LogIndexConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// FalsePositiveRate is the target false positive rate of the
+	// index's bloom filters.
+	FalsePositiveRate float64 `mapstructure:"false_positive_rate"`
+}
+
+// CompactionConfig controls merging the many small files written to a
+// partition into a single larger file once the partition has closed.
+type CompactionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Interval is how often to look for partitions ready to compact.
+	Interval time.Duration `mapstructure:"interval"`
+	// Delay is how long to wait after a partition's time window ends
+	// before compacting it, so late writers have finished.
+	Delay time.Duration `mapstructure:"delay"`
+	// MinObjects is the smallest number of files in a partition worth
+	// compacting.
+	MinObjects int `mapstructure:"min_objects"`
+}
+
 // Config contains the main configuration options for the s3 exporter
 type Config struct {
 	S3Uploader       S3UploaderConfig `mapstructure:"s3uploader"`
+	Format           string           `mapstructure:"format"`
 	Timeboxes        TimeboxesConfig  `mapstructure:"timeboxes"`
 	UseNowForMetrics bool             `mapstructure:"use_now_for_metrics"`
 	Buffering        BufferingConfig  `mapstructure:"buffering"`
 	IDSource         string           `mapstructure:"id_source"`
+	Compaction       CompactionConfig `mapstructure:"compaction"`
+	LogIndex         LogIndexConfig   `mapstructure:"log_index"`
 }
 
 func (c *Config) Validate() error {
@@ -71,6 +109,31 @@ func (c *Config) Validate() error {
 		errs = multierr.Append(errs, errors.New("id_source must be either 'auth' or 'env'"))
 	}
 
+	switch c.S3Uploader.ServerSideEncryption {
+	case "", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms:
+	default:
+		errs = multierr.Append(errs, errors.New("server_side_encryption must be either '"+s3.ServerSideEncryptionAes256+"' or '"+s3.ServerSideEncryptionAwsKms+"'"))
+	}
+	if c.S3Uploader.SSEKMSKeyID != "" && c.S3Uploader.ServerSideEncryption != s3.ServerSideEncryptionAwsKms {
+		errs = multierr.Append(errs, errors.New("sse_kms_key_id requires server_side_encryption to be '"+s3.ServerSideEncryptionAwsKms+"'"))
+	}
+	if c.S3Uploader.StorageClass != "" && !slices.Contains(s3.StorageClass_Values(), c.S3Uploader.StorageClass) {
+		errs = multierr.Append(errs, fmt.Errorf("unknown storage_class %q", c.S3Uploader.StorageClass))
+	}
+	if c.S3Uploader.ACL != "" && !slices.Contains(s3.ObjectCannedACL_Values(), c.S3Uploader.ACL) {
+		errs = multierr.Append(errs, fmt.Errorf("unknown acl %q", c.S3Uploader.ACL))
+	}
+
+	switch c.Format {
+	case "", parquetFormat, ndjsonFormat, arrowFormat:
+	default:
+		errs = multierr.Append(errs, errors.New("format must be one of '"+parquetFormat+"', '"+ndjsonFormat+"', or '"+arrowFormat+"'"))
+	}
+
+	if c.Compaction.Enabled && outputFormat(c) != parquetFormat {
+		errs = multierr.Append(errs, errors.New("compaction requires the '"+parquetFormat+"' format"))
+	}
+
 	errs = multierr.Append(errs, c.Timeboxes.Validate())
 	return errs
 }
@@ -130,6 +193,38 @@ func (c BufferingConfig) Validate() error {
 	return errs
 }
 
+func (c CompactionConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	var errs error
+
+	if c.Interval <= 0 {
+		errs = multierr.Append(errs, errors.New("compaction interval must be greater than 0"))
+	}
+
+	if c.Delay < 0 {
+		errs = multierr.Append(errs, errors.New("compaction delay must be greater than or equal to 0"))
+	}
+
+	if c.MinObjects < 2 {
+		errs = multierr.Append(errs, errors.New("compaction min_objects must be greater than or equal to 2"))
+	}
+
+	return errs
+}
+
+func (c LogIndexConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.FalsePositiveRate <= 0 || c.FalsePositiveRate >= 1 {
+		return errors.New("log index false_positive_rate must be between 0 and 1")
+	}
+	return nil
+}
+
 func testWritable(dir string) error {
 	file, err := os.CreateTemp(dir, "test")
 	if err != nil {
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -32,6 +33,7 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, e,
 		&Config{
 			IDSource: "env",
+			Format:   "parquet",
 			S3Uploader: S3UploaderConfig{
 				Region:      "us-east-1",
 				S3Bucket:    "foo",
@@ -54,6 +56,14 @@ func TestLoadConfig(t *testing.T) {
 			Buffering: BufferingConfig{
 				Type: "memory",
 			},
+			Compaction: CompactionConfig{
+				Interval:   time.Minute,
+				Delay:      5 * time.Minute,
+				MinObjects: 2,
+			},
+			LogIndex: LogIndexConfig{
+				FalsePositiveRate: 0.01,
+			},
 		},
 	)
 }
@@ -72,6 +82,7 @@ func TestConfig(t *testing.T) {
 	e := cfg.Exporters[component.MustNewID("chqs3")].(*Config)
 	expected := &Config{
 		IDSource: "env",
+		Format:   "parquet",
 		S3Uploader: S3UploaderConfig{
 			Region:      "us-east-1",
 			S3Bucket:    "foo",
@@ -82,6 +93,14 @@ func TestConfig(t *testing.T) {
 		Buffering: BufferingConfig{
 			Type: "memory",
 		},
+		Compaction: CompactionConfig{
+			Interval:   time.Minute,
+			Delay:      5 * time.Minute,
+			MinObjects: 2,
+		},
+		LogIndex: LogIndexConfig{
+			FalsePositiveRate: 0.01,
+		},
 	}
 	assert.Equal(t, expected, e)
 }
@@ -100,6 +119,7 @@ func TestConfigForS3CompatibleSystems(t *testing.T) {
 	e := cfg.Exporters[component.MustNewID("chqs3")].(*Config)
 	expected := &Config{
 		IDSource: "env",
+		Format:   "parquet",
 		S3Uploader: S3UploaderConfig{
 			Region:           "us-east-1",
 			S3Bucket:         "foo",
@@ -112,6 +132,14 @@ func TestConfigForS3CompatibleSystems(t *testing.T) {
 		Buffering: BufferingConfig{
 			Type: "memory",
 		},
+		Compaction: CompactionConfig{
+			Interval:   time.Minute,
+			Delay:      5 * time.Minute,
+			MinObjects: 2,
+		},
+		LogIndex: LogIndexConfig{
+			FalsePositiveRate: 0.01,
+		},
 	}
 	assert.Equal(t, expected, e)
 }
@@ -166,6 +194,58 @@ func TestConfig_Validate(t *testing.T) {
 			}(),
 			errExpected: errors.New("region is required"),
 		},
+		{
+			name: "sse-kms with key",
+			config: func() *Config {
+				c := createDefaultConfig().(*Config)
+				c.S3Uploader.S3Bucket = "foo"
+				c.S3Uploader.ServerSideEncryption = "aws:kms"
+				c.S3Uploader.SSEKMSKeyID = "key"
+				c.S3Uploader.StorageClass = "INTELLIGENT_TIERING"
+				c.S3Uploader.ACL = "bucket-owner-full-control"
+				return c
+			}(),
+			errExpected: nil,
+		},
+		{
+			name: "bad upload options",
+			config: func() *Config {
+				c := createDefaultConfig().(*Config)
+				c.S3Uploader.S3Bucket = "foo"
+				c.S3Uploader.ServerSideEncryption = "rot13"
+				c.S3Uploader.SSEKMSKeyID = "key"
+				c.S3Uploader.StorageClass = "CHEAP"
+				c.S3Uploader.ACL = "everyone"
+				return c
+			}(),
+			errExpected: multierr.Combine(
+				errors.New("server_side_encryption must be either 'AES256' or 'aws:kms'"),
+				errors.New("sse_kms_key_id requires server_side_encryption to be 'aws:kms'"),
+				errors.New(`unknown storage_class "CHEAP"`),
+				errors.New(`unknown acl "everyone"`),
+			),
+		},
+		{
+			name: "unknown format",
+			config: func() *Config {
+				c := createDefaultConfig().(*Config)
+				c.S3Uploader.S3Bucket = "foo"
+				c.Format = "csv"
+				return c
+			}(),
+			errExpected: errors.New("format must be one of 'parquet', 'ndjson', or 'arrow'"),
+		},
+		{
+			name: "compaction with ndjson",
+			config: func() *Config {
+				c := createDefaultConfig().(*Config)
+				c.S3Uploader.S3Bucket = "foo"
+				c.Format = ndjsonFormat
+				c.Compaction.Enabled = true
+				return c
+			}(),
+			errExpected: errors.New("compaction requires the 'parquet' format"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -253,3 +333,68 @@ func TestTimeboxConfig_Validate(t *testing.T) {
 		})
 	}
 }
+
+func TestCompactionConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *CompactionConfig
+		errExpected error
+	}{
+		{
+			name:        "disabled, zero values",
+			config:      &CompactionConfig{},
+			errExpected: nil,
+		},
+		{
+			name: "valid",
+			config: &CompactionConfig{
+				Enabled:    true,
+				Interval:   time.Minute,
+				Delay:      5 * time.Minute,
+				MinObjects: 2,
+			},
+			errExpected: nil,
+		},
+		{
+			name: "enabled, zero interval",
+			config: &CompactionConfig{
+				Enabled:    true,
+				MinObjects: 2,
+			},
+			errExpected: errors.New("compaction interval must be greater than 0"),
+		},
+		{
+			name: "enabled, negative delay",
+			config: &CompactionConfig{
+				Enabled:    true,
+				Interval:   time.Minute,
+				Delay:      -1,
+				MinObjects: 2,
+			},
+			errExpected: errors.New("compaction delay must be greater than or equal to 0"),
+		},
+		{
+			name: "enabled, too few objects",
+			config: &CompactionConfig{
+				Enabled:    true,
+				Interval:   time.Minute,
+				MinObjects: 1,
+			},
+			errExpected: errors.New("compaction min_objects must be greater than or equal to 2"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			require.Equal(t, tt.errExpected, err)
+		})
+	}
+}
+
+func TestLogIndexConfig_Validate(t *testing.T) {
+	assert.NoError(t, LogIndexConfig{}.Validate())
+	assert.NoError(t, LogIndexConfig{Enabled: true, FalsePositiveRate: 0.01}.Validate())
+	assert.Error(t, LogIndexConfig{Enabled: true}.Validate())
+	assert.Error(t, LogIndexConfig{Enabled: true, FalsePositiveRate: 1}.Validate())
+}
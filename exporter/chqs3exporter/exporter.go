@@ -33,6 +33,7 @@ import (
 	"github.com/cardinalhq/cardinalhq-otel-collector/exporter/chqs3exporter/internal/tagwriter"
 	"github.com/cardinalhq/cardinalhq-otel-collector/exporter/chqs3exporter/internal/translation/table"
 	"github.com/cardinalhq/cardinalhq-otel-collector/internal/boxer"
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/trigram"
 )
 
 type s3Exporter struct {
@@ -45,6 +46,10 @@ type s3Exporter struct {
 	metadata        map[string]string
 	writerCloseFunc context.CancelFunc
 	writerClosed    chan struct{}
+	writer          *s3Writer
+	compactor       *compactor
+	compactorStop   chan struct{}
+	compactorClosed chan struct{}
 	taglock         sync.Mutex
 	tags            map[string]map[int64]map[string]any
 	idsFromEnv      bool
@@ -56,6 +61,8 @@ const (
 	tracesFilePrefix = "traces"
 
 	parquetFormat = "parquet"
+	ndjsonFormat  = "ndjson"
+	arrowFormat   = "arrow"
 )
 
 func newS3Exporter(config *Config, params exporter.Settings, ttype string) (*s3Exporter, error) {
@@ -74,6 +81,7 @@ func newS3Exporter(config *Config, params exporter.Settings, ttype string) (*s3E
 		metadata:      metadata,
 		telemetryType: ttype,
 		tags:          map[string]map[int64]map[string]any{},
+		writer:        &s3Writer{},
 	}
 	return s3LogsExporter, nil
 }
@@ -114,6 +122,13 @@ func (e *s3Exporter) Start(_ context.Context, _ component.Host) error {
 	dbtaskContext, doneFunc := context.WithCancel(context.Background())
 	e.writerCloseFunc = doneFunc
 	go e.databaseTask(dbtaskContext, e.writerClosed)
+
+	if e.config.Compaction.Enabled {
+		e.compactor = newCompactor(e.config, e.writer, e.logger, e.telemetryType, e.metadata)
+		e.compactorStop = make(chan struct{})
+		e.compactorClosed = make(chan struct{})
+		go e.compactor.run(e.compactorStop, e.compactorClosed)
+	}
 	return nil
 }
 
@@ -127,6 +142,11 @@ func (e *s3Exporter) Shutdown(_ context.Context) error {
 	<-e.writerClosed
 	e.logger.Info("database task stopped.")
 
+	if e.compactor != nil {
+		close(e.compactorStop)
+		<-e.compactorClosed
+	}
+
 	allIntervals, err := e.boxer.GetAllIntervals()
 	if err != nil {
 		errs = multierr.Append(errs, err)
@@ -205,7 +225,9 @@ func (e *s3Exporter) consumeTags(ids string, interval int64) map[string]any {
 	return intervalTags
 }
 
-func (e *s3Exporter) newParquetWriter(ids string, interval int64) (tagwriter.MapWriter, *os.File, error) {
+// newTableWriter returns a writer for the rows of the given interval,
+// in the configured output format, writing to a new temporary file.
+func (e *s3Exporter) newTableWriter(ids string, interval int64) (tagwriter.MapWriter, *os.File, error) {
 	tags := e.consumeTags(ids, interval)
 	if len(tags) == 0 {
 		keys := map[string][]int64{}
@@ -217,25 +239,49 @@ func (e *s3Exporter) newParquetWriter(ids string, interval int64) (tagwriter.Map
 		return nil, nil, errors.New("no tags found")
 	}
 
-	schema, err := tagwriter.ParquetSchemaFromMap("schema", tags)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create parquet schema: %w", err)
-	}
-
-	f, err := os.CreateTemp(e.config.Buffering.Directory, "parquet-*")
+	format := outputFormat(e.config)
+	f, err := os.CreateTemp(e.config.Buffering.Directory, format+"-*")
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
 	}
 
-	writer, err := tagwriter.NewParquetMapWriter(f, schema)
+	writer, err := newMapWriter(f, format, tags)
 	if err != nil {
 		_ = f.Close()
-		return nil, nil, fmt.Errorf("failed to create parquet writer: %w", err)
+		_ = os.Remove(f.Name())
+		return nil, nil, err
 	}
 
 	return writer, f, nil
 }
 
+// newMapWriter returns a writer for the given format, using tags as an
+// exemplar row to build the schema.
+func newMapWriter(w io.Writer, format string, tags map[string]any) (tagwriter.MapWriter, error) {
+	switch format {
+	case parquetFormat:
+		schema, err := tagwriter.ParquetSchemaFromMap("schema", tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parquet schema: %w", err)
+		}
+		writer, err := tagwriter.NewParquetMapWriter(w, schema)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parquet writer: %w", err)
+		}
+		return writer, nil
+	case ndjsonFormat:
+		return tagwriter.NewJSONMapWriter(w), nil
+	case arrowFormat:
+		schema, err := tagwriter.ArrowSchemaFromMap(tags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create arrow schema: %w", err)
+		}
+		return tagwriter.NewArrowMapWriter(w, schema), nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
 func ensureCustomerID(tableRows []map[string]any, customerID string, logger *zap.Logger) bool {
 	for _, row := range tableRows {
 		cid := customerIDFromMap(row)
@@ -247,8 +293,8 @@ func ensureCustomerID(tableRows []map[string]any, customerID string, logger *zap
 	return true
 }
 
-func (e *s3Exporter) saveAndUploadParquet(ids string, interval int64) error {
-	writer, f, err := e.newParquetWriter(ids, interval)
+func (e *s3Exporter) saveAndUpload(ids string, interval int64) error {
+	writer, f, err := e.newTableWriter(ids, interval)
 	if err != nil {
 		return err
 	}
@@ -271,6 +317,11 @@ func (e *s3Exporter) saveAndUploadParquet(ids string, interval int64) error {
 		}
 	}()
 
+	var logIndex *trigram.IndexBuilder
+	if e.telemetryType == logFilePrefix && e.config.LogIndex.Enabled {
+		logIndex = trigram.NewIndexBuilder(e.config.LogIndex.FalsePositiveRate)
+	}
+
 	blockCount := int64(0)
 	itemCount := int64(0)
 	err = e.boxer.ForEach(interval, ids, func(index, expected int, value []byte) (bool, error) {
@@ -296,6 +347,11 @@ func (e *s3Exporter) saveAndUploadParquet(ids string, interval int64) error {
 			return false, err
 		}
 		itemCount += int64(len(tableRows))
+		if logIndex != nil {
+			for _, row := range tableRows {
+				indexLogRow(logIndex, row)
+			}
+		}
 		return true, nil
 	})
 	if err != nil {
@@ -314,7 +370,18 @@ func (e *s3Exporter) saveAndUploadParquet(ids string, interval int64) error {
 		return nil
 	}
 
-	return e.upload(f, &s3Writer{}, ids, interval)
+	key, err := e.upload(f, e.writer, ids, interval)
+	if err != nil {
+		return err
+	}
+	if logIndex != nil {
+		if err := uploadLogIndex(context.Background(), e.writer, e.config, key, logIndex, e.metadata); err != nil {
+			// The data is safely uploaded, and a missing index only means
+			// a query cannot skip this file.
+			logger.Error("Failed to upload log index", zap.String("key", key), zap.Error(err))
+		}
+	}
+	return nil
 }
 
 func (e *s3Exporter) writeInterval(interval int64) error {
@@ -332,8 +399,8 @@ func (e *s3Exporter) writeInterval(interval int64) error {
 	}(ids)
 
 	for _, id := range ids {
-		if err := e.saveAndUploadParquet(id, interval); err != nil {
-			e.logger.Error("Failed to save and upload parquet", zap.Error(err))
+		if err := e.saveAndUpload(id, interval); err != nil {
+			e.logger.Error("Failed to save and upload file", zap.Error(err))
 			return err
 		}
 	}
@@ -349,13 +416,20 @@ func filesize(f *os.File) (int64, error) {
 	return stat.Size(), nil
 }
 
-func (e *s3Exporter) upload(f io.ReadSeeker, writer filewriter, ids string, interval int64) error {
+func (e *s3Exporter) upload(f io.ReadSeeker, writer filewriter, ids string, interval int64) (string, error) {
 	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return fmt.Errorf("failed to seek to start of file: %w", err)
+		return "", fmt.Errorf("failed to seek to start of file: %w", err)
 	}
 	now := e.boxer.TimeForInterval(interval)
 	prefix := e.telemetryType + "_" + strconv.FormatInt(now.UnixMilli(), 10)
 	customerID, clusterID := splitCustomerID(ids)
 	e.logger.Debug("Uploading file", zap.String("customerID", customerID), zap.String("clusterID", clusterID), zap.String("prefix", prefix))
-	return writer.writeBuffer(context.Background(), now, f, e.config, prefix, parquetFormat, e.metadata, ids)
+	key, err := writer.writeBuffer(context.Background(), now, f, e.config, prefix, outputFormat(e.config), e.metadata, ids)
+	if err != nil {
+		return "", err
+	}
+	if e.compactor != nil {
+		e.compactor.track(now, ids, key)
+	}
+	return key, nil
 }
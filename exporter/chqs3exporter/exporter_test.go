@@ -4,6 +4,7 @@
 package chqs3exporter
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/pdata/plog"
 	"go.uber.org/zap"
@@ -28,12 +30,12 @@ type TestWriter struct {
 }
 
 // nolint: unused
-func (testWriter *TestWriter) writeBuffer(_ context.Context, _ time.Time, buf io.Reader, _ *Config, _ string, _ string, _ map[string]string, _ string) error {
+func (testWriter *TestWriter) writeBuffer(_ context.Context, _ time.Time, buf io.Reader, _ *Config, _ string, _ string, _ map[string]string, _ string) (string, error) {
 	b, err := io.ReadAll(buf)
 	assert.NoError(testWriter.t, err)
 	assert.NotZero(testWriter.t, len(b))
 	assert.Equal(testWriter.t, []byte{'P', 'A', 'R', '1'}, b[:4])
-	return nil
+	return "", nil
 }
 
 // nolint: unused
@@ -47,14 +49,14 @@ func getTestLogs(tb testing.TB) plog.Logs {
 
 // nolint: unused
 type mockFileWriter struct {
-	writeBufferFunc func(ctx context.Context, now time.Time, buf io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) error
+	writeBufferFunc func(ctx context.Context, now time.Time, buf io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) (string, error)
 }
 
-func (m *mockFileWriter) writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) error {
+func (m *mockFileWriter) writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) (string, error) {
 	if m.writeBufferFunc != nil {
 		return m.writeBufferFunc(ctx, now, buf, config, prefix, format, metadata, customerID)
 	}
-	return nil
+	return "", nil
 }
 
 var _ filewriter = (*mockFileWriter)(nil)
@@ -118,7 +120,7 @@ func TestUpload(t *testing.T) {
 	var capturedMetadata map[string]string
 	var capturedCustomerID string
 	var capturedData []byte
-	mockWriter.writeBufferFunc = func(ctx context.Context, _ time.Time, file io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) error {
+	mockWriter.writeBufferFunc = func(ctx context.Context, _ time.Time, file io.Reader, config *Config, prefix string, format string, metadata map[string]string, customerID string) (string, error) {
 		capturedConfig = config
 		capturedPrefix = prefix
 		capturedFormat = format
@@ -129,7 +131,7 @@ func TestUpload(t *testing.T) {
 		assert.NoError(t, err)
 		capturedData = data
 
-		return nil
+		return "key", nil
 	}
 
 	testdata := []byte("test data")
@@ -137,10 +139,11 @@ func TestUpload(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Call the upload function
-	err = exporter.upload(tmpfile, mockWriter, customerID, interval)
+	key, err := exporter.upload(tmpfile, mockWriter, customerID, interval)
 
 	// Assert that the writeBufferFunc was called with the correct arguments
 	assert.NoError(t, err)
+	assert.Equal(t, "key", key)
 	assert.Equal(t, config, capturedConfig)
 	assert.Equal(t, "logs_1234567890000", capturedPrefix)
 	assert.Equal(t, "parquet", capturedFormat)
@@ -149,6 +152,34 @@ func TestUpload(t *testing.T) {
 	assert.Equal(t, testdata, capturedData)
 }
 
+func TestNewMapWriter(t *testing.T) {
+	tags := map[string]any{
+		"_cardinalhq.message":   "",
+		"_cardinalhq.timestamp": int64(0),
+		"log.count":             float64(0),
+	}
+	rows := []map[string]any{
+		{"_cardinalhq.message": "hello", "_cardinalhq.timestamp": int64(1), "log.count": float64(2)},
+		{"_cardinalhq.message": "world", "_cardinalhq.timestamp": int64(3)},
+	}
+
+	for _, format := range []string{parquetFormat, ndjsonFormat, arrowFormat} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			writer, err := newMapWriter(&buf, format, tags)
+			require.NoError(t, err)
+			n, err := writer.WriteRows(rows)
+			require.NoError(t, err)
+			assert.Equal(t, len(rows), n)
+			require.NoError(t, writer.Close())
+			assert.NotZero(t, buf.Len())
+		})
+	}
+
+	_, err := newMapWriter(io.Discard, "csv", tags)
+	assert.Error(t, err)
+}
+
 func TestFilesize(t *testing.T) {
 	file, err := os.CreateTemp("", "testfile")
 	assert.NoError(t, err)
@@ -5,6 +5,7 @@ package chqs3exporter
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
@@ -12,6 +13,7 @@ import (
 	"go.opentelemetry.io/collector/exporter/exporterhelper"
 
 	"github.com/cardinalhq/cardinalhq-otel-collector/exporter/chqs3exporter/internal/metadata"
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/trigram"
 )
 
 // NewFactory creates a factory for S3 exporter.
@@ -28,6 +30,7 @@ func NewFactory() exporter.Factory {
 func createDefaultConfig() component.Config {
 	return &Config{
 		IDSource: "env",
+		Format:   parquetFormat,
 		S3Uploader: S3UploaderConfig{
 			Region:      "us-east-1",
 			S3Partition: "minute",
@@ -35,6 +38,14 @@ func createDefaultConfig() component.Config {
 		Buffering: BufferingConfig{
 			Type: bufferTypeMemory,
 		},
+		Compaction: CompactionConfig{
+			Interval:   time.Minute,
+			Delay:      5 * time.Minute,
+			MinObjects: 2,
+		},
+		LogIndex: LogIndexConfig{
+			FalsePositiveRate: trigram.DefaultFalsePositiveRate,
+		},
 	}
 }
 
@@ -0,0 +1,192 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tagwriter
+
+import (
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// ArrowMapWriter writes rows as an Arrow IPC stream, one record batch
+// per call to WriteRows.
+type ArrowMapWriter struct {
+	builder *array.RecordBuilder
+	writer  *ipc.Writer
+}
+
+var (
+	_ MapWriter = (*ArrowMapWriter)(nil)
+)
+
+// NewArrowMapWriter creates a new ArrowMapWriter writing to wr with the given schema.
+// The record batches are compressed with zstd.
+func NewArrowMapWriter(wr io.Writer, schema *arrow.Schema) *ArrowMapWriter {
+	mem := memory.NewGoAllocator()
+	return &ArrowMapWriter{
+		builder: array.NewRecordBuilder(mem, schema),
+		writer:  ipc.NewWriter(wr, ipc.WithSchema(schema), ipc.WithAllocator(mem), ipc.WithZstd()),
+	}
+}
+
+// WriteRows writes the given rows as a single record batch.  Columns
+// missing from a row are written as nulls.
+func (w *ArrowMapWriter) WriteRows(rows []map[string]any) (count int, err error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	schema := w.builder.Schema()
+	for _, row := range rows {
+		for i, field := range schema.Fields() {
+			if err := appendArrowValue(w.builder.Field(i), row[field.Name]); err != nil {
+				// Finish the row with nulls and throw away the partial
+				// batch so the next call starts clean.
+				for _, b := range w.builder.Fields()[i:] {
+					b.AppendNull()
+				}
+				w.builder.NewRecord().Release()
+				return 0, fmt.Errorf("column %s: %v", field.Name, err)
+			}
+		}
+	}
+	rec := w.builder.NewRecord()
+	defer rec.Release()
+	if err := w.writer.Write(rec); err != nil {
+		return 0, fmt.Errorf("error writing record: %v", err)
+	}
+	return len(rows), nil
+}
+
+// Close writes the end of the stream.
+func (w *ArrowMapWriter) Close() error {
+	defer w.builder.Release()
+	if err := w.writer.Close(); err != nil {
+		return fmt.Errorf("error closing writer: %v", err)
+	}
+	return nil
+}
+
+// Abort releases the writer without finishing the stream.
+func (w *ArrowMapWriter) Abort() error {
+	w.builder.Release()
+	return nil
+}
+
+func appendArrowValue(b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+	ok := false
+	switch b := b.(type) {
+	case *array.Int8Builder:
+		var vv int8
+		if vv, ok = v.(int8); ok {
+			b.Append(vv)
+		}
+	case *array.Uint8Builder:
+		var vv uint8
+		if vv, ok = v.(uint8); ok {
+			b.Append(vv)
+		}
+	case *array.Int16Builder:
+		var vv int16
+		if vv, ok = v.(int16); ok {
+			b.Append(vv)
+		}
+	case *array.Int32Builder:
+		var vv int32
+		if vv, ok = v.(int32); ok {
+			b.Append(vv)
+		}
+	case *array.Int64Builder:
+		var vv int64
+		if vv, ok = v.(int64); ok {
+			b.Append(vv)
+		}
+	case *array.Float64Builder:
+		var vv float64
+		if vv, ok = v.(float64); ok {
+			b.Append(vv)
+		}
+	case *array.StringBuilder:
+		var vv string
+		if vv, ok = v.(string); ok {
+			b.Append(vv)
+		}
+	case *array.BooleanBuilder:
+		var vv bool
+		if vv, ok = v.(bool); ok {
+			b.Append(vv)
+		}
+	default:
+		return fmt.Errorf("unsupported column type %s", b.Type())
+	}
+	if !ok {
+		return fmt.Errorf("value of type %T does not match column type %s", v, b.Type())
+	}
+	return nil
+}
+
+// ArrowTypeFromType returns an arrow.DataType for the given Go type.
+// The same types as ParquetNodeFromType are supported.
+func ArrowTypeFromType(t any) (arrow.DataType, error) {
+	switch t.(type) {
+	case int8:
+		return arrow.PrimitiveTypes.Int8, nil
+	case byte:
+		return arrow.PrimitiveTypes.Uint8, nil
+	case int16:
+		return arrow.PrimitiveTypes.Int16, nil
+	case int32:
+		return arrow.PrimitiveTypes.Int32, nil
+	case int64:
+		return arrow.PrimitiveTypes.Int64, nil
+	case float64:
+		return arrow.PrimitiveTypes.Float64, nil
+	case string:
+		return arrow.BinaryTypes.String, nil
+	case bool:
+		return arrow.FixedWidthTypes.Boolean, nil
+	default:
+		return nil, fmt.Errorf("unsupported type %T", t)
+	}
+}
+
+// ArrowSchemaFromMap returns an arrow.Schema for the given map of field names to Go types,
+// in the same way as ParquetSchemaFromMap.  Fields are sorted by name, and are all nullable
+// since a row need not have every field.
+func ArrowSchemaFromMap(typemap map[string]any) (*arrow.Schema, error) {
+	names := make([]string, 0, len(typemap))
+	for name := range typemap {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	fields := make([]arrow.Field, 0, len(names))
+	for _, name := range names {
+		dt, err := ArrowTypeFromType(typemap[name])
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, arrow.Field{Name: name, Type: dt, Nullable: true})
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
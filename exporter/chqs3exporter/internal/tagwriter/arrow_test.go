@@ -0,0 +1,138 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tagwriter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/ipc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readArrowRows(t *testing.T, data []byte) []map[string]any {
+	t.Helper()
+	reader, err := ipc.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	defer reader.Release()
+
+	var rows []map[string]any
+	for reader.Next() {
+		rec := reader.Record()
+		for r := 0; r < int(rec.NumRows()); r++ {
+			row := map[string]any{}
+			for c, field := range rec.Schema().Fields() {
+				col := rec.Column(c)
+				if col.IsNull(r) {
+					continue
+				}
+				switch col := col.(type) {
+				case *array.Int8:
+					row[field.Name] = col.Value(r)
+				case *array.Uint8:
+					row[field.Name] = col.Value(r)
+				case *array.Int16:
+					row[field.Name] = col.Value(r)
+				case *array.Int32:
+					row[field.Name] = col.Value(r)
+				case *array.Int64:
+					row[field.Name] = col.Value(r)
+				case *array.Float64:
+					row[field.Name] = col.Value(r)
+				case *array.String:
+					row[field.Name] = col.Value(r)
+				case *array.Boolean:
+					row[field.Name] = col.Value(r)
+				default:
+					t.Fatalf("unexpected column type %s", col.DataType())
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	require.NoError(t, reader.Err())
+	return rows
+}
+
+func TestArrowMapWriter_RoundTrip(t *testing.T) {
+	typemap := map[string]any{
+		"name":   "",
+		"age":    int64(0),
+		"score":  float64(0),
+		"active": false,
+		"small":  int8(0),
+		"byte":   byte(0),
+		"short":  int16(0),
+		"int":    int32(0),
+	}
+	schema, err := ArrowSchemaFromMap(typemap)
+	require.NoError(t, err)
+	names := []string{}
+	for _, field := range schema.Fields() {
+		names = append(names, field.Name)
+		assert.True(t, field.Nullable)
+	}
+	assert.Equal(t, []string{"active", "age", "byte", "int", "name", "score", "short", "small"}, names)
+
+	batch1 := []map[string]any{
+		{"name": "John", "age": int64(30), "score": 1.5, "active": true},
+		{"name": "Jane", "small": int8(-1), "byte": byte(200), "short": int16(300), "int": int32(70000)},
+	}
+	batch2 := []map[string]any{
+		{"age": int64(25), "name": nil},
+	}
+
+	var buf bytes.Buffer
+	writer := NewArrowMapWriter(&buf, schema)
+	count, err := writer.WriteRows(batch1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = writer.WriteRows(batch2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	require.NoError(t, writer.Close())
+
+	assert.Equal(t, []map[string]any{
+		{"name": "John", "age": int64(30), "score": 1.5, "active": true},
+		{"name": "Jane", "small": int8(-1), "byte": byte(200), "short": int16(300), "int": int32(70000)},
+		{"age": int64(25)},
+	}, readArrowRows(t, buf.Bytes()))
+}
+
+func TestArrowMapWriter_TypeMismatch(t *testing.T) {
+	schema, err := ArrowSchemaFromMap(map[string]any{"a": int64(0), "b": ""})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	writer := NewArrowMapWriter(&buf, schema)
+	_, err = writer.WriteRows([]map[string]any{
+		{"a": int64(1), "b": "x"},
+		{"a": "not a number", "b": "y"},
+	})
+	assert.Error(t, err)
+
+	// The failed batch is dropped and the writer can carry on.
+	_, err = writer.WriteRows([]map[string]any{{"a": int64(2), "b": "z"}})
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	assert.Equal(t, []map[string]any{{"a": int64(2), "b": "z"}}, readArrowRows(t, buf.Bytes()))
+}
+
+func TestArrowSchemaFromMap_Unsupported(t *testing.T) {
+	_, err := ArrowSchemaFromMap(map[string]any{"a": []string{}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tagwriter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// JSONMapWriter writes rows as newline-delimited JSON, one object per line.
+type JSONMapWriter struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+var (
+	_ MapWriter = (*JSONMapWriter)(nil)
+)
+
+// NewJSONMapWriter creates a new JSONMapWriter writing to wr.
+func NewJSONMapWriter(wr io.Writer) *JSONMapWriter {
+	buf := bufio.NewWriter(wr)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	return &JSONMapWriter{buf: buf, encoder: encoder}
+}
+
+// WriteRows writes the given rows, one per line.  Floating point values
+// that JSON cannot represent are written as the strings "NaN", "+Inf",
+// and "-Inf".
+func (w *JSONMapWriter) WriteRows(rows []map[string]any) (count int, err error) {
+	for _, row := range rows {
+		if err := w.encoder.Encode(jsonSafeRow(row)); err != nil {
+			return count, fmt.Errorf("error encoding row: %v", err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Close flushes any buffered rows.
+func (w *JSONMapWriter) Close() error {
+	if err := w.buf.Flush(); err != nil {
+		return fmt.Errorf("error flushing writer: %v", err)
+	}
+	return nil
+}
+
+// Abort discards any buffered rows.
+func (w *JSONMapWriter) Abort() error {
+	w.buf.Reset(io.Discard)
+	return nil
+}
+
+func jsonSafeRow(row map[string]any) map[string]any {
+	var ret map[string]any
+	for k, v := range row {
+		f, ok := v.(float64)
+		if !ok || !(math.IsNaN(f) || math.IsInf(f, 0)) {
+			continue
+		}
+		if ret == nil {
+			ret = make(map[string]any, len(row))
+			for k, v := range row {
+				ret[k] = v
+			}
+		}
+		switch {
+		case math.IsNaN(f):
+			ret[k] = "NaN"
+		case f > 0:
+			ret[k] = "+Inf"
+		default:
+			ret[k] = "-Inf"
+		}
+	}
+	if ret == nil {
+		return row
+	}
+	return ret
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tagwriter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONMapWriter_RoundTrip(t *testing.T) {
+	rows := []map[string]any{
+		{"name": "John", "age": int64(30), "score": 1.5, "active": true},
+		{"name": "<Jane & co>", "age": int64(25)},
+		{"score": math.NaN(), "high": math.Inf(1), "low": math.Inf(-1)},
+	}
+
+	var buf bytes.Buffer
+	writer := NewJSONMapWriter(&buf)
+	count, err := writer.WriteRows(rows)
+	require.NoError(t, err)
+	assert.Equal(t, len(rows), count)
+	require.NoError(t, writer.Close())
+
+	var got []map[string]any
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		row := map[string]any{}
+		require.NoError(t, decoder.Decode(&row))
+		got = append(got, row)
+	}
+	require.NoError(t, scanner.Err())
+
+	assert.Equal(t, []map[string]any{
+		{"name": "John", "age": json.Number("30"), "score": json.Number("1.5"), "active": true},
+		{"name": "<Jane & co>", "age": json.Number("25")},
+		{"score": "NaN", "high": "+Inf", "low": "-Inf"},
+	}, got)
+	// The caller's row is left alone.
+	assert.True(t, math.IsNaN(rows[2]["score"].(float64)))
+}
+
+func TestJSONMapWriter_Abort(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewJSONMapWriter(&buf)
+	_, err := writer.WriteRows([]map[string]any{{"name": "John"}})
+	require.NoError(t, err)
+	require.NoError(t, writer.Abort())
+	require.NoError(t, writer.Close())
+	assert.Zero(t, buf.Len())
+}
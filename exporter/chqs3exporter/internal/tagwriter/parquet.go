@@ -101,3 +101,51 @@ func ParquetSchemaFromMap(name string, typemap map[string]any) (*parquet.Schema,
 func ParquetSchameFromNodemap(name string, fields map[string]parquet.Node) (*parquet.Schema, error) {
 	return parquet.NewSchema(name, parquet.Group(fields)), nil
 }
+
+// ParquetSchemaUnion returns a parquet.Schema containing every top-level
+// column found in any of the given schemas.  A column stays required only
+// if it is required in every schema, otherwise it becomes optional so rows
+// from schemas that lack it can be written as nulls.  An error is returned
+// if the same column has different types in different schemas.
+func ParquetSchemaUnion(name string, schemas ...*parquet.Schema) (*parquet.Schema, error) {
+	fields := map[string]parquet.Node{}
+	required := map[string]bool{}
+	for i, schema := range schemas {
+		seen := map[string]bool{}
+		for _, field := range schema.Fields() {
+			if !field.Leaf() {
+				return nil, fmt.Errorf("column %s is not a leaf column", field.Name())
+			}
+			seen[field.Name()] = true
+			current, ok := fields[field.Name()]
+			if !ok {
+				fields[field.Name()] = field
+				required[field.Name()] = field.Required() && i == 0
+				continue
+			}
+			if current.Type().String() != field.Type().String() {
+				return nil, fmt.Errorf("column %s has mismatched types: %s and %s", field.Name(), current.Type(), field.Type())
+			}
+			if !field.Required() {
+				required[field.Name()] = false
+			}
+		}
+		for name := range fields {
+			if !seen[name] {
+				required[name] = false
+			}
+		}
+	}
+
+	nodes := make(map[string]parquet.Node, len(fields))
+	for name, field := range fields {
+		// Rebuild the leaf from its type so the repetition can be reapplied.
+		node := parquet.Leaf(field.Type())
+		if required[name] {
+			nodes[name] = parquet.Required(node)
+		} else {
+			nodes[name] = parquet.Optional(node)
+		}
+	}
+	return ParquetSchameFromNodemap(name, nodes)
+}
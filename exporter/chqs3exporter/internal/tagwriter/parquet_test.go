@@ -42,3 +42,52 @@ func TestParquetMapWriter_WriteRows(t *testing.T) {
 		t.Fatalf("expected %d, got %d", len(rows), count)
 	}
 }
+
+func TestParquetSchemaUnion(t *testing.T) {
+	a, err := ParquetSchemaFromMap("schema", map[string]any{
+		"name":  "John",
+		"age":   int64(30),
+		"score": float64(1.5),
+	})
+	assert.NoError(t, err)
+	b, err := ParquetSchemaFromMap("schema", map[string]any{
+		"name": "Jane",
+		"age":  int64(25),
+		"team": "blue",
+	})
+	assert.NoError(t, err)
+
+	union, err := ParquetSchemaUnion("schema", a, b)
+	assert.NoError(t, err)
+
+	columns := map[string]bool{}
+	for _, field := range union.Fields() {
+		columns[field.Name()] = field.Required()
+	}
+	assert.Equal(t, map[string]bool{
+		"name":  false,
+		"age":   true,
+		"score": false,
+		"team":  false,
+	}, columns)
+
+	var buf bytes.Buffer
+	writer, err := NewParquetMapWriter(&buf, union)
+	assert.NoError(t, err)
+	_, err = writer.WriteRows([]map[string]any{
+		{"name": "John", "age": int64(30), "score": float64(1.5)},
+		{"name": "Jane", "age": int64(25), "team": "blue"},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+}
+
+func TestParquetSchemaUnionMismatchedTypes(t *testing.T) {
+	a, err := ParquetSchemaFromMap("schema", map[string]any{"value": int64(1)})
+	assert.NoError(t, err)
+	b, err := ParquetSchemaFromMap("schema", map[string]any{"value": float64(1)})
+	assert.NoError(t, err)
+
+	_, err = ParquetSchemaUnion("schema", a, b)
+	assert.ErrorContains(t, err, "value")
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqs3exporter
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/trigram"
+)
+
+const (
+	logIndexSuffix      = ".idx"
+	logIndexContentType = "application/octet-stream"
+)
+
+// logIndexKey returns the key of the index file for a log file.
+func logIndexKey(key string) string {
+	return key + logIndexSuffix
+}
+
+// indexLogRow adds one log table row to the index.  Fields that are
+// unique to every row, like the ID and timestamp, are left out since
+// they would only fill the bloom filter.
+func indexLogRow(b *trigram.IndexBuilder, row map[string]any) {
+	for k, v := range row {
+		switch k {
+		case translate.CardinalFieldMessage:
+			if s, ok := v.(string); ok {
+				b.AddMessage(s)
+			}
+			continue
+		case translate.CardinalFieldFingerprint:
+			if fp, ok := v.(int64); ok {
+				b.AddFingerprint(fp)
+			}
+			continue
+		case translate.CardinalFieldID, translate.CardinalFieldTimestamp:
+			continue
+		}
+
+		switch vv := v.(type) {
+		case nil:
+		case string:
+			b.AddTag(k, vv)
+		case int64:
+			b.AddTag(k, strconv.FormatInt(vv, 10))
+		case bool:
+			b.AddTag(k, strconv.FormatBool(vv))
+		default:
+			b.AddField(k)
+		}
+	}
+}
+
+func uploadLogIndex(ctx context.Context, sink objectSink, config *Config, key string, b *trigram.IndexBuilder, kv map[string]string) error {
+	var buf bytes.Buffer
+	if _, err := b.Build().WriteTo(&buf); err != nil {
+		return err
+	}
+	return sink.putObject(ctx, config, logIndexKey(key), &buf, logIndexContentType, kv)
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqs3exporter
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/trigram"
+)
+
+func readLogIndex(t *testing.T, sink *memorySink, key string) *trigram.Index {
+	t.Helper()
+	sink.Lock()
+	obj, ok := sink.objects[logIndexKey(key)]
+	sink.Unlock()
+	require.True(t, ok, "no index for %s", key)
+	assert.Equal(t, logIndexContentType, obj.contentType)
+	idx, err := trigram.ReadIndex(bytes.NewReader(obj.data))
+	require.NoError(t, err)
+	return idx
+}
+
+func TestIndexLogRow(t *testing.T) {
+	b := trigram.NewIndexBuilder(0)
+	indexLogRow(b, map[string]any{
+		"_cardinalhq.message":     "payment declined for order 1234",
+		"_cardinalhq.fingerprint": int64(98765),
+		"_cardinalhq.id":          "abcdefgh",
+		"_cardinalhq.timestamp":   int64(1717245000000),
+		"resource.service.name":   "payments",
+		"log.retries":             int64(3),
+		"log.sampled":             true,
+		"log.duration":            float64(1.5),
+		"log.empty":               nil,
+	})
+
+	sink := newMemorySink()
+	require.NoError(t, uploadLogIndex(context.Background(), sink, nil, "a.parquet", b, nil))
+	idx := readLogIndex(t, sink, "a.parquet")
+
+	assert.True(t, idx.MayContainSubstring("declined"))
+	assert.False(t, idx.MayContainSubstring("approved"))
+	assert.True(t, idx.MayContainFingerprint(98765))
+	assert.True(t, idx.MayContainTag("resource.service.name", "payments"))
+	assert.True(t, idx.MayContainTag("log.retries", "3"))
+	assert.True(t, idx.MayContainTag("log.sampled", "true"))
+	assert.True(t, idx.MayHaveField("log.duration"))
+
+	assert.False(t, idx.MayHaveField("log.empty"))
+	assert.False(t, idx.MayHaveField("_cardinalhq.id"))
+	assert.False(t, idx.MayHaveField("_cardinalhq.timestamp"))
+}
+
+func TestCompactPartitionLogIndex(t *testing.T) {
+	cfg := testCompactionConfig(t)
+	cfg.LogIndex.Enabled = true
+	sink := newMemorySink()
+	partition := "prefix/cust/clus/year=2024/month=06/day=01/hour=12/minute=30/"
+
+	sources := []string{
+		partition + "logs_1717245000000_111111111.parquet",
+		partition + "logs_1717245010000_222222222.parquet",
+	}
+	writeParquetObject(t, sink, sources[0], []map[string]any{
+		{"_cardinalhq.message": "cache miss for user", "resource.pod": "pod-a"},
+	})
+	writeParquetObject(t, sink, sources[1], []map[string]any{
+		{"_cardinalhq.message": "request timed out", "resource.pod": "pod-b"},
+	})
+	for _, key := range sources {
+		require.NoError(t, uploadLogIndex(context.Background(), sink, cfg, key, trigram.NewIndexBuilder(0), nil))
+	}
+
+	c := newCompactor(cfg, sink, zap.NewNop(), logFilePrefix, nil)
+	require.NoError(t, c.compactPartition(context.Background(), partition, ownedKeys(sources...)))
+
+	keys := sink.keys()
+	require.Len(t, keys, 2)
+	compacted := keys[0]
+	assert.True(t, strings.HasSuffix(compacted, "."+parquetFormat))
+	assert.Equal(t, logIndexKey(compacted), keys[1])
+
+	idx := readLogIndex(t, sink, compacted)
+	assert.True(t, idx.MayContainSubstring("cache miss"))
+	assert.True(t, idx.MayContainSubstring("timed out"))
+	assert.True(t, idx.MayContainTag("resource.pod", "pod-a"))
+	assert.True(t, idx.MayContainTag("resource.pod", "pod-b"))
+	assert.False(t, idx.MayContainSubstring("connection refused"))
+}
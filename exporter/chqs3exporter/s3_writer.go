@@ -8,22 +8,62 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
-type s3Writer struct{}
+// s3Writer talks to S3 through a single session and client, created on
+// first use and kept for the life of the exporter.  Creating a session
+// for every call is slow, and with a role to assume, would request new
+// credentials from STS each time.
+type s3Writer struct {
+	sync.Mutex
+	config *Config
+	client *s3.S3
+}
 
 type filewriter interface {
-	writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, metadata string, format string, kv map[string]string, customerID string) error
+	writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, metadata string, format string, kv map[string]string, customerID string) (string, error)
+}
+
+// objectSink is a filewriter that can also find, read, and remove
+// objects it has previously written.
+type objectSink interface {
+	filewriter
+	putObject(ctx context.Context, config *Config, key string, buf io.Reader, contentType string, kv map[string]string) error
+	listObjects(ctx context.Context, config *Config, prefix string) ([]objectInfo, error)
+	downloadObject(ctx context.Context, config *Config, key string, w io.WriterAt) (int64, error)
+	deleteObjects(ctx context.Context, config *Config, keys []string) error
 }
 
-var _ filewriter = (*s3Writer)(nil)
+type objectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+var (
+	_ filewriter = (*s3Writer)(nil)
+	_ objectSink = (*s3Writer)(nil)
+)
+
+const (
+	// S3 limits a single DeleteObjects call to this many keys.
+	maxDeleteBatch = 1000
+
+	// roleExpiryWindow is how long before assumed role credentials expire
+	// that they are refreshed, so an upload never starts with credentials
+	// about to expire.
+	roleExpiryWindow = time.Minute
+)
 
 // generate the s3 time key based on partition configuration
 func getTimeKey(time time.Time, partition string, customerID string) string {
@@ -47,11 +87,38 @@ func randomInRange(low, hi int) int {
 	return low + rand.Intn(hi-low)
 }
 
+// formatExtensions and formatContentTypes give the file extension and
+// content type of each output format.  The Arrow format is an IPC stream,
+// which is registered as ".arrows"; ".arrow" is the random access file format.
+var (
+	formatExtensions = map[string]string{
+		parquetFormat: "parquet",
+		ndjsonFormat:  "ndjson",
+		arrowFormat:   "arrows",
+	}
+	formatContentTypes = map[string]string{
+		parquetFormat: parquetContentType,
+		ndjsonFormat:  "application/x-ndjson",
+		arrowFormat:   "application/vnd.apache.arrow.stream",
+	}
+)
+
+// outputFormat returns the format files are written in, which is parquet
+// unless configured otherwise.
+func outputFormat(config *Config) string {
+	if config == nil || config.Format == "" {
+		return parquetFormat
+	}
+	return config.Format
+}
+
 func getS3Key(time time.Time, keyPrefix string, partition string, filePrefix string, metadata string, fileFormat string, customerID string) string {
 	timeKey := getTimeKey(time, partition, customerID)
 	randomID := randomInRange(100000000, 999999999)
 	suffix := ""
-	if fileFormat != "" {
+	if ext, ok := formatExtensions[fileFormat]; ok {
+		suffix = "." + ext
+	} else if fileFormat != "" {
 		suffix = "." + fileFormat
 	}
 
@@ -75,51 +142,172 @@ func getSessionConfig(config *Config) *aws.Config {
 	return sessionConfig
 }
 
+// getSession returns a session using the default credential chain, or if
+// a role is configured, credentials for that role assumed using the
+// default chain.  The session caches its credentials and refreshes them
+// as they expire.
 func getSession(config *Config, sessionConfig *aws.Config) (*session.Session, error) {
 	sess, err := session.NewSession(sessionConfig)
+	if err != nil {
+		return nil, err
+	}
 
 	if config.S3Uploader.RoleArn != "" {
-		credentials := stscreds.NewCredentials(sess, config.S3Uploader.RoleArn)
+		credentials := stscreds.NewCredentials(sess, config.S3Uploader.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			p.ExpiryWindow = roleExpiryWindow
+		})
 		sess.Config.Credentials = credentials
 	}
 
-	return sess, err
+	return sess, nil
+}
+
+// s3Client returns the cached client for config, creating it if needed.
+func (s3writer *s3Writer) s3Client(config *Config) (*s3.S3, error) {
+	s3writer.Lock()
+	defer s3writer.Unlock()
+	if s3writer.client != nil && s3writer.config == config {
+		return s3writer.client, nil
+	}
+
+	sess, err := getSession(config, getSessionConfig(config))
+	if err != nil {
+		return nil, err
+	}
+	s3writer.config = config
+	s3writer.client = s3.New(sess)
+	return s3writer.client, nil
 }
 
-func (s3writer *s3Writer) writeBuffer(_ context.Context, now time.Time, buf io.Reader, config *Config, metadata string, format string, kv map[string]string, customerID string) error {
+// objectTagging returns the tags in the URL query form S3 expects.
+func objectTagging(tags map[string]string) string {
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func (s3writer *s3Writer) writeBuffer(ctx context.Context, now time.Time, buf io.Reader, config *Config, metadata string, format string, kv map[string]string, customerID string) (string, error) {
 	key := getS3Key(now,
 		config.S3Uploader.S3Prefix, config.S3Uploader.S3Partition,
 		config.S3Uploader.FilePrefix, metadata, format, customerID)
 
-	contentType := ""
-	if format == "parquet" {
-		contentType = "application/vnd.apache.parquet"
+	contentType := formatContentTypes[format]
+	if err := s3writer.putObject(ctx, config, key, buf, contentType, kv); err != nil {
+		return "", err
 	}
+	return key, nil
+}
 
-	sessionConfig := getSessionConfig(config)
-	sess, err := getSession(config, sessionConfig)
-
+func (s3writer *s3Writer) putObject(ctx context.Context, config *Config, key string, buf io.Reader, contentType string, kv map[string]string) error {
+	client, err := s3writer.s3Client(config)
 	if err != nil {
 		return err
 	}
 
-	uploader := s3manager.NewUploader(sess)
+	uploader := s3manager.NewUploaderWithClient(client)
 
 	md := make(map[string]*string)
 	for k, v := range kv {
 		md[k] = aws.String(v)
 	}
 
-	_, err = uploader.Upload(&s3manager.UploadInput{
+	input := &s3manager.UploadInput{
 		Bucket:      aws.String(config.S3Uploader.S3Bucket),
 		Key:         aws.String(key),
 		Body:        buf,
 		ContentType: &contentType,
 		Metadata:    md,
+	}
+	if sse := config.S3Uploader.ServerSideEncryption; sse != "" {
+		input.ServerSideEncryption = aws.String(sse)
+		if sse == s3.ServerSideEncryptionAwsKms && config.S3Uploader.SSEKMSKeyID != "" {
+			input.SSEKMSKeyId = aws.String(config.S3Uploader.SSEKMSKeyID)
+		}
+	}
+	if config.S3Uploader.StorageClass != "" {
+		input.StorageClass = aws.String(config.S3Uploader.StorageClass)
+	}
+	if config.S3Uploader.ACL != "" {
+		input.ACL = aws.String(config.S3Uploader.ACL)
+	}
+	if len(config.S3Uploader.ObjectTags) > 0 {
+		input.Tagging = aws.String(objectTagging(config.S3Uploader.ObjectTags))
+	}
+
+	_, err = uploader.UploadWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s3writer *s3Writer) listObjects(ctx context.Context, config *Config, prefix string) ([]objectInfo, error) {
+	client, err := s3writer.s3Client(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []objectInfo
+	err = client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(config.S3Uploader.S3Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, objectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (s3writer *s3Writer) downloadObject(ctx context.Context, config *Config, key string, w io.WriterAt) (int64, error) {
+	client, err := s3writer.s3Client(config)
+	if err != nil {
+		return 0, err
+	}
+
+	return s3manager.NewDownloaderWithClient(client).DownloadWithContext(ctx, w, &s3.GetObjectInput{
+		Bucket: aws.String(config.S3Uploader.S3Bucket),
+		Key:    aws.String(key),
 	})
+}
+
+func (s3writer *s3Writer) deleteObjects(ctx context.Context, config *Config, keys []string) error {
+	client, err := s3writer.s3Client(config)
 	if err != nil {
 		return err
 	}
 
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(keys))
+		objects := make([]*s3.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		out, err := client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(config.S3Uploader.S3Bucket),
+			Delete: &s3.Delete{
+				Objects: objects,
+				Quiet:   aws.Bool(true),
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("failed to delete %d objects, first error: %s: %s", len(out.Errors), aws.StringValue(e.Key), aws.StringValue(e.Message))
+		}
+	}
 	return nil
 }
@@ -4,11 +4,21 @@
 package chqs3exporter
 
 import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,6 +65,19 @@ func TestS3KeyEmptyFileFormat(t *testing.T) {
 	assert.Equal(t, true, matched)
 }
 
+func TestS3KeyOutputFormats(t *testing.T) {
+	tm := time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC)
+	for format, ext := range map[string]string{
+		parquetFormat: ".parquet",
+		ndjsonFormat:  ".ndjson",
+		arrowFormat:   ".arrows",
+	} {
+		s3Key := getS3Key(tm, "keyprefix", "minute", "fileprefix", "logs", format, "")
+		assert.Regexp(t, `fileprefixlogs_[0-9]+\`+ext+`$`, s3Key, format)
+		assert.NotEmpty(t, formatContentTypes[format], format)
+	}
+}
+
 func TestGetSessionConfigWithEndpoint(t *testing.T) {
 	const endpoint = "https://endpoint.com"
 	const region = "region"
@@ -118,3 +141,151 @@ func TestGetSessionConfigWithoutRoleArn(t *testing.T) {
 	assert.Equal(t, sessionConfig.Region, aws.String(region))
 	assert.NotEqual(t, creds.ProviderName, "AssumeRoleProvider")
 }
+
+// s3Stub is a minimal S3-compatible server holding objects in memory,
+// recording the headers of each object put.
+type s3Stub struct {
+	sync.Mutex
+	objects map[string][]byte
+	puts    map[string]http.Header
+}
+
+func newS3Stub(t *testing.T) (*s3Stub, *httptest.Server) {
+	stub := &s3Stub{objects: map[string][]byte{}, puts: map[string]http.Header{}}
+	server := httptest.NewServer(stub)
+	t.Cleanup(server.Close)
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return stub, server
+}
+
+func (s *s3Stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	// Path style requests are /bucket/key.
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		s.objects[key] = data
+		s.puts[key] = r.Header.Clone()
+		w.Header().Set("ETag", `"etag"`)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		prefix := r.URL.Query().Get("prefix")
+		var b strings.Builder
+		b.WriteString(`<ListBucketResult><IsTruncated>false</IsTruncated>`)
+		for k, data := range s.objects {
+			if strings.HasPrefix(k, prefix) {
+				fmt.Fprintf(&b, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, k, len(data))
+			}
+		}
+		b.WriteString(`</ListBucketResult>`)
+		_, _ = io.WriteString(w, b.String())
+	case r.Method == http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		var req struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		_ = xml.NewDecoder(r.Body).Decode(&req)
+		for _, obj := range req.Objects {
+			delete(s.objects, obj.Key)
+		}
+		_, _ = io.WriteString(w, `<DeleteResult></DeleteResult>`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func stubConfig(endpoint string) *Config {
+	cfg := createDefaultConfig().(*Config)
+	cfg.S3Uploader.S3Bucket = "bucket"
+	cfg.S3Uploader.S3Prefix = "prefix"
+	cfg.S3Uploader.Endpoint = endpoint
+	cfg.S3Uploader.S3ForcePathStyle = true
+	cfg.S3Uploader.DisableSSL = true
+	return cfg
+}
+
+func TestS3WriterUploadOptions(t *testing.T) {
+	stub, server := newS3Stub(t)
+	cfg := stubConfig(server.URL)
+	cfg.S3Uploader.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+	cfg.S3Uploader.SSEKMSKeyID = "arn:aws:kms:us-east-1:123456789012:key/abcd"
+	cfg.S3Uploader.StorageClass = s3.StorageClassStandardIa
+	cfg.S3Uploader.ACL = s3.ObjectCannedACLBucketOwnerFullControl
+	cfg.S3Uploader.ObjectTags = map[string]string{"team": "obs", "env": "prod & test"}
+
+	writer := &s3Writer{}
+	key, err := writer.writeBuffer(context.Background(), time.Now(), strings.NewReader("data"), cfg, "logs_1", parquetFormat, map[string]string{"cardinalhq-exporter": "chqs3"}, "")
+	require.NoError(t, err)
+
+	stub.Lock()
+	header := stub.puts[key]
+	assert.Equal(t, []byte("data"), stub.objects[key])
+	stub.Unlock()
+	require.NotNil(t, header)
+	assert.Equal(t, "aws:kms", header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/abcd", header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Equal(t, "STANDARD_IA", header.Get("X-Amz-Storage-Class"))
+	assert.Equal(t, "bucket-owner-full-control", header.Get("X-Amz-Acl"))
+	assert.Equal(t, "env=prod+%26+test&team=obs", header.Get("X-Amz-Tagging"))
+	assert.Equal(t, parquetContentType, header.Get("Content-Type"))
+	assert.Equal(t, "chqs3", header.Get("X-Amz-Meta-Cardinalhq-Exporter"))
+}
+
+func TestS3WriterDefaultUploadHeaders(t *testing.T) {
+	stub, server := newS3Stub(t)
+	cfg := stubConfig(server.URL)
+	cfg.S3Uploader.ServerSideEncryption = s3.ServerSideEncryptionAes256
+	cfg.S3Uploader.SSEKMSKeyID = ""
+
+	writer := &s3Writer{}
+	require.NoError(t, writer.putObject(context.Background(), cfg, "prefix/a", strings.NewReader("a"), "", nil))
+
+	stub.Lock()
+	header := stub.puts["prefix/a"]
+	stub.Unlock()
+	require.NotNil(t, header)
+	assert.Equal(t, "AES256", header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Empty(t, header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+	assert.Empty(t, header.Get("X-Amz-Storage-Class"))
+	assert.Empty(t, header.Get("X-Amz-Acl"))
+	assert.Empty(t, header.Get("X-Amz-Tagging"))
+}
+
+func TestS3WriterReusesClient(t *testing.T) {
+	_, server := newS3Stub(t)
+	cfg := stubConfig(server.URL)
+	ctx := context.Background()
+
+	writer := &s3Writer{}
+	require.NoError(t, writer.putObject(ctx, cfg, "prefix/a", strings.NewReader("a"), "", nil))
+	client := writer.client
+	require.NotNil(t, client)
+
+	require.NoError(t, writer.putObject(ctx, cfg, "prefix/b", strings.NewReader("bb"), "", nil))
+	objects, err := writer.listObjects(ctx, cfg, "prefix/")
+	require.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	buf := aws.NewWriteAtBuffer(nil)
+	n, err := writer.downloadObject(ctx, cfg, "prefix/b", buf)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	assert.Equal(t, []byte("bb"), buf.Bytes())
+
+	require.NoError(t, writer.deleteObjects(ctx, cfg, []string{"prefix/a", "prefix/b"}))
+	objects, err = writer.listObjects(ctx, cfg, "prefix/")
+	require.NoError(t, err)
+	assert.Empty(t, objects)
+
+	assert.Same(t, client, writer.client)
+}
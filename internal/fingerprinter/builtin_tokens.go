@@ -0,0 +1,122 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// These token classes are recognised ahead of the ragel tokenizer, which
+// would otherwise split them into a different sequence of tokens for each
+// value.  They run after any user-defined BeforeScan patterns.
+var builtinTokenPatterns = []TokenPattern{
+	newBuiltinTokenPattern("IPv6", ipv6Expr, containsDigit),
+	newBuiltinTokenPattern("MAC", macExpr, nil),
+	newBuiltinTokenPattern("Hex", hexExpr, isHexToken),
+	newBuiltinTokenPattern("Base64", base64Expr, isBase64Token),
+}
+
+const (
+	hex4 = `[0-9a-fA-F]{1,4}`
+	ipv4 = `(?:[0-9]{1,3}\.){3}[0-9]{1,3}`
+
+	ipv6Expr = `(?:` + hex4 + `:){7}` + hex4 +
+		`|(?:` + hex4 + `:){1,7}:` +
+		`|(?:` + hex4 + `:){1,6}:` + hex4 +
+		`|(?:` + hex4 + `:){1,5}(?::` + hex4 + `){1,2}` +
+		`|(?:` + hex4 + `:){1,4}(?::` + hex4 + `){1,3}` +
+		`|(?:` + hex4 + `:){1,3}(?::` + hex4 + `){1,4}` +
+		`|(?:` + hex4 + `:){1,2}(?::` + hex4 + `){1,5}` +
+		`|` + hex4 + `:(?::` + hex4 + `){1,6}` +
+		`|:(?::` + hex4 + `){1,7}` +
+		`|::(?:[fF]{4}:)?` + ipv4
+
+	macExpr = `[0-9a-fA-F]{2}(?:[:-][0-9a-fA-F]{2}){5}` +
+		`|[0-9a-fA-F]{4}\.[0-9a-fA-F]{4}\.[0-9a-fA-F]{4}`
+
+	hexExpr = `0[xX][0-9a-fA-F]{4,}|[0-9a-fA-F]{16,}`
+
+	base64Expr = `[A-Za-z0-9+/]{20,}={0,2}`
+)
+
+func newBuiltinTokenPattern(name string, expr string, accept func(string) bool) TokenPattern {
+	re := regexp.MustCompile(expr)
+	// Alternations such as the IPv6 forms need the longest match rather
+	// than the first alternative that matches.
+	re.Longest()
+	return TokenPattern{Name: name, Stage: BeforeScan, re: re, isolated: true, accept: accept}
+}
+
+func containsDigit(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsDigit)
+}
+
+// isHexToken rejects long runs of decimal digits, which the tokenizer
+// already treats as numbers.
+func isHexToken(s string) bool {
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		return true
+	}
+	return strings.ContainsAny(s, "abcdefABCDEF")
+}
+
+// isBase64Token tells encoded data from long identifiers and paths, which
+// share its alphabet.  Encoded data is padded or a multiple of four long,
+// and switches between upper case, lower case and digits far more often
+// than words do.
+func isBase64Token(s string) bool {
+	data := strings.TrimRight(s, "=")
+	if len(data) == len(s) && len(s)%4 != 0 {
+		return false
+	}
+	var hasUpper, hasLower, hasDigit bool
+	switches := 0
+	prev := rune(0)
+	for _, r := range data {
+		class := r
+		switch {
+		case r >= 'A' && r <= 'Z':
+			hasUpper, class = true, 'A'
+		case r >= 'a' && r <= 'z':
+			hasLower, class = true, 'a'
+		case r >= '0' && r <= '9':
+			hasDigit, class = true, '0'
+		}
+		if prev != 0 && class != prev {
+			switches++
+		}
+		prev = class
+	}
+	return hasUpper && hasLower && hasDigit && switches*2 >= len(data)
+}
+
+// isolatedMatch returns true if the match at s[start:end] is not part of a
+// longer word, address or path.
+func isolatedMatch(s string, start, end int) bool {
+	joins := func(b byte) bool {
+		return b == '_' || b == '.' || b == ':' || b == '/' || b == '+' || b == '=' ||
+			b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+	}
+	if start > 0 && joins(s[start-1]) {
+		return false
+	}
+	if end < len(s) && joins(s[end]) {
+		// A sentence can end straight after the token.
+		return s[end] == '.' && (end+1 == len(s) || s[end+1] == ' ')
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter/tokenizer"
+)
+
+// readCorpus returns the lines of a corpus file, grouped under its
+// "# heading" lines.
+func readCorpus(t *testing.T, name string) map[string][]string {
+	file, err := os.Open(name)
+	require.NoError(t, err)
+	defer file.Close()
+
+	groups := map[string][]string{}
+	heading := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if h, found := strings.CutPrefix(line, "# "); found {
+			heading = h
+			continue
+		}
+		groups[heading] = append(groups[heading], line)
+	}
+	require.NoError(t, scanner.Err())
+	return groups
+}
+
+func TestBuiltinTokensCollapseCorpus(t *testing.T) {
+	withBuiltins := NewFingerprinter()
+	withoutBuiltins := NewFingerprinter()
+	withoutBuiltins.builtin = nil
+
+	for heading, lines := range readCorpus(t, "testdata/token-corpus.txt") {
+		t.Run(heading, func(t *testing.T) {
+			before := map[int64]bool{}
+			after := map[int64]bool{}
+			for _, line := range lines {
+				fingerprint, _, err := withoutBuiltins.Fingerprint(line)
+				require.NoError(t, err)
+				before[fingerprint] = true
+				fingerprint, _, err = withBuiltins.Fingerprint(line)
+				require.NoError(t, err)
+				after[fingerprint] = true
+			}
+			assert.Greater(t, len(before), 1)
+			assert.Len(t, after, 1)
+		})
+	}
+}
+
+func TestBuiltinTokens(t *testing.T) {
+	fp := NewFingerprinter()
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"from [2001:db8::1]:8443", "from <IPv6> <Number>"},
+		{"from fe80::1.", "from <IPv6>"},
+		{"lease for 00:1a:2b:3c:4d:5e", "for <MAC>"},
+		{"object sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", "object <Identifier>"},
+		{"token dGhpcyBpcyBhIHRlc3QhISE=", "token <Base64>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, _, err := fp.Tokenize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBuiltinTokensLeaveOtherTokens(t *testing.T) {
+	withBuiltins := NewFingerprinter()
+	withoutBuiltins := NewFingerprinter()
+	withoutBuiltins.builtin = nil
+
+	for _, input := range []string{
+		"at 12:30:45",
+		"from 10.0.0.1",
+		"id 550e8400-e29b-41d4-a716-446655440000",
+		"offset 00000000000000192570",
+		"in std::vector",
+		"in cafe::bad",
+		"in AbstractSingletonProxyFactoryBean",
+		"class com/example/service/PaymentHandler2",
+		"at 2024-04-06T00:58:02.533Z",
+	} {
+		t.Run(input, func(t *testing.T) {
+			want, _, err := withoutBuiltins.Tokenize(input)
+			require.NoError(t, err)
+			got, _, err := withBuiltins.Tokenize(input)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+// The built-in classes stand in for scanner rules, so none of them may
+// share a name with a token the scanner emits.
+func TestBuiltinTokenNamesAreNotScannerTokens(t *testing.T) {
+	for _, p := range builtinTokenPatterns {
+		for _, name := range tokenizer.TokenNames {
+			assert.NotEqual(t, name, p.Name)
+		}
+	}
+}
@@ -35,18 +35,42 @@ type Fingerprinter interface {
 }
 
 type fingerprinterImpl struct {
-	wordlist map[string]bool
+	wordlist   map[string]bool
+	beforeScan []TokenPattern
+	afterScan  []TokenPattern
+	builtin    []TokenPattern
+	keyValues  bool
 }
 
 var _ Fingerprinter = (*fingerprinterImpl)(nil)
 
-func NewFingerprinter() *fingerprinterImpl {
+type FingerprinterOption func(fp *fingerprinterImpl)
+
+// WithTokenPatterns adds user-defined token classes.  Patterns of each
+// stage are tried in the order given.
+func WithTokenPatterns(patterns ...TokenPattern) FingerprinterOption {
+	return func(fp *fingerprinterImpl) {
+		for _, p := range patterns {
+			if p.Stage == AfterScan {
+				fp.afterScan = append(fp.afterScan, p)
+			} else {
+				fp.beforeScan = append(fp.beforeScan, p)
+			}
+		}
+	}
+}
+
+func NewFingerprinter(opts ...FingerprinterOption) *fingerprinterImpl {
 	fp := fingerprinterImpl{
 		wordlist: make(map[string]bool),
+		builtin:  builtinTokenPatterns,
 	}
 	for _, word := range englishWords {
 		fp.wordlist[word] = true
 	}
+	for _, opt := range opts {
+		opt(&fp)
+	}
 	return &fp
 }
 
@@ -97,7 +121,13 @@ func (fp *fingerprinterImpl) Fingerprint(input string) (fingerprint int64, level
 	if err != nil {
 		return 0, "", err
 	}
-	return int64(xxhash.Sum64String(s)), level, nil
+	return FingerprintTokens(s), level, nil
+}
+
+// FingerprintTokens returns the fingerprint of a tokenized input, as
+// returned by TokenizeInput.
+func FingerprintTokens(tokens string) int64 {
+	return int64(xxhash.Sum64String(tokens))
 }
 
 func (fp *fingerprinterImpl) TokenizeInput(input string) (string, string, error) {
@@ -111,6 +141,12 @@ func (fp *fingerprinterImpl) TokenizeInput(input string) (string, string, error)
 		}
 	}
 
+	if fp.keyValues {
+		if text, pairs, ok := ParseKeyValues(message); ok {
+			return fp.tokenizeKeyValues(text, pairs)
+		}
+	}
+
 	return fp.Tokenize(message)
 }
 
@@ -118,6 +154,9 @@ func (fp *fingerprinterImpl) IsWord(word string) bool {
 	if _, ok := fp.wordlist[strings.ToLower(word)]; ok {
 		return true
 	}
+	if isCJKWord(word) {
+		return true
+	}
 	// If the word is entirely uppercase or entirely lowercase, it needs to fully match.
 	if strings.ToUpper(word) == word || strings.ToLower(word) == word {
 		return false
@@ -133,17 +172,34 @@ func (fp *fingerprinterImpl) IsWord(word string) bool {
 }
 
 func (fp *fingerprinterImpl) Tokenize(input string) (string, string, error) {
-	tk := tokenizer.NewFingerprintTokenizer()
-	s := ragel.New("test", strings.NewReader(input), tk)
 	items := []string{}
 	level := ""
+	for _, seg := range fp.applyBeforeScan(input) {
+		if seg.placeholder != "" {
+			items = append(items, seg.placeholder)
+			continue
+		}
+		var err error
+		items, level, err = fp.scan(seg.text, items, level)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return strings.Join(items, " "), strings.ToLower(level), nil
+}
+
+// scan runs the tokenizer over input, appending to items.  level is the
+// first log level found so far.
+func (fp *fingerprinterImpl) scan(input string, items []string, level string) ([]string, string, error) {
+	tk := tokenizer.NewFingerprintTokenizer()
+	s := ragel.New("test", strings.NewReader(input), tk)
 	for {
 		_, tok, literal := s.Next()
 		switch tok {
 		case ragel.EOF:
-			return strings.Join(items, " "), strings.ToLower(level), nil
+			return items, level, nil
 		case ragel.Error:
-			return "", "", fmt.Errorf("error: %s", literal)
+			return nil, "", fmt.Errorf("error: %s", literal)
 		case tokenizer.TokenLoglevel:
 			if level == "" {
 				level = literal
@@ -151,12 +207,14 @@ func (fp *fingerprinterImpl) Tokenize(input string) (string, string, error) {
 			} else {
 				items = append(items, strings.ToLower(literal))
 			}
-		case tokenizer.TokenString:
-			if fp.IsWord(literal) {
+		default:
+			if placeholder, found := fp.matchAfterScan(literal); found {
+				items = append(items, placeholder)
+			} else if tok != tokenizer.TokenString {
+				items = append(items, "<"+tk.TokenString(tok)+">")
+			} else if fp.IsWord(literal) {
 				items = append(items, strings.ToLower(literal))
 			}
-		default:
-			items = append(items, "<"+tk.TokenString(tok)+">")
 		}
 	}
 }
@@ -0,0 +1,181 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter/tokenizer"
+)
+
+// minKeyValues is how many key=value pairs a body needs to be treated as
+// structured, so a stray "id=5" in free text is tokenized as before.
+const minKeyValues = 2
+
+var (
+	messageKeys = []string{"msg", "message"}
+	levelKeys   = []string{"level", "lvl", "loglevel", "severity"}
+)
+
+// WithKeyValues fingerprints logfmt and key=value bodies by their message
+// and keys, leaving out the values.  It changes the fingerprint of every
+// such body, so it is off by default.
+func WithKeyValues() FingerprinterOption {
+	return func(fp *fingerprinterImpl) {
+		fp.keyValues = true
+	}
+}
+
+// KeyValue is a pair parsed from a logfmt or key=value log body.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// ParseKeyValues parses a logfmt body, such as
+//
+//	level=info msg="user logged in" user=123
+//
+// or free text followed by key=value pairs, such as
+//
+//	user logged in user=123 ip=10.0.0.1
+//
+// It returns the free text and the pairs, in the order found.  ok is false
+// if the body has too few pairs, or has text after them.
+func ParseKeyValues(input string) (text string, pairs []KeyValue, ok bool) {
+	textEnd := 0
+	i := 0
+	for {
+		for i < len(input) && isSpace(input[i]) {
+			i++
+		}
+		if i == len(input) {
+			break
+		}
+		start := i
+		key, value, next, isPair := parseKeyValue(input, i)
+		if !isPair {
+			if len(pairs) > 0 {
+				return "", nil, false
+			}
+			for i < len(input) && !isSpace(input[i]) {
+				i++
+			}
+			continue
+		}
+		if len(pairs) == 0 {
+			textEnd = start
+		}
+		pairs = append(pairs, KeyValue{Key: key, Value: value})
+		i = next
+	}
+	if len(pairs) < minKeyValues {
+		return "", nil, false
+	}
+	return strings.TrimSpace(input[:textEnd]), pairs, true
+}
+
+// parseKeyValue parses a key=value pair starting at input[i], returning
+// the index after it.  A quoted value may contain spaces and Go escapes.
+func parseKeyValue(input string, i int) (key string, value string, next int, ok bool) {
+	start := i
+	for i < len(input) && isKeyChar(input[i], i == start) {
+		i++
+	}
+	if i == start || i == len(input) || input[i] != '=' {
+		return "", "", 0, false
+	}
+	key = input[start:i]
+	i++
+
+	if i < len(input) && input[i] == '"' {
+		end := i + 1
+		for end < len(input) && input[end] != '"' {
+			if input[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(input) {
+			return "", "", 0, false
+		}
+		unquoted, err := strconv.Unquote(input[i : end+1])
+		if err != nil {
+			// Not all logfmt writers escape the way Go does.
+			unquoted = input[i+1 : end]
+		}
+		i = end + 1
+		if i < len(input) && !isSpace(input[i]) {
+			return "", "", 0, false
+		}
+		return key, unquoted, i, true
+	}
+
+	valueStart := i
+	for i < len(input) && !isSpace(input[i]) {
+		if input[i] == '"' {
+			return "", "", 0, false
+		}
+		i++
+	}
+	return key, input[valueStart:i], i, true
+}
+
+func isKeyChar(c byte, first bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		return true
+	case c >= '0' && c <= '9', c == '.', c == '-', c == '/':
+		return !first
+	}
+	return false
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// tokenizeKeyValues tokenizes the message of a structured body, and adds
+// its sorted set of keys, so the values do not change the fingerprint.
+func (fp *fingerprinterImpl) tokenizeKeyValues(text string, pairs []KeyValue) (string, string, error) {
+	message := text
+	level := ""
+	keys := make([]string, 0, len(pairs))
+	for _, kv := range pairs {
+		key := strings.ToLower(kv.Key)
+		switch {
+		case slices.Contains(messageKeys, key):
+			message = strings.TrimSpace(message + " " + kv.Value)
+		case slices.Contains(levelKeys, key) && level == "":
+			if l := strings.ToLower(kv.Value); slices.Contains(tokenizer.LogLevelNames, l) {
+				level = l
+			}
+		}
+		keys = append(keys, key+"=")
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	s, nlevel, err := fp.Tokenize(message)
+	if err != nil {
+		return "", "", err
+	}
+	if level == "" {
+		level = nlevel
+	}
+	return strings.TrimSpace(s + " " + strings.Join(keys, " ")), level, nil
+}
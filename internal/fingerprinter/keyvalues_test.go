@@ -0,0 +1,127 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		wantText  string
+		wantPairs []KeyValue
+		wantOK    bool
+	}{
+		{
+			"logfmt",
+			`level=info msg="user logged in" user=123`,
+			"",
+			[]KeyValue{{"level", "info"}, {"msg", "user logged in"}, {"user", "123"}},
+			true,
+		},
+		{
+			"text then pairs",
+			"user logged in user=123 ip=10.0.0.1",
+			"user logged in",
+			[]KeyValue{{"user", "123"}, {"ip", "10.0.0.1"}},
+			true,
+		},
+		{
+			"escaped and empty values",
+			`msg="said \"hi\"" path= k8s.pod.name=web-1`,
+			"",
+			[]KeyValue{{"msg", `said "hi"`}, {"path", ""}, {"k8s.pod.name", "web-1"}},
+			true,
+		},
+		{"one pair", "request failed id=5", "", nil, false},
+		{"text after pairs", "user=123 ip=10.0.0.1 logged in", "", nil, false},
+		{"unterminated quote", `level=info msg="user logged in`, "", nil, false},
+		{"spaces around equals", "endpoint = /api level = info", "", nil, false},
+		{"plain text", "hello world", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, pairs, ok := ParseKeyValues(tt.input)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.wantText, text)
+			assert.Equal(t, tt.wantPairs, pairs)
+		})
+	}
+}
+
+func TestKeyValueFingerprints(t *testing.T) {
+	fp := NewFingerprinter(WithKeyValues())
+
+	tests := []struct {
+		name      string
+		input     string
+		want      string
+		wantLevel string
+	}{
+		{
+			"logfmt",
+			`level=warn msg="payment failed for customer" user=123 ts=2024-06-16T18:37:46Z`,
+			"payment failed for customer level= msg= ts= user=",
+			"warn",
+		},
+		{
+			"keys are sorted",
+			`user=456 ts=2024-06-17T01:02:03Z msg="payment failed for customer" level=WARN`,
+			"payment failed for customer level= msg= ts= user=",
+			"warn",
+		},
+		{
+			"level from the text",
+			"ERROR payment failed for customer user=123 host=10.0.0.1",
+			"<Loglevel> payment failed for customer host= user=",
+			"error",
+		},
+		{
+			"text and message",
+			`received: msg="payment failed" user=123`,
+			"received payment failed msg= user=",
+			"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, level, err := fp.TokenizeInput(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantLevel, level)
+		})
+	}
+
+	// Different keys are a different kind of line.
+	a, _, err := fp.Fingerprint(`msg="payment failed for customer" user=123 ip=10.0.0.1`)
+	require.NoError(t, err)
+	b, _, err := fp.Fingerprint(`msg="payment failed for customer" user=123 port=8080`)
+	require.NoError(t, err)
+	assert.NotEqual(t, a, b)
+}
+
+func TestKeyValueFingerprintsOffByDefault(t *testing.T) {
+	input := `level=warn msg="payment failed for customer" user=123`
+	want, _, err := NewFingerprinter().Tokenize(input)
+	require.NoError(t, err)
+	got, _, err := NewFingerprinter().TokenizeInput(input)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TokenStage is when a TokenPattern is applied.
+type TokenStage int
+
+const (
+	// BeforeScan patterns are matched against the input before the
+	// tokenizer sees it, so they can match text the tokenizer would split.
+	BeforeScan TokenStage = iota
+	// AfterScan patterns must match a whole token found by the tokenizer,
+	// so they only replace what the tokenizer already treats as one token.
+	AfterScan
+)
+
+var tokenPatternName = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// TokenPattern is a user-defined token class.  Text it matches is replaced
+// by the placeholder <Name> in the tokenized output.
+type TokenPattern struct {
+	Name  string
+	Stage TokenStage
+	re    *regexp.Regexp
+	// isolated matches must not run into neighbouring word characters.
+	isolated bool
+	// accept, if set, must also return true for the matched text.
+	accept func(string) bool
+}
+
+// NewTokenPattern compiles a token pattern.  The expression uses the
+// syntax of the regexp package.
+func NewTokenPattern(name string, expr string, stage TokenStage) (TokenPattern, error) {
+	if !tokenPatternName.MatchString(name) {
+		return TokenPattern{}, fmt.Errorf("token pattern name %q must be a letter followed by letters, digits or underscores", name)
+	}
+	if stage == AfterScan {
+		expr = `^(?:` + expr + `)$`
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return TokenPattern{}, fmt.Errorf("token pattern %s: %w", name, err)
+	}
+	return TokenPattern{Name: name, Stage: stage, re: re}, nil
+}
+
+func (p TokenPattern) placeholder() string {
+	return "<" + p.Name + ">"
+}
+
+// segment is a run of input text, or a placeholder for text a BeforeScan
+// pattern matched.
+type segment struct {
+	text        string
+	placeholder string
+}
+
+func (p TokenPattern) matches(s string, start, end int) bool {
+	if start == end {
+		return false
+	}
+	if p.isolated && !isolatedMatch(s, start, end) {
+		return false
+	}
+	return p.accept == nil || p.accept(s[start:end])
+}
+
+// applyBeforeScan splits input around the matches of the BeforeScan
+// patterns, trying each pattern in turn on the text the earlier ones left.
+// User-defined patterns go first, then the built-in ones.
+func (fp *fingerprinterImpl) applyBeforeScan(input string) []segment {
+	segments := []segment{{text: input}}
+	for _, p := range slices.Concat(fp.beforeScan, fp.builtin) {
+		var next []segment
+		for _, seg := range segments {
+			if seg.placeholder != "" {
+				next = append(next, seg)
+				continue
+			}
+			start := 0
+			for _, loc := range p.re.FindAllStringIndex(seg.text, -1) {
+				if !p.matches(seg.text, loc[0], loc[1]) {
+					continue
+				}
+				if loc[0] > start {
+					next = append(next, segment{text: seg.text[start:loc[0]]})
+				}
+				next = append(next, segment{placeholder: p.placeholder()})
+				start = loc[1]
+			}
+			if start < len(seg.text) {
+				next = append(next, segment{text: seg.text[start:]})
+			}
+		}
+		segments = next
+	}
+	return segments
+}
+
+// matchAfterScan returns the placeholder of the first AfterScan pattern
+// matching the whole token.
+func (fp *fingerprinterImpl) matchAfterScan(literal string) (string, bool) {
+	for _, p := range fp.afterScan {
+		if p.re.MatchString(literal) {
+			return p.placeholder(), true
+		}
+	}
+	return "", false
+}
+
+// ReplaceTokens replaces the text matched by patterns with their
+// placeholders, without tokenizing the rest of the input.  AfterScan
+// patterns must match the whole input.
+func ReplaceTokens(input string, patterns ...TokenPattern) string {
+	for _, p := range patterns {
+		if p.Stage == AfterScan {
+			if p.re.MatchString(input) {
+				input = p.placeholder()
+			}
+			continue
+		}
+		var b strings.Builder
+		start := 0
+		for _, loc := range p.re.FindAllStringIndex(input, -1) {
+			if !p.matches(input, loc[0], loc[1]) {
+				continue
+			}
+			b.WriteString(input[start:loc[0]])
+			b.WriteString(p.placeholder())
+			start = loc[1]
+		}
+		if start > 0 {
+			b.WriteString(input[start:])
+			input = b.String()
+		}
+	}
+	return input
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustTokenPattern(t *testing.T, name string, expr string, stage TokenStage) TokenPattern {
+	p, err := NewTokenPattern(name, expr, stage)
+	require.NoError(t, err)
+	return p
+}
+
+func TestNewTokenPattern(t *testing.T) {
+	_, err := NewTokenPattern("OrderID", `ORD-[0-9]+`, BeforeScan)
+	assert.NoError(t, err)
+	_, err = NewTokenPattern("", `ORD-[0-9]+`, BeforeScan)
+	assert.Error(t, err)
+	_, err = NewTokenPattern("Order ID", `ORD-[0-9]+`, BeforeScan)
+	assert.Error(t, err)
+	_, err = NewTokenPattern("OrderID", `ORD-[0-9+`, AfterScan)
+	assert.Error(t, err)
+}
+
+func TestTokenPatterns(t *testing.T) {
+	fp := NewFingerprinter(WithTokenPatterns(
+		mustTokenPattern(t, "ARN", `arn:aws:[a-z0-9-]+:[a-z0-9-]*:[0-9]*:[A-Za-z0-9/_.:-]+`, BeforeScan),
+		mustTokenPattern(t, "OrderID", `ORD-[0-9]{8}`, BeforeScan),
+		mustTokenPattern(t, "PodName", `[a-z0-9-]+-[a-z0-9]{8,10}-[a-z0-9]{5}`, AfterScan),
+		mustTokenPattern(t, "TempFile", `tmp[A-Za-z0-9]{6}`, AfterScan),
+	))
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			"before scan, inside text",
+			"created order ORD-20240101 for customer",
+			"created order <OrderID> for customer",
+		},
+		{
+			"before scan, text the tokenizer would split",
+			"uploaded to arn:aws:s3:::my-bucket/key.json",
+			"uploaded to <ARN>",
+		},
+		{
+			"after scan, whole identifier",
+			"pod checkout-7d9f8b6c5d-x2k4q deleted",
+			"pod <PodName> deleted",
+		},
+		{
+			"after scan, a string that is not a word",
+			"removed file tmpXk3fQz",
+			"removed file <TempFile>",
+		},
+		{
+			"after scan needs the whole token",
+			"removed file tmpXk3fQz9",
+			"removed file",
+		},
+		{
+			"level still found around placeholders",
+			"ERROR payment failed for ORD-12345678",
+			"<Loglevel> payment failed for <OrderID>",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := fp.Tokenize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestTokenPatternsStabilizeFingerprints(t *testing.T) {
+	// The tokenizer sees different shapes in these IDs and ARNs, so each
+	// line has its own fingerprint until the patterns replace them.
+	lines := []string{
+		"uploaded order ORD-20240101 to arn:aws:s3:::orders/2024/01.json",
+		"uploaded order ORD-2024.0102 to arn:aws:s3:::orders",
+		"uploaded order ORD-2024.0103 to arn:aws:s3:::orders/2024/01.json",
+	}
+	fingerprints := func(fp Fingerprinter) map[int64]bool {
+		ret := map[int64]bool{}
+		for _, line := range lines {
+			fingerprint, _, err := fp.Fingerprint(line)
+			require.NoError(t, err)
+			ret[fingerprint] = true
+		}
+		return ret
+	}
+
+	assert.Len(t, fingerprints(NewFingerprinter()), len(lines))
+	assert.Len(t, fingerprints(NewFingerprinter(WithTokenPatterns(
+		mustTokenPattern(t, "ARN", `arn:aws:[a-z0-9-]+:[a-z0-9-]*:[0-9]*:[A-Za-z0-9/_.:-]+`, BeforeScan),
+		mustTokenPattern(t, "OrderID", `ORD-[0-9.]+`, BeforeScan),
+	))), 1)
+}
+
+func TestReplaceTokens(t *testing.T) {
+	patterns := []TokenPattern{
+		mustTokenPattern(t, "ARN", `arn:aws:[a-z0-9-]+:[a-z0-9-]*:[0-9]*:[A-Za-z0-9/_.:-]+`, BeforeScan),
+		mustTokenPattern(t, "OrderID", `ORD-[0-9]{8}`, BeforeScan),
+		mustTokenPattern(t, "TempFile", `tmp[A-Za-z0-9]{6}`, AfterScan),
+	}
+	assert.Equal(t, "/orders/<OrderID>/refund", ReplaceTokens("/orders/ORD-20240101/refund", patterns...))
+	assert.Equal(t, "/buckets/<ARN>", ReplaceTokens("/buckets/arn:aws:s3:::orders/2024/01.json", patterns...))
+	assert.Equal(t, "<TempFile>", ReplaceTokens("tmpXk3fQz", patterns...))
+	assert.Equal(t, "/tmpXk3fQz", ReplaceTokens("/tmpXk3fQz", patterns...))
+}
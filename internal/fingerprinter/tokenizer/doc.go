@@ -12,6 +12,19 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+// Package tokenizer is the ragel scanner that splits log messages into
+// typed tokens for fingerprinting.  tokenizer.go is generated from
+// tokenizer.rl by runragel.sh and must not be edited by hand.
+//
+// IPv6 addresses, MAC addresses, long hex strings and base64 data are not
+// part of this grammar.  The fingerprinter matches them with regular
+// expressions before the scanner runs (see builtinTokenPatterns in the
+// parent package), and replaces each match with its own placeholder, so
+// the scanner never sees them.  They are kept out of the grammar because
+// they overlap with numbers, identifiers and IPv4 addresses, and would
+// add a great many states to an already large generated scanner.  If
+// they are ever moved into tokenizer.rl, remove the matching built-in
+// patterns at the same time so each class is defined only once.
 package tokenizer
 
 //go:generate sh ./runragel.sh
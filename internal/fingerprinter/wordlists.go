@@ -0,0 +1,85 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// WithWords adds words, such as those of another language, to the
+// English word list.
+func WithWords(words ...string) FingerprinterOption {
+	return func(fp *fingerprinterImpl) {
+		for _, word := range words {
+			fp.wordlist[strings.ToLower(word)] = true
+		}
+	}
+}
+
+// ReadWordList reads a word list with one word per line.  Blank lines
+// and lines starting with # are skipped.
+func ReadWordList(r io.Reader) ([]string, error) {
+	var words []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return words, nil
+}
+
+// LoadWordList reads a word list from a file.
+func LoadWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	words, err := ReadWordList(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading word list %s: %w", path, err)
+	}
+	return words, nil
+}
+
+// isCJKWord returns true if word is written entirely in Chinese, Japanese
+// or Korean script.  These languages do not separate words with spaces, so
+// a run of such characters is taken to be text rather than a value.
+func isCJKWord(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, r := range word {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		case r == 'ー':
+			// The prolonged sound mark is common to hiragana and katakana.
+		default:
+			return false
+		}
+	}
+	return true
+}
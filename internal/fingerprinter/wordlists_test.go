@@ -0,0 +1,119 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprinter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadWordList(t *testing.T) {
+	words, err := ReadWordList(strings.NewReader("# comment\nBenutzer\n\n  datei  \n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Benutzer", "datei"}, words)
+
+	_, err = LoadWordList("testdata/missing.txt")
+	assert.Error(t, err)
+}
+
+func TestWordListFingerprints(t *testing.T) {
+	words, err := LoadWordList("testdata/wordlist-de.txt")
+	require.NoError(t, err)
+	fp := NewFingerprinter(WithWords(words...))
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"Verbindung zur Datenbank fehlgeschlagen nach 3 Versuchen", "verbindung zur datenbank fehlgeschlagen nach <Number> versuchen"},
+		{"Benutzer mueller hat sich angemeldet", "benutzer hat sich angemeldet"},
+		{"Datei /var/lib/app/export.csv konnte nicht gelesen werden", "datei <Path> konnte nicht gelesen werden"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, _, err := fp.Tokenize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCJKWords(t *testing.T) {
+	fp := NewFingerprinter()
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"ユーザー 42 がログインしました", "ユーザー <Number> がログインしました"},
+		{"データベースへの接続に失敗しました: タイムアウト", "データベースへの接続に失敗しました タイムアウト"},
+		{"处理请求时出错 id=5", "处理请求时出错 id <Number>"},
+		{"사용자 42 로그인 실패", "사용자 <Number> 로그인 실패"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, _, err := fp.Tokenize(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.True(t, isCJKWord("接続"))
+	assert.False(t, isCJKWord("接続abc"))
+	assert.False(t, isCJKWord("30秒"))
+	assert.False(t, isCJKWord(""))
+}
+
+func TestNonEnglishFingerprints(t *testing.T) {
+	words, err := LoadWordList("testdata/wordlist-de.txt")
+	require.NoError(t, err)
+	fp := NewFingerprinter(WithWords(words...))
+
+	// Lines in a group differ only in their values, so they should share a
+	// fingerprint, and each group should have its own.
+	groups := [][]string{
+		{
+			"Benutzer mueller hat sich angemeldet",
+			"Benutzer schmidt hat sich angemeldet",
+		},
+		{
+			"Verbindung zur Datenbank fehlgeschlagen nach 3 Versuchen",
+			"Verbindung zur Datenbank fehlgeschlagen nach 5 Versuchen",
+		},
+		{
+			"ユーザー 42 がログインしました",
+			"ユーザー 1337 がログインしました",
+		},
+		{
+			"接続に失敗しました: タイムアウト 30秒",
+			"接続に失敗しました: タイムアウト 45秒",
+		},
+	}
+	seen := map[int64]int{}
+	for i, lines := range groups {
+		for _, line := range lines {
+			fingerprint, _, err := fp.Fingerprint(line)
+			require.NoError(t, err)
+			if group, found := seen[fingerprint]; found {
+				assert.Equal(t, i, group, line)
+			}
+			seen[fingerprint] = i
+		}
+	}
+	assert.Len(t, seen, len(groups))
+}
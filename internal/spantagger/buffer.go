@@ -0,0 +1,220 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spantagger
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// TraceBuffer groups spans by trace until each trace is complete, so the
+// whole trace can be fingerprinted.  A trace is released when its root
+// span arrives, when no span of it has arrived for the timeout, or when
+// the buffer is full and it is the least recently added to.
+//
+// A decision, such as the trace's fingerprint, can be recorded for a
+// released trace.  Spans of the trace arriving later are returned with
+// that decision rather than buffered again.  At most maxTraces decisions
+// are kept, dropping the oldest.
+type TraceBuffer[D any] struct {
+	sync.Mutex
+	maxTraces int
+	timeout   time.Duration
+
+	pending map[pcommon.TraceID]*list.Element
+	// lru holds *BufferedTrace, most recently added to first.
+	lru *list.List
+
+	decided map[pcommon.TraceID]*list.Element
+	// decisions holds *decision[D], most recently decided first.
+	decisions *list.List
+}
+
+// BufferedTrace is the spans of one trace seen so far.
+type BufferedTrace struct {
+	TraceID pcommon.TraceID
+	Traces  ptrace.Traces
+	// HasRoot is true if the root span arrived.
+	HasRoot  bool
+	lastSeen time.Time
+}
+
+// LateSpans are spans of a trace that arrived after a decision was
+// recorded for it.
+type LateSpans[D any] struct {
+	TraceID  pcommon.TraceID
+	Traces   ptrace.Traces
+	Decision D
+}
+
+type decision[D any] struct {
+	traceID pcommon.TraceID
+	value   D
+}
+
+func NewTraceBuffer[D any](maxTraces int, timeout time.Duration) *TraceBuffer[D] {
+	return &TraceBuffer[D]{
+		maxTraces: maxTraces,
+		timeout:   timeout,
+		pending:   map[pcommon.TraceID]*list.Element{},
+		lru:       list.New(),
+		decided:   map[pcommon.TraceID]*list.Element{},
+		decisions: list.New(),
+	}
+}
+
+// Add takes the spans in td.  It returns the traces released, and the
+// spans of traces already decided.
+func (b *TraceBuffer[D]) Add(td ptrace.Traces, now time.Time) (released []*BufferedTrace, late []LateSpans[D]) {
+	b.Lock()
+	defer b.Unlock()
+
+	for _, t := range splitByTrace(td) {
+		if elem, found := b.decided[t.TraceID]; found {
+			late = append(late, LateSpans[D]{TraceID: t.TraceID, Traces: t.Traces, Decision: elem.Value.(*decision[D]).value})
+			continue
+		}
+
+		var bt *BufferedTrace
+		if elem, found := b.pending[t.TraceID]; found {
+			bt = elem.Value.(*BufferedTrace)
+			t.Traces.ResourceSpans().MoveAndAppendTo(bt.Traces.ResourceSpans())
+			bt.HasRoot = bt.HasRoot || t.HasRoot
+			b.lru.MoveToFront(elem)
+		} else {
+			bt = t
+			b.pending[t.TraceID] = b.lru.PushFront(bt)
+		}
+		bt.lastSeen = now
+		if bt.HasRoot {
+			b.remove(bt.TraceID)
+			released = append(released, bt)
+		}
+	}
+
+	for b.lru.Len() > b.maxTraces {
+		bt := b.lru.Back().Value.(*BufferedTrace)
+		b.remove(bt.TraceID)
+		released = append(released, bt)
+	}
+	return released, late
+}
+
+// Decide records the decision for a released trace.
+func (b *TraceBuffer[D]) Decide(traceID pcommon.TraceID, value D) {
+	b.Lock()
+	defer b.Unlock()
+
+	if elem, found := b.decided[traceID]; found {
+		elem.Value.(*decision[D]).value = value
+		b.decisions.MoveToFront(elem)
+		return
+	}
+	b.decided[traceID] = b.decisions.PushFront(&decision[D]{traceID: traceID, value: value})
+	for b.decisions.Len() > b.maxTraces {
+		oldest := b.decisions.Back()
+		b.decisions.Remove(oldest)
+		delete(b.decided, oldest.Value.(*decision[D]).traceID)
+	}
+}
+
+// Expired returns the traces no span has arrived for in the timeout.
+func (b *TraceBuffer[D]) Expired(now time.Time) []*BufferedTrace {
+	b.Lock()
+	defer b.Unlock()
+
+	var released []*BufferedTrace
+	cutoff := now.Add(-b.timeout)
+	for elem := b.lru.Back(); elem != nil; elem = b.lru.Back() {
+		bt := elem.Value.(*BufferedTrace)
+		if bt.lastSeen.After(cutoff) {
+			break
+		}
+		b.remove(bt.TraceID)
+		released = append(released, bt)
+	}
+	return released
+}
+
+// Flush returns all the buffered traces.
+func (b *TraceBuffer[D]) Flush() []*BufferedTrace {
+	b.Lock()
+	defer b.Unlock()
+
+	released := make([]*BufferedTrace, 0, b.lru.Len())
+	for elem := b.lru.Back(); elem != nil; elem = b.lru.Back() {
+		bt := elem.Value.(*BufferedTrace)
+		b.remove(bt.TraceID)
+		released = append(released, bt)
+	}
+	return released
+}
+
+// Len returns the number of traces buffered.
+func (b *TraceBuffer[D]) Len() int {
+	b.Lock()
+	defer b.Unlock()
+	return b.lru.Len()
+}
+
+func (b *TraceBuffer[D]) remove(traceID pcommon.TraceID) {
+	b.lru.Remove(b.pending[traceID])
+	delete(b.pending, traceID)
+}
+
+// splitByTrace copies the spans of td into one ptrace.Traces per trace,
+// keeping their resources and scopes, in the order the traces first
+// appear.
+func splitByTrace(td ptrace.Traces) []*BufferedTrace {
+	var ret []*BufferedTrace
+	byID := map[pcommon.TraceID]*BufferedTrace{}
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			// The scope each trace's spans from ss go to.
+			scopes := map[pcommon.TraceID]ptrace.SpanSlice{}
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+				bt, found := byID[span.TraceID()]
+				if !found {
+					bt = &BufferedTrace{TraceID: span.TraceID(), Traces: ptrace.NewTraces()}
+					byID[span.TraceID()] = bt
+					ret = append(ret, bt)
+				}
+				spans, found := scopes[span.TraceID()]
+				if !found {
+					destRS := bt.Traces.ResourceSpans().AppendEmpty()
+					rs.Resource().CopyTo(destRS.Resource())
+					destRS.SetSchemaUrl(rs.SchemaUrl())
+					destSS := destRS.ScopeSpans().AppendEmpty()
+					ss.Scope().CopyTo(destSS.Scope())
+					destSS.SetSchemaUrl(ss.SchemaUrl())
+					spans = destSS.Spans()
+					scopes[span.TraceID()] = spans
+				}
+				span.CopyTo(spans.AppendEmpty())
+				if span.ParentSpanID().IsEmpty() {
+					bt.HasRoot = true
+				}
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spantagger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+type testSpan struct {
+	trace   byte
+	span    byte
+	parent  byte
+	service string
+}
+
+func makeTraces(spans ...testSpan) ptrace.Traces {
+	td := ptrace.NewTraces()
+	for _, s := range spans {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", s.service)
+		span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID([16]byte{s.trace}))
+		span.SetSpanID(pcommon.SpanID([8]byte{s.span}))
+		if s.parent != 0 {
+			span.SetParentSpanID(pcommon.SpanID([8]byte{s.parent}))
+		}
+		span.SetName("span")
+	}
+	return td
+}
+
+func TestTraceBufferReleasesOnRoot(t *testing.T) {
+	b := NewTraceBuffer[uint64](10, time.Minute)
+	now := time.Now()
+
+	released, late := b.Add(makeTraces(
+		testSpan{trace: 1, span: 2, parent: 1, service: "cart"},
+		testSpan{trace: 2, span: 2, parent: 1, service: "cart"},
+	), now)
+	assert.Empty(t, released)
+	assert.Empty(t, late)
+	assert.Equal(t, 2, b.Len())
+
+	released, _ = b.Add(makeTraces(testSpan{trace: 1, span: 1, service: "frontend"}), now)
+	require.Len(t, released, 1)
+	assert.Equal(t, pcommon.TraceID([16]byte{1}), released[0].TraceID)
+	assert.True(t, released[0].HasRoot)
+	assert.Equal(t, 2, released[0].Traces.SpanCount())
+	assert.Equal(t, 1, b.Len())
+
+	fingerprint, _, err := Fingerprint(released[0].Traces)
+	require.NoError(t, err)
+	b.Decide(released[0].TraceID, fingerprint)
+
+	released, late = b.Add(makeTraces(testSpan{trace: 1, span: 3, parent: 2, service: "db"}), now)
+	assert.Empty(t, released)
+	require.Len(t, late, 1)
+	assert.Equal(t, fingerprint, late[0].Decision)
+	assert.Equal(t, 1, late[0].Traces.SpanCount())
+}
+
+func TestTraceBufferExpired(t *testing.T) {
+	b := NewTraceBuffer[bool](10, time.Minute)
+	now := time.Now()
+
+	b.Add(makeTraces(testSpan{trace: 1, span: 2, parent: 1}), now)
+	b.Add(makeTraces(testSpan{trace: 2, span: 2, parent: 1}), now.Add(30*time.Second))
+
+	assert.Empty(t, b.Expired(now.Add(59*time.Second)))
+	released := b.Expired(now.Add(time.Minute))
+	require.Len(t, released, 1)
+	assert.Equal(t, pcommon.TraceID([16]byte{1}), released[0].TraceID)
+	assert.False(t, released[0].HasRoot)
+
+	released = b.Flush()
+	require.Len(t, released, 1)
+	assert.Equal(t, pcommon.TraceID([16]byte{2}), released[0].TraceID)
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestTraceBufferFull(t *testing.T) {
+	b := NewTraceBuffer[bool](2, time.Minute)
+	now := time.Now()
+
+	b.Add(makeTraces(testSpan{trace: 1, span: 2, parent: 1}), now)
+	b.Add(makeTraces(testSpan{trace: 2, span: 2, parent: 1}), now)
+	b.Add(makeTraces(testSpan{trace: 1, span: 3, parent: 1}), now)
+	released, _ := b.Add(makeTraces(testSpan{trace: 3, span: 2, parent: 1}), now)
+	require.Len(t, released, 1)
+	assert.Equal(t, pcommon.TraceID([16]byte{2}), released[0].TraceID)
+
+	for id := byte(1); id <= 3; id++ {
+		b.Decide(pcommon.TraceID([16]byte{id}), true)
+	}
+	// Only the two most recent decisions are kept.
+	_, late := b.Add(makeTraces(testSpan{trace: 1, span: 4, parent: 1}), now)
+	assert.Empty(t, late)
+	_, late = b.Add(makeTraces(testSpan{trace: 3, span: 4, parent: 1}), now)
+	assert.Len(t, late, 1)
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trigram
+
+import (
+	"math"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// bloomFilter is a fixed-size bloom filter using double hashing of a
+// single 64-bit xxhash, so the hash function is stable across releases
+// and between writers and readers.
+type bloomFilter struct {
+	k    uint64
+	bits []uint64
+}
+
+// newBloomFilter returns a bloom filter sized to hold n items with
+// roughly the given false positive rate.
+func newBloomFilter(n int, falsePositiveRate float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	m := math.Ceil(-float64(n) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round(m / float64(n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+	words := (uint64(m) + 63) / 64
+	return &bloomFilter{
+		k:    uint64(k),
+		bits: make([]uint64, words),
+	}
+}
+
+func (bf *bloomFilter) nbits() uint64 {
+	return uint64(len(bf.bits)) * 64
+}
+
+func (bf *bloomFilter) locations(data []byte) (uint64, uint64) {
+	h := xxhash.Sum64(data)
+	return h & 0xffffffff, (h >> 32) | 1
+}
+
+func (bf *bloomFilter) add(data []byte) {
+	h1, h2 := bf.locations(data)
+	n := bf.nbits()
+	for i := uint64(0); i < bf.k; i++ {
+		bit := (h1 + i*h2) % n
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (bf *bloomFilter) mayContain(data []byte) bool {
+	n := bf.nbits()
+	if n == 0 {
+		return true
+	}
+	h1, h2 := bf.locations(data)
+	for i := uint64(0); i < bf.k; i++ {
+		bit := (h1 + i*h2) % n
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,265 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trigram
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// An index summarizes the contents of one log file so a query engine can
+// skip files that cannot match a search.  It holds the exact set of
+// trigrams found in message bodies, and bloom filters of tag values and
+// fingerprints.  Lookups may return false positives but never false
+// negatives.
+//
+// The serialized form is:
+//
+//	magic "CHQX", version byte
+//	uvarint trigram count, followed by that many sorted 3-byte trigrams
+//	bloom filter of tags
+//	bloom filter of fingerprints
+//
+// where each bloom filter is a uvarint hash count, a uvarint word count,
+// and that many little-endian uint64 words.
+
+const (
+	indexMagic   = "CHQX"
+	indexVersion = 1
+
+	// DefaultFalsePositiveRate is the bloom filter false positive rate
+	// used when none is given.
+	DefaultFalsePositiveRate = 0.01
+)
+
+// ErrBadIndex is returned when reading data that is not an index.
+var ErrBadIndex = errors.New("not a trigram index")
+
+// IndexBuilder collects the contents of a log file for an index.
+type IndexBuilder struct {
+	falsePositiveRate float64
+	trigrams          map[string]struct{}
+	tags              map[string]struct{}
+	fingerprints      map[int64]struct{}
+}
+
+// NewIndexBuilder returns an empty builder whose bloom filters aim for
+// the given false positive rate, or DefaultFalsePositiveRate if it is
+// not between 0 and 1.
+func NewIndexBuilder(falsePositiveRate float64) *IndexBuilder {
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = DefaultFalsePositiveRate
+	}
+	return &IndexBuilder{
+		falsePositiveRate: falsePositiveRate,
+		trigrams:          map[string]struct{}{},
+		tags:              map[string]struct{}{},
+		fingerprints:      map[int64]struct{}{},
+	}
+}
+
+// AddMessage adds the trigrams of a message body.
+func (b *IndexBuilder) AddMessage(s string) {
+	for _, tg := range ToTrigrams(s) {
+		if len(tg) == 3 {
+			b.trigrams[tg] = struct{}{}
+		}
+	}
+}
+
+// AddTag adds a tag value, and marks the tag as present.
+func (b *IndexBuilder) AddTag(key, value string) {
+	b.tags[tagEntry(key, value)] = struct{}{}
+	b.AddField(key)
+}
+
+// AddField marks a tag as present without recording its value.
+func (b *IndexBuilder) AddField(key string) {
+	b.tags[tagEntry(key, FieldExistsMarker)] = struct{}{}
+}
+
+// AddFingerprint adds a log fingerprint.
+func (b *IndexBuilder) AddFingerprint(fp int64) {
+	b.fingerprints[fp] = struct{}{}
+}
+
+// Build returns the index for everything added so far.
+func (b *IndexBuilder) Build() *Index {
+	trigrams := make([]string, 0, len(b.trigrams))
+	for tg := range b.trigrams {
+		trigrams = append(trigrams, tg)
+	}
+	slices.Sort(trigrams)
+
+	tags := newBloomFilter(len(b.tags), b.falsePositiveRate)
+	for tag := range b.tags {
+		tags.add([]byte(tag))
+	}
+
+	fingerprints := newBloomFilter(len(b.fingerprints), b.falsePositiveRate)
+	for fp := range b.fingerprints {
+		fingerprints.add(fingerprintBytes(fp))
+	}
+
+	return &Index{
+		trigrams:     []byte(strings.Join(trigrams, "")),
+		tags:         tags,
+		fingerprints: fingerprints,
+	}
+}
+
+// Index is a searchable summary of a log file.
+type Index struct {
+	// trigrams is the sorted set of trigrams, concatenated.
+	trigrams     []byte
+	tags         *bloomFilter
+	fingerprints *bloomFilter
+}
+
+// MayContainSubstring returns false if no message in the file can
+// contain s.  Strings shorter than a trigram always may match.
+func (idx *Index) MayContainSubstring(s string) bool {
+	for _, tg := range ToTrigrams(s) {
+		if len(tg) == 3 && !idx.hasTrigram(tg) {
+			return false
+		}
+	}
+	return true
+}
+
+// MayContainTag returns false if no record in the file has the tag
+// set to value.
+func (idx *Index) MayContainTag(key, value string) bool {
+	return idx.tags.mayContain([]byte(tagEntry(key, value)))
+}
+
+// MayHaveField returns false if no record in the file has the tag set.
+func (idx *Index) MayHaveField(key string) bool {
+	return idx.MayContainTag(key, FieldExistsMarker)
+}
+
+// MayContainFingerprint returns false if no record in the file has
+// the fingerprint.
+func (idx *Index) MayContainFingerprint(fp int64) bool {
+	return idx.fingerprints.mayContain(fingerprintBytes(fp))
+}
+
+func (idx *Index) hasTrigram(tg string) bool {
+	n := len(idx.trigrams) / 3
+	i := sort.Search(n, func(i int) bool {
+		return string(idx.trigrams[i*3:i*3+3]) >= tg
+	})
+	return i < n && string(idx.trigrams[i*3:i*3+3]) == tg
+}
+
+// WriteTo writes the serialized index to w.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	buf.WriteString(indexMagic)
+	buf.WriteByte(indexVersion)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(idx.trigrams)/3)))
+	buf.Write(idx.trigrams)
+	writeBloom(&buf, idx.tags)
+	writeBloom(&buf, idx.fingerprints)
+	return buf.WriteTo(w)
+}
+
+// ReadIndex reads an index written by Index.WriteTo.
+func ReadIndex(r io.Reader) (*Index, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(indexMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadIndex, err)
+	}
+	if string(header[:len(indexMagic)]) != indexMagic {
+		return nil, ErrBadIndex
+	}
+	if header[len(indexMagic)] != indexVersion {
+		return nil, fmt.Errorf("unsupported trigram index version %d", header[len(indexMagic)])
+	}
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading trigram count: %w", err)
+	}
+	if count > 1<<24 {
+		return nil, fmt.Errorf("%w: trigram count %d too large", ErrBadIndex, count)
+	}
+	trigrams := make([]byte, count*3)
+	if _, err := io.ReadFull(br, trigrams); err != nil {
+		return nil, fmt.Errorf("reading trigrams: %w", err)
+	}
+
+	tags, err := readBloom(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading tag filter: %w", err)
+	}
+	fingerprints, err := readBloom(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading fingerprint filter: %w", err)
+	}
+
+	return &Index{
+		trigrams:     trigrams,
+		tags:         tags,
+		fingerprints: fingerprints,
+	}, nil
+}
+
+func writeBloom(buf *bytes.Buffer, bf *bloomFilter) {
+	buf.Write(binary.AppendUvarint(nil, bf.k))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(bf.bits))))
+	for _, word := range bf.bits {
+		buf.Write(binary.LittleEndian.AppendUint64(nil, word))
+	}
+}
+
+func readBloom(br *bufio.Reader) (*bloomFilter, error) {
+	k, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	words, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+	if k > 64 || words > 1<<26 {
+		return nil, ErrBadIndex
+	}
+	bf := &bloomFilter{k: k, bits: make([]uint64, words)}
+	word := make([]byte, 8)
+	for i := range bf.bits {
+		if _, err := io.ReadFull(br, word); err != nil {
+			return nil, err
+		}
+		bf.bits[i] = binary.LittleEndian.Uint64(word)
+	}
+	return bf, nil
+}
+
+func tagEntry(key, value string) string {
+	return key + "=" + value
+}
+
+func fingerprintBytes(fp int64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(fp))
+}
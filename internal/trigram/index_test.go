@@ -0,0 +1,146 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trigram
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func roundTrip(t *testing.T, b *IndexBuilder) *Index {
+	t.Helper()
+	var buf bytes.Buffer
+	_, err := b.Build().WriteTo(&buf)
+	require.NoError(t, err)
+	idx, err := ReadIndex(&buf)
+	require.NoError(t, err)
+	return idx
+}
+
+func TestIndexSubstrings(t *testing.T) {
+	messages := []string{
+		"GET /api/v1/users/1234 returned 200 in 15ms",
+		"connection reset by peer",
+		"Ünïcödé message with ✓ marks",
+	}
+	b := NewIndexBuilder(0)
+	for _, msg := range messages {
+		b.AddMessage(msg)
+	}
+	idx := roundTrip(t, b)
+
+	// Every substring of every message must be found.
+	for _, msg := range messages {
+		for i := 0; i < len(msg); i++ {
+			for j := i + 1; j <= len(msg); j++ {
+				assert.True(t, idx.MayContainSubstring(msg[i:j]), "substring %q of %q", msg[i:j], msg)
+			}
+		}
+	}
+
+	assert.False(t, idx.MayContainSubstring("timeout"))
+	assert.False(t, idx.MayContainSubstring("/api/v2/"))
+	// Too short to rule out.
+	assert.True(t, idx.MayContainSubstring("zz"))
+}
+
+func TestIndexTags(t *testing.T) {
+	b := NewIndexBuilder(0)
+	b.AddTag("resource.service.name", "checkout")
+	b.AddTag("resource.service.name", "payments")
+	b.AddTag("log.level", "error")
+	b.AddField("log.count")
+	idx := roundTrip(t, b)
+
+	assert.True(t, idx.MayContainTag("resource.service.name", "checkout"))
+	assert.True(t, idx.MayContainTag("resource.service.name", "payments"))
+	assert.True(t, idx.MayContainTag("log.level", "error"))
+	assert.True(t, idx.MayHaveField("resource.service.name"))
+	assert.True(t, idx.MayHaveField("log.level"))
+	assert.True(t, idx.MayHaveField("log.count"))
+
+	assert.False(t, idx.MayContainTag("resource.service.name", "shipping"))
+	assert.False(t, idx.MayHaveField("resource.k8s.pod.name"))
+}
+
+func TestIndexFingerprints(t *testing.T) {
+	b := NewIndexBuilder(0)
+	for _, fp := range []int64{0, 1, -1, 1234567890123} {
+		b.AddFingerprint(fp)
+	}
+	idx := roundTrip(t, b)
+	for _, fp := range []int64{0, 1, -1, 1234567890123} {
+		assert.True(t, idx.MayContainFingerprint(fp))
+	}
+	assert.False(t, idx.MayContainFingerprint(42))
+}
+
+func TestIndexNoFalseNegativesAtScale(t *testing.T) {
+	const n = 20000
+	rng := rand.New(rand.NewSource(1))
+	b := NewIndexBuilder(0.01)
+	fps := make([]int64, n)
+	tags := make([]string, n)
+	for i := range fps {
+		fps[i] = rng.Int63()
+		tags[i] = fmt.Sprintf("pod-%d", rng.Int63())
+		b.AddFingerprint(fps[i])
+		b.AddTag("resource.k8s.pod.name", tags[i])
+	}
+	idx := roundTrip(t, b)
+
+	for i := range fps {
+		require.True(t, idx.MayContainFingerprint(fps[i]))
+		require.True(t, idx.MayContainTag("resource.k8s.pod.name", tags[i]))
+	}
+
+	// The false positive rate should be in the neighbourhood of what was asked for.
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if idx.MayContainFingerprint(rng.Int63()) {
+			falsePositives++
+		}
+	}
+	assert.Less(t, falsePositives, n*3/100)
+}
+
+func TestIndexEmpty(t *testing.T) {
+	idx := roundTrip(t, NewIndexBuilder(0))
+	assert.False(t, idx.MayContainSubstring("abc"))
+	assert.True(t, idx.MayContainSubstring(""))
+	assert.False(t, idx.MayHaveField("anything"))
+	assert.False(t, idx.MayContainFingerprint(1))
+}
+
+func TestReadIndexErrors(t *testing.T) {
+	_, err := ReadIndex(bytes.NewReader(nil))
+	assert.ErrorIs(t, err, ErrBadIndex)
+
+	_, err = ReadIndex(bytes.NewReader([]byte("PAR1xxxx")))
+	assert.ErrorIs(t, err, ErrBadIndex)
+
+	var buf bytes.Buffer
+	b := NewIndexBuilder(0)
+	b.AddMessage("hello world")
+	_, err = b.Build().WriteTo(&buf)
+	require.NoError(t, err)
+	_, err = ReadIndex(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	assert.Error(t, err)
+}
@@ -16,21 +16,24 @@ package aggregationprocessor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/otel/attribute"
+	otelmetric "go.opentelemetry.io/otel/metric"
 	"go.uber.org/zap"
 
 	"github.com/cardinalhq/oteltools/pkg/ottl"
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+	"github.com/cardinalhq/oteltools/pkg/translate"
 )
 
+// emit sends on every aggregate whose interval closed before now.
 func (e *aggregationProcessor) emit(now time.Time) {
-	if now.Sub(e.lastEmitCheck) < time.Duration(e.aggregationInterval)*time.Second {
-		return
-	}
-	e.lastEmitCheck = now
 	mi := e.aggregatorI.Emit(now)
 	for _, set := range mi {
 		e.emitSetI(set)
@@ -39,6 +42,16 @@ func (e *aggregationProcessor) emit(now time.Time) {
 	for _, set := range mf {
 		e.emitSetF(set)
 	}
+	for _, set := range e.histograms.emit(now) {
+		e.emitHistogramSet(set)
+	}
+	for _, set := range e.expHistograms.emit(now) {
+		e.emitHistogramSet(set)
+	}
+	for _, set := range e.reducers.emit(now) {
+		e.emitReducerSet(set)
+	}
+	e.limiter.expire(now)
 }
 
 func (e *aggregationProcessor) emitSetI(set *ottl.AggregationSet[int64]) {
@@ -65,7 +78,7 @@ func (e *aggregationProcessor) emitSetI(set *ottl.AggregationSet[int64]) {
 		dp.SetStartTimestamp(ts)
 		dp.SetIntValue(agg.Value()[0])
 
-		setTags(res, sm, m, dp, agg.Tags())
+		setTags(res, sm, m, dp.Attributes(), agg.Tags())
 
 		for k, v := range e.additionalAttributes {
 			dp.Attributes().PutStr(k, v)
@@ -102,7 +115,7 @@ func (e *aggregationProcessor) emitSetF(set *ottl.AggregationSet[float64]) {
 		dp.SetStartTimestamp(ts)
 		dp.SetDoubleValue(agg.Value()[0])
 
-		setTags(res, sm, m, dp, agg.Tags())
+		setTags(res, sm, m, dp.Attributes(), agg.Tags())
 
 		for k, v := range e.additionalAttributes {
 			dp.Attributes().PutStr(k, v)
@@ -116,7 +129,7 @@ func (e *aggregationProcessor) emitSetF(set *ottl.AggregationSet[float64]) {
 	}
 }
 
-func setTags(res pmetric.ResourceMetrics, sm pmetric.ScopeMetrics, metric pmetric.Metric, dp pmetric.NumberDataPoint, tags map[string]string) {
+func setTags(res pmetric.ResourceMetrics, sm pmetric.ScopeMetrics, metric pmetric.Metric, dpattrs pcommon.Map, tags map[string]string) {
 	for k, v := range tags {
 		section, tagname := ottl.SplitTag(k)
 		switch section {
@@ -125,8 +138,12 @@ func setTags(res pmetric.ResourceMetrics, sm pmetric.ScopeMetrics, metric pmetri
 		case "instrumentation":
 			sm.Scope().Attributes().PutStr(tagname, v)
 		case "metric":
-			dp.Attributes().PutStr(tagname, v)
+			dpattrs.PutStr(tagname, v)
 		case "metadata":
+			if tagname == overflowMetadataKey {
+				dpattrs.PutBool(overflowAttribute, true)
+				continue
+			}
 			setMetadata(res, sm, metric, tagname, v)
 		}
 	}
@@ -171,14 +188,22 @@ func setMetricMetadata(metric pmetric.Metric, tagname string, v string) {
 	case "unit":
 		metric.SetUnit(v)
 	case "aggregationtemporality":
-		if metric.Type() != pmetric.MetricTypeSum {
-			return
-		}
+		var temporality pmetric.AggregationTemporality
 		switch v {
 		case "cumulative":
-			metric.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+			temporality = pmetric.AggregationTemporalityCumulative
 		case "delta":
-			metric.Sum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+			temporality = pmetric.AggregationTemporalityDelta
+		default:
+			return
+		}
+		switch metric.Type() {
+		case pmetric.MetricTypeSum:
+			metric.Sum().SetAggregationTemporality(temporality)
+		case pmetric.MetricTypeHistogram:
+			metric.Histogram().SetAggregationTemporality(temporality)
+		case pmetric.MetricTypeExponentialHistogram:
+			metric.ExponentialHistogram().SetAggregationTemporality(temporality)
 		}
 	case "ismonotonic":
 		if metric.Type() != pmetric.MetricTypeSum {
@@ -209,9 +234,80 @@ func (e *aggregationProcessor) aggregateGaugeDatapoint(rms pmetric.ResourceMetri
 		"metric.description":        metric.Description(),
 		"metric.unit":               metric.Unit(),
 	}
+	if function, ok := matchRule(e.rules, metric.Name()); ok {
+		return e.reduceDatapoint(function, rms, ils, metric, dp, metadata)
+	}
 	return e.aggregateDatapoint(ottl.AggregationTypeAvg, rms, ils, metric, dp, metadata)
 }
 
+func (e *aggregationProcessor) reduceDatapoint(
+	function reducerFunction,
+	rms pmetric.ResourceMetrics,
+	ils pmetric.ScopeMetrics,
+	metric pmetric.Metric,
+	dp pmetric.NumberDataPoint,
+	metadata map[string]string,
+) bool {
+	var v float64
+	switch dp.ValueType() {
+	case pmetric.NumberDataPointValueTypeInt:
+		v = float64(dp.IntValue())
+	case pmetric.NumberDataPointValueTypeDouble:
+		v = dp.DoubleValue()
+	default:
+		return false
+	}
+	t := dp.Timestamp().AsTime()
+	tags := datapointTags(metadata, rms.Resource().Attributes(), ils.Scope().Attributes(), dp.Attributes())
+	switch e.limitSeries(t, metric.Name(), tags) {
+	case seriesPassthrough:
+		return false
+	case seriesDrop:
+		return true
+	case seriesOverflow:
+		tags = overflowTags(metadata)
+	}
+	isInt := dp.ValueType() == pmetric.NumberDataPointValueTypeInt
+	if err := e.reducers.add(t, metric.Name(), tags, function, v, isInt); err != nil {
+		e.logger.Debug("Not reducing gauge datapoint", zap.String("name", metric.Name()), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+func (e *aggregationProcessor) emitReducerSet(set *reducerSet) {
+	for _, agg := range set.Aggregations {
+		mmetrics := pmetric.NewMetrics()
+		res := mmetrics.ResourceMetrics().AppendEmpty()
+		sm := res.ScopeMetrics().AppendEmpty()
+		m := sm.Metrics().AppendEmpty()
+		m.SetName(agg.name)
+
+		dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+		ts := pcommon.NewTimestampFromTime(time.UnixMilli(set.StartTime))
+		dp.SetTimestamp(ts)
+		dp.SetStartTimestamp(ts)
+		r := agg.reducer
+		if r.isInt() {
+			dp.SetIntValue(int64(r.value()))
+		} else {
+			dp.SetDoubleValue(r.value())
+		}
+
+		setTags(res, sm, m, dp.Attributes(), agg.tags)
+		dp.Attributes().PutStr(cardinalFieldAggregation, string(r.function))
+
+		for k, v := range e.additionalAttributes {
+			dp.Attributes().PutStr(k, v)
+		}
+
+		err := e.nextMetricReceiver.ConsumeMetrics(context.Background(), mmetrics)
+		if err != nil {
+			e.logger.Error("Error emitting metrics", zap.Error(err))
+		}
+	}
+}
+
 func (e *aggregationProcessor) aggregateSumDatapoint(rms pmetric.ResourceMetrics, ils pmetric.ScopeMetrics, metric pmetric.Metric, dp pmetric.NumberDataPoint) bool {
 	metadata := map[string]string{
 		"resource.schemaurl":            rms.SchemaUrl(),
@@ -227,6 +323,134 @@ func (e *aggregationProcessor) aggregateSumDatapoint(rms pmetric.ResourceMetrics
 	return e.aggregateDatapoint(ottl.AggregationTypeSum, rms, ils, metric, dp, metadata)
 }
 
+func histogramMetadata(rms pmetric.ResourceMetrics, ils pmetric.ScopeMetrics, metric pmetric.Metric, temporality pmetric.AggregationTemporality) map[string]string {
+	return map[string]string{
+		"resource.schemaurl":            rms.SchemaUrl(),
+		"instrumentation.schemaurl":     ils.SchemaUrl(),
+		"instrumentation.name":          ils.Scope().Name(),
+		"instrumentation.version":       ils.Scope().Version(),
+		"metric.name":                   metric.Name(),
+		"metric.description":            metric.Description(),
+		"metric.aggregationtemporality": strings.ToLower(temporality.String()),
+		"metric.unit":                   metric.Unit(),
+	}
+}
+
+func (e *aggregationProcessor) aggregateHistogramDatapoint(rms pmetric.ResourceMetrics, ils pmetric.ScopeMetrics, metric pmetric.Metric, dp pmetric.HistogramDataPoint) bool {
+	// Each cumulative datapoint repeats every count before it, so adding
+	// them up would count the same observations more than once.
+	if metric.Histogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative {
+		e.logger.Debug("Not aggregating cumulative histogram", zap.String("name", metric.Name()))
+		return false
+	}
+	metadata := histogramMetadata(rms, ils, metric, metric.Histogram().AggregationTemporality())
+	t := dp.Timestamp().AsTime()
+	tags := datapointTags(metadata, rms.Resource().Attributes(), ils.Scope().Attributes(), dp.Attributes())
+	switch e.limitSeries(t, metric.Name(), tags) {
+	case seriesPassthrough:
+		return false
+	case seriesDrop:
+		return true
+	case seriesOverflow:
+		tags = overflowTags(metadata)
+	}
+	err := e.histograms.addExplicit(t, metric.Name(), tags, dp)
+	switch {
+	case errors.Is(err, errHistogramBoundsMismatch):
+		telemetry.CounterAdd(e.boundsMismatches, 1, otelmetric.WithAttributes(
+			attribute.String("metric_name", metric.Name())))
+		e.logger.Debug("Histogram bounds differ from the aggregate, passing through", zap.String("name", metric.Name()))
+		return false
+	case err != nil:
+		e.logger.Debug("Not aggregating histogram datapoint", zap.String("name", metric.Name()), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+func (e *aggregationProcessor) aggregateExponentialHistogramDatapoint(rms pmetric.ResourceMetrics, ils pmetric.ScopeMetrics, metric pmetric.Metric, dp pmetric.ExponentialHistogramDataPoint) bool {
+	if metric.ExponentialHistogram().AggregationTemporality() == pmetric.AggregationTemporalityCumulative {
+		e.logger.Debug("Not aggregating cumulative exponential histogram", zap.String("name", metric.Name()))
+		return false
+	}
+	metadata := histogramMetadata(rms, ils, metric, metric.ExponentialHistogram().AggregationTemporality())
+	t := dp.Timestamp().AsTime()
+	tags := datapointTags(metadata, rms.Resource().Attributes(), ils.Scope().Attributes(), dp.Attributes())
+	switch e.limitSeries(t, metric.Name(), tags) {
+	case seriesPassthrough:
+		return false
+	case seriesDrop:
+		return true
+	case seriesOverflow:
+		tags = overflowTags(metadata)
+	}
+	e.expHistograms.addExponential(t, metric.Name(), tags, dp)
+	return true
+}
+
+func (e *aggregationProcessor) emitHistogramSet(set *histogramSet) {
+	for _, agg := range set.Aggregations {
+		mmetrics := pmetric.NewMetrics()
+		res := mmetrics.ResourceMetrics().AppendEmpty()
+		sm := res.ScopeMetrics().AppendEmpty()
+		m := sm.Metrics().AppendEmpty()
+		m.SetName(agg.name)
+
+		ts := pcommon.NewTimestampFromTime(time.UnixMilli(set.StartTime))
+		var dpattrs pcommon.Map
+		if agg.explicit != nil {
+			h := agg.explicit
+			m.SetEmptyHistogram()
+			m.Histogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+			dp := m.Histogram().DataPoints().AppendEmpty()
+			dp.SetTimestamp(ts)
+			dp.SetStartTimestamp(ts)
+			dp.ExplicitBounds().FromRaw(h.bounds)
+			dp.BucketCounts().FromRaw(h.bucketCounts)
+			setHistogramStats(h.histogramStats, dp.SetCount, dp.SetSum, dp.SetMin, dp.SetMax)
+			dpattrs = dp.Attributes()
+		} else {
+			h := agg.exponential
+			m.SetEmptyExponentialHistogram()
+			m.ExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+			dp := m.ExponentialHistogram().DataPoints().AppendEmpty()
+			dp.SetTimestamp(ts)
+			dp.SetStartTimestamp(ts)
+			dp.SetScale(h.scale)
+			dp.SetZeroCount(h.zeroCount)
+			dp.SetZeroThreshold(h.zeroThreshold)
+			setExponentialBuckets(dp.Positive(), h.positive)
+			setExponentialBuckets(dp.Negative(), h.negative)
+			setHistogramStats(h.histogramStats, dp.SetCount, dp.SetSum, dp.SetMin, dp.SetMax)
+			dpattrs = dp.Attributes()
+		}
+
+		setTags(res, sm, m, dpattrs, agg.tags)
+
+		for k, v := range e.additionalAttributes {
+			dpattrs.PutStr(k, v)
+		}
+
+		err := e.nextMetricReceiver.ConsumeMetrics(context.Background(), mmetrics)
+		if err != nil {
+			e.logger.Error("Error emitting metrics", zap.Error(err))
+		}
+	}
+}
+
+func setHistogramStats(s histogramStats, setCount func(uint64), setSum, setMin, setMax func(float64)) {
+	setCount(s.count)
+	if s.hasSum {
+		setSum(s.sum)
+	}
+	if s.hasMin {
+		setMin(s.min)
+	}
+	if s.hasMax {
+		setMax(s.max)
+	}
+}
+
 func (e *aggregationProcessor) aggregateDatapoint(
 	ty ottl.AggregationType,
 	rms pmetric.ResourceMetrics,
@@ -236,6 +460,19 @@ func (e *aggregationProcessor) aggregateDatapoint(
 	metadata map[string]string,
 ) bool {
 	t := dp.Timestamp().AsTime()
+	rattr, iattr, mattr := rms.Resource().Attributes(), ils.Scope().Attributes(), dp.Attributes()
+	if _, ok := mattr.Get(translate.CardinalFieldAggregate); ok {
+		switch e.limitSeries(t, metric.Name(), datapointTags(metadata, rattr, iattr, mattr)) {
+		case seriesPassthrough:
+			return false
+		case seriesDrop:
+			return true
+		case seriesOverflow:
+			metadata = overflowMetadata(metadata)
+			rattr, iattr, mattr = pcommon.NewMap(), pcommon.NewMap(), pcommon.NewMap()
+			mattr.PutBool(translate.CardinalFieldAggregate, true)
+		}
+	}
 	switch dp.ValueType() {
 	case pmetric.NumberDataPointValueTypeInt:
 		v := dp.IntValue()
@@ -247,9 +484,9 @@ func (e *aggregationProcessor) aggregateDatapoint(
 			ty,
 			metric.Name(),
 			metadata,
-			rms.Resource().Attributes(),
-			ils.Scope().Attributes(),
-			dp.Attributes())
+			rattr,
+			iattr,
+			mattr)
 		if err != nil {
 			e.logger.Error("Error matching and adding int datapoint", zap.Error(err))
 			return false
@@ -266,9 +503,9 @@ func (e *aggregationProcessor) aggregateDatapoint(
 			ty,
 			metric.Name(),
 			metadata,
-			rms.Resource().Attributes(),
-			ils.Scope().Attributes(),
-			dp.Attributes())
+			rattr,
+			iattr,
+			mattr)
 		if err != nil {
 			e.logger.Error("Error matching and adding float64 datapoint", zap.Error(err))
 			return false
@@ -278,3 +515,49 @@ func (e *aggregationProcessor) aggregateDatapoint(
 		return false
 	}
 }
+
+type seriesAction int
+
+const (
+	seriesAggregate seriesAction = iota
+	seriesPassthrough
+	seriesDrop
+	seriesOverflow
+)
+
+// limitSeries decides what to do with a datapoint of the series with the
+// given tags, once the series limit for its interval may have been hit.
+func (e *aggregationProcessor) limitSeries(t time.Time, metricName string, tags map[string]string) seriesAction {
+	if e.limiter.admit(t, ottl.FingerprintTags(tags)) {
+		return seriesAggregate
+	}
+	telemetry.CounterAdd(e.seriesOverflows, 1, otelmetric.WithAttributes(
+		attribute.String("metric_name", metricName),
+		attribute.String("action", e.overflow)))
+	switch e.overflow {
+	case overflowDrop:
+		return seriesDrop
+	case overflowFold:
+		return seriesOverflow
+	default:
+		return seriesPassthrough
+	}
+}
+
+// overflowMetadata returns a copy of metadata marked as belonging to the
+// overflow series.
+func overflowMetadata(metadata map[string]string) map[string]string {
+	ret := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		ret[k] = v
+	}
+	ret[overflowMetadataKey] = "true"
+	return ret
+}
+
+// overflowTags returns the tags of the overflow series, which keeps the
+// metric's metadata but none of its attributes.
+func overflowTags(metadata map[string]string) map[string]string {
+	empty := pcommon.NewMap()
+	return datapointTags(overflowMetadata(metadata), empty, empty, empty)
+}
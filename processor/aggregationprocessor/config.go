@@ -16,6 +16,8 @@ package aggregationprocessor
 
 import (
 	"errors"
+	"fmt"
+	"regexp"
 	"time"
 
 	"go.uber.org/multierr"
@@ -28,6 +30,23 @@ type Config struct {
 
 type MetricAggregationConfig struct {
 	Interval time.Duration `mapstructure:"interval"`
+	// Rules choose how gauges are reduced.  The first rule that matches a
+	// gauge's name is used, and gauges no rule matches are averaged.
+	Rules []AggregationRule `mapstructure:"rules"`
+	// MaxSeries limits how many distinct series are aggregated in each
+	// interval.  0 means no limit.
+	MaxSeries int `mapstructure:"max_series"`
+	// Overflow is what happens to datapoints of new series once the
+	// limit is reached: "passthrough", "drop", or "overflow".
+	Overflow string `mapstructure:"overflow"`
+}
+
+// AggregationRule applies a reducer function to gauges matching either
+// an exact metric name or a regular expression.
+type AggregationRule struct {
+	MetricName    string `mapstructure:"metric_name"`
+	MetricPattern string `mapstructure:"metric_pattern"`
+	Function      string `mapstructure:"function"`
 }
 
 func (c *Config) Validate() error {
@@ -42,6 +61,37 @@ func (c *MetricAggregationConfig) Validate() error {
 	if c.Interval < 1*time.Second {
 		errs = multierr.Append(errs, errors.New("interval must be greater than or equal to 1s"))
 	}
+	if c.MaxSeries < 0 {
+		errs = multierr.Append(errs, errors.New("max_series must be greater than or equal to 0"))
+	}
+	switch c.Overflow {
+	case overflowPassthrough, overflowDrop, overflowFold:
+	default:
+		errs = multierr.Append(errs, errors.New("overflow must be one of 'passthrough', 'drop', or 'overflow'"))
+	}
+	for i, rule := range c.Rules {
+		if err := rule.Validate(); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("rules[%d]: %w", i, err))
+		}
+	}
+
+	return errs
+}
+
+func (r *AggregationRule) Validate() error {
+	var errs error
+
+	if (r.MetricName == "") == (r.MetricPattern == "") {
+		errs = multierr.Append(errs, errors.New("exactly one of metric_name or metric_pattern must be set"))
+	}
+	if r.MetricPattern != "" {
+		if _, err := regexp.Compile(r.MetricPattern); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("invalid metric_pattern: %w", err))
+		}
+	}
+	if _, ok := reducerFunctions[r.Function]; !ok {
+		errs = multierr.Append(errs, fmt.Errorf("unknown function %q", r.Function))
+	}
 
 	return errs
 }
@@ -63,7 +63,37 @@ func TestConfig(t *testing.T) {
 	expected := &Config{
 		MetricAggregation: MetricAggregationConfig{
 			Interval: 10 * time.Second,
+			Overflow: "passthrough",
 		},
 	}
 	assert.Equal(t, expected, e)
 }
+
+func TestAggregationRuleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AggregationRule
+		wantErr bool
+	}{
+		{"name", AggregationRule{MetricName: "cpu", Function: "avg"}, false},
+		{"pattern", AggregationRule{MetricPattern: "^queue\\.", Function: "p99"}, false},
+		{"neither", AggregationRule{Function: "max"}, true},
+		{"both", AggregationRule{MetricName: "cpu", MetricPattern: "cpu", Function: "max"}, true},
+		{"bad pattern", AggregationRule{MetricPattern: "(", Function: "max"}, true},
+		{"unknown function", AggregationRule{MetricName: "cpu", Function: "median"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	cfg := createDefaultConfig().(*Config)
+	cfg.MetricAggregation.Rules = []AggregationRule{{MetricName: "cpu", Function: "mode"}}
+	assert.ErrorContains(t, cfg.Validate(), `rules[0]: unknown function "mode"`)
+}
@@ -43,6 +43,7 @@ func createDefaultConfig() component.Config {
 	return &Config{
 		MetricAggregation: MetricAggregationConfig{
 			Interval: defaultMetricAggregation,
+			Overflow: overflowPassthrough,
 		},
 	}
 }
@@ -55,5 +56,7 @@ func createMetricsProcessor(ctx context.Context, set processor.Settings, cfg com
 	return processorhelper.NewMetrics(
 		ctx, set, cfg, nextConsumer,
 		e.ConsumeMetrics,
-		processorhelper.WithCapabilities(e.Capabilities()))
+		processorhelper.WithCapabilities(e.Capabilities()),
+		processorhelper.WithStart(e.Start),
+		processorhelper.WithShutdown(e.Shutdown))
 }
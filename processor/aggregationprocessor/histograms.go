@@ -0,0 +1,287 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+)
+
+var (
+	errHistogramBoundsMismatch = errors.New("histogram bucket bounds do not match")
+	errInvalidHistogram        = errors.New("histogram bucket counts do not match its bounds")
+)
+
+// maxExponentialBuckets is the most buckets of each sign a merged
+// exponential histogram may have before it is downscaled, matching the
+// OpenTelemetry SDK default.
+const maxExponentialBuckets = 160
+
+// histogramStats holds the count, sum, min, and max shared by both kinds
+// of histogram.  The sum, min, and max are only valid if every merged
+// datapoint had them.
+type histogramStats struct {
+	count   uint64
+	sum     float64
+	min     float64
+	max     float64
+	hasSum  bool
+	hasMin  bool
+	hasMax  bool
+	nmerged int
+}
+
+func (s *histogramStats) merge(count uint64, sum float64, hasSum bool, min float64, hasMin bool, max float64, hasMax bool) {
+	if s.nmerged == 0 {
+		s.hasSum, s.hasMin, s.hasMax = hasSum, hasMin, hasMax
+		s.min, s.max = min, max
+	} else {
+		s.hasSum = s.hasSum && hasSum
+		s.hasMin = s.hasMin && hasMin
+		s.hasMax = s.hasMax && hasMax
+		s.min = math.Min(s.min, min)
+		s.max = math.Max(s.max, max)
+	}
+	s.nmerged++
+	s.count += count
+	s.sum += sum
+}
+
+// explicitHistogram is the bucket-wise sum of explicit bounds histograms
+// that all have the same bounds.
+type explicitHistogram struct {
+	histogramStats
+	bounds       []float64
+	bucketCounts []uint64
+}
+
+func (h *explicitHistogram) merge(dp pmetric.HistogramDataPoint) error {
+	bounds := dp.ExplicitBounds().AsRaw()
+	counts := dp.BucketCounts().AsRaw()
+	if h.nmerged == 0 {
+		h.bounds = bounds
+		h.bucketCounts = make([]uint64, len(bounds)+1)
+	} else if !slices.Equal(h.bounds, bounds) {
+		return errHistogramBoundsMismatch
+	}
+	for i, c := range counts {
+		h.bucketCounts[i] += c
+	}
+	h.histogramStats.merge(dp.Count(), dp.Sum(), dp.HasSum(), dp.Min(), dp.HasMin(), dp.Max(), dp.HasMax())
+	return nil
+}
+
+// exponentialHistogram is the sum of exponential histograms, kept at the
+// smallest scale of any merged datapoint.  Buckets are held sparsely by
+// index, and the scale is reduced further if either sign would need more
+// than maxExponentialBuckets buckets.  The zero bucket is the widest of
+// any merged datapoint, and holds the counts of every bucket it overlaps.
+type exponentialHistogram struct {
+	histogramStats
+	scale         int32
+	zeroCount     uint64
+	zeroThreshold float64
+	positive      map[int32]uint64
+	negative      map[int32]uint64
+}
+
+func (h *exponentialHistogram) merge(dp pmetric.ExponentialHistogramDataPoint) {
+	if h.nmerged == 0 {
+		h.scale = dp.Scale()
+		h.positive = map[int32]uint64{}
+		h.negative = map[int32]uint64{}
+	} else if dp.Scale() < h.scale {
+		h.downscale(h.scale - dp.Scale())
+	}
+
+	shift := dp.Scale() - h.scale
+	addExponentialBuckets(h.positive, dp.Positive(), shift)
+	addExponentialBuckets(h.negative, dp.Negative(), shift)
+	h.zeroCount += dp.ZeroCount()
+	h.zeroThreshold = math.Max(h.zeroThreshold, dp.ZeroThreshold())
+	h.histogramStats.merge(dp.Count(), dp.Sum(), dp.HasSum(), dp.Min(), dp.HasMin(), dp.Max(), dp.HasMax())
+
+	for bucketSpan(h.positive) > maxExponentialBuckets || bucketSpan(h.negative) > maxExponentialBuckets {
+		h.downscale(1)
+	}
+	h.foldZeroBuckets()
+}
+
+// foldZeroBuckets moves the count of every bucket whose lower bound is
+// below the zero threshold into the zero count.  A bucket that straddles
+// the threshold is moved whole, and the threshold widened to its upper
+// bound, so no value is counted in both the zero bucket and a regular one.
+func (h *exponentialHistogram) foldZeroBuckets() {
+	if h.zeroThreshold <= 0 {
+		return
+	}
+	threshold := h.zeroThreshold
+	for _, buckets := range []map[int32]uint64{h.positive, h.negative} {
+		for idx, c := range buckets {
+			if exponentialBucketBound(h.scale, idx) >= threshold {
+				continue
+			}
+			h.zeroCount += c
+			delete(buckets, idx)
+			h.zeroThreshold = math.Max(h.zeroThreshold, exponentialBucketBound(h.scale, idx+1))
+		}
+	}
+}
+
+// exponentialBucketBound returns the lower bound of the absolute values
+// in the bucket at idx, which is also the upper bound of bucket idx-1.
+func exponentialBucketBound(scale int32, idx int32) float64 {
+	return math.Exp2(float64(idx) * math.Exp2(-float64(scale)))
+}
+
+// downscale reduces the scale by by, merging each 2^by adjacent buckets.
+func (h *exponentialHistogram) downscale(by int32) {
+	h.scale -= by
+	h.positive = downscaleBuckets(h.positive, by)
+	h.negative = downscaleBuckets(h.negative, by)
+}
+
+func addExponentialBuckets(into map[int32]uint64, buckets pmetric.ExponentialHistogramDataPointBuckets, shift int32) {
+	for i, c := range buckets.BucketCounts().AsRaw() {
+		if c == 0 {
+			continue
+		}
+		// An arithmetic shift rounds towards negative infinity, which is
+		// what maps a bucket index onto the index at a lower scale.
+		into[(buckets.Offset()+int32(i))>>shift] += c
+	}
+}
+
+func downscaleBuckets(buckets map[int32]uint64, by int32) map[int32]uint64 {
+	ret := make(map[int32]uint64, len(buckets))
+	for idx, c := range buckets {
+		ret[idx>>by] += c
+	}
+	return ret
+}
+
+// bucketSpan returns how many dense buckets are needed to hold buckets.
+func bucketSpan(buckets map[int32]uint64) int {
+	if len(buckets) == 0 {
+		return 0
+	}
+	lo, hi := bucketRange(buckets)
+	return int(hi-lo) + 1
+}
+
+func bucketRange(buckets map[int32]uint64) (int32, int32) {
+	first := true
+	var lo, hi int32
+	for idx := range buckets {
+		if first || idx < lo {
+			lo = idx
+		}
+		if first || idx > hi {
+			hi = idx
+		}
+		first = false
+	}
+	return lo, hi
+}
+
+func setExponentialBuckets(to pmetric.ExponentialHistogramDataPointBuckets, buckets map[int32]uint64) {
+	if len(buckets) == 0 {
+		return
+	}
+	lo, hi := bucketRange(buckets)
+	counts := make([]uint64, hi-lo+1)
+	for idx, c := range buckets {
+		counts[idx-lo] = c
+	}
+	to.SetOffset(lo)
+	to.BucketCounts().FromRaw(counts)
+}
+
+// histogramAggregation is the merged value of every histogram datapoint
+// with the same name and tags within one interval.
+type histogramAggregation struct {
+	name        string
+	tags        map[string]string
+	explicit    *explicitHistogram
+	exponential *exponentialHistogram
+}
+
+type histogramSet = intervalSet[*histogramAggregation]
+
+// histogramAggregator collects histogram datapoints into sets by interval.
+type histogramAggregator struct {
+	*intervalAggregator[*histogramAggregation]
+}
+
+func newHistogramAggregator(interval int64) *histogramAggregator {
+	return &histogramAggregator{
+		intervalAggregator: newIntervalAggregator(interval, func(name string, tags map[string]string) *histogramAggregation {
+			return &histogramAggregation{name: name, tags: tags}
+		}),
+	}
+}
+
+func (h *histogramAggregator) addExplicit(t time.Time, name string, tags map[string]string, dp pmetric.HistogramDataPoint) error {
+	// A datapoint may leave out its bucket counts, but if it has them
+	// there must be one more than the number of bounds.
+	if n := dp.BucketCounts().Len(); n != 0 && n != dp.ExplicitBounds().Len()+1 {
+		return errInvalidHistogram
+	}
+	h.Lock()
+	defer h.Unlock()
+	agg := h.aggregation(t, name, tags)
+	if agg.explicit == nil {
+		agg.explicit = &explicitHistogram{}
+	}
+	return agg.explicit.merge(dp)
+}
+
+func (h *histogramAggregator) addExponential(t time.Time, name string, tags map[string]string, dp pmetric.ExponentialHistogramDataPoint) {
+	h.Lock()
+	defer h.Unlock()
+	agg := h.aggregation(t, name, tags)
+	if agg.exponential == nil {
+		agg.exponential = &exponentialHistogram{}
+	}
+	agg.exponential.merge(dp)
+}
+
+// datapointTags returns the tags a datapoint is aggregated by, in the
+// same form as ottl.MetricAggregatorImpl uses: every resource, scope,
+// and datapoint attribute that is not internal, plus the metadata.
+func datapointTags(metadata map[string]string, rattr, iattr, mattr pcommon.Map) map[string]string {
+	ret := map[string]string{}
+	for scope, attrs := range map[string]pcommon.Map{
+		"resource":        rattr,
+		"instrumentation": iattr,
+		"metric":          mattr,
+	} {
+		attrs.Range(func(k string, v pcommon.Value) bool {
+			if k == "_dd.rateInterval" || (k != "" && k[0] != '_' && k != "timestamp") {
+				ret[scope+"."+k] = v.AsString()
+			}
+			return true
+		})
+	}
+	for k, v := range metadata {
+		ret["metadata."+k] = v
+	}
+	return ret
+}
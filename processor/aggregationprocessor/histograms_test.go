@@ -0,0 +1,283 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func newTestProcessor(t *testing.T) (*aggregationProcessor, *MockMetricsConsumer) {
+	t.Helper()
+	cfg := createDefaultConfig().(*Config)
+	p, err := newPitbull(cfg, "metrics", processortest.NewNopSettings(), consumertest.NewNop())
+	require.NoError(t, err)
+	mockConsumer := &MockMetricsConsumer{}
+	p.nextMetricReceiver = mockConsumer
+	return p, mockConsumer
+}
+
+func addHistogramDatapoint(m pmetric.Metric, ts time.Time, pod string, bounds []float64, counts []uint64, sum, min, max float64) pmetric.HistogramDataPoint {
+	dp := m.Histogram().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+	dp.ExplicitBounds().FromRaw(bounds)
+	dp.BucketCounts().FromRaw(counts)
+	var count uint64
+	for _, c := range counts {
+		count += c
+	}
+	dp.SetCount(count)
+	dp.SetSum(sum)
+	dp.SetMin(min)
+	dp.SetMax(max)
+	dp.Attributes().PutStr("service", "checkout")
+	dp.Attributes().PutStr("_pod", pod)
+	dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	return dp
+}
+
+func histogramMetrics(name string) (pmetric.Metrics, pmetric.Metric) {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName(name)
+	m.SetUnit("ms")
+	m.SetEmptyHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	return md, m
+}
+
+func TestAggregateHistograms(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now().Truncate(10 * time.Second)
+	bounds := []float64{10, 100, 1000}
+
+	md, m := histogramMetrics("http.server.duration")
+	addHistogramDatapoint(m, now, "a", bounds, []uint64{1, 2, 3, 0}, 2000, 5, 900)
+	addHistogramDatapoint(m, now, "b", bounds, []uint64{0, 1, 0, 1}, 1100, 50, 1050)
+	addHistogramDatapoint(m, now.Add(time.Second), "c", bounds, []uint64{4, 0, 0, 0}, 8, 1, 3)
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.Histogram().DataPoints().Len())
+
+	p.emit(now.Add(time.Minute))
+	require.Len(t, mockConsumer.ConsumedMetrics, 1)
+	out := mockConsumer.ConsumedMetrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	assert.Equal(t, "http.server.duration", out.Name())
+	assert.Equal(t, "ms", out.Unit())
+	require.Equal(t, pmetric.MetricTypeHistogram, out.Type())
+	assert.Equal(t, pmetric.AggregationTemporalityDelta, out.Histogram().AggregationTemporality())
+	require.Equal(t, 1, out.Histogram().DataPoints().Len())
+
+	dp := out.Histogram().DataPoints().At(0)
+	assert.Equal(t, bounds, dp.ExplicitBounds().AsRaw())
+	assert.Equal(t, []uint64{5, 3, 3, 1}, dp.BucketCounts().AsRaw())
+	assert.Equal(t, uint64(12), dp.Count())
+	assert.Equal(t, 3108.0, dp.Sum())
+	assert.Equal(t, 1.0, dp.Min())
+	assert.Equal(t, 1050.0, dp.Max())
+	assert.Equal(t, map[string]any{"service": "checkout"}, dp.Attributes().AsRaw())
+	assert.Equal(t, pcommon.NewTimestampFromTime(now), dp.Timestamp())
+}
+
+func TestAggregateHistogramsMismatchedBounds(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now().Truncate(10 * time.Second)
+
+	md, m := histogramMetrics("latency")
+	addHistogramDatapoint(m, now, "a", []float64{10, 100}, []uint64{1, 1, 1}, 111, 1, 200)
+	addHistogramDatapoint(m, now, "b", []float64{5, 50}, []uint64{2, 0, 0}, 4, 1, 3)
+	// Malformed datapoints are passed through too.
+	addHistogramDatapoint(m, now, "c", []float64{10, 100}, []uint64{1, 1}, 0, 0, 0)
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Histogram().DataPoints().Len())
+	assert.Equal(t, []float64{5, 50}, m.Histogram().DataPoints().At(0).ExplicitBounds().AsRaw())
+	assert.Equal(t, []uint64{1, 1}, m.Histogram().DataPoints().At(1).BucketCounts().AsRaw())
+
+	p.emit(now.Add(time.Minute))
+	require.Len(t, mockConsumer.ConsumedMetrics, 1)
+	dp := mockConsumer.ConsumedMetrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram().DataPoints().At(0)
+	assert.Equal(t, []uint64{1, 1, 1}, dp.BucketCounts().AsRaw())
+}
+
+func TestAggregateHistogramsPassesCumulativeThrough(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now().Truncate(10 * time.Second)
+	bounds := []float64{10, 100}
+
+	md, m := histogramMetrics("latency")
+	m.Histogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	addHistogramDatapoint(m, now, "a", bounds, []uint64{1, 2, 0}, 50, 1, 60)
+	addHistogramDatapoint(m, now.Add(time.Second), "a", bounds, []uint64{2, 3, 0}, 80, 1, 60)
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.Histogram().DataPoints().Len())
+	assert.Equal(t, []uint64{1, 2, 0}, m.Histogram().DataPoints().At(0).BucketCounts().AsRaw())
+	assert.Equal(t, []uint64{2, 3, 0}, m.Histogram().DataPoints().At(1).BucketCounts().AsRaw())
+
+	p.emit(now.Add(time.Minute))
+	assert.Empty(t, mockConsumer.ConsumedMetrics)
+}
+
+func TestHistogramStatsDropMissingValues(t *testing.T) {
+	var s histogramStats
+	s.merge(1, 5, true, 5, true, 5, true)
+	s.merge(2, 7, false, 1, false, 9, true)
+	assert.Equal(t, uint64(3), s.count)
+	assert.False(t, s.hasSum)
+	assert.False(t, s.hasMin)
+	assert.True(t, s.hasMax)
+	assert.Equal(t, 9.0, s.max)
+}
+
+func exponentialDatapoint(scale int32, offset int32, counts []uint64) pmetric.ExponentialHistogramDataPoint {
+	dp := pmetric.NewExponentialHistogramDataPoint()
+	dp.SetScale(scale)
+	dp.Positive().SetOffset(offset)
+	dp.Positive().BucketCounts().FromRaw(counts)
+	var count uint64
+	for _, c := range counts {
+		count += c
+	}
+	dp.SetCount(count)
+	return dp
+}
+
+func TestExponentialHistogramMergeScales(t *testing.T) {
+	h := &exponentialHistogram{}
+
+	// At scale 2, indexes 4..7 cover (2, 4], which is index 1 at scale 0
+	// and indexes 2..3 at scale 1.
+	h.merge(exponentialDatapoint(2, 4, []uint64{1, 2, 3, 4}))
+	assert.Equal(t, int32(2), h.scale)
+
+	h.merge(exponentialDatapoint(1, -1, []uint64{5, 0, 0, 6}))
+	assert.Equal(t, int32(1), h.scale)
+	assert.Equal(t, map[int32]uint64{-1: 5, 2: 1 + 2 + 6, 3: 3 + 4}, h.positive)
+
+	// A higher scale datapoint is mapped down to the current scale.
+	h.merge(exponentialDatapoint(3, -3, []uint64{7}))
+	assert.Equal(t, int32(1), h.scale)
+	assert.Equal(t, uint64(12), h.positive[-1])
+	assert.Equal(t, uint64(28), h.count)
+
+	out := pmetric.NewExponentialHistogramDataPoint()
+	setExponentialBuckets(out.Positive(), h.positive)
+	assert.Equal(t, int32(-1), out.Positive().Offset())
+	assert.Equal(t, []uint64{12, 0, 0, 9, 7}, out.Positive().BucketCounts().AsRaw())
+}
+
+func TestExponentialHistogramLimitsBuckets(t *testing.T) {
+	h := &exponentialHistogram{}
+	h.merge(exponentialDatapoint(4, 0, []uint64{1}))
+	h.merge(exponentialDatapoint(4, 1000, []uint64{1}))
+
+	assert.LessOrEqual(t, bucketSpan(h.positive), maxExponentialBuckets)
+	// 1000 >> 3 is 125, which fits, while 1000 >> 2 does not.
+	assert.Equal(t, int32(1), h.scale)
+	assert.Equal(t, map[int32]uint64{0: 1, 125: 1}, h.positive)
+}
+
+func TestExponentialHistogramFoldsZeroBuckets(t *testing.T) {
+	h := &exponentialHistogram{}
+
+	// At scale 0, index -2 is (0.25, 0.5], -1 is (0.5, 1], and 0 is (1, 2].
+	h.merge(exponentialDatapoint(0, -2, []uint64{1, 2, 3}))
+	assert.Equal(t, uint64(0), h.zeroCount)
+
+	// A threshold of 0.75 covers all of bucket -2 and part of bucket -1,
+	// so both are moved into the zero bucket, which widens to 1.
+	dp := exponentialDatapoint(0, 0, []uint64{4})
+	dp.SetZeroThreshold(0.75)
+	dp.SetZeroCount(5)
+	dp.Negative().SetOffset(-2)
+	dp.Negative().BucketCounts().FromRaw([]uint64{6, 0, 7})
+	h.merge(dp)
+
+	assert.Equal(t, 1.0, h.zeroThreshold)
+	assert.Equal(t, uint64(1+2+5+6), h.zeroCount)
+	assert.Equal(t, map[int32]uint64{0: 3 + 4}, h.positive)
+	assert.Equal(t, map[int32]uint64{0: 7}, h.negative)
+}
+
+func TestAggregateExponentialHistogramsPassesCumulativeThrough(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now().Truncate(10 * time.Second)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("rpc.duration")
+	m.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+	for i, counts := range [][]uint64{{1, 1}, {2, 3}} {
+		dp := m.ExponentialHistogram().DataPoints().AppendEmpty()
+		exponentialDatapoint(2, 8, counts).CopyTo(dp)
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(now.Add(time.Duration(i) * time.Second)))
+		dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	}
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	require.Equal(t, 2, m.ExponentialHistogram().DataPoints().Len())
+	assert.Equal(t, []uint64{2, 3}, m.ExponentialHistogram().DataPoints().At(1).Positive().BucketCounts().AsRaw())
+
+	p.emit(now.Add(time.Minute))
+	assert.Empty(t, mockConsumer.ConsumedMetrics)
+}
+
+func TestAggregateExponentialHistograms(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now().Truncate(10 * time.Second)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("rpc.duration")
+	m.SetEmptyExponentialHistogram().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	for i, scale := range []int32{3, 2} {
+		dp := m.ExponentialHistogram().DataPoints().AppendEmpty()
+		exponentialDatapoint(scale, 8, []uint64{1, 1}).CopyTo(dp)
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(now))
+		dp.SetZeroCount(uint64(i + 1))
+		dp.SetZeroThreshold(float64(i) / 1000)
+		dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	}
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.ExponentialHistogram().DataPoints().Len())
+
+	p.emit(now.Add(time.Minute))
+	require.Len(t, mockConsumer.ConsumedMetrics, 1)
+	out := mockConsumer.ConsumedMetrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	require.Equal(t, pmetric.MetricTypeExponentialHistogram, out.Type())
+	dp := out.ExponentialHistogram().DataPoints().At(0)
+	assert.Equal(t, int32(2), dp.Scale())
+	assert.Equal(t, uint64(3), dp.ZeroCount())
+	assert.Equal(t, 0.001, dp.ZeroThreshold())
+	assert.Equal(t, int32(4), dp.Positive().Offset())
+	assert.Equal(t, []uint64{2, 0, 0, 0, 1, 1}, dp.Positive().BucketCounts().AsRaw())
+	assert.Equal(t, uint64(4), dp.Count())
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cardinalhq/oteltools/pkg/ottl"
+)
+
+// intervalSet holds the aggregations for one interval, keyed by the
+// fingerprint of their tags.
+type intervalSet[A any] struct {
+	StartTime    int64
+	Aggregations map[uint64]A
+}
+
+// intervalAggregator groups aggregations into sets by interval, in the
+// same way ottl.MetricAggregatorImpl does for sums and averages.
+type intervalAggregator[A any] struct {
+	sync.Mutex
+	interval int64
+	sets     map[int64]*intervalSet[A]
+	create   func(name string, tags map[string]string) A
+}
+
+func newIntervalAggregator[A any](interval int64, create func(name string, tags map[string]string) A) *intervalAggregator[A] {
+	return &intervalAggregator[A]{
+		interval: interval,
+		sets:     map[int64]*intervalSet[A]{},
+		create:   create,
+	}
+}
+
+// aggregation returns the aggregation for name and tags in the interval
+// containing t, creating it if needed.  It must be called with the lock held.
+func (a *intervalAggregator[A]) aggregation(t time.Time, name string, tags map[string]string) A {
+	startTime := timebox(t, a.interval)
+	set, ok := a.sets[startTime]
+	if !ok {
+		set = &intervalSet[A]{StartTime: startTime, Aggregations: map[uint64]A{}}
+		a.sets[startTime] = set
+	}
+	fingerprint := ottl.FingerprintTags(tags)
+	agg, ok := set.Aggregations[fingerprint]
+	if !ok {
+		agg = a.create(name, tags)
+		set.Aggregations[fingerprint] = agg
+	}
+	return agg
+}
+
+// emit removes and returns the sets that are old enough to be emitted,
+// using the same cutoff as ottl.MetricAggregatorImpl.
+func (a *intervalAggregator[A]) emit(now time.Time) []*intervalSet[A] {
+	targetTime := timebox(now, a.interval) - a.interval*2
+	a.Lock()
+	defer a.Unlock()
+	var ret []*intervalSet[A]
+	for k, v := range a.sets {
+		if k < targetTime {
+			ret = append(ret, v)
+			delete(a.sets, k)
+		}
+	}
+	return ret
+}
+
+func timebox(t time.Time, interval int64) int64 {
+	n := t.UTC().UnixMilli()
+	return n - (n % interval)
+}
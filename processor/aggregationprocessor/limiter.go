@@ -0,0 +1,97 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	overflowPassthrough = "passthrough"
+	overflowDrop        = "drop"
+	overflowFold        = "overflow"
+)
+
+// overflowAttribute is set on the datapoints of overflow series.
+const overflowAttribute = "__overflow__"
+
+// overflowMetadataKey marks the tags of an overflow series.  It is kept in
+// the metadata because the aggregators leave out attributes that start
+// with an underscore.
+const overflowMetadataKey = "metric.overflow"
+
+// seriesLimiter counts the distinct series being aggregated in each
+// interval, across every kind of aggregation, and refuses new series
+// once an interval holds maxSeries of them.  A maxSeries of 0 only counts.
+type seriesLimiter struct {
+	sync.Mutex
+	maxSeries int
+	interval  int64
+	series    map[int64]map[uint64]struct{}
+}
+
+func newSeriesLimiter(maxSeries int, interval int64) *seriesLimiter {
+	return &seriesLimiter{
+		maxSeries: maxSeries,
+		interval:  interval,
+		series:    map[int64]map[uint64]struct{}{},
+	}
+}
+
+// admit returns true if the series with the given fingerprint may be
+// aggregated in the interval containing t.  Series already seen in that
+// interval are always admitted.
+func (l *seriesLimiter) admit(t time.Time, fingerprint uint64) bool {
+	startTime := timebox(t, l.interval)
+	l.Lock()
+	defer l.Unlock()
+	set, ok := l.series[startTime]
+	if !ok {
+		set = map[uint64]struct{}{}
+		l.series[startTime] = set
+	}
+	if _, ok := set[fingerprint]; ok {
+		return true
+	}
+	if l.maxSeries > 0 && len(set) >= l.maxSeries {
+		return false
+	}
+	set[fingerprint] = struct{}{}
+	return true
+}
+
+// expire forgets the intervals the aggregators emit at now.
+func (l *seriesLimiter) expire(now time.Time) {
+	targetTime := timebox(now, l.interval) - l.interval*2
+	l.Lock()
+	defer l.Unlock()
+	for k := range l.series {
+		if k < targetTime {
+			delete(l.series, k)
+		}
+	}
+}
+
+// active returns the number of series held across all open intervals.
+func (l *seriesLimiter) active() int {
+	l.Lock()
+	defer l.Unlock()
+	n := 0
+	for _, set := range l.series {
+		n += len(set)
+	}
+	return n
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func TestSeriesLimiter(t *testing.T) {
+	start := time.UnixMilli(1717245000000)
+	l := newSeriesLimiter(2, 10000)
+
+	assert.True(t, l.admit(start, 1))
+	assert.True(t, l.admit(start, 2))
+	assert.False(t, l.admit(start, 3))
+	// Series already held are still admitted.
+	assert.True(t, l.admit(start.Add(time.Second), 1))
+	// Each interval has its own limit.
+	assert.True(t, l.admit(start.Add(10*time.Second), 3))
+	assert.Equal(t, 3, l.active())
+
+	l.expire(start.Add(30 * time.Second))
+	assert.Equal(t, 1, l.active())
+	assert.True(t, l.admit(start.Add(10*time.Second), 4))
+	assert.False(t, l.admit(start.Add(10*time.Second), 5))
+
+	l.expire(drainTime)
+	assert.Equal(t, 0, l.active())
+}
+
+func TestSeriesLimiterUnlimited(t *testing.T) {
+	l := newSeriesLimiter(0, 10000)
+	now := time.Now()
+	for i := range 1000 {
+		assert.True(t, l.admit(now, uint64(i)))
+	}
+	assert.Equal(t, 1000, l.active())
+}
+
+// spikeMetrics returns a sum with one datapoint for each of n pods, as if a
+// label that should have been removed had exploded.
+func spikeMetrics(ts time.Time, n int) (pmetric.Metrics, pmetric.Metric) {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("requests")
+	m.SetEmptySum().SetAggregationTemporality(pmetric.AggregationTemporalityDelta)
+	for i := range n {
+		dp := m.Sum().DataPoints().AppendEmpty()
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+		dp.SetIntValue(1)
+		dp.Attributes().PutStr("pod", fmt.Sprintf("pod-%d", i))
+		dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	}
+	return md, m
+}
+
+func TestCardinalitySpike(t *testing.T) {
+	tests := []struct {
+		overflow      string
+		wantRemaining int
+		wantEmitted   int
+	}{
+		{overflowPassthrough, 90, 10},
+		{overflowDrop, 0, 10},
+		{overflowFold, 0, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.overflow, func(t *testing.T) {
+			p, mockConsumer := newTestProcessor(t)
+			p.limiter = newSeriesLimiter(10, 10000)
+			p.overflow = tt.overflow
+			now := time.Now().Truncate(10 * time.Second)
+
+			md, m := spikeMetrics(now, 100)
+			_, err := p.ConsumeMetrics(context.Background(), md)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRemaining, m.Sum().DataPoints().Len())
+			assert.Equal(t, 10, p.limiter.active())
+
+			p.emit(now.Add(time.Minute))
+			require.Len(t, mockConsumer.ConsumedMetrics, tt.wantEmitted)
+			assert.Equal(t, 0, p.limiter.active())
+
+			var overflowed []pmetric.NumberDataPoint
+			for _, out := range mockConsumer.ConsumedMetrics {
+				dp := out.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Sum().DataPoints().At(0)
+				if _, ok := dp.Attributes().Get(overflowAttribute); ok {
+					overflowed = append(overflowed, dp)
+				}
+			}
+			if tt.overflow != overflowFold {
+				assert.Empty(t, overflowed)
+				return
+			}
+			require.Len(t, overflowed, 1)
+			assert.Equal(t, int64(90), overflowed[0].IntValue())
+			assert.Equal(t, map[string]any{overflowAttribute: true}, overflowed[0].Attributes().AsRaw())
+		})
+	}
+}
+
+func TestCardinalitySpikeOverflowHistograms(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	p.limiter = newSeriesLimiter(1, 10000)
+	p.overflow = overflowFold
+	now := time.Now().Truncate(10 * time.Second)
+
+	md, m := histogramMetrics("latency")
+	for _, pod := range []string{"a", "b", "c"} {
+		dp := addHistogramDatapoint(m, now, pod, []float64{10}, []uint64{1, 1}, 20, 1, 19)
+		dp.Attributes().PutStr("pod", pod)
+	}
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	assert.Equal(t, 0, m.Histogram().DataPoints().Len())
+
+	p.emit(now.Add(time.Minute))
+	require.Len(t, mockConsumer.ConsumedMetrics, 2)
+	counts := map[bool]uint64{}
+	for _, out := range mockConsumer.ConsumedMetrics {
+		dp := out.ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Histogram().DataPoints().At(0)
+		_, overflowed := dp.Attributes().Get(overflowAttribute)
+		counts[overflowed] = dp.Count()
+	}
+	assert.Equal(t, map[bool]uint64{false: 2, true: 4}, counts)
+}
@@ -16,7 +16,6 @@ package aggregationprocessor
 
 import (
 	"context"
-	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/pmetric"
@@ -78,7 +77,14 @@ func (e *aggregationProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Me
 						if !needsAttr(dattr) {
 							return false
 						}
-						return false
+						agg := e.aggregateHistogramDatapoint(rm, ilm, m, dp)
+						if agg {
+							telemetry.CounterAdd(e.aggregatedDatapoints, 1, metric.WithAttributes(
+								attribute.String("type", "histogram"),
+								attribute.String("metric_name", metricName),
+								attribute.String("service_name", serviceName)))
+						}
+						return agg
 					})
 				case pmetric.MetricTypeSummary:
 					m.Summary().DataPoints().RemoveIf(func(dp pmetric.SummaryDataPoint) bool {
@@ -94,7 +100,14 @@ func (e *aggregationProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Me
 						if !needsAttr(dattr) {
 							return false
 						}
-						return false
+						agg := e.aggregateExponentialHistogramDatapoint(rm, ilm, m, dp)
+						if agg {
+							telemetry.CounterAdd(e.aggregatedDatapoints, 1, metric.WithAttributes(
+								attribute.String("type", "exponential_histogram"),
+								attribute.String("metric_name", metricName),
+								attribute.String("service_name", serviceName)))
+						}
+						return agg
 					})
 				}
 
@@ -105,8 +118,6 @@ func (e *aggregationProcessor) ConsumeMetrics(ctx context.Context, md pmetric.Me
 		return rm.ScopeMetrics().Len() == 0
 	})
 
-	e.emit(time.Now())
-
 	if md.ResourceMetrics().Len() == 0 {
 		return md, processorhelper.ErrSkipProcessingData
 	}
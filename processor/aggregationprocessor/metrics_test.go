@@ -93,6 +93,10 @@ func TestAggregateCounter(t *testing.T) {
 	mp := &aggregationProcessor{
 		aggregatorF:        ottl.NewMetricAggregatorImpl[float64](10000),
 		aggregatorI:        ottl.NewMetricAggregatorImpl[int64](10000),
+		histograms:         newHistogramAggregator(10000),
+		expHistograms:      newHistogramAggregator(10000),
+		reducers:           newReducerAggregator(10000),
+		limiter:            newSeriesLimiter(0, 10000),
 		nextMetricReceiver: mockConsumer,
 		logger:             zap.NewNop(),
 	}
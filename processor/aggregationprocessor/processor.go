@@ -16,6 +16,7 @@ package aggregationprocessor
 
 import (
 	"context"
+	"math"
 	"sync"
 	"time"
 
@@ -53,8 +54,18 @@ type aggregationProcessor struct {
 	aggregationInterval  time.Duration
 	aggregatorI          ottl.MetricAggregator[int64]
 	aggregatorF          ottl.MetricAggregator[float64]
-	lastEmitCheck        time.Time
+	histograms           *histogramAggregator
+	expHistograms        *histogramAggregator
+	rules                []reducerRule
+	reducers             *reducerAggregator
+	limiter              *seriesLimiter
+	overflow             string
+	clock                clock
+	stopFlush            chan struct{}
+	flushDone            chan struct{}
 	aggregatedDatapoints *telemetry.DeferrableInt64Counter
+	boundsMismatches     *telemetry.DeferrableInt64Counter
+	seriesOverflows      *telemetry.DeferrableInt64Counter
 }
 
 func newPitbull(config *Config, ttype string, set processor.Settings, nextConsumer consumer.Metrics) (*aggregationProcessor, error) {
@@ -66,6 +77,8 @@ func newPitbull(config *Config, ttype string, set processor.Settings, nextConsum
 		additionalAttributes: config.AdditionalAttributes,
 		logger:               set.Logger,
 		nextMetricReceiver:   nextConsumer,
+		aggregationInterval:  config.MetricAggregation.Interval,
+		clock:                realClock{},
 	}
 
 	attrset := attribute.NewSet(
@@ -73,10 +86,15 @@ func newPitbull(config *Config, ttype string, set processor.Settings, nextConsum
 		attribute.String("signal", ttype),
 	)
 
-	dog.lastEmitCheck = time.Now()
 	interval := config.MetricAggregation.Interval.Milliseconds()
 	dog.aggregatorI = ottl.NewMetricAggregatorImpl[int64](interval)
 	dog.aggregatorF = ottl.NewMetricAggregatorImpl[float64](interval)
+	dog.histograms = newHistogramAggregator(interval)
+	dog.expHistograms = newHistogramAggregator(interval)
+	dog.rules = compileRules(config.MetricAggregation.Rules)
+	dog.reducers = newReducerAggregator(interval)
+	dog.limiter = newSeriesLimiter(config.MetricAggregation.MaxSeries, interval)
+	dog.overflow = config.MetricAggregation.Overflow
 	err := dog.setupMetricTelemetry(set, attrset)
 	if err != nil {
 		return nil, err
@@ -89,6 +107,62 @@ func (e *aggregationProcessor) Capabilities() consumer.Capabilities {
 	return consumer.Capabilities{MutatesData: true}
 }
 
+// clock is the source of time for the flush task, so tests can step
+// time and ticks by hand.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+func (e *aggregationProcessor) Start(_ context.Context, _ component.Host) error {
+	ticks, stop := e.clock.NewTicker(e.aggregationInterval)
+	e.stopFlush = make(chan struct{})
+	e.flushDone = make(chan struct{})
+	go e.flushTask(ticks, stop)
+	return nil
+}
+
+// Shutdown stops the flush task and then emits every aggregate still
+// held, including those for intervals that have not closed yet.
+func (e *aggregationProcessor) Shutdown(_ context.Context) error {
+	if e.stopFlush != nil {
+		close(e.stopFlush)
+		<-e.flushDone
+		e.stopFlush = nil
+	}
+	e.emit(drainTime)
+	return nil
+}
+
+// drainTime is late enough that emitting at it releases every set.
+var drainTime = time.UnixMilli(math.MaxInt64)
+
+// flushTask emits closed intervals on every tick, so aggregates are
+// released even when no new metrics arrive.
+func (e *aggregationProcessor) flushTask(ticks <-chan time.Time, stopTicker func()) {
+	defer close(e.flushDone)
+	defer stopTicker()
+	for {
+		select {
+		case <-e.stopFlush:
+			return
+		case <-ticks:
+			e.emit(e.clock.Now())
+		}
+	}
+}
+
 func (e *aggregationProcessor) setupMetricTelemetry(set processor.Settings, attrset attribute.Set) error {
 	counter, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
 		"aggregation_datapoints_processed",
@@ -104,5 +178,45 @@ func (e *aggregationProcessor) setupMetricTelemetry(set processor.Settings, attr
 		return err
 	}
 	e.aggregatedDatapoints = counter
-	return nil
+
+	mismatches, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
+		"aggregation_histogram_bounds_mismatch",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of histogram datapoints passed through unaggregated because their bucket bounds differed from others in the same series"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	e.boundsMismatches = mismatches
+
+	overflows, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
+		"aggregation_series_overflow",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of datapoints of new series that arrived after the series limit for their interval was reached"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	e.seriesOverflows = overflows
+
+	_, err = metadata.Meter(set.TelemetrySettings).Int64ObservableGauge(
+		"aggregation_series_active",
+		metric.WithDescription("The number of series being aggregated across all open intervals"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(e.limiter.active()), metric.WithAttributeSet(attrset))
+			return nil
+		}),
+	)
+	return err
 }
@@ -0,0 +1,147 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+type fakeClock struct {
+	sync.Mutex
+	now      time.Time
+	ticks    chan time.Time
+	interval time.Duration
+	stopped  bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.interval = d
+	return c.ticks, func() {
+		c.Lock()
+		defer c.Unlock()
+		c.stopped = true
+	}
+}
+
+// advance moves the clock forward by d and ticks.  It returns once the
+// flush task has finished handling the tick: the tick channel is
+// unbuffered, so the second send cannot complete until the flush task is
+// back waiting for the next tick, and that tick emits nothing new.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.Unlock()
+	c.ticks <- now
+	c.ticks <- now
+}
+
+func gaugeMetrics(ts time.Time, value float64) pmetric.Metrics {
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("queue.depth")
+	dp := m.SetEmptyGauge().DataPoints().AppendEmpty()
+	dp.SetTimestamp(pcommon.NewTimestampFromTime(ts))
+	dp.SetDoubleValue(value)
+	dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	return md
+}
+
+func TestFlushTaskEmitsClosedIntervals(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	start := time.UnixMilli(1717245000000)
+	clk := newFakeClock(start)
+	p.clock = clk
+
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+	assert.Equal(t, 10*time.Second, clk.interval)
+
+	_, err := p.ConsumeMetrics(context.Background(), gaugeMetrics(start, 1))
+	require.NoError(t, err)
+	_, err = p.ConsumeMetrics(context.Background(), gaugeMetrics(start.Add(10*time.Second), 2))
+	require.NoError(t, err)
+	assert.Empty(t, mockConsumer.ConsumedMetrics)
+
+	// The first interval is held until two more have passed, whether or
+	// not anything else arrives.
+	clk.advance(20 * time.Second)
+	assert.Empty(t, mockConsumer.ConsumedMetrics)
+	clk.advance(10 * time.Second)
+	require.Len(t, mockConsumer.ConsumedMetrics, 1)
+	dp := mockConsumer.ConsumedMetrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0).Gauge().DataPoints().At(0)
+	assert.Equal(t, pcommon.NewTimestampFromTime(start), dp.Timestamp())
+
+	clk.advance(10 * time.Second)
+	require.Len(t, mockConsumer.ConsumedMetrics, 2)
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.True(t, clk.stopped)
+}
+
+func TestShutdownDrainsOpenIntervals(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	start := time.UnixMilli(1717245000000)
+	clk := newFakeClock(start)
+	p.clock = clk
+
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+
+	_, err := p.ConsumeMetrics(context.Background(), gaugeMetrics(start, 1))
+	require.NoError(t, err)
+	md, m := histogramMetrics("latency")
+	addHistogramDatapoint(m, start.Add(time.Hour), "a", []float64{1}, []uint64{1, 1}, 3, 1, 2)
+	_, err = p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Len(t, mockConsumer.ConsumedMetrics, 2)
+	assert.True(t, clk.stopped)
+
+	// Everything has been released, so nothing is left to emit.
+	p.emit(drainTime)
+	assert.Len(t, mockConsumer.ConsumedMetrics, 2)
+}
+
+func TestShutdownWithoutStart(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	now := time.Now()
+	_, err := p.ConsumeMetrics(context.Background(), gaugeMetrics(now, 1))
+	require.NoError(t, err)
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Len(t, mockConsumer.ConsumedMetrics, 1)
+}
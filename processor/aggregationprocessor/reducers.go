@@ -0,0 +1,212 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"math"
+	"regexp"
+	"time"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// cardinalFieldAggregation is set on datapoints emitted by a reducer, and
+// names the function used.
+const cardinalFieldAggregation = translate.CardinalFieldPrefixDot + "aggregation"
+
+const (
+	// sketchRelativeAccuracy is the relative error of the percentiles.
+	sketchRelativeAccuracy = 0.01
+	// sketchMaxBins bounds the memory used by each sketch.  The lowest
+	// bins are collapsed first, which only affects very low percentiles.
+	sketchMaxBins = 2048
+)
+
+type reducerFunction string
+
+const (
+	reduceSum   reducerFunction = "sum"
+	reduceMin   reducerFunction = "min"
+	reduceMax   reducerFunction = "max"
+	reduceAvg   reducerFunction = "avg"
+	reduceCount reducerFunction = "count"
+	reduceLast  reducerFunction = "last"
+	reduceP50   reducerFunction = "p50"
+	reduceP90   reducerFunction = "p90"
+	reduceP99   reducerFunction = "p99"
+)
+
+// reducerFunctions maps each supported function name to the quantile it
+// computes, or -1 if it is not a percentile.
+var reducerFunctions = map[string]float64{
+	string(reduceSum):   -1,
+	string(reduceMin):   -1,
+	string(reduceMax):   -1,
+	string(reduceAvg):   -1,
+	string(reduceCount): -1,
+	string(reduceLast):  -1,
+	string(reduceP50):   0.5,
+	string(reduceP90):   0.9,
+	string(reduceP99):   0.99,
+}
+
+type reducerRule struct {
+	name     string
+	pattern  *regexp.Regexp
+	function reducerFunction
+}
+
+// compileRules prepares the configured rules for matching.  The rules
+// must already have been validated.
+func compileRules(rules []AggregationRule) []reducerRule {
+	ret := make([]reducerRule, 0, len(rules))
+	for _, r := range rules {
+		rule := reducerRule{name: r.MetricName, function: reducerFunction(r.Function)}
+		if r.MetricPattern != "" {
+			rule.pattern = regexp.MustCompile(r.MetricPattern)
+		}
+		ret = append(ret, rule)
+	}
+	return ret
+}
+
+// matchRule returns the function of the first rule matching name.
+func matchRule(rules []reducerRule, name string) (reducerFunction, bool) {
+	for _, r := range rules {
+		if r.pattern != nil {
+			if r.pattern.MatchString(name) {
+				return r.function, true
+			}
+		} else if r.name == name {
+			return r.function, true
+		}
+	}
+	return "", false
+}
+
+// reducer combines the values of gauge datapoints with one function.
+// Only the state that function needs is kept up to date.
+type reducer struct {
+	function reducerFunction
+	count    uint64
+	sum      float64
+	min      float64
+	max      float64
+	last     float64
+	lastTime time.Time
+	allInts  bool
+	sketch   *ddsketch.DDSketch
+}
+
+func newReducer(function reducerFunction) *reducer {
+	r := &reducer{function: function, allInts: true}
+	if reducerFunctions[string(function)] >= 0 {
+		// This only fails if the accuracy or bin count are out of range.
+		r.sketch, _ = ddsketch.LogCollapsingLowestDenseDDSketch(sketchRelativeAccuracy, sketchMaxBins)
+	}
+	return r
+}
+
+func (r *reducer) add(t time.Time, v float64, isInt bool) error {
+	if r.sketch != nil {
+		if err := r.sketch.Add(v); err != nil {
+			return err
+		}
+	}
+	if r.count == 0 {
+		r.min, r.max = v, v
+	} else {
+		r.min = math.Min(r.min, v)
+		r.max = math.Max(r.max, v)
+	}
+	if r.count == 0 || !t.Before(r.lastTime) {
+		r.last, r.lastTime = v, t
+	}
+	r.count++
+	r.sum += v
+	r.allInts = r.allInts && isInt
+	return nil
+}
+
+func (r *reducer) value() float64 {
+	switch r.function {
+	case reduceSum:
+		return r.sum
+	case reduceMin:
+		return r.min
+	case reduceMax:
+		return r.max
+	case reduceAvg:
+		return r.sum / float64(r.count)
+	case reduceCount:
+		return float64(r.count)
+	case reduceLast:
+		return r.last
+	default:
+		v, err := r.sketch.GetValueAtQuantile(reducerFunctions[string(r.function)])
+		if err != nil {
+			return math.NaN()
+		}
+		return v
+	}
+}
+
+// isInt returns true if the value should be emitted as an integer: the
+// count always is, and functions that pick or add up values are if
+// every input was.
+func (r *reducer) isInt() bool {
+	switch r.function {
+	case reduceCount:
+		return true
+	case reduceSum, reduceMin, reduceMax, reduceLast:
+		return r.allInts
+	default:
+		return false
+	}
+}
+
+type reducerAggregation struct {
+	name    string
+	tags    map[string]string
+	reducer *reducer
+}
+
+type reducerSet = intervalSet[*reducerAggregation]
+
+// reducerAggregator collects gauge datapoints matched by a rule into sets
+// by interval.
+type reducerAggregator struct {
+	*intervalAggregator[*reducerAggregation]
+}
+
+func newReducerAggregator(interval int64) *reducerAggregator {
+	return &reducerAggregator{
+		intervalAggregator: newIntervalAggregator(interval, func(name string, tags map[string]string) *reducerAggregation {
+			return &reducerAggregation{name: name, tags: tags}
+		}),
+	}
+}
+
+func (a *reducerAggregator) add(t time.Time, name string, tags map[string]string, function reducerFunction, v float64, isInt bool) error {
+	a.Lock()
+	defer a.Unlock()
+	agg := a.aggregation(t, name, tags)
+	if agg.reducer == nil {
+		agg.reducer = newReducer(function)
+	}
+	return agg.reducer.add(t, v, isInt)
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregationprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func TestReducerFunctions(t *testing.T) {
+	start := time.UnixMilli(1717245000000)
+	// Added out of order, so "last" has to go by timestamp.
+	values := []float64{4, 1, 9, 2}
+	offsets := []time.Duration{0, 3 * time.Second, time.Second, 2 * time.Second}
+
+	tests := []struct {
+		function reducerFunction
+		want     float64
+	}{
+		{reduceSum, 16},
+		{reduceMin, 1},
+		{reduceMax, 9},
+		{reduceAvg, 4},
+		{reduceCount, 4},
+		{reduceLast, 1},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.function), func(t *testing.T) {
+			r := newReducer(tt.function)
+			for i, v := range values {
+				require.NoError(t, r.add(start.Add(offsets[i]), v, false))
+			}
+			assert.Equal(t, tt.want, r.value())
+		})
+	}
+}
+
+func TestReducerPercentiles(t *testing.T) {
+	now := time.Now()
+	p50, p90, p99 := newReducer(reduceP50), newReducer(reduceP90), newReducer(reduceP99)
+	for i := 1; i <= 1000; i++ {
+		for _, r := range []*reducer{p50, p90, p99} {
+			require.NoError(t, r.add(now, float64(i), true))
+		}
+	}
+	assert.InEpsilon(t, 500.0, p50.value(), sketchRelativeAccuracy*2)
+	assert.InEpsilon(t, 900.0, p90.value(), sketchRelativeAccuracy*2)
+	assert.InEpsilon(t, 990.0, p99.value(), sketchRelativeAccuracy*2)
+	assert.False(t, p50.isInt())
+}
+
+func TestReducerIsInt(t *testing.T) {
+	now := time.Now()
+	r := newReducer(reduceMax)
+	require.NoError(t, r.add(now, 3, true))
+	assert.True(t, r.isInt())
+	require.NoError(t, r.add(now, 2.5, false))
+	assert.False(t, r.isInt())
+
+	c := newReducer(reduceCount)
+	require.NoError(t, c.add(now, 2.5, false))
+	assert.True(t, c.isInt())
+}
+
+func TestMatchRule(t *testing.T) {
+	rules := compileRules([]AggregationRule{
+		{MetricName: "queue.depth", Function: "max"},
+		{MetricPattern: `^system\.cpu\.`, Function: "avg"},
+		{MetricPattern: `^queue\.`, Function: "p90"},
+	})
+
+	f, ok := matchRule(rules, "queue.depth")
+	assert.True(t, ok)
+	assert.Equal(t, reduceMax, f)
+
+	f, ok = matchRule(rules, "queue.latency")
+	assert.True(t, ok)
+	assert.Equal(t, reduceP90, f)
+
+	f, ok = matchRule(rules, "system.cpu.utilization")
+	assert.True(t, ok)
+	assert.Equal(t, reduceAvg, f)
+
+	_, ok = matchRule(rules, "system.memory.usage")
+	assert.False(t, ok)
+}
+
+func TestAggregateGaugeWithRule(t *testing.T) {
+	p, mockConsumer := newTestProcessor(t)
+	p.rules = compileRules([]AggregationRule{{MetricName: "queue.depth", Function: "max"}})
+	now := time.Now().Truncate(10 * time.Second)
+
+	md := pmetric.NewMetrics()
+	m := md.ResourceMetrics().AppendEmpty().ScopeMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName("queue.depth")
+	m.SetEmptyGauge()
+	for i, v := range []int64{7, 12, 3} {
+		dp := m.Gauge().DataPoints().AppendEmpty()
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(now.Add(time.Duration(i) * time.Second)))
+		dp.SetIntValue(v)
+		dp.Attributes().PutStr("queue", "orders")
+		dp.Attributes().PutBool(translate.CardinalFieldAggregate, true)
+	}
+
+	_, err := p.ConsumeMetrics(context.Background(), md)
+	require.NoError(t, err)
+	p.emit(now.Add(time.Minute))
+
+	require.Len(t, mockConsumer.ConsumedMetrics, 1)
+	out := mockConsumer.ConsumedMetrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics().At(0)
+	assert.Equal(t, "queue.depth", out.Name())
+	require.Equal(t, pmetric.MetricTypeGauge, out.Type())
+	dp := out.Gauge().DataPoints().At(0)
+	assert.Equal(t, pmetric.NumberDataPointValueTypeInt, dp.ValueType())
+	assert.Equal(t, int64(12), dp.IntValue())
+	assert.Equal(t, pcommon.NewTimestampFromTime(now), dp.Timestamp())
+	assert.Equal(t, map[string]any{
+		"queue":                  "orders",
+		cardinalFieldAggregation: "max",
+	}, dp.Attributes().AsRaw())
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cardinalhq/oteltools/pkg/stats"
+)
+
+// statsCombiner merges stats objects with the same key, and hands back
+// everything it holds once per interval.  It behaves like
+// stats.StatsCombiner, but can also be flushed without recording
+// anything, so stats are reported when no new telemetry arrives.
+type statsCombiner[T stats.StatsObject] struct {
+	sync.Mutex
+	interval time.Duration
+	cutoff   time.Time
+	bucket   *map[uint64][]T
+}
+
+func newStatsCombiner[T stats.StatsObject](now time.Time, interval time.Duration) *statsCombiner[T] {
+	return &statsCombiner[T]{
+		interval: interval,
+		cutoff:   now.Add(interval),
+		bucket:   &map[uint64][]T{},
+	}
+}
+
+// Record adds item, and returns the held stats if the interval has ended.
+func (l *statsCombiner[T]) Record(now time.Time, item T, incKey string, count int, size int64) (*map[uint64][]T, error) {
+	key := item.Key()
+	l.Lock()
+	defer l.Unlock()
+	for _, existing := range (*l.bucket)[key] {
+		if existing.Matches(item) {
+			if err := existing.Increment(incKey, count, size); err != nil {
+				return nil, err
+			}
+			return l.flush(now, false), nil
+		}
+	}
+	if err := item.Initialize(); err != nil {
+		return nil, err
+	}
+	(*l.bucket)[key] = append((*l.bucket)[key], item)
+	return l.flush(now, false), nil
+}
+
+// Flush returns the held stats if the interval has ended, or whenever
+// force is set.  It returns nil if there is nothing to report.
+func (l *statsCombiner[T]) Flush(now time.Time, force bool) *map[uint64][]T {
+	l.Lock()
+	defer l.Unlock()
+	return l.flush(now, force)
+}
+
+func (l *statsCombiner[T]) flush(now time.Time, force bool) *map[uint64][]T {
+	if !force && now.Before(l.cutoff) {
+		return nil
+	}
+	l.cutoff = now.Add(l.interval)
+	if len(*l.bucket) == 0 {
+		return nil
+	}
+	bucketpile := l.bucket
+	l.bucket = &map[uint64][]T{}
+	return bucketpile
+}
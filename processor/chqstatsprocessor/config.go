@@ -20,19 +20,65 @@ import (
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config/confighttp"
+	"go.opentelemetry.io/collector/config/configretry"
 	"go.uber.org/multierr"
 )
 
 type Config struct {
 	Statistics             StatisticsConfig `mapstructure:"statistics"`
 	ConfigurationExtension *component.ID    `mapstructure:"configuration_extension"`
+	Exemplars              ExemplarConfig   `mapstructure:"exemplars"`
+	// LocalSink, if set, keeps recent stats in memory and serves them
+	// over HTTP.
+	LocalSink *LocalSinkConfig `mapstructure:"local_sink"`
 }
 
 type StatisticsConfig struct {
 	confighttp.ClientConfig `mapstructure:",squash"`
 
-	Interval time.Duration `mapstructure:"interval"`
-	Phase    string        `mapstructure:"phase"`
+	Interval     time.Duration             `mapstructure:"interval"`
+	Phase        string                    `mapstructure:"phase"`
+	RetryConfig  configretry.BackOffConfig `mapstructure:"retry_on_failure"`
+	SendingQueue SendingQueueConfig        `mapstructure:"sending_queue"`
+}
+
+// SendingQueueConfig controls how stats reports are queued for sending.
+type SendingQueueConfig struct {
+	// QueueSize is how many reports may wait to be sent.
+	QueueSize int `mapstructure:"queue_size"`
+	// NumConsumers is how many reports may be sent at once.
+	NumConsumers int `mapstructure:"num_consumers"`
+	// SpoolDirectory, if set, is where reports that cannot be queued or
+	// sent are written, to be sent once the endpoint is reachable again.
+	SpoolDirectory string `mapstructure:"spool_directory"`
+	// MaxSpoolFiles is how many reports the spool holds before the
+	// oldest are removed.
+	MaxSpoolFiles int `mapstructure:"max_spool_files"`
+	// DrainTimeout is how long shutdown waits for queued reports to be
+	// sent before spooling or dropping the rest.
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// ExemplarConfig controls which record is kept as the exemplar for each
+// fingerprint in a stats interval.
+type ExemplarConfig struct {
+	// Policy is first, most_recent or reservoir.
+	Policy string `mapstructure:"policy"`
+	// PreferErrors keeps error records over others, whatever the policy.
+	PreferErrors bool `mapstructure:"prefer_errors"`
+	// MaxBytes, if set, limits the size of each exemplar.  Long strings
+	// are truncated to fit, and exemplars that still do not fit are not
+	// kept.
+	MaxBytes int `mapstructure:"max_bytes"`
+}
+
+// LocalSinkConfig controls the local stats sink, which lets operators
+// inspect stats on the collector itself.
+type LocalSinkConfig struct {
+	confighttp.ServerConfig `mapstructure:",squash"`
+
+	// Windows is how many stats reports are kept for each signal.
+	Windows int `mapstructure:"windows"`
 }
 
 type ContextID = string
@@ -41,6 +87,10 @@ func (c *Config) Validate() error {
 	var errs error
 
 	errs = multierr.Append(errs, c.Statistics.Validate())
+	errs = multierr.Append(errs, c.Exemplars.Validate())
+	if c.LocalSink != nil {
+		errs = multierr.Append(errs, c.LocalSink.Validate())
+	}
 
 	return errs
 }
@@ -59,5 +109,61 @@ func (c *StatisticsConfig) Validate() error {
 		errs = multierr.Append(errs, errors.New("phase must be either presample or postsample, not "+c.Phase))
 	}
 
+	errs = multierr.Append(errs, c.RetryConfig.Validate())
+	errs = multierr.Append(errs, c.SendingQueue.Validate())
+
+	return errs
+}
+
+func (c *SendingQueueConfig) Validate() error {
+	var errs error
+
+	if c.QueueSize < 1 {
+		errs = multierr.Append(errs, errors.New("queue_size must be greater than 0"))
+	}
+	if c.NumConsumers < 1 {
+		errs = multierr.Append(errs, errors.New("num_consumers must be greater than 0"))
+	}
+	if c.SpoolDirectory != "" && c.MaxSpoolFiles < 1 {
+		errs = multierr.Append(errs, errors.New("max_spool_files must be greater than 0"))
+	}
+	if c.DrainTimeout < 0 {
+		errs = multierr.Append(errs, errors.New("drain_timeout must be greater than or equal to 0"))
+	}
+
+	return errs
+}
+
+func (c *ExemplarConfig) Validate() error {
+	var errs error
+	if c.Policy == "" {
+		c.Policy = exemplarPolicyFirst
+	}
+
+	switch c.Policy {
+	case exemplarPolicyFirst, exemplarPolicyMostRecent, exemplarPolicyReservoir:
+	default:
+		errs = multierr.Append(errs, errors.New("exemplars policy must be first, most_recent or reservoir, not "+c.Policy))
+	}
+	if c.MaxBytes < 0 {
+		errs = multierr.Append(errs, errors.New("exemplars max_bytes must be greater than or equal to 0"))
+	}
+
+	return errs
+}
+
+func (c *LocalSinkConfig) Validate() error {
+	var errs error
+	if c.Windows == 0 {
+		c.Windows = defaultSinkWindows
+	}
+
+	if c.Endpoint == "" {
+		errs = multierr.Append(errs, errors.New("local_sink endpoint must be set"))
+	}
+	if c.Windows < 0 {
+		errs = multierr.Append(errs, errors.New("local_sink windows must be greater than 0"))
+	}
+
 	return errs
 }
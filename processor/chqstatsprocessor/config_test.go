@@ -25,6 +25,7 @@ import (
 	"go.opentelemetry.io/collector/config/configcompression"
 	"go.opentelemetry.io/collector/config/confighttp"
 	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/config/configretry"
 	"go.opentelemetry.io/collector/otelcol/otelcoltest"
 
 	"github.com/cardinalhq/cardinalhq-otel-collector/processor/chqstatsprocessor/internal/metadata"
@@ -76,9 +77,54 @@ func TestConfig(t *testing.T) {
 					"User-Agent": "cardinalhq-otel-collector",
 				},
 			},
-			Interval: 100 * time.Second,
-			Phase:    "presample",
+			Interval:    100 * time.Second,
+			Phase:       "presample",
+			RetryConfig: configretry.NewDefaultBackOffConfig(),
+			SendingQueue: SendingQueueConfig{
+				QueueSize:     100,
+				NumConsumers:  2,
+				MaxSpoolFiles: 1000,
+				DrainTimeout:  10 * time.Second,
+			},
+		},
+		Exemplars: ExemplarConfig{
+			Policy: exemplarPolicyFirst,
 		},
 	}
 	assert.Equal(t, expected, e)
 }
+
+func TestSendingQueueConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SendingQueueConfig
+		wantErr bool
+	}{
+		{"defaults", createDefaultConfig().(*Config).Statistics.SendingQueue, false},
+		{"empty queue", SendingQueueConfig{QueueSize: 0, NumConsumers: 1}, true},
+		{"no consumers", SendingQueueConfig{QueueSize: 1, NumConsumers: 0}, true},
+		{"spool without limit", SendingQueueConfig{QueueSize: 1, NumConsumers: 1, SpoolDirectory: "/tmp/spool"}, true},
+		{"negative drain timeout", SendingQueueConfig{QueueSize: 1, NumConsumers: 1, DrainTimeout: -time.Second}, true},
+		{"spool", SendingQueueConfig{QueueSize: 1, NumConsumers: 1, SpoolDirectory: "/tmp/spool", MaxSpoolFiles: 10}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestExemplarConfigValidate(t *testing.T) {
+	config := &ExemplarConfig{}
+	require.NoError(t, config.Validate())
+	assert.Equal(t, exemplarPolicyFirst, config.Policy)
+
+	assert.NoError(t, (&ExemplarConfig{Policy: exemplarPolicyReservoir, PreferErrors: true, MaxBytes: 4096}).Validate())
+	assert.Error(t, (&ExemplarConfig{Policy: "random"}).Validate())
+	assert.Error(t, (&ExemplarConfig{MaxBytes: -1}).Validate())
+}
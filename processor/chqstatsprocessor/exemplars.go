@@ -0,0 +1,313 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+)
+
+// Exemplar policies, which choose the record kept for each fingerprint.
+const (
+	exemplarPolicyFirst      = "first"
+	exemplarPolicyMostRecent = "most_recent"
+	exemplarPolicyReservoir  = "reservoir"
+)
+
+// exemplarTruncationLimits are the string lengths tried, longest first,
+// when an exemplar is larger than the configured maximum.
+var exemplarTruncationLimits = []int{1024, 256, 64, 16}
+
+// exemplarCache holds one exemplar per key for the current stats
+// interval.  Copying a record is costly, so callers first offer a key and
+// only build and keep an exemplar if the policy wants it.
+type exemplarCache[K comparable, T any] struct {
+	sync.Mutex
+	policy       string
+	preferErrors bool
+	maxBytes     int
+	size         func(T) int
+	truncate     func(T, int)
+	randN        func(n int64) int64
+	entries      map[K]*exemplarEntry[T]
+}
+
+type exemplarEntry[T any] struct {
+	exemplar T
+	present  bool
+	// kept is set once any record has been offered to keep, even if it
+	// was too large to store, so later records follow the policy instead
+	// of each being copied in turn.
+	kept    bool
+	isError bool
+	// seen counts the records offered that were eligible to replace the
+	// current exemplar, for reservoir sampling.
+	seen int64
+}
+
+func newExemplarCache[K comparable, T any](config ExemplarConfig, size func(T) int, truncate func(T, int)) *exemplarCache[K, T] {
+	return &exemplarCache[K, T]{
+		policy:       config.Policy,
+		preferErrors: config.PreferErrors,
+		maxBytes:     config.MaxBytes,
+		size:         size,
+		truncate:     truncate,
+		randN:        rand.Int64N,
+		entries:      map[K]*exemplarEntry[T]{},
+	}
+}
+
+// offer records that a record was seen for key, and returns true if it
+// should replace the current exemplar.
+func (c *exemplarCache[K, T]) offer(key K, isError bool) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	entry, found := c.entries[key]
+	if !found {
+		c.entries[key] = &exemplarEntry[T]{seen: 1}
+		return true
+	}
+	if c.preferErrors && isError != entry.isError && entry.kept {
+		if !isError {
+			return false
+		}
+		// An error always replaces a record that is not one, and
+		// sampling starts over among errors.
+		entry.seen = 1
+		return true
+	}
+	entry.seen++
+	if !entry.kept {
+		return true
+	}
+	switch c.policy {
+	case exemplarPolicyMostRecent:
+		return true
+	case exemplarPolicyReservoir:
+		// Keeping the nth record with probability 1/n leaves each record
+		// equally likely to be the exemplar.
+		return c.randN(entry.seen) == 0
+	default:
+		return false
+	}
+}
+
+// keep stores exemplar for key, truncating its strings if it is too large.
+// If it cannot be made small enough, the current exemplar is left alone,
+// but the attempt is still recorded.
+func (c *exemplarCache[K, T]) keep(key K, exemplar T, isError bool) {
+	fits := c.fit(exemplar)
+
+	c.Lock()
+	defer c.Unlock()
+	entry, found := c.entries[key]
+	if !found {
+		entry = &exemplarEntry[T]{seen: 1}
+		c.entries[key] = entry
+	}
+	entry.kept = true
+	if !fits {
+		if !entry.present {
+			entry.isError = isError
+		}
+		return
+	}
+	entry.exemplar = exemplar
+	entry.present = true
+	entry.isError = isError
+}
+
+// fit truncates the strings in exemplar until it is no larger than
+// maxBytes, and returns false if it is still too large.
+func (c *exemplarCache[K, T]) fit(exemplar T) bool {
+	if c.maxBytes <= 0 || c.size(exemplar) <= c.maxBytes {
+		return true
+	}
+	for _, limit := range exemplarTruncationLimits {
+		c.truncate(exemplar, limit)
+		if c.size(exemplar) <= c.maxBytes {
+			return true
+		}
+	}
+	return false
+}
+
+// take returns the exemplars held, and starts over for the next interval.
+func (c *exemplarCache[K, T]) take() map[K]T {
+	c.Lock()
+	entries := c.entries
+	c.entries = map[K]*exemplarEntry[T]{}
+	c.Unlock()
+
+	ret := make(map[K]T, len(entries))
+	for key, entry := range entries {
+		if entry.present {
+			ret[key] = entry.exemplar
+		}
+	}
+	return ret
+}
+
+func newLogExemplarCache(config ExemplarConfig, marshaler plog.Marshaler) *exemplarCache[int64, plog.Logs] {
+	return newExemplarCache[int64](config, func(ld plog.Logs) int {
+		b, err := marshaler.MarshalLogs(ld)
+		if err != nil {
+			return math.MaxInt
+		}
+		return len(b)
+	}, truncateLogs)
+}
+
+func newSpanExemplarCache(config ExemplarConfig, marshaler ptrace.Marshaler) *exemplarCache[int64, ptrace.Traces] {
+	return newExemplarCache[int64](config, func(td ptrace.Traces) int {
+		b, err := marshaler.MarshalTraces(td)
+		if err != nil {
+			return math.MaxInt
+		}
+		return len(b)
+	}, truncateTraces)
+}
+
+func newMetricExemplarCache(config ExemplarConfig, marshaler pmetric.Marshaler) *exemplarCache[string, pmetric.Metrics] {
+	return newExemplarCache[string](config, func(md pmetric.Metrics) int {
+		b, err := marshaler.MarshalMetrics(md)
+		if err != nil {
+			return math.MaxInt
+		}
+		return len(b)
+	}, truncateMetrics)
+}
+
+// isErrorLog returns true for records at error severity or above, using
+// the severity text when no number is set.
+func isErrorLog(lr plog.LogRecord) bool {
+	if lr.SeverityNumber() != plog.SeverityNumberUnspecified {
+		return lr.SeverityNumber() >= plog.SeverityNumberError
+	}
+	text := strings.ToUpper(lr.SeverityText())
+	return strings.HasPrefix(text, "ERROR") || strings.HasPrefix(text, "FATAL") || strings.HasPrefix(text, "CRITICAL")
+}
+
+func truncateLogs(ld plog.Logs, limit int) {
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		truncateMap(rl.Resource().Attributes(), limit)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			sl := rl.ScopeLogs().At(j)
+			truncateMap(sl.Scope().Attributes(), limit)
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				lr := sl.LogRecords().At(k)
+				truncateMap(lr.Attributes(), limit)
+				truncateValue(lr.Body(), limit)
+			}
+		}
+	}
+}
+
+func truncateTraces(td ptrace.Traces, limit int) {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		truncateMap(rs.Resource().Attributes(), limit)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			ss := rs.ScopeSpans().At(j)
+			truncateMap(ss.Scope().Attributes(), limit)
+			for k := 0; k < ss.Spans().Len(); k++ {
+				span := ss.Spans().At(k)
+				truncateMap(span.Attributes(), limit)
+				for l := 0; l < span.Events().Len(); l++ {
+					truncateMap(span.Events().At(l).Attributes(), limit)
+				}
+				for l := 0; l < span.Links().Len(); l++ {
+					truncateMap(span.Links().At(l).Attributes(), limit)
+				}
+			}
+		}
+	}
+}
+
+func truncateMetrics(md pmetric.Metrics, limit int) {
+	for i := 0; i < md.ResourceMetrics().Len(); i++ {
+		rm := md.ResourceMetrics().At(i)
+		truncateMap(rm.Resource().Attributes(), limit)
+		for j := 0; j < rm.ScopeMetrics().Len(); j++ {
+			sm := rm.ScopeMetrics().At(j)
+			truncateMap(sm.Scope().Attributes(), limit)
+			for k := 0; k < sm.Metrics().Len(); k++ {
+				m := sm.Metrics().At(k)
+				switch m.Type() {
+				case pmetric.MetricTypeGauge:
+					for l := 0; l < m.Gauge().DataPoints().Len(); l++ {
+						truncateMap(m.Gauge().DataPoints().At(l).Attributes(), limit)
+					}
+				case pmetric.MetricTypeSum:
+					for l := 0; l < m.Sum().DataPoints().Len(); l++ {
+						truncateMap(m.Sum().DataPoints().At(l).Attributes(), limit)
+					}
+				case pmetric.MetricTypeHistogram:
+					for l := 0; l < m.Histogram().DataPoints().Len(); l++ {
+						truncateMap(m.Histogram().DataPoints().At(l).Attributes(), limit)
+					}
+				case pmetric.MetricTypeExponentialHistogram:
+					for l := 0; l < m.ExponentialHistogram().DataPoints().Len(); l++ {
+						truncateMap(m.ExponentialHistogram().DataPoints().At(l).Attributes(), limit)
+					}
+				case pmetric.MetricTypeSummary:
+					for l := 0; l < m.Summary().DataPoints().Len(); l++ {
+						truncateMap(m.Summary().DataPoints().At(l).Attributes(), limit)
+					}
+				}
+			}
+		}
+	}
+}
+
+func truncateMap(m pcommon.Map, limit int) {
+	m.Range(func(_ string, v pcommon.Value) bool {
+		truncateValue(v, limit)
+		return true
+	})
+}
+
+// truncateValue shortens strings longer than limit bytes, including those
+// inside maps and slices, without splitting a UTF-8 sequence.
+func truncateValue(v pcommon.Value, limit int) {
+	switch v.Type() {
+	case pcommon.ValueTypeStr:
+		s := v.Str()
+		if len(s) <= limit {
+			return
+		}
+		n := limit
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		v.SetStr(s[:n])
+	case pcommon.ValueTypeMap:
+		truncateMap(v.Map(), limit)
+	case pcommon.ValueTypeSlice:
+		for i := 0; i < v.Slice().Len(); i++ {
+			truncateValue(v.Slice().At(i), limit)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/plog"
+)
+
+// offerAll offers a record for key for each value, keeping those the
+// cache wants, and returns the exemplar left at the end.
+func offerAll(c *exemplarCache[string, string], key string, values []string, isError func(string) bool) string {
+	for _, v := range values {
+		if c.offer(key, isError(v)) {
+			c.keep(key, v, isError(v))
+		}
+	}
+	return c.take()[key]
+}
+
+func noErrors(string) bool { return false }
+
+func newStringExemplarCache(config ExemplarConfig) *exemplarCache[string, string] {
+	return newExemplarCache[string](config, func(s string) int { return len(s) }, func(string, int) {})
+}
+
+func TestExemplarPolicyFirst(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyFirst})
+	assert.Equal(t, "a", offerAll(c, "fp", []string{"a", "b", "c"}, noErrors))
+
+	// take starts a new interval.
+	assert.Empty(t, c.take())
+	assert.Equal(t, "d", offerAll(c, "fp", []string{"d", "e"}, noErrors))
+}
+
+func TestExemplarPolicyMostRecent(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyMostRecent})
+	assert.Equal(t, "c", offerAll(c, "fp", []string{"a", "b", "c"}, noErrors))
+}
+
+func TestExemplarPolicyReservoir(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyReservoir})
+	var seen []int64
+	c.randN = func(n int64) int64 {
+		seen = append(seen, n)
+		if n == 3 {
+			return 0
+		}
+		return n - 1
+	}
+	assert.Equal(t, "c", offerAll(c, "fp", []string{"a", "b", "c", "d", "e"}, noErrors))
+	assert.Equal(t, []int64{2, 3, 4, 5}, seen)
+}
+
+func TestExemplarPolicyReservoirIsUniform(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyReservoir})
+	values := []string{"a", "b", "c", "d"}
+	counts := map[string]int{}
+	for range 4000 {
+		counts[offerAll(c, "fp", values, noErrors)]++
+	}
+	for _, v := range values {
+		assert.InDelta(t, 1000, counts[v], 200, v)
+	}
+}
+
+func TestExemplarPreferErrors(t *testing.T) {
+	isError := func(s string) bool { return strings.HasPrefix(s, "err") }
+
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyFirst, PreferErrors: true})
+	assert.Equal(t, "err1", offerAll(c, "fp", []string{"ok1", "err1", "ok2", "err2"}, isError))
+	assert.Equal(t, "ok1", offerAll(c, "fp", []string{"ok1", "ok2"}, isError))
+
+	c = newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyMostRecent, PreferErrors: true})
+	assert.Equal(t, "err2", offerAll(c, "fp", []string{"ok1", "err1", "ok2", "err2", "ok3"}, isError))
+
+	// Without the preference, errors are treated like anything else.
+	c = newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyFirst})
+	assert.Equal(t, "ok1", offerAll(c, "fp", []string{"ok1", "err1"}, isError))
+}
+
+func TestExemplarCacheKeysAreIndependent(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyFirst})
+	for _, key := range []string{"x", "y", "x"} {
+		if c.offer(key, false) {
+			c.keep(key, key+"-exemplar", false)
+		}
+	}
+	assert.Equal(t, map[string]string{"x": "x-exemplar", "y": "y-exemplar"}, c.take())
+}
+
+func logExemplar(body string, attr string) plog.Logs {
+	ld := plog.NewLogs()
+	lr := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	lr.Body().SetStr(body)
+	lr.Attributes().PutStr("payload", attr)
+	nested := lr.Attributes().PutEmptyMap("nested")
+	nested.PutStr("inner", attr)
+	return ld
+}
+
+func TestExemplarMaxBytesTruncates(t *testing.T) {
+	c := newLogExemplarCache(ExemplarConfig{Policy: exemplarPolicyMostRecent, MaxBytes: 1500}, &plog.JSONMarshaler{})
+
+	// A multi-byte rune straddles the truncation point.
+	long := strings.Repeat("x", 255) + "é" + strings.Repeat("y", 5000)
+	require.True(t, c.offer(1, false))
+	c.keep(1, logExemplar("short body", long), false)
+
+	exemplar, found := c.take()[1]
+	require.True(t, found)
+	lr := exemplar.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+	payload, _ := lr.Attributes().Get("payload")
+	assert.Equal(t, strings.Repeat("x", 255), payload.Str())
+	assert.True(t, utf8.ValidString(payload.Str()))
+	nested, _ := lr.Attributes().Get("nested")
+	inner, _ := nested.Map().Get("inner")
+	assert.Equal(t, payload.Str(), inner.Str())
+	assert.Equal(t, "short body", lr.Body().Str())
+
+	b, err := (&plog.JSONMarshaler{}).MarshalLogs(exemplar)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(b), 1500)
+}
+
+func TestExemplarMaxBytesKeepsPreviousWhenTooLarge(t *testing.T) {
+	c := newLogExemplarCache(ExemplarConfig{Policy: exemplarPolicyMostRecent, MaxBytes: 1000}, &plog.JSONMarshaler{})
+
+	require.True(t, c.offer(1, false))
+	c.keep(1, logExemplar("first", "small"), false)
+
+	// Too many attributes to ever fit, however short the strings are.
+	big := logExemplar("second", "small")
+	lr := big.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+	for i := range 100 {
+		lr.Attributes().PutInt(strings.Repeat("k", i+1), int64(i))
+	}
+	require.True(t, c.offer(1, false))
+	c.keep(1, big, false)
+
+	exemplar := c.take()[1]
+	assert.Equal(t, "first", exemplar.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0).Body().Str())
+}
+
+func TestExemplarMaxBytesRemembersTooLarge(t *testing.T) {
+	c := newStringExemplarCache(ExemplarConfig{Policy: exemplarPolicyFirst, MaxBytes: 4})
+
+	require.True(t, c.offer("fp", false))
+	c.keep("fp", "much too long", false)
+	// The record that did not fit counts as the first, so later records
+	// are not copied just to be offered again.
+	assert.False(t, c.offer("fp", false))
+	_, found := c.take()["fp"]
+	assert.False(t, found)
+}
+
+func TestLogStatsReportedWithoutExemplar(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Exemplars.MaxBytes = 100
+	p, _ := newFlushTestProc(t, "logs", cfg)
+
+	for range 3 {
+		ld := fingerprintedLogs(42)
+		lr := ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+		for i := range 20 {
+			lr.Attributes().PutInt(strings.Repeat("k", i+1), int64(i))
+		}
+		_, err := p.ConsumeLogs(context.Background(), ld)
+		require.NoError(t, err)
+	}
+	p.flush(p.clock.Now(), true)
+
+	reports := queuedLogReports(t, p.sender)
+	require.Len(t, reports, 1)
+	require.Len(t, reports[0].Stats, 1)
+	assert.Equal(t, int64(42), reports[0].Stats[0].Fingerprint)
+	assert.Equal(t, int64(3), reports[0].Stats[0].Count)
+	assert.Empty(t, reports[0].Stats[0].Exemplar)
+}
+
+func TestIsErrorLog(t *testing.T) {
+	tests := []struct {
+		number plog.SeverityNumber
+		text   string
+		want   bool
+	}{
+		{plog.SeverityNumberInfo, "", false},
+		{plog.SeverityNumberWarn, "ERROR", false},
+		{plog.SeverityNumberError, "", true},
+		{plog.SeverityNumberFatal4, "", true},
+		{plog.SeverityNumberUnspecified, "error", true},
+		{plog.SeverityNumberUnspecified, "Critical", true},
+		{plog.SeverityNumberUnspecified, "info", false},
+	}
+	for _, tt := range tests {
+		lr := plog.NewLogRecord()
+		lr.SetSeverityNumber(tt.number)
+		lr.SetSeverityText(tt.text)
+		assert.Equal(t, tt.want, isErrorLog(lr), "%v %q", tt.number, tt.text)
+	}
+}
+
+func TestLogStatsUseChosenExemplar(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Exemplars.Policy = exemplarPolicyMostRecent
+	cfg.Exemplars.PreferErrors = true
+	p, _ := newFlushTestProc(t, "logs", cfg)
+
+	for _, severity := range []plog.SeverityNumber{plog.SeverityNumberInfo, plog.SeverityNumberError, plog.SeverityNumberInfo} {
+		ld := fingerprintedLogs(42)
+		lr := ld.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+		lr.SetSeverityNumber(severity)
+		lr.Body().SetStr("record at " + severity.String())
+		_, err := p.ConsumeLogs(context.Background(), ld)
+		require.NoError(t, err)
+	}
+	p.flush(p.clock.Now(), true)
+
+	reports := queuedLogReports(t, p.sender)
+	require.Len(t, reports, 1)
+	require.Len(t, reports[0].Stats, 1)
+	exemplar, err := (&plog.JSONUnmarshaler{}).UnmarshalLogs(reports[0].Stats[0].Exemplar)
+	require.NoError(t, err)
+	require.Equal(t, 1, exemplar.LogRecordCount())
+	assert.Equal(t, "record at Error", exemplar.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0).Body().Str())
+	service, _ := exemplar.ResourceLogs().At(0).Resource().Attributes().Get("service.name")
+	assert.Equal(t, "checkout", service.Str())
+}
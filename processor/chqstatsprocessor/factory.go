@@ -22,6 +22,7 @@ import (
 	"go.opentelemetry.io/collector/config/configcompression"
 	"go.opentelemetry.io/collector/config/confighttp"
 	"go.opentelemetry.io/collector/config/configopaque"
+	"go.opentelemetry.io/collector/config/configretry"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/processor"
 	"go.opentelemetry.io/collector/processor/processorhelper"
@@ -42,6 +43,12 @@ func NewFactory() processor.Factory {
 
 const (
 	defaultStatisticsInterval = 1 * time.Minute
+	defaultQueueSize          = 100
+	defaultNumConsumers       = 2
+	defaultMaxSpoolFiles      = 1000
+	defaultDrainTimeout       = 10 * time.Second
+	defaultSinkWindows        = 10
+	defaultSinkLimit          = 20
 )
 
 func createDefaultConfig() component.Config {
@@ -56,6 +63,16 @@ func createDefaultConfig() component.Config {
 				},
 				Compression: configcompression.TypeGzip,
 			},
+			RetryConfig: configretry.NewDefaultBackOffConfig(),
+			SendingQueue: SendingQueueConfig{
+				QueueSize:     defaultQueueSize,
+				NumConsumers:  defaultNumConsumers,
+				MaxSpoolFiles: defaultMaxSpoolFiles,
+				DrainTimeout:  defaultDrainTimeout,
+			},
+		},
+		Exemplars: ExemplarConfig{
+			Policy: exemplarPolicyFirst,
 		},
 	}
 }
@@ -15,11 +15,7 @@
 package chqstatsprocessor
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io"
-	"net/http"
 	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
@@ -50,7 +46,7 @@ func getFingerprint(l pcommon.Map) int64 {
 }
 
 func (e *statsProc) ConsumeLogs(_ context.Context, ld plog.Logs) (plog.Logs, error) {
-	now := time.Now()
+	now := e.clock.Now()
 
 	for i := 0; i < ld.ResourceLogs().Len(); i++ {
 		rl := ld.ResourceLogs().At(i)
@@ -92,85 +88,81 @@ func (e *statsProc) recordLog(ld plog.Logs, now time.Time, serviceName string, f
 		Attributes:  enrichmentAttributes,
 	}
 
-	e.addLogExemplar(ld, fingerprint)
-
+	// The exemplar and the count it belongs to go into the same interval,
+	// so a flush cannot take one without the other.
+	e.recordLock.Lock()
+	e.addLogExemplar(fingerprint, rl, sl, lr)
 	bucketpile, err := e.logstats.Record(now, rec, "", 1, logSize)
+	exemplars := e.takeLogExemplars(bucketpile)
+	e.recordLock.Unlock()
 	if err != nil {
 		return err
 	}
-	e.sendLogStatsWithExemplars(bucketpile, now)
+	e.sendLogStatsWithExemplars(bucketpile, exemplars, now)
 	return nil
 }
 
-func (e *statsProc) sendLogStatsWithExemplars(bucketpile *map[uint64][]*chqpb.LogStats, now time.Time) {
+// flushLogStats reports the held log stats if the interval has ended, or
+// always if force is set.
+func (e *statsProc) flushLogStats(now time.Time, force bool) {
+	e.recordLock.Lock()
+	bucketpile := e.logstats.Flush(now, force)
+	exemplars := e.takeLogExemplars(bucketpile)
+	e.recordLock.Unlock()
+	e.sendLogStatsWithExemplars(bucketpile, exemplars, now)
+}
+
+// takeLogExemplars takes the held exemplars if there are stats to report
+// them with.  The caller must hold recordLock.
+func (e *statsProc) takeLogExemplars(bucketpile *map[uint64][]*chqpb.LogStats) map[int64]plog.Logs {
+	if bucketpile == nil || len(*bucketpile) == 0 {
+		return nil
+	}
+	return e.logExemplars.take()
+}
+
+func (e *statsProc) sendLogStatsWithExemplars(bucketpile *map[uint64][]*chqpb.LogStats, exemplars map[int64]plog.Logs, now time.Time) {
 	if bucketpile != nil && len(*bucketpile) > 0 {
-		for bucketKey, items := range *bucketpile {
-			itemsWithValidExemplars := items[:0]
+		// Every stats item is reported, whether or not there is an
+		// exemplar for its fingerprint.
+		for _, items := range *bucketpile {
 			for _, item := range items {
-				e.exemplarsMu.RLock()
-				exemplar := e.logExemplars[item.Fingerprint]
-				e.exemplarsMu.RUnlock()
-
+				exemplar, found := exemplars[item.Fingerprint]
+				if !found {
+					continue
+				}
 				marshalled, err := e.jsonMarshaller.logsMarshaler.MarshalLogs(exemplar)
 				if err != nil {
+					e.logger.Error("Failed to marshal log exemplar", zap.Error(err))
 					continue
 				}
 				item.Exemplar = marshalled
-				itemsWithValidExemplars = append(itemsWithValidExemplars, item)
-			}
-			if len(itemsWithValidExemplars) > 0 {
-				(*bucketpile)[bucketKey] = itemsWithValidExemplars
-			} else {
-				delete(*bucketpile, bucketKey)
 			}
 		}
 
-		// clear the logExemplars map
-		e.exemplarsMu.Lock()
-		e.logExemplars = make(map[int64]plog.Logs)
-		e.exemplarsMu.Unlock()
-
-		// TODO should send this to a channel and have a separate goroutine send it
-		go e.sendLogStats(context.Background(), now, bucketpile)
+		e.sendLogStats(now, bucketpile)
 	}
 }
 
-func (e *statsProc) addLogExemplar(ld plog.Logs, fingerprint int64) {
-	e.exemplarsMu.RLock()
-	_, found := e.logExemplars[fingerprint]
-	e.exemplarsMu.RUnlock()
-
-	if !found {
-		copyObj := plog.NewLogs()
-		ld.CopyTo(copyObj)
-		// iterate over all 3 levels, and just filter any log records that don't match the fingerprint
-		copyObj.ResourceLogs().RemoveIf(func(rsp plog.ResourceLogs) bool {
-			rsp.ScopeLogs().RemoveIf(func(ss plog.ScopeLogs) bool {
-				foundFp := false // make sure we only add one record matching the fingerprint.
-				ss.LogRecords().RemoveIf(func(lr plog.LogRecord) bool {
-					if getFingerprint(lr.Attributes()) == fingerprint {
-						if !foundFp {
-							foundFp = true
-							return false
-						}
-						return true
-					}
-					return true
-				})
-				return ss.LogRecords().Len() == 0
-			})
-			return rsp.ScopeLogs().Len() == 0
-		})
-
-		if copyObj.ResourceLogs().Len() > 0 {
-			e.exemplarsMu.Lock()
-			e.logExemplars[fingerprint] = copyObj
-			e.exemplarsMu.Unlock()
-		}
+// addLogExemplar offers lr as the exemplar for its fingerprint, copying it
+// with its resource and scope if the exemplar policy keeps it.
+func (e *statsProc) addLogExemplar(fingerprint int64, rl plog.ResourceLogs, sl plog.ScopeLogs, lr plog.LogRecord) {
+	isError := isErrorLog(lr)
+	if !e.logExemplars.offer(fingerprint, isError) {
+		return
 	}
+	exemplar := plog.NewLogs()
+	erl := exemplar.ResourceLogs().AppendEmpty()
+	rl.Resource().CopyTo(erl.Resource())
+	erl.SetSchemaUrl(rl.SchemaUrl())
+	esl := erl.ScopeLogs().AppendEmpty()
+	sl.Scope().CopyTo(esl.Scope())
+	esl.SetSchemaUrl(sl.SchemaUrl())
+	lr.CopyTo(esl.LogRecords().AppendEmpty())
+	e.logExemplars.keep(fingerprint, exemplar, isError)
 }
 
-func (e *statsProc) sendLogStats(ctx context.Context, now time.Time, bucketpile *map[uint64][]*chqpb.LogStats) {
+func (e *statsProc) sendLogStats(now time.Time, bucketpile *map[uint64][]*chqpb.LogStats) {
 	wrapper := &chqpb.LogStatsReport{
 		SubmittedAt: now.UnixMilli(),
 		Stats:       []*chqpb.LogStats{},
@@ -179,36 +171,23 @@ func (e *statsProc) sendLogStats(ctx context.Context, now time.Time, bucketpile
 		wrapper.Stats = append(wrapper.Stats, items...)
 	}
 
-	if err := e.postLogStats(ctx, wrapper); err != nil {
-		e.logger.Error("Failed to send log stats", zap.Error(err))
+	if e.sink != nil {
+		e.sink.addLogs(wrapper)
+	}
+	if err := e.queueLogStats(wrapper); err != nil {
+		e.logger.Error("Failed to queue log stats", zap.Error(err))
 	}
-	e.logger.Debug("Sent log stats", zap.Int("count", len(wrapper.Stats)))
 }
 
-func (e *statsProc) postLogStats(ctx context.Context, wrapper *chqpb.LogStatsReport) error {
+func (e *statsProc) queueLogStats(wrapper *chqpb.LogStatsReport) error {
 	b, err := proto.Marshal(wrapper)
 	if err != nil {
 		return err
 	}
 	telemetry.HistogramRecord(e.statsBatchSize, int64(len(b)))
-	e.logger.Debug("Sending log stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
-	endpoint := e.config.Statistics.Endpoint + "/api/v1/logstats"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-protobuf")
-
-	resp, err := e.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		e.logger.Error("Failed to send log stats", zap.Int("status", resp.StatusCode), zap.String("body", string(body)))
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	e.logger.Debug("Queueing log stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
+	if e.sender != nil {
+		e.sender.enqueue(logStatsKind, b)
 	}
 	return nil
 }
@@ -15,11 +15,7 @@
 package chqstatsprocessor
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
@@ -36,7 +32,7 @@ import (
 )
 
 func (e *statsProc) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) (pmetric.Metrics, error) {
-	now := time.Now()
+	now := e.clock.Now()
 	environment := translate.EnvironmentFromEnv()
 
 	for i := 0; i < md.ResourceMetrics().Len(); i++ {
@@ -50,32 +46,33 @@ func (e *statsProc) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) (pme
 				m := ilm.Metrics().At(k)
 				metricName := m.Name()
 				extra := map[string]string{"name": m.Name()}
+				e.addMetricsExemplar(serviceName, rm, ilm, m)
 
 				switch m.Type() {
 				case pmetric.MetricTypeGauge:
 					for l := 0; l < m.Gauge().DataPoints().Len(); l++ {
 						dp := m.Gauge().DataPoints().At(l)
-						e.processDatapoint(md, now, metricName, pmetric.MetricTypeGauge.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
+						e.processDatapoint(now, metricName, pmetric.MetricTypeGauge.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
 					}
 				case pmetric.MetricTypeSum:
 					for l := 0; l < m.Sum().DataPoints().Len(); l++ {
 						dp := m.Sum().DataPoints().At(l)
-						e.processDatapoint(md, now, metricName, pmetric.MetricTypeSum.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
+						e.processDatapoint(now, metricName, pmetric.MetricTypeSum.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
 					}
 				case pmetric.MetricTypeHistogram:
 					for l := 0; l < m.Histogram().DataPoints().Len(); l++ {
 						dp := m.Histogram().DataPoints().At(l)
-						e.processDatapoint(md, now, metricName, pmetric.MetricTypeHistogram.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
+						e.processDatapoint(now, metricName, pmetric.MetricTypeHistogram.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
 					}
 				case pmetric.MetricTypeSummary:
 					for l := 0; l < m.Summary().DataPoints().Len(); l++ {
 						dp := m.Summary().DataPoints().At(l)
-						e.processDatapoint(md, now, metricName, pmetric.MetricTypeSummary.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
+						e.processDatapoint(now, metricName, pmetric.MetricTypeSummary.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
 					}
 				case pmetric.MetricTypeExponentialHistogram:
 					for l := 0; l < m.ExponentialHistogram().DataPoints().Len(); l++ {
 						dp := m.ExponentialHistogram().DataPoints().At(l)
-						e.processDatapoint(md, now, metricName, pmetric.MetricTypeExponentialHistogram.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
+						e.processDatapoint(now, metricName, pmetric.MetricTypeExponentialHistogram.String(), serviceName, extra, environment, rattr, sattr, dp.Attributes())
 					}
 				}
 			}
@@ -85,9 +82,9 @@ func (e *statsProc) ConsumeMetrics(ctx context.Context, md pmetric.Metrics) (pme
 	return md, nil
 }
 
-func (e *statsProc) processDatapoint(lm pmetric.Metrics, now time.Time, metricName, metricType, serviceName string, extra map[string]string, environment translate.Environment, rattr, sattr, dattr pcommon.Map) {
+func (e *statsProc) processDatapoint(now time.Time, metricName, metricType, serviceName string, extra map[string]string, environment translate.Environment, rattr, sattr, dattr pcommon.Map) {
 	tid := translate.CalculateTID(extra, rattr, sattr, dattr, "metric", environment)
-	if err := e.recordDatapoint(lm, now, metricName, metricType, serviceName, tid, rattr, sattr, dattr); err != nil {
+	if err := e.recordDatapoint(now, metricName, metricType, serviceName, tid, rattr, sattr, dattr); err != nil {
 		e.logger.Error("Failed to record datapoint", zap.Error(err))
 	}
 }
@@ -99,7 +96,7 @@ func computeStatsOnField(k string) bool {
 	return !strings.HasPrefix(k, translate.CardinalFieldPrefixDot)
 }
 
-func (e *statsProc) recordDatapoint(lm pmetric.Metrics, now time.Time, metricName, metricType, serviceName string, tid int64, rattr, sattr, dpAttr pcommon.Map) error {
+func (e *statsProc) recordDatapoint(now time.Time, metricName, metricType, serviceName string, tid int64, rattr, sattr, dpAttr pcommon.Map) error {
 	var errs error
 
 	attributes := e.processEnrichments(map[string]pcommon.Map{
@@ -107,7 +104,6 @@ func (e *statsProc) recordDatapoint(lm pmetric.Metrics, now time.Time, metricNam
 		"scope":    sattr,
 		"metric":   dpAttr,
 	})
-	e.addMetricsExemplar(lm, serviceName, metricName, metricType)
 
 	rattr.Range(func(k string, v pcommon.Value) bool {
 		if computeStatsOnField(k) {
@@ -148,11 +144,14 @@ func (e *statsProc) recordMetric(now time.Time, metricName string, metricType st
 	if err != nil {
 		return err
 	}
-	if bucketpile != nil && len(*bucketpile) > 0 {
-		e.exemplarsMu.RLock()
+	e.sendMetricStatsWithExemplars(bucketpile, now)
+	return nil
+}
 
+func (e *statsProc) sendMetricStatsWithExemplars(bucketpile *map[uint64][]*MetricStat, now time.Time) {
+	if bucketpile != nil && len(*bucketpile) > 0 {
 		var marshalledExemplars []*chqpb.MetricExemplar
-		for fingerprint, exemplar := range e.metricExemplars {
+		for fingerprint, exemplar := range e.metricExemplars.take() {
 			b, err := e.jsonMarshaller.metricsMarshaler.MarshalMetrics(exemplar)
 			if err != nil {
 				e.logger.Error("Failed to marshal metric exemplars", zap.Error(err))
@@ -166,58 +165,31 @@ func (e *statsProc) recordMetric(now time.Time, metricName string, metricType st
 				Exemplar:    b,
 			})
 		}
-		e.exemplarsMu.RUnlock()
-
-		// clear exemplars map for next batch
-		e.exemplarsMu.Lock()
-		e.metricExemplars = make(map[string]pmetric.Metrics)
-		e.exemplarsMu.Unlock()
 
-		// TODO should send this to a channel and have a separate goroutine send it
-		go e.sendMetricStats(context.Background(), now, bucketpile, marshalledExemplars)
+		e.sendMetricStats(now, bucketpile, marshalledExemplars)
 	}
-	return nil
 }
 
-func (e *statsProc) addMetricsExemplar(lm pmetric.Metrics, serviceName, metricName, metricType string) {
-	fingerprint := serviceName + ":" + metricName + ":" + metricType
-	e.exemplarsMu.RLock()
-	_, found := e.metricExemplars[fingerprint]
-	e.exemplarsMu.RUnlock()
-
-	if !found {
-		copyObj := pmetric.NewMetrics()
-		lm.CopyTo(copyObj)
-		// iterate over all 3 levels, and just filter any metrics records that don't match the fingerprint
-		foundFp := false // make sure we only add one record matching the fingerprint.
-		copyObj.ResourceMetrics().RemoveIf(func(rsp pmetric.ResourceMetrics) bool {
-			incomingServiceName := getServiceName(rsp.Resource().Attributes())
-			rsp.ScopeMetrics().RemoveIf(func(ss pmetric.ScopeMetrics) bool {
-				ss.Metrics().RemoveIf(func(lr pmetric.Metric) bool {
-					incomingFingerprint := incomingServiceName + ":" + lr.Name() + ":" + lr.Type().String()
-					if incomingFingerprint == fingerprint {
-						if !foundFp {
-							foundFp = true
-							return false
-						}
-						return true
-					}
-					return true
-				})
-				return ss.Metrics().Len() == 0
-			})
-			return rsp.ScopeMetrics().Len() == 0
-		})
-
-		if copyObj.ResourceMetrics().Len() > 0 {
-			e.exemplarsMu.Lock()
-			e.metricExemplars[fingerprint] = copyObj
-			e.exemplarsMu.Unlock()
-		}
+// addMetricsExemplar offers m as the exemplar for its service, name and
+// type, copying it with its resource and scope if the exemplar policy
+// keeps it.
+func (e *statsProc) addMetricsExemplar(serviceName string, rm pmetric.ResourceMetrics, sm pmetric.ScopeMetrics, m pmetric.Metric) {
+	fingerprint := serviceName + ":" + m.Name() + ":" + m.Type().String()
+	if !e.metricExemplars.offer(fingerprint, false) {
+		return
 	}
+	exemplar := pmetric.NewMetrics()
+	erm := exemplar.ResourceMetrics().AppendEmpty()
+	rm.Resource().CopyTo(erm.Resource())
+	erm.SetSchemaUrl(rm.SchemaUrl())
+	esm := erm.ScopeMetrics().AppendEmpty()
+	sm.Scope().CopyTo(esm.Scope())
+	esm.SetSchemaUrl(sm.SchemaUrl())
+	m.CopyTo(esm.Metrics().AppendEmpty())
+	e.metricExemplars.keep(fingerprint, exemplar, false)
 }
 
-func (e *statsProc) sendMetricStats(ctx context.Context, now time.Time, bucketpile *map[uint64][]*MetricStat, marshalledExemplars []*chqpb.MetricExemplar) {
+func (e *statsProc) sendMetricStats(now time.Time, bucketpile *map[uint64][]*MetricStat, marshalledExemplars []*chqpb.MetricExemplar) {
 	wrapper := &chqpb.MetricStatsReport{
 		SubmittedAt: now.UnixMilli(),
 		Stats:       []*chqpb.MetricStats{},
@@ -253,36 +225,23 @@ func (e *statsProc) sendMetricStats(ctx context.Context, now time.Time, bucketpi
 		}
 	}
 
-	if err := e.postMetricStats(ctx, wrapper); err != nil {
-		e.logger.Error("Failed to send metric stats", zap.Error(err))
+	if e.sink != nil {
+		e.sink.addMetrics(wrapper)
+	}
+	if err := e.queueMetricStats(wrapper); err != nil {
+		e.logger.Error("Failed to queue metric stats", zap.Error(err))
 	}
-	e.logger.Debug("Sent metric stats", zap.Int("count", len(wrapper.Stats)))
 }
 
-func (e *statsProc) postMetricStats(ctx context.Context, wrapper *chqpb.MetricStatsReport) error {
+func (e *statsProc) queueMetricStats(wrapper *chqpb.MetricStatsReport) error {
 	b, err := proto.Marshal(wrapper)
 	if err != nil {
 		return err
 	}
 	telemetry.HistogramRecord(e.statsBatchSize, int64(len(b)))
-	e.logger.Debug("Sending metric stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
-	endpoint := e.config.Statistics.Endpoint + "/api/v1/metricstats"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-protobuf")
-
-	resp, err := e.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		e.logger.Error("Failed to send metric stats", zap.Int("status", resp.StatusCode), zap.String("body", string(body)))
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	e.logger.Debug("Queueing metric stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
+	if e.sender != nil {
+		e.sender.enqueue(metricStatsKind, b)
 	}
 	return nil
 }
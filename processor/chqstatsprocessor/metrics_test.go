@@ -20,19 +20,25 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
-	"go.opentelemetry.io/collector/config/confighttp"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/cardinalhq/oteltools/pkg/chqpb"
 )
 
-func TestPostMetricStats(t *testing.T) {
+func TestQueueMetricStats(t *testing.T) {
+	received := make(chan struct{})
 	// Create a mock server to handle the HTTP request
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(received)
 		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/metricstats", r.URL.Path)
 		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
 
 		body, err := io.ReadAll(r.Body)
@@ -58,16 +64,18 @@ func TestPostMetricStats(t *testing.T) {
 	defer server.Close()
 
 	// Create a statsExporter instance with the mock server's URL and API key
+	cfg := createDefaultConfig().(*Config)
+	cfg.Statistics.Endpoint = server.URL
+	sender, err := newStatsSender(&cfg.Statistics, server.Client(), zap.NewNop(), componenttest.NewNopTelemetrySettings(), attribute.NewSet())
+	require.NoError(t, err)
+	sender.start()
+	defer func() {
+		assert.NoError(t, sender.stop(context.Background()))
+	}()
 	processor := &statsProc{
-		config: &Config{
-			Statistics: StatisticsConfig{
-				ClientConfig: confighttp.ClientConfig{
-					Endpoint: server.URL,
-				},
-			},
-		},
-		httpClient: server.Client(),
-		logger:     zap.NewNop(),
+		config: cfg,
+		logger: zap.NewNop(),
+		sender: sender,
 	}
 
 	// Create a mock MetricStatsReport
@@ -86,9 +94,11 @@ func TestPostMetricStats(t *testing.T) {
 		},
 	}
 
-	// Call the postMetricStats function
-	err := processor.postMetricStats(context.Background(), report)
-
-	// Verify that no error occurred
-	assert.NoError(t, err)
+	// Queue the report, and wait for the sender to post it
+	assert.NoError(t, processor.queueMetricStats(report))
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stats report was not sent")
+	}
 }
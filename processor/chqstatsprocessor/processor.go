@@ -43,7 +43,6 @@ import (
 	"github.com/cardinalhq/cardinalhq-otel-collector/extension/chqconfigextension"
 	"github.com/cardinalhq/oteltools/pkg/chqpb"
 	"github.com/cardinalhq/oteltools/pkg/ottl"
-	"github.com/cardinalhq/oteltools/pkg/stats"
 )
 
 func newMarshaller() otelJsonMarshaller {
@@ -75,14 +74,20 @@ type statsProc struct {
 
 	configCallbackID int
 
-	logstats    *stats.StatsCombiner[*chqpb.LogStats]
-	spanStats   *stats.StatsCombiner[*chqpb.SpanStats]
-	metricstats *stats.StatsCombiner[*MetricStat]
+	logstats    *statsCombiner[*chqpb.LogStats]
+	spanStats   *statsCombiner[*chqpb.SpanStats]
+	metricstats *statsCombiner[*MetricStat]
 
-	exemplarsMu     sync.RWMutex
-	logExemplars    map[int64]plog.Logs
-	traceExemplars  map[int64]ptrace.Traces
-	metricExemplars map[string]pmetric.Metrics
+	clock     clock
+	stopFlush chan struct{}
+	flushDone chan struct{}
+
+	// recordLock makes adding an exemplar and recording its stats one
+	// step with respect to flushing them.
+	recordLock      sync.Mutex
+	logExemplars    *exemplarCache[int64, plog.Logs]
+	traceExemplars  *exemplarCache[int64, ptrace.Traces]
+	metricExemplars *exemplarCache[string, pmetric.Metrics]
 
 	jsonMarshaller otelJsonMarshaller
 
@@ -90,6 +95,9 @@ type statsProc struct {
 	metricsStatsEnrichments atomic.Pointer[[]ottl.StatsEnrichment]
 	tracesStatsEnrichments  atomic.Pointer[[]ottl.StatsEnrichment]
 	statsBatchSize          telemetry.DeferrableHistogram
+	attrset                 attribute.Set
+	sender                  *statsSender
+	sink                    *localSink
 }
 
 func newStatsProc(config *Config, ttype string, set processor.Settings) (*statsProc, error) {
@@ -101,13 +109,15 @@ func newStatsProc(config *Config, ttype string, set processor.Settings) (*statsP
 		httpClientSettings: config.Statistics.ClientConfig,
 		telemetrySettings:  set.TelemetrySettings,
 		jsonMarshaller:     newMarshaller(),
-		logExemplars:       make(map[int64]plog.Logs),
-		traceExemplars:     make(map[int64]ptrace.Traces),
-		metricExemplars:    make(map[string]pmetric.Metrics),
 		logger:             set.Logger,
 		podName:            os.Getenv("POD_NAME"),
+		clock:              realClock{},
 	}
 
+	dog.logExemplars = newLogExemplarCache(config.Exemplars, dog.jsonMarshaller.logsMarshaler)
+	dog.traceExemplars = newSpanExemplarCache(config.Exemplars, dog.jsonMarshaller.tracesMarshaler)
+	dog.metricExemplars = newMetricExemplarCache(config.Exemplars, dog.jsonMarshaller.metricsMarshaler)
+
 	if config.Statistics.Phase == "presample" {
 		dog.pbPhase = chqpb.Phase_PRE
 	} else {
@@ -116,13 +126,13 @@ func newStatsProc(config *Config, ttype string, set processor.Settings) (*statsP
 
 	switch ttype {
 	case "logs":
-		dog.logstats = stats.NewStatsCombiner[*chqpb.LogStats](now, config.Statistics.Interval)
+		dog.logstats = newStatsCombiner[*chqpb.LogStats](now, config.Statistics.Interval)
 		dog.logger.Info("sending log statistics", zap.Duration("interval", config.Statistics.Interval))
 	case "metrics":
-		dog.metricstats = stats.NewStatsCombiner[*MetricStat](now, config.Statistics.Interval)
+		dog.metricstats = newStatsCombiner[*MetricStat](now, config.Statistics.Interval)
 		dog.logger.Info("sending metric statistics", zap.Duration("interval", config.Statistics.Interval))
 	case "traces":
-		dog.spanStats = stats.NewStatsCombiner[*chqpb.SpanStats](now, config.Statistics.Interval)
+		dog.spanStats = newStatsCombiner[*chqpb.SpanStats](now, config.Statistics.Interval)
 		dog.logger.Info("sending span statistics", zap.Duration("interval", config.Statistics.Interval))
 	}
 
@@ -141,6 +151,7 @@ func newStatsProc(config *Config, ttype string, set processor.Settings) (*statsP
 		return nil, histogramError
 	}
 	dog.statsBatchSize = histogram
+	dog.attrset = attrset
 
 	return dog, nil
 }
@@ -149,6 +160,24 @@ func (e *statsProc) Capabilities() consumer.Capabilities {
 	return consumer.Capabilities{MutatesData: false}
 }
 
+// clock is the source of time for the flush task, so tests can step
+// through intervals.
+type clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
 func (e *statsProc) Start(ctx context.Context, host component.Host) error {
 	httpClient, err := e.httpClientSettings.ToClient(ctx, host, e.telemetrySettings)
 	if err != nil {
@@ -156,6 +185,15 @@ func (e *statsProc) Start(ctx context.Context, host component.Host) error {
 	}
 	e.httpClient = httpClient
 
+	// Without an endpoint, stats are only kept by the local sink.
+	if e.config.Statistics.Endpoint != "" {
+		sender, err := newStatsSender(&e.config.Statistics, httpClient, e.logger, e.telemetrySettings, e.attrset)
+		if err != nil {
+			return err
+		}
+		e.sender = sender
+	}
+
 	ext, found := host.GetExtensions()[*e.config.ConfigurationExtension]
 	if !found {
 		return errors.New("configuration extension " + e.config.ConfigurationExtension.String() + " not found")
@@ -167,15 +205,78 @@ func (e *statsProc) Start(ctx context.Context, host component.Host) error {
 	e.configExtension = cext
 	e.configCallbackID = e.configExtension.RegisterCallback(e.id.String()+"/"+e.ttype, e.configUpdateCallback)
 
+	if e.config.LocalSink != nil {
+		sink, err := acquireLocalSink(ctx, host, e.telemetrySettings, e.config.LocalSink)
+		if err != nil {
+			return err
+		}
+		e.sink = sink
+	}
+
+	if e.sender != nil {
+		e.sender.start()
+	}
+	e.startFlushTask()
 	return nil
 }
 
+func (e *statsProc) startFlushTask() {
+	ticks, stop := e.clock.NewTicker(e.config.Statistics.Interval)
+	e.stopFlush = make(chan struct{})
+	e.flushDone = make(chan struct{})
+	go e.flushTask(ticks, stop)
+}
+
+// Shutdown stops the flush task, reports whatever stats are held, and
+// waits for queued reports to be sent.
 func (e *statsProc) Shutdown(ctx context.Context) error {
 	var errors *multierror.Error
-	e.configExtension.UnregisterCallback(e.configCallbackID)
+	if e.configExtension != nil {
+		e.configExtension.UnregisterCallback(e.configCallbackID)
+	}
+	if e.stopFlush != nil {
+		close(e.stopFlush)
+		<-e.flushDone
+		e.stopFlush = nil
+	}
+	e.flush(e.clock.Now(), true)
+	if e.sender != nil {
+		errors = multierror.Append(errors, e.sender.stop(ctx))
+	}
+	if e.sink != nil {
+		errors = multierror.Append(errors, e.sink.release(ctx))
+	}
 	return errors.ErrorOrNil()
 }
 
+// flushTask reports stats on every tick, so they are sent on time even
+// when no telemetry arrives to trigger a flush.
+func (e *statsProc) flushTask(ticks <-chan time.Time, stopTicker func()) {
+	defer close(e.flushDone)
+	defer stopTicker()
+	for {
+		select {
+		case <-e.stopFlush:
+			return
+		case <-ticks:
+			e.flush(e.clock.Now(), false)
+		}
+	}
+}
+
+// flush reports the stats for this signal if the interval has ended, or
+// always if force is set.
+func (e *statsProc) flush(now time.Time, force bool) {
+	switch e.ttype {
+	case "logs":
+		e.flushLogStats(now, force)
+	case "metrics":
+		e.sendMetricStatsWithExemplars(e.metricstats.Flush(now, force), now)
+	case "traces":
+		e.flushSpanStats(now, force)
+	}
+}
+
 func (e *statsProc) processEnrichments(attributesByScope map[string]pcommon.Map) []*chqpb.Attribute {
 	tags := make([]*chqpb.Attribute, 0)
 	var enrichments *[]ottl.StatsEnrichment
@@ -0,0 +1,268 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/cardinalhq/oteltools/pkg/chqpb"
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+type fakeClock struct {
+	sync.Mutex
+	now      time.Time
+	ticks    chan time.Time
+	interval time.Duration
+	stopped  bool
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.Lock()
+	defer c.Unlock()
+	c.interval = d
+	return c.ticks, func() {
+		c.Lock()
+		defer c.Unlock()
+		c.stopped = true
+	}
+}
+
+// advance moves the clock forward by d and ticks.  It returns once the
+// flush task has handled the tick: the tick channel is unbuffered, so the
+// second send cannot complete until the flush task is waiting again, and
+// that tick reports nothing new.
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.Unlock()
+	c.ticks <- now
+	c.ticks <- now
+}
+
+// newFlushTestProc returns a processor for ttype on a fake clock, whose
+// sender is not started so reports stay in its queue.
+func newFlushTestProc(t *testing.T, ttype string, cfg *Config) (*statsProc, *fakeClock) {
+	p, err := newStatsProc(cfg, ttype, processortest.NewNopSettings())
+	require.NoError(t, err)
+	clk := newFakeClock(time.UnixMilli(1717245000000))
+	p.clock = clk
+	p.logstats = newStatsCombiner[*chqpb.LogStats](clk.Now(), cfg.Statistics.Interval)
+	p.spanStats = newStatsCombiner[*chqpb.SpanStats](clk.Now(), cfg.Statistics.Interval)
+	p.metricstats = newStatsCombiner[*MetricStat](clk.Now(), cfg.Statistics.Interval)
+	p.sender = newTestSender(t, &cfg.Statistics)
+	return p, clk
+}
+
+func fingerprintedLogs(fingerprint int64) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "checkout")
+	lr := rl.ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+	lr.Body().SetStr("payment accepted")
+	lr.Attributes().PutInt(translate.CardinalFieldFingerprint, fingerprint)
+	return ld
+}
+
+func queuedLogReports(t *testing.T, s *statsSender) []*chqpb.LogStatsReport {
+	var reports []*chqpb.LogStatsReport
+	for len(s.queue) > 0 {
+		p := <-s.queue
+		require.Equal(t, logStatsKind, p.kind)
+		report := &chqpb.LogStatsReport{}
+		require.NoError(t, proto.Unmarshal(p.body, report))
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func TestFlushTaskReportsOnInterval(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Statistics.Interval = 10 * time.Second
+	p, clk := newFlushTestProc(t, "logs", cfg)
+	p.startFlushTask()
+	assert.Equal(t, 10*time.Second, clk.interval)
+
+	_, err := p.ConsumeLogs(context.Background(), fingerprintedLogs(42))
+	require.NoError(t, err)
+	clk.advance(5 * time.Second)
+	_, err = p.ConsumeLogs(context.Background(), fingerprintedLogs(42))
+	require.NoError(t, err)
+	assert.Empty(t, queuedLogReports(t, p.sender))
+
+	// Nothing else arrives, but the stats are reported when the interval
+	// ends.
+	clk.advance(5 * time.Second)
+	reports := queuedLogReports(t, p.sender)
+	require.Len(t, reports, 1)
+	assert.Equal(t, clk.Now().UnixMilli(), reports[0].SubmittedAt)
+	require.Len(t, reports[0].Stats, 1)
+	assert.Equal(t, int64(42), reports[0].Stats[0].Fingerprint)
+	assert.Equal(t, int64(2), reports[0].Stats[0].Count)
+
+	// Empty intervals send nothing.
+	clk.advance(10 * time.Second)
+	assert.Empty(t, queuedLogReports(t, p.sender))
+
+	_, err = p.ConsumeLogs(context.Background(), fingerprintedLogs(7))
+	require.NoError(t, err)
+	clk.advance(10 * time.Second)
+	reports = queuedLogReports(t, p.sender)
+	require.Len(t, reports, 1)
+	assert.Equal(t, int64(7), reports[0].Stats[0].Fingerprint)
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.True(t, clk.stopped)
+}
+
+func TestFlushTaskReportsSpans(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Statistics.Interval = time.Minute
+	p, clk := newFlushTestProc(t, "traces", cfg)
+	p.startFlushTask()
+
+	td := ptrace.NewTraces()
+	span := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+	span.SetName("GET /cart")
+	span.Attributes().PutInt(translate.CardinalFieldFingerprint, 99)
+	_, err := p.ConsumeTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	clk.advance(30 * time.Second)
+	assert.Empty(t, p.sender.queue)
+	clk.advance(30 * time.Second)
+	require.Len(t, p.sender.queue, 1)
+	assert.Equal(t, spanStatsKind, (<-p.sender.queue).kind)
+
+	require.NoError(t, p.Shutdown(context.Background()))
+}
+
+func TestShutdownFlushesHeldStats(t *testing.T) {
+	server := newFlakyStatsServer(t, func(int) int { return 0 })
+	cfg := createDefaultConfig().(*Config)
+	cfg.Statistics.Endpoint = server.URL
+	p, clk := newFlushTestProc(t, "logs", cfg)
+	p.sender.start()
+	p.startFlushTask()
+
+	_, err := p.ConsumeLogs(context.Background(), fingerprintedLogs(42))
+	require.NoError(t, err)
+	clk.advance(time.Second)
+
+	// The interval has not ended, but shutdown reports it anyway, and
+	// waits for it to be sent.
+	require.NoError(t, p.Shutdown(context.Background()))
+	received := server.receivedSorted()
+	require.Len(t, received, 1)
+	assert.Contains(t, received[0], "/api/v1/logstats ")
+	assert.True(t, clk.stopped)
+}
+
+func TestShutdownDrainDeadline(t *testing.T) {
+	release := make(chan struct{})
+	server := newFlakyStatsServer(t, func(int) int {
+		<-release
+		return http.StatusServiceUnavailable
+	})
+	defer close(release)
+	cfg := createDefaultConfig().(*Config)
+	cfg.Statistics.Endpoint = server.URL
+	cfg.Statistics.SendingQueue.DrainTimeout = 50 * time.Millisecond
+	cfg.Statistics.SendingQueue.SpoolDirectory = t.TempDir()
+	p, _ := newFlushTestProc(t, "logs", cfg)
+	p.sender.start()
+	p.startFlushTask()
+
+	_, err := p.ConsumeLogs(context.Background(), fingerprintedLogs(42))
+	require.NoError(t, err)
+
+	// The endpoint never answers, so shutdown gives up at the deadline and
+	// spools the report.
+	started := time.Now()
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Less(t, time.Since(started), 5*time.Second)
+	names, err := p.sender.spool.list()
+	require.NoError(t, err)
+	assert.Len(t, names, 1)
+}
+
+func TestConcurrentFlushKeepsExemplarsWithStats(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	p, _ := newFlushTestProc(t, "logs", cfg)
+	p.sender.queue = make(chan statsPayload, 10000)
+
+	const writers, records = 4, 200
+	var wg sync.WaitGroup
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each fingerprint is seen once, so a count that lost its
+			// exemplar to a flush would not pick up another one.
+			for i := range records {
+				_, err := p.ConsumeLogs(context.Background(), fingerprintedLogs(int64(w*records+i)))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				p.flush(p.clock.Now(), true)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-done
+	p.flush(p.clock.Now(), true)
+
+	total := int64(0)
+	for _, report := range queuedLogReports(t, p.sender) {
+		for _, stat := range report.Stats {
+			total += stat.Count
+			assert.NotEmpty(t, stat.Exemplar, "fingerprint %d", stat.Fingerprint)
+		}
+	}
+	assert.Equal(t, int64(writers*records), total)
+}
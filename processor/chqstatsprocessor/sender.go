@@ -0,0 +1,344 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/configretry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/processor/chqstatsprocessor/internal/metadata"
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+)
+
+// The kinds of stats report, which are also the last element of the API
+// path each is posted to.
+const (
+	logStatsKind    = "logstats"
+	spanStatsKind   = "spanstats"
+	metricStatsKind = "metricstats"
+)
+
+// Delivery outcomes, recorded on the stats_deliveries counter.
+const (
+	outcomeSent    = "sent"
+	outcomeRetried = "retried"
+	outcomeSpooled = "spooled"
+	outcomeDropped = "dropped"
+)
+
+// errStatsRejected marks reports that can never be delivered, because
+// the endpoint refused them or the request could not be built.
+var errStatsRejected = errors.New("stats report rejected")
+
+// statsPayload is a serialized chqpb stats report waiting to be posted.
+type statsPayload struct {
+	kind string
+	body []byte
+}
+
+// statsSender posts stats reports to the control plane from a bounded
+// queue, using a fixed number of sender goroutines.  Failed posts are
+// retried with backoff.  If a spool directory is configured, reports
+// that do not fit in the queue or run out of retries are written there
+// and replayed once the endpoint accepts reports again.
+type statsSender struct {
+	config   SendingQueueConfig
+	retry    configretry.BackOffConfig
+	endpoint string
+	client   *http.Client
+	logger   *zap.Logger
+	spool    *statsSpool
+
+	queue     chan statsPayload
+	closing   chan struct{}
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	stopOnce  sync.Once
+	replaying sync.Mutex
+
+	deliveries *telemetry.DeferrableInt64Counter
+}
+
+func newStatsSender(config *StatisticsConfig, client *http.Client, logger *zap.Logger, set component.TelemetrySettings, attrset attribute.Set) (*statsSender, error) {
+	deliveries, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set),
+		"stats_deliveries",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of stats reports by delivery outcome"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &statsSender{
+		config:     config.SendingQueue,
+		retry:      config.RetryConfig,
+		endpoint:   config.Endpoint,
+		client:     client,
+		logger:     logger,
+		queue:      make(chan statsPayload, config.SendingQueue.QueueSize),
+		closing:    make(chan struct{}),
+		deliveries: deliveries,
+	}
+	if config.SendingQueue.SpoolDirectory != "" {
+		s.spool, err = newStatsSpool(config.SendingQueue.SpoolDirectory, config.SendingQueue.MaxSpoolFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s, nil
+}
+
+// start launches the sender goroutines, and replays anything left in
+// the spool from before.
+func (s *statsSender) start() {
+	for i := 0; i < s.config.NumConsumers; i++ {
+		s.wg.Add(1)
+		go s.run()
+	}
+	if s.spool != nil {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.replaySpool()
+		}()
+	}
+}
+
+// stop lets the sender goroutines finish what is queued, until ctx is
+// done or the drain timeout passes, and then cancels whatever is still
+// in progress.  Reports that were not sent are spooled if possible, and
+// dropped otherwise.
+func (s *statsSender) stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.closing) })
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	drainCtx, cancel := context.WithTimeout(ctx, s.config.DrainTimeout)
+	defer cancel()
+	select {
+	case <-done:
+	case <-drainCtx.Done():
+		s.logger.Warn("Timed out sending queued stats reports", zap.Int("queued", len(s.queue)))
+	}
+	s.cancel()
+	<-done
+
+	for {
+		select {
+		case p := <-s.queue:
+			s.keep(p)
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// enqueue adds a report to the queue without blocking.  If the queue is
+// full or the sender has stopped, the report is spooled or dropped.
+func (s *statsSender) enqueue(kind string, body []byte) {
+	p := statsPayload{kind: kind, body: body}
+	select {
+	case <-s.closing:
+		s.keep(p)
+		return
+	default:
+	}
+	select {
+	case s.queue <- p:
+	default:
+		s.logger.Warn("Stats queue is full", zap.String("kind", kind))
+		s.keep(p)
+	}
+}
+
+// run sends queued reports until the sender is cancelled, or until it is
+// stopping and the queue is empty.
+func (s *statsSender) run() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case p := <-s.queue:
+			s.deliver(p)
+		case <-s.closing:
+			if len(s.queue) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// deliver posts a report, retrying transient failures until the retry
+// policy gives up or the sender is stopped.
+func (s *statsSender) deliver(p statsPayload) {
+	var b backoff.BackOff = &backoff.StopBackOff{}
+	if s.retry.Enabled {
+		b = backoff.NewExponentialBackOff(
+			backoff.WithInitialInterval(s.retry.InitialInterval),
+			backoff.WithRandomizationFactor(s.retry.RandomizationFactor),
+			backoff.WithMultiplier(s.retry.Multiplier),
+			backoff.WithMaxInterval(s.retry.MaxInterval),
+			backoff.WithMaxElapsedTime(s.retry.MaxElapsedTime),
+		)
+	}
+	err := backoff.RetryNotify(func() error {
+		return s.post(s.ctx, p)
+	}, backoff.WithContext(b, s.ctx), func(err error, _ time.Duration) {
+		s.count(p.kind, outcomeRetried)
+		s.logger.Debug("Retrying stats report", zap.String("kind", p.kind), zap.Error(err))
+	})
+
+	switch {
+	case err == nil:
+		s.count(p.kind, outcomeSent)
+		if s.spool != nil {
+			s.replaySpool()
+		}
+	case errors.Is(err, errStatsRejected):
+		s.logger.Error("Dropping stats report the endpoint rejected", zap.String("kind", p.kind), zap.Error(err))
+		s.count(p.kind, outcomeDropped)
+	default:
+		s.logger.Warn("Failed to send stats report", zap.String("kind", p.kind), zap.Error(err))
+		s.keep(p)
+	}
+}
+
+// keep spools a report that could not be sent, or drops it if there is
+// no spool.
+func (s *statsSender) keep(p statsPayload) {
+	if s.spool == nil {
+		s.count(p.kind, outcomeDropped)
+		return
+	}
+	dropped, err := s.spool.write(p)
+	if err != nil {
+		s.logger.Error("Failed to spool stats report", zap.String("kind", p.kind), zap.Error(err))
+		s.count(p.kind, outcomeDropped)
+		return
+	}
+	s.count(p.kind, outcomeSpooled)
+	for _, kind := range dropped {
+		s.count(kind, outcomeDropped)
+	}
+}
+
+// replaySpool sends spooled reports oldest first, stopping at the first
+// one that fails for a reason that may go away.  Only one replay runs at
+// a time; if one is already running this returns at once.
+func (s *statsSender) replaySpool() {
+	if !s.replaying.TryLock() {
+		return
+	}
+	defer s.replaying.Unlock()
+
+	names, err := s.spool.list()
+	if err != nil {
+		s.logger.Error("Failed to list stats spool", zap.Error(err))
+		return
+	}
+	for _, name := range names {
+		if s.ctx.Err() != nil {
+			return
+		}
+		p, err := s.spool.read(name)
+		if err != nil {
+			s.logger.Error("Removing unreadable spooled stats report", zap.String("file", name), zap.Error(err))
+			_ = s.spool.remove(name)
+			continue
+		}
+		err = s.post(s.ctx, p)
+		switch {
+		case err == nil:
+			s.count(p.kind, outcomeSent)
+		case errors.Is(err, errStatsRejected):
+			s.logger.Error("Dropping spooled stats report the endpoint rejected", zap.String("kind", p.kind), zap.Error(err))
+			s.count(p.kind, outcomeDropped)
+		default:
+			return
+		}
+		if err := s.spool.remove(name); err != nil {
+			s.logger.Error("Failed to remove spooled stats report", zap.String("file", name), zap.Error(err))
+			return
+		}
+	}
+}
+
+// post sends one report.  Failures that will never succeed wrap
+// errStatsRejected, and are returned as permanent errors so they are not
+// retried.
+func (s *statsSender) post(ctx context.Context, p statsPayload) error {
+	endpoint := s.endpoint + "/api/v1/" + p.kind
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(p.body))
+	if err != nil {
+		return backoff.Permanent(fmt.Errorf("%w: %w", errStatsRejected, err))
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	if !isRetryableStatus(resp.StatusCode) {
+		return backoff.Permanent(fmt.Errorf("%w: status code %d, body: %s", errStatsRejected, resp.StatusCode, body))
+	}
+	return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, body)
+}
+
+// isRetryableStatus returns true if the status code indicates a
+// transient condition on the receiving side.
+func isRetryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+func (s *statsSender) count(kind, outcome string) {
+	telemetry.CounterAdd(s.deliveries, 1, metric.WithAttributes(
+		attribute.String("kind", kind),
+		attribute.String("outcome", outcome),
+	))
+}
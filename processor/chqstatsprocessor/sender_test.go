@@ -0,0 +1,257 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// flakyStatsServer accepts stats reports, failing whenever fail says to.
+type flakyStatsServer struct {
+	sync.Mutex
+	*httptest.Server
+	requests int
+	received []string
+	fail     func(request int) int
+}
+
+func newFlakyStatsServer(t *testing.T, fail func(request int) int) *flakyStatsServer {
+	s := &flakyStatsServer{fail: fail}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.Lock()
+		defer s.Unlock()
+		s.requests++
+		if code := s.fail(s.requests); code != 0 {
+			w.WriteHeader(code)
+			return
+		}
+		s.received = append(s.received, r.URL.Path+" "+string(body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *flakyStatsServer) receivedSorted() []string {
+	s.Lock()
+	defer s.Unlock()
+	ret := slices.Clone(s.received)
+	slices.Sort(ret)
+	return ret
+}
+
+func (s *flakyStatsServer) setFail(fail func(request int) int) {
+	s.Lock()
+	defer s.Unlock()
+	s.fail = fail
+}
+
+func testSenderConfig(endpoint string) *StatisticsConfig {
+	cfg := createDefaultConfig().(*Config).Statistics
+	cfg.Endpoint = endpoint
+	cfg.RetryConfig.InitialInterval = time.Millisecond
+	cfg.RetryConfig.MaxInterval = 5 * time.Millisecond
+	cfg.RetryConfig.MaxElapsedTime = 5 * time.Second
+	return &cfg
+}
+
+func newTestSender(t *testing.T, cfg *StatisticsConfig) *statsSender {
+	return newObservedTestSender(t, cfg, zap.NewNop())
+}
+
+func newObservedTestSender(t *testing.T, cfg *StatisticsConfig, logger *zap.Logger) *statsSender {
+	s, err := newStatsSender(cfg, http.DefaultClient, logger, componenttest.NewNopTelemetrySettings(), attribute.NewSet())
+	require.NoError(t, err)
+	return s
+}
+
+func TestSenderRetriesIntermittentFailures(t *testing.T) {
+	server := newFlakyStatsServer(t, func(request int) int {
+		switch request % 3 {
+		case 1:
+			return http.StatusServiceUnavailable
+		case 2:
+			return http.StatusTooManyRequests
+		}
+		return 0
+	})
+	s := newTestSender(t, testSenderConfig(server.URL))
+	s.start()
+
+	var want []string
+	for i := range 10 {
+		body := fmt.Sprintf("report-%02d", i)
+		s.enqueue(logStatsKind, []byte(body))
+		want = append(want, "/api/v1/logstats "+body)
+	}
+
+	require.Eventually(t, func() bool {
+		return len(server.receivedSorted()) == len(want)
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, want, server.receivedSorted())
+	require.NoError(t, s.stop(context.Background()))
+}
+
+func TestSenderDropsRejectedReports(t *testing.T) {
+	server := newFlakyStatsServer(t, func(int) int { return http.StatusBadRequest })
+	cfg := testSenderConfig(server.URL)
+	cfg.SendingQueue.SpoolDirectory = t.TempDir()
+	core, logs := observer.New(zap.ErrorLevel)
+	s := newObservedTestSender(t, cfg, zap.New(core))
+	s.start()
+
+	s.enqueue(metricStatsKind, []byte("bad"))
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("Dropping stats report the endpoint rejected").Len() == 1
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, s.stop(context.Background()))
+
+	// A rejected report is not retried or spooled.
+	server.Lock()
+	assert.Equal(t, 1, server.requests)
+	server.Unlock()
+	names, err := s.spool.list()
+	require.NoError(t, err)
+	assert.Empty(t, names)
+}
+
+func TestSenderSpoolsUntilEndpointRecovers(t *testing.T) {
+	server := newFlakyStatsServer(t, func(int) int { return http.StatusBadGateway })
+	cfg := testSenderConfig(server.URL)
+	cfg.RetryConfig.Enabled = false
+	cfg.SendingQueue.SpoolDirectory = t.TempDir()
+	s := newTestSender(t, cfg)
+	s.start()
+
+	s.enqueue(spanStatsKind, []byte("one"))
+	s.enqueue(spanStatsKind, []byte("two"))
+	require.Eventually(t, func() bool {
+		names, err := s.spool.list()
+		return err == nil && len(names) == 2
+	}, 5*time.Second, time.Millisecond)
+	assert.Empty(t, server.receivedSorted())
+
+	// Once a report gets through, the spool is replayed.
+	server.setFail(func(int) int { return 0 })
+	s.enqueue(spanStatsKind, []byte("three"))
+	require.Eventually(t, func() bool {
+		return len(server.receivedSorted()) == 3
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{
+		"/api/v1/spanstats one",
+		"/api/v1/spanstats three",
+		"/api/v1/spanstats two",
+	}, server.receivedSorted())
+	require.Eventually(t, func() bool {
+		names, err := s.spool.list()
+		return err == nil && len(names) == 0
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, s.stop(context.Background()))
+}
+
+func TestSenderSpoolsQueueAcrossRestart(t *testing.T) {
+	server := newFlakyStatsServer(t, func(int) int { return 0 })
+	cfg := testSenderConfig(server.URL)
+	cfg.SendingQueue.QueueSize = 1
+	cfg.SendingQueue.SpoolDirectory = t.TempDir()
+
+	// Without starting, the queue fills and the rest are spooled, and the
+	// queued report is spooled when the sender stops.
+	s := newTestSender(t, cfg)
+	s.enqueue(logStatsKind, []byte("a"))
+	s.enqueue(spanStatsKind, []byte("b"))
+	s.enqueue(metricStatsKind, []byte("c"))
+	require.NoError(t, s.stop(context.Background()))
+	names, err := s.spool.list()
+	require.NoError(t, err)
+	assert.Len(t, names, 3)
+	assert.Empty(t, server.receivedSorted())
+
+	restarted := newTestSender(t, cfg)
+	restarted.start()
+	require.Eventually(t, func() bool {
+		return len(server.receivedSorted()) == 3
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, []string{
+		"/api/v1/logstats a",
+		"/api/v1/metricstats c",
+		"/api/v1/spanstats b",
+	}, server.receivedSorted())
+	require.NoError(t, restarted.stop(context.Background()))
+}
+
+func TestSenderWithoutSpoolDropsWhenFull(t *testing.T) {
+	cfg := testSenderConfig("http://127.0.0.1:0")
+	cfg.SendingQueue.QueueSize = 1
+	s := newTestSender(t, cfg)
+	s.enqueue(logStatsKind, []byte("a"))
+	s.enqueue(logStatsKind, []byte("b"))
+	assert.Len(t, s.queue, 1)
+	require.NoError(t, s.stop(context.Background()))
+	assert.Empty(t, s.queue)
+}
+
+func TestStatsSpoolEvictsOldest(t *testing.T) {
+	spool, err := newStatsSpool(t.TempDir(), 2)
+	require.NoError(t, err)
+
+	dropped, err := spool.write(statsPayload{kind: logStatsKind, body: []byte("1")})
+	require.NoError(t, err)
+	assert.Empty(t, dropped)
+	_, err = spool.write(statsPayload{kind: spanStatsKind, body: []byte("2")})
+	require.NoError(t, err)
+	dropped, err = spool.write(statsPayload{kind: metricStatsKind, body: []byte("3")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{logStatsKind}, dropped)
+
+	names, err := spool.list()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	p, err := spool.read(names[0])
+	require.NoError(t, err)
+	assert.Equal(t, statsPayload{kind: spanStatsKind, body: []byte("2")}, p)
+}
+
+func TestStatsSpoolIgnoresOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := newStatsSpool(dir, 10)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(dir+"/.partial.logstats.pb.tmp", []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(dir+"/notes.txt", []byte("x"), 0o600))
+	require.NoError(t, os.WriteFile(dir+"/1-1.bogus.pb", []byte("x"), 0o600))
+
+	names, err := spool.list()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1-1.bogus.pb"}, names)
+	_, err = spool.read(names[0])
+	assert.Error(t, err)
+}
@@ -0,0 +1,328 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/oteltools/pkg/chqpb"
+)
+
+// The logs, metrics and traces processors built from one configuration
+// share a local sink, so they can serve from a single endpoint.  Each
+// processor holds a reference while it runs, and the last one to shut
+// down stops the server.
+var (
+	localSinksMu sync.Mutex
+	localSinks   = map[*LocalSinkConfig]*localSink{}
+)
+
+// localSink keeps the most recent stats windows for each signal in memory
+// and serves them as JSON.
+type localSink struct {
+	sync.Mutex
+	config  *LocalSinkConfig
+	logger  *zap.Logger
+	refs    int
+	server  *http.Server
+	address string
+
+	logs    []sinkFingerprintWindow
+	spans   []sinkFingerprintWindow
+	metrics []sinkMetricWindow
+}
+
+type sinkFingerprint struct {
+	Fingerprint int64           `json:"fingerprint"`
+	ServiceName string          `json:"service_name"`
+	Count       int64           `json:"count"`
+	Bytes       int64           `json:"bytes"`
+	Exemplar    json.RawMessage `json:"exemplar,omitempty"`
+}
+
+type sinkFingerprintWindow struct {
+	SubmittedAt  time.Time         `json:"submitted_at"`
+	Fingerprints []sinkFingerprint `json:"fingerprints"`
+}
+
+type sinkMetricTag struct {
+	MetricName          string  `json:"metric_name"`
+	MetricType          string  `json:"metric_type"`
+	ServiceName         string  `json:"service_name"`
+	TagScope            string  `json:"tag_scope"`
+	TagName             string  `json:"tag_name"`
+	Count               int64   `json:"count"`
+	CardinalityEstimate float64 `json:"cardinality_estimate"`
+}
+
+type sinkMetricExemplar struct {
+	MetricName  string          `json:"metric_name"`
+	MetricType  string          `json:"metric_type"`
+	ServiceName string          `json:"service_name"`
+	Exemplar    json.RawMessage `json:"exemplar"`
+}
+
+type sinkMetricWindow struct {
+	SubmittedAt time.Time            `json:"submitted_at"`
+	Tags        []sinkMetricTag      `json:"tags"`
+	Exemplars   []sinkMetricExemplar `json:"exemplars"`
+}
+
+// acquireLocalSink returns the sink for config, starting its server if
+// this is the first processor to use it.
+func acquireLocalSink(ctx context.Context, host component.Host, set component.TelemetrySettings, config *LocalSinkConfig) (*localSink, error) {
+	localSinksMu.Lock()
+	defer localSinksMu.Unlock()
+
+	if s, found := localSinks[config]; found {
+		s.refs++
+		return s, nil
+	}
+	s := newLocalSink(config, set.Logger)
+	if err := s.start(ctx, host, set); err != nil {
+		return nil, err
+	}
+	s.refs = 1
+	localSinks[config] = s
+	return s, nil
+}
+
+// release drops a reference to the sink, stopping its server when the
+// last one is gone.
+func (s *localSink) release(ctx context.Context) error {
+	localSinksMu.Lock()
+	defer localSinksMu.Unlock()
+
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(localSinks, s.config)
+	return s.server.Shutdown(ctx)
+}
+
+func newLocalSink(config *LocalSinkConfig, logger *zap.Logger) *localSink {
+	return &localSink{config: config, logger: logger}
+}
+
+func (s *localSink) start(ctx context.Context, host component.Host, set component.TelemetrySettings) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/logs", s.handleLogs)
+	mux.HandleFunc("GET /v1/traces", s.handleTraces)
+	mux.HandleFunc("GET /v1/metrics", s.handleMetrics)
+
+	var err error
+	s.server, err = s.config.ServerConfig.ToServer(ctx, host, set, mux)
+	if err != nil {
+		return fmt.Errorf("failed to create local sink server: %w", err)
+	}
+	ln, err := s.config.ServerConfig.ToListener(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create local sink listener: %w", err)
+	}
+	s.address = ln.Addr().String()
+	s.logger.Info("Serving local stats", zap.String("address", s.address))
+
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(err))
+		}
+	}()
+	return nil
+}
+
+// keepWindow appends w, dropping the oldest windows beyond max.
+func keepWindow[W any](windows []W, w W, max int) []W {
+	windows = append(windows, w)
+	if len(windows) > max {
+		windows = slices.Delete(windows, 0, len(windows)-max)
+	}
+	return windows
+}
+
+func (s *localSink) addLogs(report *chqpb.LogStatsReport) {
+	fingerprints := map[string]*sinkFingerprint{}
+	for _, item := range report.Stats {
+		addFingerprint(fingerprints, item.Fingerprint, item.ServiceName, item.Count, item.LogSize, item.Exemplar)
+	}
+	w := sinkFingerprintWindow{SubmittedAt: time.UnixMilli(report.SubmittedAt), Fingerprints: sortedFingerprints(fingerprints)}
+
+	s.Lock()
+	defer s.Unlock()
+	s.logs = keepWindow(s.logs, w, s.config.Windows)
+}
+
+func (s *localSink) addSpans(report *chqpb.SpanStatsReport) {
+	fingerprints := map[string]*sinkFingerprint{}
+	for _, item := range report.Stats {
+		addFingerprint(fingerprints, item.Fingerprint, item.ServiceName, item.Count, item.SpanSize, item.Exemplar)
+	}
+	w := sinkFingerprintWindow{SubmittedAt: time.UnixMilli(report.SubmittedAt), Fingerprints: sortedFingerprints(fingerprints)}
+
+	s.Lock()
+	defer s.Unlock()
+	s.spans = keepWindow(s.spans, w, s.config.Windows)
+}
+
+func (s *localSink) addMetrics(report *chqpb.MetricStatsReport) {
+	w := sinkMetricWindow{
+		SubmittedAt: time.UnixMilli(report.SubmittedAt),
+		Tags:        make([]sinkMetricTag, 0, len(report.Stats)),
+		Exemplars:   make([]sinkMetricExemplar, 0, len(report.Exemplars)),
+	}
+	for _, item := range report.Stats {
+		w.Tags = append(w.Tags, sinkMetricTag{
+			MetricName:          item.MetricName,
+			MetricType:          item.MetricType,
+			ServiceName:         item.ServiceName,
+			TagScope:            item.TagScope,
+			TagName:             item.TagName,
+			Count:               item.Count,
+			CardinalityEstimate: item.CardinalityEstimate,
+		})
+	}
+	slices.SortFunc(w.Tags, func(a, b sinkMetricTag) int {
+		return cmp.Or(
+			cmp.Compare(b.CardinalityEstimate, a.CardinalityEstimate),
+			cmp.Compare(a.MetricName, b.MetricName),
+			cmp.Compare(a.TagName, b.TagName),
+		)
+	})
+	for _, exemplar := range report.Exemplars {
+		if !json.Valid(exemplar.Exemplar) {
+			continue
+		}
+		w.Exemplars = append(w.Exemplars, sinkMetricExemplar{
+			MetricName:  exemplar.MetricName,
+			MetricType:  exemplar.MetricType,
+			ServiceName: exemplar.ServiceName,
+			Exemplar:    exemplar.Exemplar,
+		})
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.metrics = keepWindow(s.metrics, w, s.config.Windows)
+}
+
+// addFingerprint merges one stats item into the window's fingerprints.
+// The same fingerprint can appear more than once in a report when its
+// enrichment attributes differ.
+func addFingerprint(fingerprints map[string]*sinkFingerprint, fingerprint int64, serviceName string, count, size int64, exemplar []byte) {
+	key := serviceName + ":" + strconv.FormatInt(fingerprint, 10)
+	fp, found := fingerprints[key]
+	if !found {
+		fp = &sinkFingerprint{Fingerprint: fingerprint, ServiceName: serviceName}
+		fingerprints[key] = fp
+	}
+	fp.Count += count
+	fp.Bytes += size
+	if fp.Exemplar == nil && json.Valid(exemplar) {
+		fp.Exemplar = exemplar
+	}
+}
+
+// sortedFingerprints returns the fingerprints, most frequent first.
+func sortedFingerprints(fingerprints map[string]*sinkFingerprint) []sinkFingerprint {
+	ret := make([]sinkFingerprint, 0, len(fingerprints))
+	for _, fp := range fingerprints {
+		ret = append(ret, *fp)
+	}
+	slices.SortFunc(ret, func(a, b sinkFingerprint) int {
+		return cmp.Or(
+			cmp.Compare(b.Count, a.Count),
+			cmp.Compare(a.ServiceName, b.ServiceName),
+			cmp.Compare(a.Fingerprint, b.Fingerprint),
+		)
+	})
+	return ret
+}
+
+func (s *localSink) handleLogs(w http.ResponseWriter, r *http.Request) {
+	s.serveFingerprints(w, r, func() []sinkFingerprintWindow { return s.logs })
+}
+
+func (s *localSink) handleTraces(w http.ResponseWriter, r *http.Request) {
+	s.serveFingerprints(w, r, func() []sinkFingerprintWindow { return s.spans })
+}
+
+func (s *localSink) serveFingerprints(w http.ResponseWriter, r *http.Request, windows func() []sinkFingerprintWindow) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Lock()
+	ret := make([]sinkFingerprintWindow, 0, len(windows()))
+	for _, window := range slices.Backward(windows()) {
+		window.Fingerprints = window.Fingerprints[:min(limit, len(window.Fingerprints))]
+		ret = append(ret, window)
+	}
+	s.Unlock()
+
+	writeJSON(w, map[string]any{"windows": ret})
+}
+
+func (s *localSink) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.Lock()
+	ret := make([]sinkMetricWindow, 0, len(s.metrics))
+	for _, window := range slices.Backward(s.metrics) {
+		window.Tags = window.Tags[:min(limit, len(window.Tags))]
+		ret = append(ret, window)
+	}
+	s.Unlock()
+
+	writeJSON(w, map[string]any{"windows": ret})
+}
+
+// queryLimit returns the number of entries to show from each window.
+func queryLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultSinkLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("limit must be a positive integer, not %q", v)
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config/confighttp"
+
+	"github.com/cardinalhq/oteltools/pkg/chqpb"
+)
+
+func newTestSink(t *testing.T, windows int) *localSink {
+	config := &LocalSinkConfig{
+		ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:0"},
+		Windows:      windows,
+	}
+	s, err := acquireLocalSink(context.Background(), componenttest.NewNopHost(), componenttest.NewNopTelemetrySettings(), config)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if localSinks[config] != nil {
+			assert.NoError(t, s.release(context.Background()))
+		}
+	})
+	return s
+}
+
+func getSink(t *testing.T, s *localSink, path string, v any) int {
+	resp, err := http.Get("http://" + s.address + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestLocalSinkServesTopFingerprints(t *testing.T) {
+	s := newTestSink(t, 2)
+	start := time.UnixMilli(1717245000000)
+	for i := range 3 {
+		s.addLogs(&chqpb.LogStatsReport{
+			SubmittedAt: start.Add(time.Duration(i) * time.Minute).UnixMilli(),
+			Stats: []*chqpb.LogStats{
+				{ServiceName: "checkout", Fingerprint: 1, Count: 2, LogSize: 20},
+				{ServiceName: "checkout", Fingerprint: 2, Count: 5, LogSize: 50, Exemplar: []byte(`{"resourceLogs":[]}`)},
+				// Same fingerprint with different enrichments.
+				{ServiceName: "checkout", Fingerprint: 1, Count: int64(2 * i), LogSize: 10},
+			},
+		})
+	}
+
+	var got struct {
+		Windows []sinkFingerprintWindow `json:"windows"`
+	}
+	require.Equal(t, http.StatusOK, getSink(t, s, "/v1/logs?limit=1", &got))
+
+	// Only the last two windows are kept, and the newest comes first.
+	require.Len(t, got.Windows, 2)
+	assert.Equal(t, start.Add(2*time.Minute), got.Windows[0].SubmittedAt.Local())
+	assert.Equal(t, start.Add(time.Minute), got.Windows[1].SubmittedAt.Local())
+	assert.Equal(t, []sinkFingerprint{
+		{Fingerprint: 1, ServiceName: "checkout", Count: 6, Bytes: 30},
+	}, got.Windows[0].Fingerprints)
+	assert.Equal(t, []sinkFingerprint{
+		{Fingerprint: 2, ServiceName: "checkout", Count: 5, Bytes: 50, Exemplar: json.RawMessage(`{"resourceLogs":[]}`)},
+	}, got.Windows[1].Fingerprints)
+
+	require.Equal(t, http.StatusOK, getSink(t, s, "/v1/traces", &got))
+	assert.Empty(t, got.Windows)
+	assert.Equal(t, http.StatusBadRequest, getSink(t, s, "/v1/logs?limit=none", &got))
+}
+
+func TestLocalSinkServesCardinality(t *testing.T) {
+	s := newTestSink(t, 10)
+	s.addMetrics(&chqpb.MetricStatsReport{
+		SubmittedAt: 1717245000000,
+		Stats: []*chqpb.MetricStats{
+			{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", TagScope: "datapoint", TagName: "route", Count: 10, CardinalityEstimate: 4},
+			{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", TagScope: "datapoint", TagName: "user.id", Count: 10, CardinalityEstimate: 9000, Hll: []byte{1, 2, 3}},
+		},
+		Exemplars: []*chqpb.MetricExemplar{
+			{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", Exemplar: []byte(`{"resourceMetrics":[]}`)},
+		},
+	})
+
+	var got struct {
+		Windows []sinkMetricWindow `json:"windows"`
+	}
+	require.Equal(t, http.StatusOK, getSink(t, s, "/v1/metrics", &got))
+	require.Len(t, got.Windows, 1)
+	assert.Equal(t, []sinkMetricTag{
+		{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", TagScope: "datapoint", TagName: "user.id", Count: 10, CardinalityEstimate: 9000},
+		{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", TagScope: "datapoint", TagName: "route", Count: 10, CardinalityEstimate: 4},
+	}, got.Windows[0].Tags)
+	assert.Equal(t, []sinkMetricExemplar{
+		{MetricName: "http.requests", MetricType: "Sum", ServiceName: "cart", Exemplar: json.RawMessage(`{"resourceMetrics":[]}`)},
+	}, got.Windows[0].Exemplars)
+}
+
+func TestLocalSinkSharedBySignals(t *testing.T) {
+	config := &LocalSinkConfig{
+		ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:0"},
+		Windows:      1,
+	}
+	host := componenttest.NewNopHost()
+	set := componenttest.NewNopTelemetrySettings()
+	logs, err := acquireLocalSink(context.Background(), host, set, config)
+	require.NoError(t, err)
+	traces, err := acquireLocalSink(context.Background(), host, set, config)
+	require.NoError(t, err)
+	assert.Same(t, logs, traces)
+
+	var got map[string]any
+	require.NoError(t, logs.release(context.Background()))
+	assert.Equal(t, http.StatusOK, getSink(t, traces, "/v1/traces", &got))
+
+	require.NoError(t, traces.release(context.Background()))
+	assert.NotContains(t, localSinks, config)
+	_, err = http.Get("http://" + traces.address + "/v1/traces")
+	assert.Error(t, err)
+}
+
+func TestLocalSinkConfigValidate(t *testing.T) {
+	config := &LocalSinkConfig{ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:8888"}}
+	require.NoError(t, config.Validate())
+	assert.Equal(t, defaultSinkWindows, config.Windows)
+
+	assert.Error(t, (&LocalSinkConfig{}).Validate())
+	assert.Error(t, (&LocalSinkConfig{ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:8888"}, Windows: -1}).Validate())
+}
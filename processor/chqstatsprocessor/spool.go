@@ -0,0 +1,129 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package chqstatsprocessor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const spoolSuffix = ".pb"
+
+// statsSpool keeps serialized stats reports as files in a directory, one
+// report per file.  File names start with the time they were written so
+// they sort oldest first, and end with the report kind.
+type statsSpool struct {
+	sync.Mutex
+	dir      string
+	maxFiles int
+	seq      uint64
+}
+
+func newStatsSpool(dir string, maxFiles int) (*statsSpool, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	return &statsSpool{dir: dir, maxFiles: maxFiles}, nil
+}
+
+// write stores a report.  If the spool is full, the oldest reports are
+// removed to make room, and their kinds are returned.
+func (s *statsSpool) write(p statsPayload) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	names, err := s.listLocked()
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for len(names) >= s.maxFiles {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+		dropped = append(dropped, spoolKind(names[0]))
+		names = names[1:]
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d-%06d.%s%s", time.Now().UnixNano(), s.seq%1000000, p.kind, spoolSuffix)
+	// Write to a temporary name first so a crash never leaves a partial
+	// report where list would find it.
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, p.body, 0o640); err != nil {
+		return dropped, err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		_ = os.Remove(tmp)
+		return dropped, err
+	}
+	return dropped, nil
+}
+
+// list returns the names of the spooled reports, oldest first.
+func (s *statsSpool) list() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	return s.listLocked()
+}
+
+func (s *statsSpool) listLocked() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && !strings.HasPrefix(name, ".") && strings.HasSuffix(name, spoolSuffix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s *statsSpool) read(name string) (statsPayload, error) {
+	kind := spoolKind(name)
+	switch kind {
+	case logStatsKind, spanStatsKind, metricStatsKind:
+	default:
+		return statsPayload{}, fmt.Errorf("unknown stats kind %q", kind)
+	}
+	body, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return statsPayload{}, err
+	}
+	return statsPayload{kind: kind, body: body}, nil
+}
+
+func (s *statsSpool) remove(name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// spoolKind returns the report kind from a spool file name.
+func spoolKind(name string) string {
+	name = strings.TrimSuffix(name, spoolSuffix)
+	return name[strings.LastIndex(name, ".")+1:]
+}
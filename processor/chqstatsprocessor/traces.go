@@ -15,11 +15,8 @@
 package chqstatsprocessor
 
 import (
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
@@ -33,7 +30,7 @@ import (
 )
 
 func (e *statsProc) ConsumeTraces(ctx context.Context, td ptrace.Traces) (ptrace.Traces, error) {
-	now := time.Now()
+	now := e.clock.Now()
 	for i := 0; i < td.ResourceSpans().Len(); i++ {
 		rs := td.ResourceSpans().At(i)
 		serviceName := getServiceName(rs.Resource().Attributes())
@@ -103,86 +100,79 @@ func (e *statsProc) recordSpan(
 		Count:       1,
 		Attributes:  enrichmentAttributes,
 	}
-	e.addSpanExemplar(td, fingerprint)
-
+	e.recordLock.Lock()
+	e.addSpanExemplar(fingerprint, rs, iss, span)
 	bucketpile, err := e.spanStats.Record(now, rec, "", 1, spanSize)
+	exemplars := e.takeSpanExemplars(bucketpile)
+	e.recordLock.Unlock()
 	if err != nil {
 		return err
 	}
-	e.sendSpanStatsWithExemplars(bucketpile, now)
+	e.sendSpanStatsWithExemplars(bucketpile, exemplars, now)
 	return nil
 }
 
-func (e *statsProc) sendSpanStatsWithExemplars(bucketpile *map[uint64][]*chqpb.SpanStats, now time.Time) {
+// flushSpanStats reports the held span stats if the interval has ended,
+// or always if force is set.
+func (e *statsProc) flushSpanStats(now time.Time, force bool) {
+	e.recordLock.Lock()
+	bucketpile := e.spanStats.Flush(now, force)
+	exemplars := e.takeSpanExemplars(bucketpile)
+	e.recordLock.Unlock()
+	e.sendSpanStatsWithExemplars(bucketpile, exemplars, now)
+}
+
+// takeSpanExemplars takes the held exemplars if there are stats to report
+// them with.  The caller must hold recordLock.
+func (e *statsProc) takeSpanExemplars(bucketpile *map[uint64][]*chqpb.SpanStats) map[int64]ptrace.Traces {
+	if bucketpile == nil || len(*bucketpile) == 0 {
+		return nil
+	}
+	return e.traceExemplars.take()
+}
+
+func (e *statsProc) sendSpanStatsWithExemplars(bucketpile *map[uint64][]*chqpb.SpanStats, exemplars map[int64]ptrace.Traces, now time.Time) {
 	if bucketpile != nil && len(*bucketpile) > 0 {
-		for bucketKey, items := range *bucketpile {
-			itemsWithValidExemplars := items[:0]
+		// Every stats item is reported, whether or not there is an
+		// exemplar for its fingerprint.
+		for _, items := range *bucketpile {
 			for _, item := range items {
-				e.exemplarsMu.RLock()
-				exemplar, found := e.traceExemplars[item.Fingerprint]
-				e.exemplarsMu.RUnlock()
+				exemplar, found := exemplars[item.Fingerprint]
 				if !found {
 					continue
 				}
 				marshalled, err := e.jsonMarshaller.tracesMarshaler.MarshalTraces(exemplar)
 				if err != nil {
+					e.logger.Error("Failed to marshal span exemplar", zap.Error(err))
 					continue
 				}
 				item.Exemplar = marshalled
-				itemsWithValidExemplars = append(itemsWithValidExemplars, item)
-			}
-			if len(itemsWithValidExemplars) > 0 {
-				(*bucketpile)[bucketKey] = itemsWithValidExemplars
-			} else {
-				delete(*bucketpile, bucketKey)
 			}
 		}
 
-		// clear the logExemplars map
-		e.exemplarsMu.Lock()
-		e.traceExemplars = make(map[int64]ptrace.Traces)
-		e.exemplarsMu.Unlock()
-
-		// TODO should send this to a channel and have a separate goroutine send it
-		go e.sendSpanStats(context.Background(), now, bucketpile)
+		e.sendSpanStats(now, bucketpile)
 	}
 }
 
-func (e *statsProc) addSpanExemplar(td ptrace.Traces, fingerprint int64) {
-	e.exemplarsMu.RLock()
-	_, found := e.traceExemplars[fingerprint]
-	e.exemplarsMu.RUnlock()
-
-	if !found {
-		copyObj := ptrace.NewTraces()
-		td.CopyTo(copyObj)
-		// iterate over all 3 levels, and just filter any span records that don't match the fingerprint
-		copyObj.ResourceSpans().RemoveIf(func(rsp ptrace.ResourceSpans) bool {
-			rsp.ScopeSpans().RemoveIf(func(ss ptrace.ScopeSpans) bool {
-				foundFp := false
-				ss.Spans().RemoveIf(func(sr ptrace.Span) bool {
-					if getFingerprint(sr.Attributes()) == fingerprint {
-						if !foundFp {
-							foundFp = true
-							return false
-						}
-						return true
-					}
-					return true
-				})
-				return ss.Spans().Len() == 0
-			})
-			return rsp.ScopeSpans().Len() == 0
-		})
-		if copyObj.ResourceSpans().Len() > 0 {
-			e.exemplarsMu.Lock()
-			e.traceExemplars[fingerprint] = copyObj
-			e.exemplarsMu.Unlock()
-		}
+// addSpanExemplar offers span as the exemplar for its fingerprint, copying
+// it with its resource and scope if the exemplar policy keeps it.
+func (e *statsProc) addSpanExemplar(fingerprint int64, rs ptrace.ResourceSpans, iss ptrace.ScopeSpans, span ptrace.Span) {
+	isError := span.Status().Code() == ptrace.StatusCodeError
+	if !e.traceExemplars.offer(fingerprint, isError) {
+		return
 	}
+	exemplar := ptrace.NewTraces()
+	ers := exemplar.ResourceSpans().AppendEmpty()
+	rs.Resource().CopyTo(ers.Resource())
+	ers.SetSchemaUrl(rs.SchemaUrl())
+	ess := ers.ScopeSpans().AppendEmpty()
+	iss.Scope().CopyTo(ess.Scope())
+	ess.SetSchemaUrl(iss.SchemaUrl())
+	span.CopyTo(ess.Spans().AppendEmpty())
+	e.traceExemplars.keep(fingerprint, exemplar, isError)
 }
 
-func (e *statsProc) sendSpanStats(ctx context.Context, now time.Time, bucketpile *map[uint64][]*chqpb.SpanStats) {
+func (e *statsProc) sendSpanStats(now time.Time, bucketpile *map[uint64][]*chqpb.SpanStats) {
 	wrapper := &chqpb.SpanStatsReport{
 		SubmittedAt: now.UnixMilli(),
 		Stats:       []*chqpb.SpanStats{},
@@ -191,36 +181,23 @@ func (e *statsProc) sendSpanStats(ctx context.Context, now time.Time, bucketpile
 		wrapper.Stats = append(wrapper.Stats, items...)
 	}
 
-	if err := e.postSpanStats(ctx, wrapper); err != nil {
-		e.logger.Error("Failed to send span stats", zap.Error(err))
+	if e.sink != nil {
+		e.sink.addSpans(wrapper)
+	}
+	if err := e.queueSpanStats(wrapper); err != nil {
+		e.logger.Error("Failed to queue span stats", zap.Error(err))
 	}
-	e.logger.Debug("Sent log stats", zap.Int("count", len(wrapper.Stats)))
 }
 
-func (e *statsProc) postSpanStats(ctx context.Context, wrapper *chqpb.SpanStatsReport) error {
+func (e *statsProc) queueSpanStats(wrapper *chqpb.SpanStatsReport) error {
 	b, err := proto.Marshal(wrapper)
 	if err != nil {
 		return err
 	}
 	telemetry.HistogramRecord(e.statsBatchSize, int64(len(b)))
-	e.logger.Debug("Sending span stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
-	endpoint := e.config.Statistics.Endpoint + "/api/v1/spanstats"
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(b))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-protobuf")
-
-	resp, err := e.httpClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		e.logger.Error("Failed to send span stats", zap.Int("status", resp.StatusCode), zap.String("body", string(body)))
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	e.logger.Debug("Queueing span stats", zap.Int("count", len(wrapper.Stats)), zap.Int("length", len(b)))
+	if e.sender != nil {
+		e.sender.enqueue(spanStatsKind, b)
 	}
 	return nil
 }
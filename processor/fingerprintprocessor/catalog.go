@@ -0,0 +1,243 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"cmp"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componentstatus"
+	"go.uber.org/zap"
+)
+
+// Log processors built from one configuration share a catalogue, so they
+// serve from a single endpoint.  Each processor holds a reference while it
+// runs, and the last one to shut down stops the server.
+var (
+	catalogsMu sync.Mutex
+	catalogs   = map[*CatalogConfig]*fingerprintCatalog{}
+)
+
+// fingerprintCatalog maps log fingerprints to the template they were made
+// from, so a fingerprint can be explained without finding a log with it.
+// When it is full, the least recently seen fingerprint is dropped.
+type fingerprintCatalog struct {
+	sync.Mutex
+	config  *CatalogConfig
+	logger  *zap.Logger
+	refs    int
+	server  *http.Server
+	address string
+
+	entries map[int64]*list.Element
+	// lru holds *catalogEntry, most recently seen first.
+	lru *list.List
+}
+
+type catalogEntry struct {
+	Fingerprint int64     `json:"fingerprint"`
+	Template    string    `json:"template"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Count       int64     `json:"count"`
+}
+
+// catalogSighting is a fingerprint seen count times in one batch of logs.
+type catalogSighting struct {
+	template string
+	count    int64
+}
+
+// acquireCatalog returns the catalogue for config, starting its server if
+// this is the first processor to use it.
+func acquireCatalog(ctx context.Context, host component.Host, set component.TelemetrySettings, config *CatalogConfig) (*fingerprintCatalog, error) {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+
+	if c, found := catalogs[config]; found {
+		c.refs++
+		return c, nil
+	}
+	c := newFingerprintCatalog(config, set.Logger)
+	if err := c.start(ctx, host, set); err != nil {
+		return nil, err
+	}
+	c.refs = 1
+	catalogs[config] = c
+	return c, nil
+}
+
+// release drops a reference to the catalogue, stopping its server when
+// the last one is gone.
+func (c *fingerprintCatalog) release(ctx context.Context) error {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+
+	c.refs--
+	if c.refs > 0 {
+		return nil
+	}
+	delete(catalogs, c.config)
+	return c.server.Shutdown(ctx)
+}
+
+func newFingerprintCatalog(config *CatalogConfig, logger *zap.Logger) *fingerprintCatalog {
+	return &fingerprintCatalog{
+		config:  config,
+		logger:  logger,
+		entries: map[int64]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (c *fingerprintCatalog) start(ctx context.Context, host component.Host, set component.TelemetrySettings) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/fingerprints", c.handleList)
+	mux.HandleFunc("GET /v1/fingerprints/{fingerprint}", c.handleGet)
+
+	var err error
+	c.server, err = c.config.ServerConfig.ToServer(ctx, host, set, mux)
+	if err != nil {
+		return fmt.Errorf("failed to create fingerprint catalogue server: %w", err)
+	}
+	ln, err := c.config.ServerConfig.ToListener(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create fingerprint catalogue listener: %w", err)
+	}
+	c.address = ln.Addr().String()
+	c.logger.Info("Serving fingerprint catalogue", zap.String("address", c.address))
+
+	go func() {
+		if err := c.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			componentstatus.ReportStatus(host, componentstatus.NewFatalErrorEvent(err))
+		}
+	}()
+	return nil
+}
+
+// record adds the fingerprints seen in a batch of logs.
+func (c *fingerprintCatalog) record(sightings map[int64]catalogSighting, now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for fingerprint, sighting := range sightings {
+		var entry *catalogEntry
+		if elem, found := c.entries[fingerprint]; found {
+			entry = elem.Value.(*catalogEntry)
+			c.lru.MoveToFront(elem)
+		} else {
+			if c.lru.Len() >= c.config.MaxEntries {
+				oldest := c.lru.Back()
+				c.lru.Remove(oldest)
+				delete(c.entries, oldest.Value.(*catalogEntry).Fingerprint)
+			}
+			entry = &catalogEntry{Fingerprint: fingerprint, Template: sighting.template, FirstSeen: now}
+			c.entries[fingerprint] = c.lru.PushFront(entry)
+		}
+		entry.LastSeen = now
+		entry.Count += sighting.count
+	}
+}
+
+// list returns the entries first seen at or after since, most frequent
+// first.
+func (c *fingerprintCatalog) list(since time.Time, limit int) []catalogEntry {
+	c.Lock()
+	ret := make([]catalogEntry, 0, c.lru.Len())
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*catalogEntry)
+		if !entry.FirstSeen.Before(since) {
+			ret = append(ret, *entry)
+		}
+	}
+	c.Unlock()
+
+	slices.SortFunc(ret, func(a, b catalogEntry) int {
+		return cmp.Or(
+			cmp.Compare(b.Count, a.Count),
+			cmp.Compare(a.Fingerprint, b.Fingerprint),
+		)
+	})
+	return ret[:min(limit, len(ret))]
+}
+
+func (c *fingerprintCatalog) get(fingerprint int64) (catalogEntry, bool) {
+	c.Lock()
+	defer c.Unlock()
+	elem, found := c.entries[fingerprint]
+	if !found {
+		return catalogEntry{}, false
+	}
+	return *elem.Value.(*catalogEntry), true
+}
+
+func (c *fingerprintCatalog) len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.lru.Len()
+}
+
+func (c *fingerprintCatalog) handleList(w http.ResponseWriter, r *http.Request) {
+	limit := defaultCatalogLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("limit must be a positive integer, not %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("since must be an RFC 3339 time, not %q", v), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, map[string]any{"fingerprints": c.list(since, limit)})
+}
+
+func (c *fingerprintCatalog) handleGet(w http.ResponseWriter, r *http.Request) {
+	fingerprint, err := strconv.ParseInt(r.PathValue("fingerprint"), 10, 64)
+	if err != nil {
+		http.Error(w, "fingerprint must be an integer", http.StatusBadRequest)
+		return
+	}
+	entry, found := c.get(fingerprint)
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, entry)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/config/confighttp"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/processor/processortest"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func getCatalog(t *testing.T, c *fingerprintCatalog, path string, v any) int {
+	resp, err := http.Get("http://" + c.address + path)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+func TestFingerprintCatalogRecord(t *testing.T) {
+	c := newFingerprintCatalog(&CatalogConfig{MaxEntries: 2}, zap.NewNop())
+	start := time.UnixMilli(1717245000000)
+
+	c.record(map[int64]catalogSighting{
+		1: {template: "user <Number> logged in", count: 3},
+		2: {template: "payment failed for <UUID>", count: 1},
+	}, start)
+	c.record(map[int64]catalogSighting{
+		1: {template: "user <Number> logged in", count: 2},
+	}, start.Add(time.Minute))
+
+	entry, found := c.get(1)
+	require.True(t, found)
+	assert.Equal(t, catalogEntry{
+		Fingerprint: 1,
+		Template:    "user <Number> logged in",
+		FirstSeen:   start,
+		LastSeen:    start.Add(time.Minute),
+		Count:       5,
+	}, entry)
+
+	// The catalogue is full, so the least recently seen fingerprint goes.
+	c.record(map[int64]catalogSighting{3: {template: "cache miss", count: 1}}, start.Add(2*time.Minute))
+	assert.Equal(t, 2, c.len())
+	_, found = c.get(2)
+	assert.False(t, found)
+
+	list := c.list(time.Time{}, 10)
+	require.Len(t, list, 2)
+	assert.Equal(t, int64(1), list[0].Fingerprint)
+	assert.Equal(t, int64(3), list[1].Fingerprint)
+
+	list = c.list(start.Add(time.Minute), 10)
+	require.Len(t, list, 1)
+	assert.Equal(t, "cache miss", list[0].Template)
+
+	assert.Len(t, c.list(time.Time{}, 1), 1)
+}
+
+func TestFingerprintCatalogServer(t *testing.T) {
+	config := &CatalogConfig{
+		ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:0"},
+		MaxEntries:   10,
+	}
+	c, err := acquireCatalog(context.Background(), componenttest.NewNopHost(), componenttest.NewNopTelemetrySettings(), config)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, c.release(context.Background())) }()
+
+	start := time.Now().Truncate(time.Second)
+	c.record(map[int64]catalogSighting{
+		1: {template: "user <Number> logged in", count: 3},
+		2: {template: "payment failed for <UUID>", count: 5},
+	}, start.Add(-time.Hour))
+	c.record(map[int64]catalogSighting{3: {template: "cache miss", count: 1}}, start)
+
+	var got struct {
+		Fingerprints []catalogEntry `json:"fingerprints"`
+	}
+	require.Equal(t, http.StatusOK, getCatalog(t, c, "/v1/fingerprints?limit=2", &got))
+	require.Len(t, got.Fingerprints, 2)
+	assert.Equal(t, "payment failed for <UUID>", got.Fingerprints[0].Template)
+	assert.Equal(t, "user <Number> logged in", got.Fingerprints[1].Template)
+
+	since := start.Add(-time.Minute).UTC().Format(time.RFC3339)
+	require.Equal(t, http.StatusOK, getCatalog(t, c, "/v1/fingerprints?since="+since, &got))
+	require.Len(t, got.Fingerprints, 1)
+	assert.Equal(t, "cache miss", got.Fingerprints[0].Template)
+
+	var entry catalogEntry
+	require.Equal(t, http.StatusOK, getCatalog(t, c, "/v1/fingerprints/1", &entry))
+	assert.Equal(t, int64(3), entry.Count)
+
+	assert.Equal(t, http.StatusNotFound, getCatalog(t, c, "/v1/fingerprints/4", nil))
+	assert.Equal(t, http.StatusBadRequest, getCatalog(t, c, "/v1/fingerprints/x", nil))
+	assert.Equal(t, http.StatusBadRequest, getCatalog(t, c, "/v1/fingerprints?limit=0", nil))
+	assert.Equal(t, http.StatusBadRequest, getCatalog(t, c, "/v1/fingerprints?since=yesterday", nil))
+}
+
+func TestCatalogRecordsLogTemplates(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Catalog = &CatalogConfig{ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:0"}}
+	require.NoError(t, cfg.Validate())
+	p, err := newPitbull(cfg, "logs", processortest.NewNopSettings())
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+	defer func() { assert.NoError(t, p.Shutdown(context.Background())) }()
+
+	ld := plog.NewLogs()
+	records := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	records.AppendEmpty().Body().SetStr("user 42 logged in from 10.0.0.1")
+	records.AppendEmpty().Body().SetStr("user 1337 logged in from 10.0.0.2")
+	_, err = p.ConsumeLogs(context.Background(), ld)
+	require.NoError(t, err)
+
+	fingerprint, found := records.At(0).Attributes().Get(translate.CardinalFieldFingerprint)
+	require.True(t, found)
+	var entry catalogEntry
+	path := "/v1/fingerprints/" + strconv.FormatInt(fingerprint.Int(), 10)
+	require.Equal(t, http.StatusOK, getCatalog(t, p.catalog, path, &entry))
+	assert.Equal(t, int64(2), entry.Count)
+	assert.Contains(t, entry.Template, "<Number>")
+	assert.Contains(t, entry.Template, "<IPv4>")
+}
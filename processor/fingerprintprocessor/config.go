@@ -15,24 +15,185 @@
 package fingerprintprocessor
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"time"
 
+	"go.opentelemetry.io/collector/config/confighttp"
 	"go.uber.org/multierr"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
+)
+
+const (
+	customTokenStageBefore = "before"
+	customTokenStageAfter  = "after"
 )
 
 type Config struct {
 	TracesConfig TracesConfig `mapstructure:"traces"`
+	LogsConfig   LogsConfig   `mapstructure:"logs"`
+	// Catalog, if set, keeps a template for each log fingerprint and
+	// serves them over HTTP.
+	Catalog *CatalogConfig `mapstructure:"catalog"`
+	// Multiline, if set, joins log records that are lines of one message,
+	// such as a stack trace, before they are fingerprinted.
+	Multiline *MultilineConfig `mapstructure:"multiline"`
+	// CustomTokens replace domain-specific identifiers with a named
+	// placeholder when fingerprinting log bodies and URL paths.
+	CustomTokens []CustomTokenConfig `mapstructure:"custom_tokens"`
+	// WordLists add words, such as those of other languages, to the
+	// English word list used when fingerprinting log bodies.
+	WordLists []WordListConfig `mapstructure:"word_lists"`
+	// WordListAttribute is the resource attribute whose value names the
+	// word list to use for that resource's logs.
+	WordListAttribute string `mapstructure:"word_list_attribute"`
+}
+
+type WordListConfig struct {
+	// Name is matched against the value of the WordListAttribute.
+	Name string `mapstructure:"name"`
+	// Path is a file with one word per line.
+	Path string `mapstructure:"path"`
+	// Words are added to those read from Path.
+	Words []string `mapstructure:"words"`
+}
+
+type CustomTokenConfig struct {
+	// Name is used for the placeholder, as in <Name>.
+	Name string `mapstructure:"name"`
+	// Pattern is a regular expression in Go syntax.
+	Pattern string `mapstructure:"pattern"`
+	// Stage is "before" to match the input before it is tokenized, or
+	// "after" to match whole tokens.  The default is "before".
+	Stage string `mapstructure:"stage"`
+}
+
+type LogsConfig struct {
+	// KeyValueFingerprints fingerprints logfmt and key=value bodies by
+	// their message and keys, so the values do not change the
+	// fingerprint.  Turning it on changes the fingerprint of these logs.
+	KeyValueFingerprints bool `mapstructure:"key_value_fingerprints"`
+	// PromoteKeyValues adds the pairs of logfmt and key=value bodies to
+	// the log record's attributes, without replacing any already there.
+	PromoteKeyValues bool `mapstructure:"promote_key_values"`
+}
+
+type MultilineConfig struct {
+	// StartPatterns are regular expressions matching the first line of a
+	// record.  Lines matching none of them are added to the record before.
+	StartPatterns []string `mapstructure:"start_patterns"`
+	// Detectors recognise the continuation lines of stack traces, and are
+	// any of "java", "python" and "go".  If neither these nor
+	// StartPatterns are set, all of them are used.
+	Detectors []string `mapstructure:"detectors"`
+	// StreamAttributes are the log record attributes that, with the
+	// resource and scope, tell apart streams whose lines are joined.
+	StreamAttributes []string `mapstructure:"stream_attributes"`
+	// FlushTimeout is how long a record is held for more lines after
+	// its stream's last one.
+	FlushTimeout time.Duration `mapstructure:"flush_timeout"`
+	// MaxLines is the most lines joined into one record.
+	MaxLines int `mapstructure:"max_lines"`
+	// MaxStreams is the most streams a record is held for at once.  When
+	// a new stream would go past it, the record of the stream idle the
+	// longest is sent on.
+	MaxStreams int `mapstructure:"max_streams"`
+}
+
+// CatalogConfig controls the fingerprint catalogue, which shows what
+// pattern each log fingerprint stands for.
+type CatalogConfig struct {
+	confighttp.ServerConfig `mapstructure:",squash"`
+
+	// MaxEntries is the most fingerprints to keep.  The least recently
+	// seen is dropped to make room for a new one.
+	MaxEntries int `mapstructure:"max_entries"`
 }
 
 type TracesConfig struct {
 	EstimatorWindowSize int   `mapstructure:"estimator_window_size"`
 	EstimatorInterval   int64 `mapstructure:"estimator_interval"`
+	// EstimatorMaxEntries is the most span fingerprints to keep a
+	// slow-span estimator for.
+	EstimatorMaxEntries int `mapstructure:"estimator_max_entries"`
+	// EstimatorTTL is how long an estimator is kept after its fingerprint
+	// was last seen.
+	EstimatorTTL time.Duration `mapstructure:"estimator_ttl"`
+	// SlowSpan chooses how spans are marked slow.
+	SlowSpan SlowSpanConfig `mapstructure:"slow_span"`
+	// URLTemplates are routes, such as "/users/{id}/orders", used as the
+	// resource of HTTP spans whose url.path matches them.  A {name}
+	// segment matches any one segment, and a final * matches the rest.
+	URLTemplates []string `mapstructure:"url_templates"`
+	// RouteLearningThreshold is how many distinct values a segment of a
+	// service's URL paths may have before they are all replaced by <Var>.
+	RouteLearningThreshold int `mapstructure:"route_learning_threshold"`
+	// RouteLearningMaxNodes is the most path segments the route learner
+	// remembers across all services.  The routes of the least recently
+	// seen services are forgotten to make room.
+	RouteLearningMaxNodes int `mapstructure:"route_learning_max_nodes"`
+	// TraceShape, if set, holds spans until their trace is complete, and
+	// stamps each with the fingerprint of the trace's shape.
+	TraceShape *TraceShapeConfig `mapstructure:"trace_shape"`
+}
+
+type TraceShapeConfig struct {
+	// Timeout is how long a trace's spans are held for its root span,
+	// after the trace's last span arrived.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxTraces is the most traces held at once, and the most whose
+	// fingerprint is remembered for spans arriving after the root.
+	MaxTraces int `mapstructure:"max_traces"`
+}
+
+type SlowSpanConfig struct {
+	// Strategy is one of "stddev", "percentile" or "static".
+	Strategy string `mapstructure:"strategy"`
+	// StdDevMultiplier is how many standard deviations above the mean a
+	// span must be to be slow, for the stddev strategy.
+	StdDevMultiplier float64 `mapstructure:"stddev_multiplier"`
+	// Percentile is the quantile, between 0 and 1, above which a span is
+	// slow, for the percentile strategy.
+	Percentile float64 `mapstructure:"percentile"`
+	// MinSamples is how many spans of a fingerprint must be seen before
+	// any are marked slow, for the stddev and percentile strategies.
+	MinSamples int `mapstructure:"min_samples"`
+	// Thresholds are the durations above which spans of each service are
+	// slow, for the static strategy.
+	Thresholds map[string]time.Duration `mapstructure:"thresholds"`
+	// DefaultThreshold applies to services not in Thresholds, for the
+	// static strategy.  If it is not set, their spans are never slow.
+	DefaultThreshold time.Duration `mapstructure:"default_threshold"`
 }
 
 func (c *Config) Validate() error {
 	var errs error
 
 	errs = multierr.Append(errs, c.TracesConfig.Validate())
+	for i := range c.CustomTokens {
+		errs = multierr.Append(errs, c.CustomTokens[i].Validate())
+	}
+
+	if c.Catalog != nil {
+		errs = multierr.Append(errs, c.Catalog.Validate())
+	}
+	if c.Multiline != nil {
+		errs = multierr.Append(errs, c.Multiline.Validate())
+	}
+
+	names := map[string]bool{}
+	for _, wl := range c.WordLists {
+		errs = multierr.Append(errs, wl.Validate())
+		if names[wl.Name] {
+			errs = multierr.Append(errs, fmt.Errorf("word_lists name %q is used more than once", wl.Name))
+		}
+		names[wl.Name] = true
+	}
+	if len(c.WordLists) > 0 && c.WordListAttribute == "" {
+		errs = multierr.Append(errs, fmt.Errorf("word_list_attribute must be set to use word_lists"))
+	}
 
 	return errs
 }
@@ -55,5 +216,221 @@ func (tc *TracesConfig) Validate() error {
 		errors = multierr.Append(errors, err)
 	}
 
+	if tc.EstimatorMaxEntries == 0 {
+		tc.EstimatorMaxEntries = defaultEstimatorMaxEntries
+	}
+	if tc.EstimatorMaxEntries < 0 {
+		err := fmt.Errorf("estimator_max_entries must be positive")
+		errors = multierr.Append(errors, err)
+	}
+
+	if tc.EstimatorTTL == 0 {
+		tc.EstimatorTTL = defaultEstimatorTTL
+	}
+	if tc.EstimatorTTL < 0 {
+		err := fmt.Errorf("estimator_ttl must be positive")
+		errors = multierr.Append(errors, err)
+	}
+
+	for _, t := range tc.URLTemplates {
+		if _, err := newURLTemplate(t); err != nil {
+			errors = multierr.Append(errors, err)
+		}
+	}
+
+	if tc.RouteLearningThreshold == 0 {
+		tc.RouteLearningThreshold = defaultRouteLearningThreshold
+	}
+	if tc.RouteLearningThreshold < 0 {
+		err := fmt.Errorf("route_learning_threshold must be positive")
+		errors = multierr.Append(errors, err)
+	}
+	if tc.RouteLearningMaxNodes == 0 {
+		tc.RouteLearningMaxNodes = defaultRouteLearningMaxNodes
+	}
+	if tc.RouteLearningMaxNodes < 0 {
+		err := fmt.Errorf("route_learning_max_nodes must be positive")
+		errors = multierr.Append(errors, err)
+	}
+
+	errors = multierr.Append(errors, tc.SlowSpan.Validate())
+	if tc.TraceShape != nil {
+		errors = multierr.Append(errors, tc.TraceShape.Validate())
+	}
+
+	return errors
+}
+
+func (sc *SlowSpanConfig) Validate() error {
+	var errors error
+	switch sc.Strategy {
+	case "":
+		sc.Strategy = slowSpanStrategyStdDev
+	case slowSpanStrategyStdDev, slowSpanStrategyPercentile, slowSpanStrategyStatic:
+	default:
+		err := fmt.Errorf("slow_span.strategy must be one of %q, %q or %q, not %q",
+			slowSpanStrategyStdDev, slowSpanStrategyPercentile, slowSpanStrategyStatic, sc.Strategy)
+		errors = multierr.Append(errors, err)
+	}
+
+	if sc.StdDevMultiplier == 0 {
+		sc.StdDevMultiplier = defaultStdDevMultiplier
+	}
+	if sc.StdDevMultiplier < 0 {
+		err := fmt.Errorf("slow_span.stddev_multiplier must be positive")
+		errors = multierr.Append(errors, err)
+	}
+
+	if sc.Percentile == 0 {
+		sc.Percentile = defaultSlowPercentile
+	}
+	if sc.Percentile < 0 || sc.Percentile >= 1 {
+		err := fmt.Errorf("slow_span.percentile must be between 0 and 1")
+		errors = multierr.Append(errors, err)
+	}
+
+	if sc.MinSamples < 0 {
+		err := fmt.Errorf("slow_span.min_samples must not be negative")
+		errors = multierr.Append(errors, err)
+	}
+
+	for service, threshold := range sc.Thresholds {
+		if threshold <= 0 {
+			err := fmt.Errorf("slow_span.thresholds for service %q must be positive", service)
+			errors = multierr.Append(errors, err)
+		}
+	}
+	if sc.DefaultThreshold < 0 {
+		err := fmt.Errorf("slow_span.default_threshold must not be negative")
+		errors = multierr.Append(errors, err)
+	}
+	if sc.Strategy == slowSpanStrategyStatic && len(sc.Thresholds) == 0 && sc.DefaultThreshold == 0 {
+		err := fmt.Errorf("slow_span.thresholds or slow_span.default_threshold must be set for the static strategy")
+		errors = multierr.Append(errors, err)
+	}
+
+	return errors
+}
+
+func (sc *TraceShapeConfig) Validate() error {
+	var errs error
+	if sc.Timeout == 0 {
+		sc.Timeout = defaultTraceShapeTimeout
+	}
+	if sc.MaxTraces == 0 {
+		sc.MaxTraces = defaultTraceShapeMaxTraces
+	}
+
+	if sc.Timeout < 0 {
+		errs = multierr.Append(errs, errors.New("trace_shape timeout must be positive"))
+	}
+	if sc.MaxTraces < 0 {
+		errs = multierr.Append(errs, errors.New("trace_shape max_traces must be positive"))
+	}
+
+	return errs
+}
+
+func (c *CatalogConfig) Validate() error {
+	var errs error
+	if c.MaxEntries == 0 {
+		c.MaxEntries = defaultCatalogMaxEntries
+	}
+
+	if c.Endpoint == "" {
+		errs = multierr.Append(errs, errors.New("catalog endpoint must be set"))
+	}
+	if c.MaxEntries < 0 {
+		errs = multierr.Append(errs, errors.New("catalog max_entries must be greater than 0"))
+	}
+
+	return errs
+}
+
+func (mc *MultilineConfig) Validate() error {
+	var errs error
+	if len(mc.StartPatterns) == 0 && len(mc.Detectors) == 0 {
+		mc.Detectors = []string{multilineDetectorJava, multilineDetectorPython, multilineDetectorGo}
+	}
+	if mc.StreamAttributes == nil {
+		mc.StreamAttributes = defaultMultilineStreamAttributes
+	}
+	if mc.FlushTimeout == 0 {
+		mc.FlushTimeout = defaultMultilineFlushTimeout
+	}
+	if mc.MaxLines == 0 {
+		mc.MaxLines = defaultMultilineMaxLines
+	}
+	if mc.MaxStreams == 0 {
+		mc.MaxStreams = defaultMultilineMaxStreams
+	}
+
+	for _, expr := range mc.StartPatterns {
+		if _, err := regexp.Compile(expr); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("multiline start_patterns: %w", err))
+		}
+	}
+	for _, name := range mc.Detectors {
+		if _, found := multilineDetectors[name]; !found {
+			err := fmt.Errorf("multiline detectors must be %q, %q or %q, not %q",
+				multilineDetectorJava, multilineDetectorPython, multilineDetectorGo, name)
+			errs = multierr.Append(errs, err)
+		}
+	}
+	if mc.FlushTimeout < 0 {
+		errs = multierr.Append(errs, errors.New("multiline flush_timeout must be positive"))
+	}
+	if mc.MaxLines < 0 {
+		errs = multierr.Append(errs, errors.New("multiline max_lines must be positive"))
+	}
+	if mc.MaxStreams < 0 {
+		errs = multierr.Append(errs, errors.New("multiline max_streams must be positive"))
+	}
+
+	return errs
+}
+
+func (ct *CustomTokenConfig) Validate() error {
+	if ct.Stage == "" {
+		ct.Stage = customTokenStageBefore
+	}
+	_, err := ct.tokenPattern()
+	return err
+}
+
+func (ct *CustomTokenConfig) tokenPattern() (fingerprinter.TokenPattern, error) {
+	var stage fingerprinter.TokenStage
+	switch ct.Stage {
+	case customTokenStageBefore, "":
+		stage = fingerprinter.BeforeScan
+	case customTokenStageAfter:
+		stage = fingerprinter.AfterScan
+	default:
+		return fingerprinter.TokenPattern{}, fmt.Errorf("custom_tokens stage must be %q or %q, not %q", customTokenStageBefore, customTokenStageAfter, ct.Stage)
+	}
+	return fingerprinter.NewTokenPattern(ct.Name, ct.Pattern, stage)
+}
+
+func (wl WordListConfig) Validate() error {
+	var errors error
+	if wl.Name == "" {
+		errors = multierr.Append(errors, fmt.Errorf("word_lists name must be set"))
+	}
+	if wl.Path == "" && len(wl.Words) == 0 {
+		err := fmt.Errorf("word_lists %q must have a path or words", wl.Name)
+		errors = multierr.Append(errors, err)
+	}
 	return errors
 }
+
+// words returns the words read from the Path, if any, and the Words.
+func (wl WordListConfig) words() ([]string, error) {
+	if wl.Path == "" {
+		return wl.Words, nil
+	}
+	words, err := fingerprinter.LoadWordList(wl.Path)
+	if err != nil {
+		return nil, fmt.Errorf("word_lists %q: %w", wl.Name, err)
+	}
+	return append(words, wl.Words...), nil
+}
@@ -17,10 +17,12 @@ package fingerprintprocessor
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config/confighttp"
 	"go.opentelemetry.io/collector/otelcol/otelcoltest"
 
 	"github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor/internal/metadata"
@@ -43,3 +45,54 @@ func TestLoadConfig(t *testing.T) {
 	assert.NoError(t, e.Validate())
 	assert.Equal(t, createDefaultConfig(), e)
 }
+
+func TestTracesConfigValidate(t *testing.T) {
+	tc := &TracesConfig{}
+	require.NoError(t, tc.Validate())
+	assert.Equal(t, defaultEstimatorMaxEntries, tc.EstimatorMaxEntries)
+	assert.Equal(t, defaultEstimatorTTL, tc.EstimatorTTL)
+	assert.Equal(t, defaultRouteLearningThreshold, tc.RouteLearningThreshold)
+	assert.Equal(t, defaultRouteLearningMaxNodes, tc.RouteLearningMaxNodes)
+
+	assert.Error(t, (&TracesConfig{EstimatorMaxEntries: -1}).Validate())
+	assert.Error(t, (&TracesConfig{EstimatorTTL: -time.Minute}).Validate())
+	assert.Error(t, (&TracesConfig{RouteLearningThreshold: -1}).Validate())
+	assert.Error(t, (&TracesConfig{RouteLearningMaxNodes: -1}).Validate())
+	assert.Error(t, (&TracesConfig{URLTemplates: []string{"users/{id}"}}).Validate())
+	assert.Error(t, (&TracesConfig{URLTemplates: []string{"/files/*/meta"}}).Validate())
+	assert.NoError(t, (&TracesConfig{URLTemplates: []string{"/users/{id}", "/files/*"}}).Validate())
+}
+
+func TestCustomTokenConfigValidate(t *testing.T) {
+	ct := &CustomTokenConfig{Name: "OrderID", Pattern: `ORD-[0-9]+`}
+	require.NoError(t, ct.Validate())
+	assert.Equal(t, customTokenStageBefore, ct.Stage)
+
+	assert.NoError(t, (&CustomTokenConfig{Name: "Hash", Pattern: `[0-9a-f]{40}`, Stage: customTokenStageAfter}).Validate())
+	assert.Error(t, (&CustomTokenConfig{Name: "OrderID", Pattern: `ORD-[0-9]+`, Stage: "during"}).Validate())
+	assert.Error(t, (&CustomTokenConfig{Name: "OrderID", Pattern: `ORD-[0-9+`}).Validate())
+	assert.Error(t, (&CustomTokenConfig{Pattern: `ORD-[0-9]+`}).Validate())
+}
+
+func TestWordListsConfigValidate(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.WordLists = []WordListConfig{{Name: "de", Words: []string{"benutzer"}}}
+	assert.Error(t, cfg.Validate())
+	cfg.WordListAttribute = "service.language"
+	assert.NoError(t, cfg.Validate())
+
+	cfg.WordLists = append(cfg.WordLists, WordListConfig{Name: "de", Path: "de.txt"})
+	assert.Error(t, cfg.Validate())
+
+	assert.Error(t, WordListConfig{Words: []string{"benutzer"}}.Validate())
+	assert.Error(t, WordListConfig{Name: "de"}.Validate())
+}
+
+func TestCatalogConfigValidate(t *testing.T) {
+	c := &CatalogConfig{ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:4320"}}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, defaultCatalogMaxEntries, c.MaxEntries)
+
+	assert.Error(t, (&CatalogConfig{}).Validate())
+	assert.Error(t, (&CatalogConfig{ServerConfig: confighttp.ServerConfig{Endpoint: "localhost:4320"}, MaxEntries: -1}).Validate())
+}
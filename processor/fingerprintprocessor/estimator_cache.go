@@ -0,0 +1,140 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// estimatorCacheShards is the most shards an estimator cache is split
+// into, so concurrent consumers seldom wait on the same lock.
+const estimatorCacheShards = 16
+
+// Reasons an estimator is evicted from the cache.
+const (
+	evictionReasonTTL      = "ttl"
+	evictionReasonCapacity = "capacity"
+)
+
+// estimatorCache holds a slow-span estimator per span fingerprint.  It is
+// split into shards by fingerprint, each an LRU list guarded by its own
+// mutex.  Estimators not used within the TTL are dropped, as is the least
+// recently used one when a shard is full.
+type estimatorCache struct {
+	shards       []*estimatorShard
+	ttl          time.Duration
+	newEstimator func() spanEstimator
+	onEvict      func(reason string, count int)
+}
+
+type estimatorShard struct {
+	sync.Mutex
+	maxEntries int
+	entries    map[uint64]*list.Element
+	// lru holds *estimatorEntry, most recently used first.
+	lru *list.List
+}
+
+type estimatorEntry struct {
+	fingerprint uint64
+	estimator   spanEstimator
+	lastUsed    time.Time
+}
+
+// newEstimatorCache returns a cache holding at most maxEntries estimators.
+// onEvict is called with the number of estimators evicted for each reason.
+func newEstimatorCache(maxEntries int, ttl time.Duration, newEstimator func() spanEstimator, onEvict func(reason string, count int)) *estimatorCache {
+	n := max(1, min(estimatorCacheShards, maxEntries))
+	c := &estimatorCache{
+		shards:       make([]*estimatorShard, n),
+		ttl:          ttl,
+		newEstimator: newEstimator,
+		onEvict:      onEvict,
+	}
+	for i := range c.shards {
+		// Spread the remainder so the shards add up to maxEntries.
+		shardMax := maxEntries / n
+		if i < maxEntries%n {
+			shardMax++
+		}
+		c.shards[i] = &estimatorShard{
+			maxEntries: shardMax,
+			entries:    map[uint64]*list.Element{},
+			lru:        list.New(),
+		}
+	}
+	return c
+}
+
+// update adds a span duration to the estimator for fingerprint, creating
+// one if needed, and returns its threshold.
+func (c *estimatorCache) update(fingerprint uint64, now time.Time, duration float64) (float64, bool) {
+	shard := c.shards[fingerprint%uint64(len(c.shards))]
+	shard.Lock()
+	defer shard.Unlock()
+
+	if expired := shard.evictExpired(now.Add(-c.ttl)); expired > 0 {
+		c.onEvict(evictionReasonTTL, expired)
+	}
+
+	var entry *estimatorEntry
+	if elem, found := shard.entries[fingerprint]; found {
+		entry = elem.Value.(*estimatorEntry)
+		shard.lru.MoveToFront(elem)
+	} else {
+		if shard.lru.Len() >= shard.maxEntries {
+			shard.remove(shard.lru.Back())
+			c.onEvict(evictionReasonCapacity, 1)
+		}
+		entry = &estimatorEntry{fingerprint: fingerprint, estimator: c.newEstimator()}
+		shard.entries[fingerprint] = shard.lru.PushFront(entry)
+	}
+	entry.lastUsed = now
+
+	return entry.estimator.update(now, duration)
+}
+
+// len returns the number of estimators held.
+func (c *estimatorCache) len() int {
+	n := 0
+	for _, shard := range c.shards {
+		shard.Lock()
+		n += shard.lru.Len()
+		shard.Unlock()
+	}
+	return n
+}
+
+// evictExpired removes the estimators last used before cutoff, and
+// returns how many there were.  The LRU list is in order of use, so they
+// are all at the back.
+func (s *estimatorShard) evictExpired(cutoff time.Time) int {
+	n := 0
+	for elem := s.lru.Back(); elem != nil; elem = s.lru.Back() {
+		if !elem.Value.(*estimatorEntry).lastUsed.Before(cutoff) {
+			break
+		}
+		s.remove(elem)
+		n++
+	}
+	return n
+}
+
+func (s *estimatorShard) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*estimatorEntry).fingerprint)
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// evictionRecorder counts evictions by reason.
+type evictionRecorder struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (r *evictionRecorder) record(reason string, count int) {
+	r.Lock()
+	defer r.Unlock()
+	if r.counts == nil {
+		r.counts = map[string]int{}
+	}
+	r.counts[reason] += count
+}
+
+func newTestEstimatorCache(maxEntries int, ttl time.Duration) (*estimatorCache, *evictionRecorder) {
+	r := &evictionRecorder{}
+	c := newEstimatorCache(maxEntries, ttl, func() spanEstimator {
+		return newStdDevEstimator(10, 1000, 3)
+	}, r.record)
+	return c, r
+}
+
+func (c *estimatorCache) contains(fingerprint uint64) bool {
+	shard := c.shards[fingerprint%uint64(len(c.shards))]
+	shard.Lock()
+	defer shard.Unlock()
+	_, found := shard.entries[fingerprint]
+	return found
+}
+
+func TestEstimatorCacheShardsAddUpToMaxEntries(t *testing.T) {
+	for _, maxEntries := range []int{1, 5, 16, 100} {
+		c, _ := newTestEstimatorCache(maxEntries, time.Hour)
+		total := 0
+		for _, shard := range c.shards {
+			assert.Positive(t, shard.maxEntries)
+			total += shard.maxEntries
+		}
+		assert.Equal(t, maxEntries, total)
+	}
+}
+
+func TestEstimatorCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	// Two entries in each of 16 shards.
+	c, r := newTestEstimatorCache(32, time.Hour)
+	require.Len(t, c.shards, estimatorCacheShards)
+	now := time.UnixMilli(1717245000000)
+
+	c.update(0, now, 10)
+	c.update(16, now, 10)
+	c.update(0, now, 10)
+	// Shard 0 is full, and 16 was used least recently.
+	c.update(32, now, 10)
+
+	assert.True(t, c.contains(0))
+	assert.False(t, c.contains(16))
+	assert.True(t, c.contains(32))
+	assert.Equal(t, 2, c.len())
+	assert.Equal(t, map[string]int{evictionReasonCapacity: 1}, r.counts)
+
+	for fp := range uint64(1000) {
+		c.update(fp, now, 10)
+	}
+	assert.Equal(t, 32, c.len())
+}
+
+func TestEstimatorCacheEvictsExpired(t *testing.T) {
+	c, r := newTestEstimatorCache(100, time.Minute)
+	now := time.UnixMilli(1717245000000)
+
+	c.update(0, now, 10)
+	c.update(16, now.Add(30*time.Second), 10)
+	c.update(32, now.Add(30*time.Second), 10)
+
+	// Using a shard drops what has gone unused there for the TTL.
+	c.update(48, now.Add(61*time.Second), 10)
+	assert.False(t, c.contains(0))
+	assert.True(t, c.contains(16))
+	assert.Equal(t, map[string]int{evictionReasonTTL: 1}, r.counts)
+
+	c.update(48, now.Add(100*time.Second), 10)
+	assert.False(t, c.contains(16))
+	assert.False(t, c.contains(32))
+	assert.Equal(t, map[string]int{evictionReasonTTL: 3}, r.counts)
+	assert.Equal(t, 1, c.len())
+}
+
+func TestEstimatorCacheStartsOverAfterExpiry(t *testing.T) {
+	c, _ := newTestEstimatorCache(100, time.Minute)
+	now := time.UnixMilli(1717245000000)
+
+	c.update(7, now, 10)
+	first := c.shards[7].entries[7].Value.(*estimatorEntry).estimator
+	c.update(7, now.Add(59*time.Second), 10)
+	assert.Same(t, first, c.shards[7].entries[7].Value.(*estimatorEntry).estimator)
+
+	c.update(7, now.Add(2*time.Minute), 10)
+	assert.NotSame(t, first, c.shards[7].entries[7].Value.(*estimatorEntry).estimator)
+}
+
+func spansForService(service string, count int) ptrace.Traces {
+	td := ptrace.NewTraces()
+	rs := td.ResourceSpans().AppendEmpty()
+	rs.Resource().Attributes().PutStr("service.name", service)
+	spans := rs.ScopeSpans().AppendEmpty().Spans()
+	start := time.Now()
+	for i := range count {
+		span := spans.AppendEmpty()
+		span.Attributes().PutStr(translate.CardinalFieldSpanName, fmt.Sprintf("operation-%d", i))
+		span.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+		span.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(time.Duration(i) * time.Millisecond)))
+	}
+	return td
+}
+
+// Run with -race to check consumers can share the estimators.
+func TestConsumeTracesConcurrently(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesConfig.EstimatorMaxEntries = 50
+	p, err := newPitbull(cfg, "traces", processortest.NewNopSettings())
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 20 {
+				td := spansForService(fmt.Sprintf("service-%d", (i+j)%4), 25)
+				_, err := p.ConsumeTraces(context.Background(), td)
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 4 services with 25 span names each is more than the cache holds.
+	assert.LessOrEqual(t, p.estimators.len(), 50)
+}
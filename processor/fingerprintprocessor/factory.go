@@ -16,6 +16,7 @@ package fingerprintprocessor
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
@@ -25,6 +26,28 @@ import (
 	"github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor/internal/metadata"
 )
 
+const (
+	defaultEstimatorMaxEntries   = 10_000
+	defaultEstimatorTTL          = 30 * time.Minute
+	defaultStdDevMultiplier      = 3
+	defaultSlowPercentile        = 0.99
+	defaultCatalogMaxEntries     = 10_000
+	defaultCatalogLimit          = 100
+	defaultMultilineMaxLines     = 500
+	defaultMultilineFlushTimeout = time.Second
+	defaultMultilineMaxStreams   = 10_000
+	// defaultRouteLearningThreshold is well above the number of fixed
+	// routes usually found under one path prefix.
+	defaultRouteLearningThreshold = 50
+	defaultRouteLearningMaxNodes  = 100_000
+	defaultTraceShapeTimeout      = 30 * time.Second
+	defaultTraceShapeMaxTraces    = 50_000
+)
+
+// defaultMultilineStreamAttributes tell apart the files and standard
+// output and error streams of a resource.
+var defaultMultilineStreamAttributes = []string{"log.file.path", "log.iostream"}
+
 // NewFactory creates a factory for S3 exporter.
 func NewFactory() processor.Factory {
 	return processor.NewFactory(
@@ -39,8 +62,17 @@ func NewFactory() processor.Factory {
 func createDefaultConfig() component.Config {
 	return &Config{
 		TracesConfig: TracesConfig{
-			EstimatorWindowSize: 30,
-			EstimatorInterval:   10000,
+			EstimatorWindowSize:    30,
+			EstimatorInterval:      10000,
+			EstimatorMaxEntries:    defaultEstimatorMaxEntries,
+			EstimatorTTL:           defaultEstimatorTTL,
+			RouteLearningThreshold: defaultRouteLearningThreshold,
+			RouteLearningMaxNodes:  defaultRouteLearningMaxNodes,
+			SlowSpan: SlowSpanConfig{
+				Strategy:         slowSpanStrategyStdDev,
+				StdDevMultiplier: defaultStdDevMultiplier,
+				Percentile:       defaultSlowPercentile,
+			},
 		},
 	}
 }
@@ -50,10 +82,13 @@ func createLogsProcessor(ctx context.Context, set processor.Settings, cfg compon
 	if err != nil {
 		return nil, err
 	}
+	e.nextLogs = nextConsumer
 	return processorhelper.NewLogs(
 		ctx, set, cfg, nextConsumer,
 		e.ConsumeLogs,
-		processorhelper.WithCapabilities(e.Capabilities()))
+		processorhelper.WithCapabilities(e.Capabilities()),
+		processorhelper.WithStart(e.Start),
+		processorhelper.WithShutdown(e.Shutdown))
 }
 
 func createSpansProcessor(ctx context.Context, set processor.Settings, cfg component.Config, nextConsumer consumer.Traces) (processor.Traces, error) {
@@ -61,10 +96,13 @@ func createSpansProcessor(ctx context.Context, set processor.Settings, cfg compo
 	if err != nil {
 		return nil, err
 	}
+	e.nextTraces = nextConsumer
 	return processorhelper.NewTraces(
 		ctx, set, cfg, nextConsumer,
 		e.ConsumeTraces,
-		processorhelper.WithCapabilities(e.Capabilities()))
+		processorhelper.WithCapabilities(e.Capabilities()),
+		processorhelper.WithStart(e.Start),
+		processorhelper.WithShutdown(e.Shutdown))
 }
 
 func createMetricsProcessor(ctx context.Context, set processor.Settings, cfg component.Config, nextConsumer consumer.Metrics) (processor.Metrics, error) {
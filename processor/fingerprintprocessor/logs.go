@@ -17,13 +17,17 @@ package fingerprintprocessor
 import (
 	"context"
 	"strings"
+	"time"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/processor/processorhelper"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"go.uber.org/zap"
 
 	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
 )
 
 func getServiceName(r pcommon.Map) string {
@@ -34,18 +38,104 @@ func getServiceName(r pcommon.Map) string {
 	return "unknown"
 }
 
+// promoteKeyValues adds the pairs of a logfmt or key=value body to attrs,
+// keeping any attribute already set.
+func promoteKeyValues(attrs pcommon.Map, body string) {
+	_, pairs, ok := fingerprinter.ParseKeyValues(strings.TrimSpace(body))
+	if !ok {
+		return
+	}
+	for _, kv := range pairs {
+		if _, found := attrs.Get(kv.Key); !found {
+			attrs.PutStr(kv.Key, kv.Value)
+		}
+	}
+}
+
+// logFingerprinterFor returns the fingerprinter for the word list named by
+// the resource, or the English one if it names none.
+func (e *fingerprintProcessor) logFingerprinterFor(r pcommon.Map) fingerprinter.Fingerprinter {
+	if e.config.WordListAttribute == "" {
+		return e.logFingerprinter
+	}
+	if name, found := r.Get(e.config.WordListAttribute); found {
+		if fp, found := e.wordListFingerprinters[name.AsString()]; found {
+			return fp
+		}
+	}
+	return e.logFingerprinter
+}
+
 func (e *fingerprintProcessor) ConsumeLogs(_ context.Context, ld plog.Logs) (plog.Logs, error) {
+	if e.multiline != nil {
+		ld = e.multiline.add(ld, time.Now())
+		if ld.LogRecordCount() == 0 {
+			return ld, processorhelper.ErrSkipProcessingData
+		}
+	}
+	e.fingerprintLogs(ld)
+	return ld, nil
+}
+
+// multilineFlushTask sends on log records whose streams have had no more
+// lines for the flush timeout.
+func (e *fingerprintProcessor) multilineFlushTask() {
+	defer close(e.flushDone)
+	ticker := time.NewTicker(max(e.multiline.flushTimeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stopFlush:
+			return
+		case now := <-ticker.C:
+			if err := e.sendCombinedLogs(context.Background(), e.multiline.flushExpired(now)); err != nil {
+				e.logger.Warn("Failed to send combined log records", zap.Error(err))
+			}
+		}
+	}
+}
+
+// sendCombinedLogs fingerprints records released by the multi-line
+// combiner outside of ConsumeLogs, and passes them to the next consumer.
+func (e *fingerprintProcessor) sendCombinedLogs(ctx context.Context, ld plog.Logs) error {
+	if ld.LogRecordCount() == 0 || e.nextLogs == nil {
+		return nil
+	}
+	e.fingerprintLogs(ld)
+	return e.nextLogs.ConsumeLogs(ctx, ld)
+}
+
+func (e *fingerprintProcessor) fingerprintLogs(ld plog.Logs) {
+	var sightings map[int64]catalogSighting
+	if e.catalog != nil {
+		sightings = map[int64]catalogSighting{}
+	}
+
 	for i := 0; i < ld.ResourceLogs().Len(); i++ {
 		rl := ld.ResourceLogs().At(i)
+		fp := e.logFingerprinterFor(rl.Resource().Attributes())
 		for j := 0; j < rl.ScopeLogs().Len(); j++ {
 			sl := rl.ScopeLogs().At(j)
 			for k := 0; k < sl.LogRecords().Len(); k++ {
 				lr := sl.LogRecords().At(k)
-				fingerprint, level, err := e.logFingerprinter.Fingerprint(lr.Body().AsString())
+				body := lr.Body().AsString()
+				if _, combined := lr.Attributes().Get(cardinalFieldLogLineCount); combined {
+					// A stack trace is fingerprinted by its first line.
+					body, _, _ = strings.Cut(body, "\n")
+				}
+				template, level, err := fp.TokenizeInput(body)
 				if err != nil {
 					e.logger.Debug("Error fingerprinting log", zap.Error(err))
 					continue
 				}
+				fingerprint := fingerprinter.FingerprintTokens(template)
+				if sightings != nil {
+					sighting := sightings[fingerprint]
+					sightings[fingerprint] = catalogSighting{template: template, count: sighting.count + 1}
+				}
+				if e.config.LogsConfig.PromoteKeyValues {
+					promoteKeyValues(lr.Attributes(), body)
+				}
 				lr.Attributes().PutInt(translate.CardinalFieldFingerprint, fingerprint)
 				if lr.SeverityNumber() == plog.SeverityNumberUnspecified {
 					lr.SetSeverityText(strings.ToUpper(level))
@@ -55,5 +145,7 @@ func (e *fingerprintProcessor) ConsumeLogs(_ context.Context, ld plog.Logs) (plo
 		}
 	}
 
-	return ld, nil
+	if e.catalog != nil {
+		e.catalog.record(sightings, time.Now())
+	}
 }
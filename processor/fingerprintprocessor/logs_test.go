@@ -15,11 +15,19 @@
 package fingerprintprocessor
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/processor/processortest"
 	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
 )
 
 func TestGetServiceName(t *testing.T) {
@@ -32,3 +40,129 @@ func TestGetServiceName(t *testing.T) {
 	serviceName = getServiceName(attr)
 	assert.Equal(t, "unknown", serviceName)
 }
+
+func TestCustomTokensInLogFingerprints(t *testing.T) {
+	fingerprints := func(cfg *Config) []int64 {
+		p, err := newPitbull(cfg, "logs", processortest.NewNopSettings())
+		require.NoError(t, err)
+		ld := plog.NewLogs()
+		records := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+		records.AppendEmpty().Body().SetStr("uploaded order ORD-20240101 to arn:aws:s3:::orders/2024/01.json")
+		records.AppendEmpty().Body().SetStr("uploaded order ORD-2024.0102 to arn:aws:s3:::orders")
+		_, err = p.ConsumeLogs(context.Background(), ld)
+		require.NoError(t, err)
+
+		var ret []int64
+		for i := 0; i < records.Len(); i++ {
+			fingerprint, found := records.At(i).Attributes().Get(translate.CardinalFieldFingerprint)
+			require.True(t, found)
+			ret = append(ret, fingerprint.Int())
+		}
+		return ret
+	}
+
+	cfg := createDefaultConfig().(*Config)
+	got := fingerprints(cfg)
+	assert.NotEqual(t, got[0], got[1])
+
+	cfg.CustomTokens = []CustomTokenConfig{
+		{Name: "OrderID", Pattern: `ORD-[0-9.]+`},
+		{Name: "ARN", Pattern: `arn:aws:[a-z0-9-]+:[a-z0-9-]*:[0-9]*:[A-Za-z0-9/_.:-]+`},
+	}
+	require.NoError(t, cfg.Validate())
+	got = fingerprints(cfg)
+	assert.Equal(t, got[0], got[1])
+}
+
+func TestWordListsInLogFingerprints(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.WordListAttribute = "service.language"
+	cfg.WordLists = []WordListConfig{{
+		Name:  "de",
+		Words: []string{"benutzer", "hat", "sich", "angemeldet", "abgemeldet"},
+	}}
+	require.NoError(t, cfg.Validate())
+	p, err := newPitbull(cfg, "logs", processortest.NewNopSettings())
+	require.NoError(t, err)
+
+	ld := plog.NewLogs()
+	for _, language := range []string{"de", "en"} {
+		rl := ld.ResourceLogs().AppendEmpty()
+		rl.Resource().Attributes().PutStr("service.language", language)
+		records := rl.ScopeLogs().AppendEmpty().LogRecords()
+		records.AppendEmpty().Body().SetStr("Benutzer mueller hat sich angemeldet")
+		records.AppendEmpty().Body().SetStr("Benutzer mueller hat sich abgemeldet")
+	}
+	_, err = p.ConsumeLogs(context.Background(), ld)
+	require.NoError(t, err)
+
+	fingerprints := func(i int) []int64 {
+		records := ld.ResourceLogs().At(i).ScopeLogs().At(0).LogRecords()
+		var ret []int64
+		for j := 0; j < records.Len(); j++ {
+			fingerprint, found := records.At(j).Attributes().Get(translate.CardinalFieldFingerprint)
+			require.True(t, found)
+			ret = append(ret, fingerprint.Int())
+		}
+		return ret
+	}
+	// Only the resource choosing the German list tells the lines apart.
+	de := fingerprints(0)
+	assert.NotEqual(t, de[0], de[1])
+	en := fingerprints(1)
+	assert.Equal(t, en[0], en[1])
+}
+
+func TestPromoteKeyValues(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.LogsConfig.PromoteKeyValues = true
+	p, err := newPitbull(cfg, "logs", processortest.NewNopSettings())
+	require.NoError(t, err)
+
+	ld := plog.NewLogs()
+	records := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords()
+	lr := records.AppendEmpty()
+	lr.Body().SetStr(`level=warn msg="payment failed" user=123 order=ORD-1`)
+	lr.Attributes().PutStr("user", "already-set")
+	records.AppendEmpty().Body().SetStr("payment failed for user 123")
+	_, err = p.ConsumeLogs(context.Background(), ld)
+	require.NoError(t, err)
+
+	attrs := records.At(0).Attributes()
+	assert.Equal(t, "warn", attrs.AsRaw()["level"])
+	assert.Equal(t, "payment failed", attrs.AsRaw()["msg"])
+	assert.Equal(t, "ORD-1", attrs.AsRaw()["order"])
+	assert.Equal(t, "already-set", attrs.AsRaw()["user"])
+	level, _ := attrs.Get(translate.CardinalFieldLevel)
+	assert.Equal(t, "warn", level.Str())
+
+	_, found := records.At(1).Attributes().Get("user")
+	assert.False(t, found)
+}
+
+func TestKeyValueFingerprintsOptIn(t *testing.T) {
+	body := `level=warn msg="payment failed" user=123 order=ORD-1`
+	fingerprint := func(cfg *Config) int64 {
+		p, err := newPitbull(cfg, "logs", processortest.NewNopSettings())
+		require.NoError(t, err)
+		ld := plog.NewLogs()
+		lr := ld.ResourceLogs().AppendEmpty().ScopeLogs().AppendEmpty().LogRecords().AppendEmpty()
+		lr.Body().SetStr(body)
+		_, err = p.ConsumeLogs(context.Background(), ld)
+		require.NoError(t, err)
+		fingerprint, found := lr.Attributes().Get(translate.CardinalFieldFingerprint)
+		require.True(t, found)
+		return fingerprint.Int()
+	}
+
+	// By default the body is fingerprinted as free text, as before.
+	cfg := createDefaultConfig().(*Config)
+	want, _, err := fingerprinter.NewFingerprinter().Fingerprint(body)
+	require.NoError(t, err)
+	assert.Equal(t, want, fingerprint(cfg))
+
+	cfg.LogsConfig.KeyValueFingerprints = true
+	want, _, err = fingerprinter.NewFingerprinter(fingerprinter.WithKeyValues()).Fingerprint(body)
+	require.NoError(t, err)
+	assert.Equal(t, want, fingerprint(cfg))
+}
@@ -0,0 +1,299 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"container/list"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// Built-in multi-line detectors.
+const (
+	multilineDetectorJava   = "java"
+	multilineDetectorPython = "python"
+	multilineDetectorGo     = "go"
+)
+
+// cardinalFieldLogLineCount is set on records combined from more than one
+// line.
+var cardinalFieldLogLineCount = translate.CardinalFieldPrefixDot + "line_count"
+
+// multilineDetector returns true if line continues the record made of
+// lines so far.
+type multilineDetector func(lines []string, line string) bool
+
+var multilineDetectors = map[string]multilineDetector{
+	multilineDetectorJava:   javaContinues,
+	multilineDetectorPython: pythonContinues,
+	multilineDetectorGo:     goPanicContinues,
+}
+
+var (
+	javaContinuation = regexp.MustCompile(`^\s+at \S|^\s*\.\.\. \d+ (?:more|common frames omitted)|^\s*Caused by: |^\s*Suppressed: `)
+
+	pythonTraceback    = "Traceback (most recent call last):"
+	pythonChained      = regexp.MustCompile(`^(?:During handling of the above exception|The above exception was the direct cause)`)
+	pythonIndented     = regexp.MustCompile(`^\s+\S`)
+	pythonExceptionEnd = regexp.MustCompile(`^[A-Za-z_][\w.]*(?:Error|Exception|Warning|Exit|Interrupt|Iteration)\b`)
+
+	goPanicStart        = regexp.MustCompile(`^(?:panic: |fatal error: )`)
+	goPanicContinuation = regexp.MustCompile(`^(?:goroutine \d+ \[|\t|created by |\[signal |exit status \d+|panic: |[\w./*()\[\]-]+\(.*\)$)`)
+)
+
+// javaContinues accepts the stack frames and cause of a Java exception.
+func javaContinues(_ []string, line string) bool {
+	return javaContinuation.MatchString(line)
+}
+
+// pythonContinues accepts a traceback, which the logging module writes
+// straight after the message, and the lines that follow it up to the
+// exception.
+func pythonContinues(lines []string, line string) bool {
+	if line == pythonTraceback {
+		return true
+	}
+	if !slices.Contains(lines, pythonTraceback) {
+		return false
+	}
+	prev := lines[len(lines)-1]
+	switch {
+	case strings.TrimSpace(line) == "", pythonChained.MatchString(line), pythonIndented.MatchString(line):
+		return true
+	case pythonIndented.MatchString(prev):
+		return pythonExceptionEnd.MatchString(line)
+	}
+	return false
+}
+
+// goPanicContinues accepts the goroutine stacks following a Go panic.
+func goPanicContinues(lines []string, line string) bool {
+	if !goPanicStart.MatchString(lines[0]) {
+		return false
+	}
+	return strings.TrimSpace(line) == "" || goPanicContinuation.MatchString(line)
+}
+
+// multilineCombiner joins log records that are lines of one message, such
+// as a stack trace, into a single record.  Records are held per stream,
+// identified by the resource, scope and stream attributes, until a line
+// starting a new record arrives or the stream is idle for the timeout.
+// At most maxStreams records are held; a new stream past that sends on
+// the record of the stream idle the longest.
+type multilineCombiner struct {
+	sync.Mutex
+	startPatterns    []*regexp.Regexp
+	detectors        []multilineDetector
+	streamAttributes []string
+	flushTimeout     time.Duration
+	maxLines         int
+	maxStreams       int
+
+	pending map[uint64]*pendingRecord
+	// idle orders the pending records by lastSeen, oldest first.
+	idle *list.List
+}
+
+type pendingRecord struct {
+	key      uint64
+	elem     *list.Element
+	resource pcommon.Resource
+	scope    pcommon.InstrumentationScope
+	record   plog.LogRecord
+	lines    []string
+	lastSeen time.Time
+}
+
+func newMultilineCombiner(mc MultilineConfig) (*multilineCombiner, error) {
+	c := &multilineCombiner{
+		streamAttributes: mc.StreamAttributes,
+		flushTimeout:     mc.FlushTimeout,
+		maxLines:         mc.MaxLines,
+		maxStreams:       mc.MaxStreams,
+		pending:          map[uint64]*pendingRecord{},
+		idle:             list.New(),
+	}
+	for _, expr := range mc.StartPatterns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		c.startPatterns = append(c.startPatterns, re)
+	}
+	for _, name := range mc.Detectors {
+		c.detectors = append(c.detectors, multilineDetectors[name])
+	}
+	return c, nil
+}
+
+// add takes the records in ld, and returns those that are complete.
+func (c *multilineCombiner) add(ld plog.Logs, now time.Time) plog.Logs {
+	c.Lock()
+	defer c.Unlock()
+
+	out := newCombinedLogs()
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		h := xxhash.New()
+		hashMap(h, rl.Resource().Attributes())
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			sl := rl.ScopeLogs().At(j)
+			scopeHash := *h
+			_, _ = scopeHash.WriteString(sl.Scope().Name() + "\x00" + sl.Scope().Version() + "\x00")
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				lr := sl.LogRecords().At(k)
+				recordHash := scopeHash
+				for _, name := range c.streamAttributes {
+					if v, found := lr.Attributes().Get(name); found {
+						_, _ = recordHash.WriteString(name + "=" + v.AsString() + "\x00")
+					}
+				}
+				key := recordHash.Sum64()
+
+				line := lr.Body().AsString()
+				p := c.pending[key]
+				if p != nil && len(p.lines) < c.maxLines && c.continues(p.lines, line) {
+					p.lines = append(p.lines, line)
+					p.lastSeen = now
+					c.idle.MoveToBack(p.elem)
+					continue
+				}
+				if p != nil {
+					c.remove(p)
+					out.emit(key, p)
+				} else if len(c.pending) >= c.maxStreams {
+					oldest := c.idle.Front().Value.(*pendingRecord)
+					c.remove(oldest)
+					out.emit(oldest.key, oldest)
+				}
+				p = &pendingRecord{
+					key:      key,
+					resource: pcommon.NewResource(),
+					scope:    pcommon.NewInstrumentationScope(),
+					record:   plog.NewLogRecord(),
+					lines:    []string{line},
+					lastSeen: now,
+				}
+				rl.Resource().CopyTo(p.resource)
+				sl.Scope().CopyTo(p.scope)
+				lr.CopyTo(p.record)
+				p.elem = c.idle.PushBack(p)
+				c.pending[key] = p
+			}
+		}
+	}
+	return out.ld
+}
+
+func (c *multilineCombiner) remove(p *pendingRecord) {
+	c.idle.Remove(p.elem)
+	delete(c.pending, p.key)
+}
+
+// flushExpired returns the records whose streams have been idle for the
+// timeout.
+func (c *multilineCombiner) flushExpired(now time.Time) plog.Logs {
+	c.Lock()
+	defer c.Unlock()
+
+	out := newCombinedLogs()
+	cutoff := now.Add(-c.flushTimeout)
+	for e := c.idle.Front(); e != nil; e = c.idle.Front() {
+		p := e.Value.(*pendingRecord)
+		if p.lastSeen.After(cutoff) {
+			break
+		}
+		c.remove(p)
+		out.emit(p.key, p)
+	}
+	return out.ld
+}
+
+// flushAll returns all held records.
+func (c *multilineCombiner) flushAll() plog.Logs {
+	c.Lock()
+	defer c.Unlock()
+
+	out := newCombinedLogs()
+	for e := c.idle.Front(); e != nil; e = c.idle.Front() {
+		p := e.Value.(*pendingRecord)
+		c.remove(p)
+		out.emit(p.key, p)
+	}
+	return out.ld
+}
+
+func (c *multilineCombiner) continues(lines []string, line string) bool {
+	if len(c.startPatterns) > 0 && !slices.ContainsFunc(c.startPatterns, func(re *regexp.Regexp) bool { return re.MatchString(line) }) {
+		return true
+	}
+	for _, detector := range c.detectors {
+		if detector(lines, line) {
+			return true
+		}
+	}
+	return false
+}
+
+// combinedLogs builds the output of the combiner, putting the records of
+// each stream under one resource and scope.
+type combinedLogs struct {
+	ld     plog.Logs
+	scopes map[uint64]plog.ScopeLogs
+}
+
+func newCombinedLogs() *combinedLogs {
+	return &combinedLogs{ld: plog.NewLogs(), scopes: map[uint64]plog.ScopeLogs{}}
+}
+
+func (o *combinedLogs) emit(key uint64, p *pendingRecord) {
+	sl, found := o.scopes[key]
+	if !found {
+		rl := o.ld.ResourceLogs().AppendEmpty()
+		p.resource.MoveTo(rl.Resource())
+		sl = rl.ScopeLogs().AppendEmpty()
+		p.scope.MoveTo(sl.Scope())
+		o.scopes[key] = sl
+	}
+	lr := sl.LogRecords().AppendEmpty()
+	p.record.MoveTo(lr)
+	if len(p.lines) > 1 {
+		lr.Body().SetStr(strings.Join(p.lines, "\n"))
+		lr.Attributes().PutInt(cardinalFieldLogLineCount, int64(len(p.lines)))
+	}
+}
+
+// hashMap adds the attributes to h, in sorted order.
+func hashMap(h *xxhash.Digest, m pcommon.Map) {
+	keys := make([]string, 0, m.Len())
+	m.Range(func(k string, _ pcommon.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	slices.Sort(keys)
+	for _, k := range keys {
+		v, _ := m.Get(k)
+		_, _ = h.WriteString(k + "=" + v.AsString() + "\x00")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func newTestCombiner(t *testing.T, mc MultilineConfig) *multilineCombiner {
+	require.NoError(t, mc.Validate())
+	c, err := newMultilineCombiner(mc)
+	require.NoError(t, err)
+	return c
+}
+
+// linesToLogs makes a log record for each line, with the stream attribute
+// log.iostream set to stream.
+func linesToLogs(stream string, lines ...string) plog.Logs {
+	ld := plog.NewLogs()
+	rl := ld.ResourceLogs().AppendEmpty()
+	rl.Resource().Attributes().PutStr("service.name", "checkout")
+	records := rl.ScopeLogs().AppendEmpty().LogRecords()
+	for _, line := range lines {
+		lr := records.AppendEmpty()
+		lr.Body().SetStr(line)
+		lr.Attributes().PutStr("log.iostream", stream)
+	}
+	return ld
+}
+
+func readLines(t *testing.T, path string) []string {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+func logBodies(ld plog.Logs) []string {
+	var ret []string
+	for i := 0; i < ld.ResourceLogs().Len(); i++ {
+		rl := ld.ResourceLogs().At(i)
+		for j := 0; j < rl.ScopeLogs().Len(); j++ {
+			records := rl.ScopeLogs().At(j).LogRecords()
+			for k := 0; k < records.Len(); k++ {
+				ret = append(ret, records.At(k).Body().AsString())
+			}
+		}
+	}
+	return ret
+}
+
+func TestMultilineDetectors(t *testing.T) {
+	for _, tc := range []struct {
+		detector string
+		// records are the number of lines in each record of the sample.
+		records []int
+	}{
+		{multilineDetectorJava, []int{1, 7, 1}},
+		{multilineDetectorPython, []int{14, 1}},
+		{multilineDetectorGo, []int{9, 1}},
+	} {
+		t.Run(tc.detector, func(t *testing.T) {
+			c := newTestCombiner(t, MultilineConfig{})
+			now := time.Now()
+			lines := readLines(t, "testdata/multiline/"+tc.detector+".txt")
+			got := logBodies(c.add(linesToLogs("stderr", lines...), now))
+			got = append(got, logBodies(c.flushAll())...)
+
+			require.Len(t, got, len(tc.records))
+			next := 0
+			for i, n := range tc.records {
+				assert.Equal(t, strings.Join(lines[next:next+n], "\n"), got[i])
+				next += n
+			}
+		})
+	}
+}
+
+func TestMultilineStartPatterns(t *testing.T) {
+	c := newTestCombiner(t, MultilineConfig{StartPatterns: []string{`^\d{4}-\d{2}-\d{2} `}})
+	ld := linesToLogs("stdout",
+		"2024-06-16 18:37:46 query failed:",
+		"  SELECT * FROM orders",
+		"  WHERE id = 42",
+		"2024-06-16 18:37:47 query ok",
+	)
+	got := c.add(ld, time.Now())
+	require.Equal(t, 1, got.LogRecordCount())
+	lr := got.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0)
+	assert.Equal(t, "2024-06-16 18:37:46 query failed:\n  SELECT * FROM orders\n  WHERE id = 42", lr.Body().Str())
+	assert.Equal(t, map[string]any{"log.iostream": "stdout", cardinalFieldLogLineCount: int64(3)}, lr.Attributes().AsRaw())
+	assert.Equal(t, "checkout", got.ResourceLogs().At(0).Resource().Attributes().AsRaw()["service.name"])
+
+	// The last record waits for more lines.
+	assert.Equal(t, []string{"2024-06-16 18:37:47 query ok"}, logBodies(c.flushAll()))
+}
+
+func TestMultilineStreams(t *testing.T) {
+	c := newTestCombiner(t, MultilineConfig{})
+	now := time.Now()
+	assert.Equal(t, 0, c.add(linesToLogs("stderr", "java.lang.IllegalStateException: boom"), now).LogRecordCount())
+	// Another stream's lines do not continue the exception.
+	assert.Equal(t, 0, c.add(linesToLogs("stdout", "\tat com.example.Main.main(Main.java:1)"), now).LogRecordCount())
+	assert.Equal(t, 0, c.add(linesToLogs("stderr", "\tat com.example.Main.run(Main.java:9)"), now).LogRecordCount())
+
+	assert.ElementsMatch(t, []string{
+		"java.lang.IllegalStateException: boom\n\tat com.example.Main.run(Main.java:9)",
+		"\tat com.example.Main.main(Main.java:1)",
+	}, logBodies(c.flushAll()))
+}
+
+func TestMultilineFlushTimeoutAndMaxLines(t *testing.T) {
+	c := newTestCombiner(t, MultilineConfig{FlushTimeout: time.Second, MaxLines: 2})
+	start := time.Now()
+	c.add(linesToLogs("stderr", "java.lang.IllegalStateException: boom"), start)
+	c.add(linesToLogs("stdout", "hello"), start.Add(800*time.Millisecond))
+
+	got := c.flushExpired(start.Add(time.Second))
+	assert.Equal(t, []string{"java.lang.IllegalStateException: boom"}, logBodies(got))
+
+	got = c.add(linesToLogs("stderr", "java.lang.IllegalStateException: boom",
+		"\tat com.example.Main.run(Main.java:9)",
+		"\tat com.example.Main.main(Main.java:1)"), start.Add(2*time.Second))
+	assert.Equal(t, []string{"java.lang.IllegalStateException: boom\n\tat com.example.Main.run(Main.java:9)"}, logBodies(got))
+}
+
+func TestMultilineMaxStreams(t *testing.T) {
+	c := newTestCombiner(t, MultilineConfig{MaxStreams: 2})
+	start := time.Now()
+	assert.Equal(t, 0, c.add(linesToLogs("a", "first a"), start).LogRecordCount())
+	assert.Equal(t, 0, c.add(linesToLogs("b", "first b"), start.Add(time.Millisecond)).LogRecordCount())
+	// Stream a is seen again, so b is now the one idle the longest.
+	assert.Equal(t, 0, c.add(linesToLogs("a", "\tat com.example.Main.run(Main.java:9)"), start.Add(2*time.Millisecond)).LogRecordCount())
+
+	got := c.add(linesToLogs("c", "first c"), start.Add(3*time.Millisecond))
+	assert.Equal(t, []string{"first b"}, logBodies(got))
+	assert.Len(t, c.pending, 2)
+	assert.Equal(t, []string{
+		"first a\n\tat com.example.Main.run(Main.java:9)",
+		"first c",
+	}, logBodies(c.flushAll()))
+	assert.Empty(t, c.pending)
+	assert.Equal(t, 0, c.idle.Len())
+}
+
+func TestMultilineConfigValidate(t *testing.T) {
+	mc := &MultilineConfig{}
+	require.NoError(t, mc.Validate())
+	assert.Equal(t, []string{multilineDetectorJava, multilineDetectorPython, multilineDetectorGo}, mc.Detectors)
+	assert.Equal(t, defaultMultilineStreamAttributes, mc.StreamAttributes)
+	assert.Equal(t, defaultMultilineFlushTimeout, mc.FlushTimeout)
+	assert.Equal(t, defaultMultilineMaxLines, mc.MaxLines)
+	assert.Equal(t, defaultMultilineMaxStreams, mc.MaxStreams)
+
+	mc = &MultilineConfig{StartPatterns: []string{`^\[`}}
+	require.NoError(t, mc.Validate())
+	assert.Empty(t, mc.Detectors)
+
+	assert.Error(t, (&MultilineConfig{StartPatterns: []string{`^[`}}).Validate())
+	assert.Error(t, (&MultilineConfig{Detectors: []string{"ruby"}}).Validate())
+	assert.Error(t, (&MultilineConfig{FlushTimeout: -time.Second}).Validate())
+	assert.Error(t, (&MultilineConfig{MaxLines: -1}).Validate())
+	assert.Error(t, (&MultilineConfig{MaxStreams: -1}).Validate())
+}
+
+func TestMultilineLogsProcessor(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.Multiline = &MultilineConfig{FlushTimeout: 50 * time.Millisecond}
+	require.NoError(t, cfg.Validate())
+	sink := new(consumertest.LogsSink)
+	p, err := NewFactory().CreateLogs(context.Background(), processortest.NewNopSettings(), cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+
+	lines := readLines(t, "testdata/multiline/java.txt")
+	require.NoError(t, p.ConsumeLogs(context.Background(), linesToLogs("stderr", lines[:4]...)))
+	require.NoError(t, p.ConsumeLogs(context.Background(), linesToLogs("stderr", lines[4:]...)))
+	// The last record is sent once the stream has been idle.
+	require.Eventually(t, func() bool { return sink.LogRecordCount() == 3 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Shutdown(context.Background()))
+
+	var records []plog.LogRecord
+	for _, ld := range sink.AllLogs() {
+		for i := 0; i < ld.ResourceLogs().Len(); i++ {
+			sl := ld.ResourceLogs().At(i).ScopeLogs().At(0)
+			for k := 0; k < sl.LogRecords().Len(); k++ {
+				records = append(records, sl.LogRecords().At(k))
+			}
+		}
+	}
+	require.Len(t, records, 3)
+	assert.Equal(t, strings.Join(lines[1:8], "\n"), records[1].Body().Str())
+
+	// The stack trace is fingerprinted by its first line.
+	fp, err := newPitbull(createDefaultConfig().(*Config), "logs", processortest.NewNopSettings())
+	require.NoError(t, err)
+	single := linesToLogs("stderr", lines[1])
+	fp.fingerprintLogs(single)
+	want, _ := single.ResourceLogs().At(0).ScopeLogs().At(0).LogRecords().At(0).Attributes().Get(translate.CardinalFieldFingerprint)
+	got, found := records[1].Attributes().Get(translate.CardinalFieldFingerprint)
+	require.True(t, found)
+	assert.Equal(t, want.Int(), got.Int())
+}
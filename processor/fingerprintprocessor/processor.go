@@ -15,13 +15,24 @@
 package fingerprintprocessor
 
 import (
+	"cmp"
+	"context"
+	"time"
+
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/consumer"
 	"go.opentelemetry.io/collector/pdata/pcommon"
 	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+
 	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/spantagger"
+	"github.com/cardinalhq/cardinalhq-otel-collector/processor/fingerprintprocessor/internal/metadata"
 )
 
 type fingerprintProcessor struct {
@@ -34,12 +45,27 @@ type fingerprintProcessor struct {
 
 	// for logs
 	logFingerprinter fingerprinter.Fingerprinter
+	// wordListFingerprinters also use the words of the list they are
+	// keyed by.
+	wordListFingerprinters map[string]fingerprinter.Fingerprinter
+	catalog                *fingerprintCatalog
+	multiline              *multilineCombiner
+	nextLogs               consumer.Logs
+	stopFlush              chan struct{}
+	flushDone              chan struct{}
 
 	// for spans
-	traceFingerprinter  fingerprinter.Fingerprinter
-	estimators          map[uint64]*SlidingEstimatorStat
-	estimatorWindowSize int
-	estimatorInterval   int64
+	urlTokens          []fingerprinter.TokenPattern
+	urlTemplates       []urlTemplate
+	routes             *routeLearner
+	slowSpanStrategy   string
+	slowSpans          slowSpanDetector
+	estimators         *estimatorCache
+	estimatorEvictions *telemetry.DeferrableInt64Counter
+	traceShapes        *spantagger.TraceBuffer[traceShape]
+	traceShapeTimeout  time.Duration
+	traceShapeErrors   *telemetry.DeferrableInt64Counter
+	nextTraces         consumer.Traces
 }
 
 func newPitbull(config *Config, ttype string, set processor.Settings) (*fingerprintProcessor, error) {
@@ -51,20 +77,189 @@ func newPitbull(config *Config, ttype string, set processor.Settings) (*fingerpr
 		logger:            set.Logger,
 	}
 
+	patterns := make([]fingerprinter.TokenPattern, 0, len(config.CustomTokens))
+	for _, ct := range config.CustomTokens {
+		p, err := ct.tokenPattern()
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+
 	switch ttype {
 	case "logs":
-		dog.logFingerprinter = fingerprinter.NewFingerprinter()
+		opts := []fingerprinter.FingerprinterOption{fingerprinter.WithTokenPatterns(patterns...)}
+		if config.LogsConfig.KeyValueFingerprints {
+			opts = append(opts, fingerprinter.WithKeyValues())
+		}
+		dog.logFingerprinter = fingerprinter.NewFingerprinter(opts...)
+		dog.wordListFingerprinters = map[string]fingerprinter.Fingerprinter{}
+		for _, wl := range config.WordLists {
+			words, err := wl.words()
+			if err != nil {
+				return nil, err
+			}
+			dog.wordListFingerprinters[wl.Name] = fingerprinter.NewFingerprinter(
+				append(opts, fingerprinter.WithWords(words...))...,
+			)
+		}
+		if config.Multiline != nil {
+			mc := *config.Multiline
+			mc.FlushTimeout = cmp.Or(mc.FlushTimeout, defaultMultilineFlushTimeout)
+			mc.MaxLines = cmp.Or(mc.MaxLines, defaultMultilineMaxLines)
+			mc.MaxStreams = cmp.Or(mc.MaxStreams, defaultMultilineMaxStreams)
+			combiner, err := newMultilineCombiner(mc)
+			if err != nil {
+				return nil, err
+			}
+			dog.multiline = combiner
+		}
 
 	case "traces":
-		dog.traceFingerprinter = fingerprinter.NewFingerprinter()
-		dog.estimators = make(map[uint64]*SlidingEstimatorStat)
-		dog.estimatorWindowSize = config.TracesConfig.EstimatorWindowSize
-		dog.estimatorInterval = config.TracesConfig.EstimatorInterval
+		tc := config.TracesConfig
+		dog.urlTokens = patterns
+		for _, t := range tc.URLTemplates {
+			ut, err := newURLTemplate(t)
+			if err != nil {
+				return nil, err
+			}
+			dog.urlTemplates = append(dog.urlTemplates, ut)
+		}
+		dog.routes = newRouteLearner(
+			cmp.Or(tc.RouteLearningThreshold, defaultRouteLearningThreshold),
+			cmp.Or(tc.RouteLearningMaxNodes, defaultRouteLearningMaxNodes),
+		)
+		tc.EstimatorMaxEntries = cmp.Or(tc.EstimatorMaxEntries, defaultEstimatorMaxEntries)
+		tc.EstimatorTTL = cmp.Or(tc.EstimatorTTL, defaultEstimatorTTL)
+		tc.SlowSpan.Strategy = cmp.Or(tc.SlowSpan.Strategy, slowSpanStrategyStdDev)
+		tc.SlowSpan.StdDevMultiplier = cmp.Or(tc.SlowSpan.StdDevMultiplier, defaultStdDevMultiplier)
+		tc.SlowSpan.Percentile = cmp.Or(tc.SlowSpan.Percentile, defaultSlowPercentile)
+		dog.slowSpanStrategy = tc.SlowSpan.Strategy
+		dog.slowSpans, dog.estimators = newSlowSpanDetector(tc, dog.recordEstimatorEvictions)
+		if tc.TraceShape != nil {
+			dog.traceShapeTimeout = cmp.Or(tc.TraceShape.Timeout, defaultTraceShapeTimeout)
+			dog.traceShapes = spantagger.NewTraceBuffer[traceShape](
+				cmp.Or(tc.TraceShape.MaxTraces, defaultTraceShapeMaxTraces), dog.traceShapeTimeout)
+		}
+		attrset := attribute.NewSet(
+			attribute.String("processor", set.ID.String()),
+			attribute.String("signal", ttype),
+		)
+		if err := dog.setupTraceTelemetry(set, attrset); err != nil {
+			return nil, err
+		}
 	}
 
 	return dog, nil
 }
 
+func (e *fingerprintProcessor) setupTraceTelemetry(set processor.Settings, attrset attribute.Set) error {
+	evictions, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
+		"fingerprint_span_estimator_evictions",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of slow-span estimators evicted, because they were unused for the TTL or the cache was full"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	e.estimatorEvictions = evictions
+
+	if e.estimators != nil {
+		_, err = metadata.Meter(set.TelemetrySettings).Int64ObservableGauge(
+			"fingerprint_span_estimators_active",
+			metric.WithDescription("The number of slow-span estimators held"),
+			metric.WithUnit("1"),
+			metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+				o.Observe(int64(e.estimators.len()), metric.WithAttributeSet(attrset))
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.traceShapes == nil {
+		return nil
+	}
+	shapeErrors, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
+		"fingerprint_trace_shape_errors",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of traces whose shape could not be fingerprinted, by reason"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	e.traceShapeErrors = shapeErrors
+	_, err = metadata.Meter(set.TelemetrySettings).Int64ObservableGauge(
+		"fingerprint_traces_buffered",
+		metric.WithDescription("The number of traces whose spans are held waiting for the root span"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(e.traceShapes.Len()), metric.WithAttributeSet(attrset))
+			return nil
+		}),
+	)
+	return err
+}
+
+func (e *fingerprintProcessor) recordEstimatorEvictions(reason string, count int) {
+	telemetry.CounterAdd(e.estimatorEvictions, int64(count), metric.WithAttributes(
+		attribute.String("reason", reason)))
+}
+
+func (e *fingerprintProcessor) Start(ctx context.Context, host component.Host) error {
+	if e.ttype == "logs" && e.config.Catalog != nil {
+		catalog, err := acquireCatalog(ctx, host, e.telemetrySettings, e.config.Catalog)
+		if err != nil {
+			return err
+		}
+		e.catalog = catalog
+	}
+	if e.multiline != nil {
+		e.stopFlush = make(chan struct{})
+		e.flushDone = make(chan struct{})
+		go e.multilineFlushTask()
+	}
+	if e.traceShapes != nil {
+		e.stopFlush = make(chan struct{})
+		e.flushDone = make(chan struct{})
+		go e.traceShapeFlushTask(e.traceShapeTimeout)
+	}
+	return nil
+}
+
+// Shutdown sends on any log records still waiting for more lines, and any
+// spans still waiting for their trace's root span.
+func (e *fingerprintProcessor) Shutdown(ctx context.Context) error {
+	var errs error
+	if e.stopFlush != nil {
+		close(e.stopFlush)
+		<-e.flushDone
+		e.stopFlush = nil
+	}
+	if e.multiline != nil {
+		errs = multierr.Append(errs, e.sendCombinedLogs(ctx, e.multiline.flushAll()))
+	}
+	if e.traceShapes != nil {
+		errs = multierr.Append(errs, e.sendShapedTraces(ctx, e.shapeTraces(e.traceShapes.Flush())))
+	}
+	if e.catalog != nil {
+		errs = multierr.Append(errs, e.catalog.release(ctx))
+	}
+	return errs
+}
+
 func (e *fingerprintProcessor) Capabilities() consumer.Capabilities {
 	return consumer.Capabilities{MutatesData: true}
 }
@@ -84,7 +84,12 @@ func (o *OnlineWindowStat) getMean() float64 {
 }
 
 func (o *OnlineWindowStat) GreaterThanThreeStdDev(t float64) bool {
-	return o.getMean()+3*o.stdDev() <= t
+	return o.Threshold(3) <= t
+}
+
+// Threshold returns the value multiplier standard deviations above the mean.
+func (o *OnlineWindowStat) Threshold(multiplier float64) float64 {
+	return o.getMean() + multiplier*o.stdDev()
 }
 
 func round(d float64) float64 {
@@ -146,3 +151,7 @@ func (s *SlidingEstimatorStat) Update(t int64, x float64) {
 func (s *SlidingEstimatorStat) GreaterThanThreeStdDev(t float64) bool {
 	return s.OnlineWindowStat.GreaterThanThreeStdDev(t)
 }
+
+func (s *SlidingEstimatorStat) Threshold(multiplier float64) float64 {
+	return s.OnlineWindowStat.Threshold(multiplier)
+}
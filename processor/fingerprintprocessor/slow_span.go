@@ -0,0 +1,190 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"time"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// Slow-span strategies, which decide the duration above which a span is
+// slow.
+const (
+	slowSpanStrategyStdDev     = "stddev"
+	slowSpanStrategyPercentile = "percentile"
+	slowSpanStrategyStatic     = "static"
+)
+
+// Attributes recording how a span was judged slow, next to
+// translate.CardinalFieldSpanIsSlow.
+const (
+	cardinalFieldSpanSlowStrategy  = translate.CardinalFieldPrefixDot + "slow_strategy"
+	cardinalFieldSpanSlowThreshold = translate.CardinalFieldPrefixDot + "slow_threshold_ms"
+)
+
+const (
+	// sketchRelativeAccuracy is the relative error of the percentile
+	// thresholds.
+	sketchRelativeAccuracy = 0.01
+	// sketchMaxBins bounds the memory used by each fingerprint's sketch.
+	// The lowest bins are collapsed first, which does not affect the high
+	// percentiles used as thresholds.
+	sketchMaxBins = 512
+)
+
+// slowSpanDetector decides the duration, in milliseconds, at or above
+// which a span is slow.
+type slowSpanDetector interface {
+	// threshold adds the span's duration to what is known about its
+	// fingerprint, and returns the threshold to compare it with.  It
+	// returns false if there is no threshold yet.
+	threshold(fingerprint uint64, serviceName string, now time.Time, duration float64) (float64, bool)
+}
+
+// newSlowSpanDetector returns the detector for the configured strategy.
+// Strategies that learn from the spans seen also return the cache of
+// per-fingerprint estimators they use.
+func newSlowSpanDetector(tc TracesConfig, onEvict func(reason string, count int)) (slowSpanDetector, *estimatorCache) {
+	config := tc.SlowSpan
+	if config.Strategy == slowSpanStrategyStatic {
+		return newStaticDetector(config), nil
+	}
+
+	var newEstimator func() spanEstimator
+	switch config.Strategy {
+	case slowSpanStrategyPercentile:
+		newEstimator = func() spanEstimator {
+			return newPercentileEstimator(config.Percentile)
+		}
+	default:
+		newEstimator = func() spanEstimator {
+			return newStdDevEstimator(tc.EstimatorWindowSize, tc.EstimatorInterval, config.StdDevMultiplier)
+		}
+	}
+	if config.MinSamples > 0 {
+		inner := newEstimator
+		newEstimator = func() spanEstimator {
+			return &warmupEstimator{spanEstimator: inner(), minSamples: config.MinSamples}
+		}
+	}
+	cache := newEstimatorCache(tc.EstimatorMaxEntries, tc.EstimatorTTL, newEstimator, onEvict)
+	return &adaptiveDetector{cache: cache}, cache
+}
+
+// adaptiveDetector learns a threshold for each span fingerprint.
+type adaptiveDetector struct {
+	cache *estimatorCache
+}
+
+func (d *adaptiveDetector) threshold(fingerprint uint64, _ string, now time.Time, duration float64) (float64, bool) {
+	return d.cache.update(fingerprint, now, duration)
+}
+
+// staticDetector uses a fixed threshold for each service.
+type staticDetector struct {
+	thresholds       map[string]float64
+	defaultThreshold float64
+}
+
+func newStaticDetector(config SlowSpanConfig) *staticDetector {
+	d := &staticDetector{
+		thresholds:       make(map[string]float64, len(config.Thresholds)),
+		defaultThreshold: durationMs(config.DefaultThreshold),
+	}
+	for service, threshold := range config.Thresholds {
+		d.thresholds[service] = durationMs(threshold)
+	}
+	return d
+}
+
+func (d *staticDetector) threshold(_ uint64, serviceName string, _ time.Time, _ float64) (float64, bool) {
+	if threshold, found := d.thresholds[serviceName]; found {
+		return threshold, true
+	}
+	return d.defaultThreshold, d.defaultThreshold > 0
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// spanEstimator learns the threshold for one span fingerprint.  The
+// estimator cache serializes calls to it.
+type spanEstimator interface {
+	// update adds a span duration, in milliseconds, and returns the
+	// threshold, or false if there is none yet.
+	update(now time.Time, duration float64) (float64, bool)
+}
+
+// stdDevEstimator puts the threshold a multiple of the standard deviation
+// above the mean of the recent interval averages.
+type stdDevEstimator struct {
+	stat       *SlidingEstimatorStat
+	multiplier float64
+}
+
+func newStdDevEstimator(windowSize int, windowInterval int64, multiplier float64) *stdDevEstimator {
+	return &stdDevEstimator{
+		stat:       NewSlidingEstimatorStat(windowSize, windowInterval),
+		multiplier: multiplier,
+	}
+}
+
+func (e *stdDevEstimator) update(now time.Time, duration float64) (float64, bool) {
+	e.stat.Update(now.UnixMilli(), duration)
+	return e.stat.Threshold(e.multiplier), true
+}
+
+// percentileEstimator puts the threshold at a percentile of the durations
+// seen, using a streaming sketch.
+type percentileEstimator struct {
+	sketch     *ddsketch.DDSketch
+	percentile float64
+}
+
+func newPercentileEstimator(percentile float64) *percentileEstimator {
+	// This only fails if the accuracy or bin count are out of range.
+	sketch, _ := ddsketch.LogCollapsingLowestDenseDDSketch(sketchRelativeAccuracy, sketchMaxBins)
+	return &percentileEstimator{sketch: sketch, percentile: percentile}
+}
+
+func (e *percentileEstimator) update(_ time.Time, duration float64) (float64, bool) {
+	// The threshold comes from the spans before this one, so a single
+	// outlier is not compared with itself.
+	threshold, err := e.sketch.GetValueAtQuantile(e.percentile)
+	found := err == nil
+	_ = e.sketch.Add(duration)
+	return threshold, found
+}
+
+// warmupEstimator withholds the threshold until minSamples spans have been
+// seen, so a fingerprint is not judged on a handful of spans.
+type warmupEstimator struct {
+	spanEstimator
+	minSamples int
+	samples    int
+}
+
+func (e *warmupEstimator) update(now time.Time, duration float64) (float64, bool) {
+	threshold, found := e.spanEstimator.update(now, duration)
+	if e.samples < e.minSamples {
+		e.samples++
+		return 0, false
+	}
+	return threshold, found
+}
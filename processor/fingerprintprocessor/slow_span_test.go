@@ -0,0 +1,159 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func TestStdDevEstimatorMultiplier(t *testing.T) {
+	start := time.UnixMilli(1717245000000)
+	narrow := newStdDevEstimator(10, 1000, 1)
+	wide := newStdDevEstimator(10, 1000, 5)
+	// Spans take 10ms and 30ms in alternate intervals, giving interval
+	// averages with a mean of 20 and a standard deviation of about 10.5.
+	for i := range 80 {
+		now := start.Add(time.Duration(i) * 250 * time.Millisecond)
+		d := float64(10 + 20*(i/4%2))
+		narrow.update(now, d)
+		wide.update(now, d)
+	}
+
+	threshold, found := narrow.update(start.Add(20*time.Second), 20)
+	require.True(t, found)
+	assert.InDelta(t, 30.5, threshold, 1)
+	threshold, found = wide.update(start.Add(20*time.Second), 20)
+	require.True(t, found)
+	assert.InDelta(t, 72.7, threshold, 1)
+}
+
+func TestPercentileEstimator(t *testing.T) {
+	e := newPercentileEstimator(0.9)
+	now := time.UnixMilli(1717245000000)
+
+	// Nothing to compare the first span with.
+	_, found := e.update(now, 1)
+	assert.False(t, found)
+
+	for i := 2; i <= 100; i++ {
+		e.update(now, float64(i))
+	}
+	threshold, found := e.update(now, 1000)
+	require.True(t, found)
+	assert.InEpsilon(t, 90, threshold, 0.02)
+
+	// The outlier barely moves a high percentile.
+	threshold, _ = e.update(now, 50)
+	assert.InEpsilon(t, 90, threshold, 0.02)
+}
+
+func TestWarmupEstimator(t *testing.T) {
+	e := &warmupEstimator{spanEstimator: newPercentileEstimator(0.5), minSamples: 3}
+	now := time.UnixMilli(1717245000000)
+	for range 3 {
+		_, found := e.update(now, 10)
+		assert.False(t, found)
+	}
+	threshold, found := e.update(now, 10)
+	require.True(t, found)
+	assert.InEpsilon(t, 10, threshold, 0.02)
+}
+
+func TestStaticDetector(t *testing.T) {
+	d := newStaticDetector(SlowSpanConfig{
+		Thresholds: map[string]time.Duration{"checkout": 250 * time.Millisecond},
+	})
+	threshold, found := d.threshold(1, "checkout", time.Now(), 10)
+	assert.True(t, found)
+	assert.Equal(t, 250.0, threshold)
+	_, found = d.threshold(1, "cart", time.Now(), 10)
+	assert.False(t, found)
+
+	d = newStaticDetector(SlowSpanConfig{DefaultThreshold: time.Second})
+	threshold, found = d.threshold(1, "cart", time.Now(), 10)
+	assert.True(t, found)
+	assert.Equal(t, 1000.0, threshold)
+}
+
+func TestSlowSpanAttributes(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesConfig.SlowSpan = SlowSpanConfig{
+		Strategy:   slowSpanStrategyStatic,
+		Thresholds: map[string]time.Duration{"checkout": 100 * time.Millisecond},
+	}
+	require.NoError(t, cfg.Validate())
+	p, err := newPitbull(cfg, "traces", processortest.NewNopSettings())
+	require.NoError(t, err)
+	assert.Nil(t, p.estimators)
+
+	td := ptrace.NewTraces()
+	start := time.Now()
+	for _, tc := range []struct {
+		service  string
+		duration time.Duration
+	}{
+		{"checkout", 150 * time.Millisecond},
+		{"checkout", 50 * time.Millisecond},
+		{"cart", time.Hour},
+	} {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", tc.service)
+		span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetStartTimestamp(pcommon.NewTimestampFromTime(start))
+		span.SetEndTimestamp(pcommon.NewTimestampFromTime(start.Add(tc.duration)))
+	}
+	_, err = p.ConsumeTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	for i, want := range []struct {
+		slow      bool
+		threshold any
+	}{
+		{true, 100.0},
+		{false, 100.0},
+		{false, nil},
+	} {
+		attrs := td.ResourceSpans().At(i).ScopeSpans().At(0).Spans().At(0).Attributes().AsRaw()
+		assert.Equal(t, want.slow, attrs[translate.CardinalFieldSpanIsSlow], i)
+		assert.Equal(t, slowSpanStrategyStatic, attrs[cardinalFieldSpanSlowStrategy], i)
+		assert.Equal(t, want.threshold, attrs[cardinalFieldSpanSlowThreshold], i)
+	}
+}
+
+func TestSlowSpanConfigValidate(t *testing.T) {
+	sc := &SlowSpanConfig{}
+	require.NoError(t, sc.Validate())
+	assert.Equal(t, slowSpanStrategyStdDev, sc.Strategy)
+	assert.Equal(t, float64(defaultStdDevMultiplier), sc.StdDevMultiplier)
+	assert.Equal(t, defaultSlowPercentile, sc.Percentile)
+
+	assert.Error(t, (&SlowSpanConfig{Strategy: "median"}).Validate())
+	assert.Error(t, (&SlowSpanConfig{StdDevMultiplier: -1}).Validate())
+	assert.Error(t, (&SlowSpanConfig{Percentile: 1.5}).Validate())
+	assert.Error(t, (&SlowSpanConfig{MinSamples: -1}).Validate())
+	assert.Error(t, (&SlowSpanConfig{Strategy: slowSpanStrategyStatic}).Validate())
+	assert.Error(t, (&SlowSpanConfig{Strategy: slowSpanStrategyStatic, Thresholds: map[string]time.Duration{"cart": 0}}).Validate())
+	assert.NoError(t, (&SlowSpanConfig{Strategy: slowSpanStrategyStatic, DefaultThreshold: time.Second}).Validate())
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// Reference: https://opentelemetry.io/docs/specs/semconv/database/database-spans/
+const (
+	dbQueryText       = "db.query.text"
+	dbStatement       = "db.statement"
+	dbOperationName   = "db.operation.name"
+	dbOperation       = "db.operation"
+	dbCollectionName  = "db.collection.name"
+	dbSQLTable        = "db.sql.table"
+	dbSystem          = "db.system"
+	dbSystemName      = "db.system.name"
+	dbNamespace       = "db.namespace"
+	dbName            = "db.name"
+	messagingDestName = "messaging.destination.name"
+	messagingDestTmpl = "messaging.destination.template"
+	messagingDestTemp = "messaging.destination.temporary"
+	messagingDestAnon = "messaging.destination.anonymous"
+	messagingOpType   = "messaging.operation.type"
+	messagingOp       = "messaging.operation"
+	rpcService        = "rpc.service"
+	rpcMethod         = "rpc.method"
+)
+
+// cardinalFieldDBTables lists the tables a SQL statement uses.
+var cardinalFieldDBTables = translate.CardinalFieldPrefixDot + "db_tables"
+
+var (
+	destinationSeparators = regexp.MustCompile(`[._:/-]`)
+	destinationUUID       = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+)
+
+// getSpanResource returns the resource a span acts on, such as the route
+// of an HTTP request or the statement of a database query, with the
+// values that differ between calls removed.
+func (c *fingerprintProcessor) getSpanResource(span ptrace.Span, serviceName string) string {
+	if resource := c.getHttpResource(span, serviceName); resource != "" {
+		return resource
+	}
+	if resource := getRPCResource(span.Attributes()); resource != "" {
+		return resource
+	}
+	if resource := getDBResource(span.Attributes()); resource != "" {
+		return resource
+	}
+	return getMessagingResource(span.Attributes())
+}
+
+// getRPCResource returns the service and method of a gRPC or other RPC
+// call, as in "checkout.CheckoutService/PlaceOrder".
+func getRPCResource(attrs pcommon.Map) string {
+	service, found := attrs.Get(rpcService)
+	if !found || service.Str() == "" {
+		return ""
+	}
+	if method, found := attrs.Get(rpcMethod); found && method.Str() != "" {
+		return service.Str() + "/" + method.Str()
+	}
+	return service.Str()
+}
+
+// getDBResource returns the obfuscated statement of a SQL query, or the
+// operation and collection of other database calls.  The tables a SQL
+// statement uses are recorded on the span.
+func getDBResource(attrs pcommon.Map) string {
+	statement := firstStr(attrs, dbQueryText, dbStatement)
+	if statement != "" && isSQL(statement) {
+		if tables := sqlTables(statement); len(tables) > 0 {
+			attrs.PutStr(cardinalFieldDBTables, strings.Join(tables, ","))
+		}
+		return obfuscateSQL(statement)
+	}
+
+	if firstStr(attrs, dbSystem, dbSystemName) == "" && statement == "" {
+		return ""
+	}
+	operation := firstStr(attrs, dbOperationName, dbOperation)
+	if operation == "" && statement != "" {
+		// Commands such as Redis ones start with the operation, and are
+		// followed by keys and values.
+		operation = sqlOperation(statement)
+	}
+	collection := firstStr(attrs, dbCollectionName, dbSQLTable)
+	if collection == "" {
+		collection = firstStr(attrs, dbNamespace, dbName)
+	}
+	return strings.TrimSpace(operation + " " + collection)
+}
+
+// getMessagingResource returns the operation and destination of a
+// messaging span, with IDs in the destination name replaced.
+func getMessagingResource(attrs pcommon.Map) string {
+	destination := firstStr(attrs, messagingDestTmpl)
+	if destination == "" {
+		if temporary, found := attrs.Get(messagingDestTemp); found && temporary.Bool() {
+			destination = "(temporary)"
+		} else if anonymous, found := attrs.Get(messagingDestAnon); found && anonymous.Bool() {
+			destination = "(anonymous)"
+		} else if name := firstStr(attrs, messagingDestName); name != "" {
+			destination = templateDestination(name)
+		}
+	}
+	if destination == "" {
+		return ""
+	}
+	if operation := firstStr(attrs, messagingOpType, messagingOp); operation != "" {
+		return operation + " " + destination
+	}
+	return destination
+}
+
+// templateDestination replaces the parts of a queue or topic name that
+// look like IDs, as in "orders.<Number>.events".
+func templateDestination(name string) string {
+	name = destinationUUID.ReplaceAllString(name, "<UUID>")
+	var b strings.Builder
+	start := 0
+	for _, loc := range destinationSeparators.FindAllStringIndex(name, -1) {
+		b.WriteString(templateDestinationPart(name[start:loc[0]]))
+		b.WriteString(name[loc[0]:loc[1]])
+		start = loc[1]
+	}
+	b.WriteString(templateDestinationPart(name[start:]))
+	return b.String()
+}
+
+func templateDestinationPart(part string) string {
+	if templated := templateSegment(part); templated != part {
+		return templated
+	}
+	if len(part) >= 16 && strings.ContainsAny(part, "0123456789") {
+		// Long random names, such as generated reply queues.
+		return "<ID>"
+	}
+	return part
+}
+
+// firstStr returns the value of the first of keys that is set.
+func firstStr(attrs pcommon.Map, keys ...string) string {
+	for _, k := range keys {
+		if v, found := attrs.Get(k); found && v.AsString() != "" {
+			return v.AsString()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,144 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+func TestGetSpanResource(t *testing.T) {
+	tests := []struct {
+		name       string
+		attributes map[string]any
+		want       string
+		wantTables any
+	}{
+		{
+			name:       "sql statement",
+			attributes: map[string]any{dbSystem: "postgresql", dbQueryText: "SELECT * FROM orders WHERE id = 42"},
+			want:       "SELECT * FROM orders WHERE id = ?",
+			wantTables: "orders",
+		},
+		{
+			name:       "old sql attribute",
+			attributes: map[string]any{dbStatement: "UPDATE carts c SET total = 0 FROM items i WHERE c.id = 'x'"},
+			want:       "UPDATE carts c SET total = ? FROM items i WHERE c.id = ?",
+			wantTables: "carts,items",
+		},
+		{
+			name:       "redis command",
+			attributes: map[string]any{dbSystem: "redis", dbStatement: "HGETALL session:1234"},
+			want:       "HGETALL",
+		},
+		{
+			name:       "document database",
+			attributes: map[string]any{dbSystem: "mongodb", dbOperationName: "find", dbCollectionName: "orders"},
+			want:       "find orders",
+		},
+		{
+			name:       "grpc",
+			attributes: map[string]any{"rpc.system": "grpc", rpcService: "checkout.CheckoutService", rpcMethod: "PlaceOrder"},
+			want:       "checkout.CheckoutService/PlaceOrder",
+		},
+		{
+			name:       "grpc service only",
+			attributes: map[string]any{rpcService: "checkout.CheckoutService"},
+			want:       "checkout.CheckoutService",
+		},
+		{
+			name:       "messaging destination",
+			attributes: map[string]any{messagingOpType: "publish", messagingDestName: "orders.12345.events"},
+			want:       "publish orders.<Number>.events",
+		},
+		{
+			name:       "messaging destination with ids",
+			attributes: map[string]any{messagingOp: "receive", messagingDestName: "reply-550e8400-e29b-41d4-a716-446655440000/5f3a9c2b1d"},
+			want:       "receive reply-<UUID>/<Hex>",
+		},
+		{
+			name:       "messaging destination template",
+			attributes: map[string]any{messagingOpType: "process", messagingDestName: "orders.12345", messagingDestTmpl: "orders.{id}"},
+			want:       "process orders.{id}",
+		},
+		{
+			name:       "temporary destination",
+			attributes: map[string]any{messagingOpType: "receive", messagingDestName: "amq.gen-JzTY20BRgKO-HjmUJj0wLg", messagingDestTemp: true},
+			want:       "receive (temporary)",
+		},
+		{
+			name:       "http comes first",
+			attributes: map[string]any{httpMethod: "GET", httpRoute: "/orders/{id}", dbStatement: "SELECT 1"},
+			want:       "GET /orders/{id}",
+		},
+		{
+			name:       "nothing known",
+			attributes: map[string]any{"custom": "value"},
+			want:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span := ptrace.NewSpan()
+			require.NoError(t, span.Attributes().FromRaw(tt.attributes))
+			p := &fingerprintProcessor{}
+			assert.Equal(t, tt.want, p.getSpanResource(span, "service"))
+			assert.Equal(t, tt.wantTables, span.Attributes().AsRaw()[cardinalFieldDBTables])
+		})
+	}
+}
+
+func TestSpanResourceFingerprints(t *testing.T) {
+	p, err := newPitbull(createDefaultConfig().(*Config), "traces", processortest.NewNopSettings())
+	require.NoError(t, err)
+
+	td := ptrace.NewTraces()
+	spans := td.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty().Spans()
+	for _, attrs := range []map[string]any{
+		{dbSystem: "mysql", dbStatement: "SELECT * FROM orders WHERE id = 1"},
+		{dbSystem: "mysql", dbStatement: "SELECT * FROM orders WHERE id = 2"},
+		{dbSystem: "mysql", dbStatement: "SELECT * FROM carts WHERE id = 2"},
+		{messagingOpType: "publish", messagingDestName: "orders.1"},
+		{messagingOpType: "publish", messagingDestName: "orders.2"},
+		{rpcService: "checkout.CheckoutService", rpcMethod: "PlaceOrder"},
+		{rpcService: "checkout.CheckoutService", rpcMethod: "GetCart"},
+	} {
+		span := spans.AppendEmpty()
+		span.SetName("span")
+		require.NoError(t, span.Attributes().FromRaw(attrs))
+	}
+	_, err = p.ConsumeTraces(context.Background(), td)
+	require.NoError(t, err)
+
+	fingerprint := func(i int) int64 {
+		v, found := spans.At(i).Attributes().Get(translate.CardinalFieldFingerprint)
+		require.True(t, found)
+		return v.Int()
+	}
+	assert.Equal(t, fingerprint(0), fingerprint(1))
+	assert.NotEqual(t, fingerprint(1), fingerprint(2))
+	assert.Equal(t, fingerprint(3), fingerprint(4))
+	assert.NotEqual(t, fingerprint(5), fingerprint(6))
+
+	resource, _ := spans.At(0).Attributes().Get(translate.CardinalFieldResourceName)
+	assert.Equal(t, "SELECT * FROM orders WHERE id = ?", resource.Str())
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// sqlStatementKeywords start the statements treated as SQL.
+var sqlStatementKeywords = []string{
+	"SELECT", "INSERT", "UPDATE", "DELETE", "UPSERT", "MERGE", "REPLACE", "WITH",
+	"CREATE", "ALTER", "DROP", "TRUNCATE", "CALL", "EXEC", "EXECUTE",
+	"BEGIN", "COMMIT", "ROLLBACK", "SET", "SHOW", "EXPLAIN",
+}
+
+// sqlTableKeywords are followed by a table name.
+var sqlTableKeywords = []string{"FROM", "JOIN", "UPDATE", "INTO", "TABLE"}
+
+var (
+	// A list of placeholders, as left by obfuscation of IN (1, 2, 3).
+	sqlPlaceholderList = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+	// Repeated rows, as left by obfuscation of VALUES (1, 'a'), (2, 'b').
+	sqlPlaceholderRows = regexp.MustCompile(`\(\?\)(?:\s*,\s*\(\?\))+`)
+)
+
+// isSQL returns true if statement starts with a SQL keyword.
+func isSQL(statement string) bool {
+	return slices.Contains(sqlStatementKeywords, sqlOperation(statement))
+}
+
+// sqlOperation returns the first word of the statement, in upper case.
+func sqlOperation(statement string) string {
+	word, _, _ := strings.Cut(strings.TrimSpace(statement), " ")
+	return strings.ToUpper(strings.TrimRight(word, "(;"))
+}
+
+// obfuscateSQL replaces the literals in a SQL statement with ?, removes
+// comments, and collapses lists of literals and runs of whitespace, so
+// statements differing only in their values are the same.
+func obfuscateSQL(statement string) string {
+	var b strings.Builder
+	b.Grow(len(statement))
+	space := false
+	writeSpace := func() {
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+	}
+
+	for i := 0; i < len(statement); {
+		c := statement[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			i++
+		case c == '-' && strings.HasPrefix(statement[i:], "--"):
+			end := strings.IndexByte(statement[i:], '\n')
+			if end < 0 {
+				end = len(statement) - i
+			}
+			space = true
+			i += end
+		case c == '/' && strings.HasPrefix(statement[i:], "/*"):
+			end := strings.Index(statement[i+2:], "*/")
+			if end < 0 {
+				end = len(statement) - i - 4
+			}
+			space = true
+			i += end + 4
+		case c == '\'':
+			// A quote is escaped by doubling it.
+			i++
+			for i < len(statement) {
+				if statement[i] == '\\' {
+					i += 2
+					continue
+				}
+				if statement[i] == '\'' {
+					if i+1 < len(statement) && statement[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			writeSpace()
+			b.WriteByte('?')
+		case c == '"' || c == '`':
+			// Quoted identifiers are kept.
+			end := strings.IndexByte(statement[i+1:], c)
+			if end < 0 {
+				end = len(statement) - i - 2
+			}
+			writeSpace()
+			b.WriteString(statement[i : i+end+2])
+			i += end + 2
+		case isSQLDigit(c) || (c == '.' || c == '-') && i+1 < len(statement) && isSQLDigit(statement[i+1]) && !sqlFollowsValue(b.String()):
+			// A minus sign belongs to the number only where a value
+			// cannot precede it, as in "= -1" but not "a-1".
+			i++
+			for i < len(statement) && (isSQLIdentChar(statement[i]) || statement[i] == '.') {
+				i++
+			}
+			writeSpace()
+			b.WriteByte('?')
+		case isSQLIdentChar(c) || c == '$' || c == '@' || c == ':':
+			// Identifiers, including any digits in them, and bind
+			// parameters such as $1, @p1 and :name.
+			start := i
+			i++
+			for i < len(statement) && (isSQLIdentChar(statement[i]) || statement[i] == '.') {
+				i++
+			}
+			writeSpace()
+			b.WriteString(statement[start:i])
+		default:
+			writeSpace()
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	s := strings.TrimRight(b.String(), "; ")
+	s = sqlPlaceholderList.ReplaceAllString(s, "(?)")
+	return sqlPlaceholderRows.ReplaceAllString(s, "(?)")
+}
+
+// sqlTables returns the tables a SQL statement names after FROM, JOIN,
+// UPDATE, INTO or TABLE, in the order they first appear.
+func sqlTables(statement string) []string {
+	var tables []string
+	words := strings.Fields(strings.NewReplacer(",", " , ", "(", " ( ", ")", " ) ", ";", " ").Replace(statement))
+	for i := 0; i+1 < len(words); i++ {
+		if !slices.Contains(sqlTableKeywords, strings.ToUpper(words[i])) {
+			continue
+		}
+		table := strings.NewReplacer(`"`, "", "`", "", "[", "", "]", "").Replace(words[i+1])
+		if table == "" || table == "(" || slices.Contains(sqlStatementKeywords, strings.ToUpper(table)) {
+			continue
+		}
+		if !slices.Contains(tables, table) {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+func isSQLDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSQLIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isSQLDigit(c) || c >= 0x80
+}
+
+// sqlFollowsValue returns true if the obfuscated text so far ends with a
+// value, so a following - or . is an operator.
+func sqlFollowsValue(s string) bool {
+	s = strings.TrimRight(s, " ")
+	if s == "" {
+		return false
+	}
+	c := s[len(s)-1]
+	return isSQLIdentChar(c) || c == '?' || c == ')' || c == '"' || c == '`'
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObfuscateSQL(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		want      string
+	}{
+		{
+			"literals",
+			"SELECT * FROM orders WHERE id = 42 AND status = 'paid' AND total > -1.5",
+			"SELECT * FROM orders WHERE id = ? AND status = ? AND total > ?",
+		},
+		{
+			"escaped quotes",
+			`SELECT id FROM users WHERE name = 'O''Brien' OR name = 'a\'b'`,
+			"SELECT id FROM users WHERE name = ? OR name = ?",
+		},
+		{
+			"in lists and rows",
+			"INSERT INTO items (id, name) VALUES (1, 'a'), (2, 'b'), (3, 'c'); ",
+			"INSERT INTO items (id, name) VALUES (?)",
+		},
+		{
+			"in list",
+			"DELETE FROM carts WHERE id IN (1, 2, 3)",
+			"DELETE FROM carts WHERE id IN (?)",
+		},
+		{
+			"comments and whitespace",
+			"SELECT a  -- the a column\n\tFROM t1 /* hint */ WHERE b=0x1F",
+			"SELECT a FROM t1 WHERE b=?",
+		},
+		{
+			"identifiers with digits, bind parameters and casts",
+			`SELECT "col1", t2.c3 FROM t2 WHERE c4 = $1 AND c5 = :name AND c6::text = @p1 AND c7 = c8-1`,
+			`SELECT "col1", t2.c3 FROM t2 WHERE c4 = $1 AND c5 = :name AND c6::text = @p1 AND c7 = c8-?`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, obfuscateSQL(tt.statement))
+		})
+	}
+}
+
+func TestSQLTables(t *testing.T) {
+	assert.Equal(t, []string{"orders", "public.customers"},
+		sqlTables(`SELECT * FROM orders o JOIN "public"."customers" c ON o.cid = c.id WHERE o.id IN (SELECT id FROM orders)`))
+	assert.Equal(t, []string{"items"}, sqlTables("INSERT INTO items (id) VALUES (1)"))
+	assert.Equal(t, []string{"carts"}, sqlTables("UPDATE carts SET total = 0"))
+	assert.Empty(t, sqlTables("SELECT 1"))
+}
+
+func TestIsSQL(t *testing.T) {
+	assert.True(t, isSQL("select * from t"))
+	assert.True(t, isSQL("  WITH x AS (SELECT 1) SELECT * FROM x"))
+	assert.False(t, isSQL("HGETALL session:1234"))
+	assert.False(t, isSQL(`{"find": "orders"}`))
+}
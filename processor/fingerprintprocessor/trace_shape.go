@@ -0,0 +1,133 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/spantagger"
+)
+
+// cardinalFieldTraceFingerprint is the fingerprint of the shape of the
+// trace a span belongs to.
+var cardinalFieldTraceFingerprint = translate.CardinalFieldPrefixDot + "trace_fingerprint"
+
+// traceShape is the result of fingerprinting a whole trace, kept so spans
+// arriving after the trace was released get the same one.
+type traceShape struct {
+	fingerprint int64
+	hasError    bool
+	err         error
+}
+
+// shapeTraces fingerprints the shape of each released trace, stamps it on
+// the trace's spans, and returns them all together.
+func (e *fingerprintProcessor) shapeTraces(released []*spantagger.BufferedTrace) ptrace.Traces {
+	out := ptrace.NewTraces()
+	for _, bt := range released {
+		fingerprint, hasError, err := spantagger.Fingerprint(bt.Traces)
+		shape := traceShape{fingerprint: int64(fingerprint), hasError: hasError, err: err}
+		if err != nil {
+			e.recordTraceShapeError(err)
+		}
+		e.traceShapes.Decide(bt.TraceID, shape)
+		stampTraceShape(bt.Traces, shape)
+		bt.Traces.ResourceSpans().MoveAndAppendTo(out.ResourceSpans())
+	}
+	return out
+}
+
+func stampTraceShape(td ptrace.Traces, shape traceShape) {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			spans := rs.ScopeSpans().At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				attrs := spans.At(k).Attributes()
+				if shape.err != nil {
+					attrs.PutStr(translate.CardinalFieldFingerprintError, shape.err.Error())
+					continue
+				}
+				attrs.PutInt(cardinalFieldTraceFingerprint, shape.fingerprint)
+				attrs.PutBool(translate.CardinalFieldTraceHasError, shape.hasError)
+			}
+		}
+	}
+}
+
+// bufferTraces holds spans until their trace is complete, and returns the
+// spans of traces that are, stamped with the trace's fingerprint.
+func (e *fingerprintProcessor) bufferTraces(td ptrace.Traces, now time.Time) ptrace.Traces {
+	released, late := e.traceShapes.Add(td, now)
+	out := e.shapeTraces(released)
+	for _, ls := range late {
+		stampTraceShape(ls.Traces, ls.Decision)
+		ls.Traces.ResourceSpans().MoveAndAppendTo(out.ResourceSpans())
+	}
+	return out
+}
+
+// traceShapeFlushTask sends on the traces whose root span has not arrived
+// in the timeout.
+func (e *fingerprintProcessor) traceShapeFlushTask(timeout time.Duration) {
+	defer close(e.flushDone)
+	ticker := time.NewTicker(max(timeout/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stopFlush:
+			return
+		case now := <-ticker.C:
+			if err := e.sendShapedTraces(context.Background(), e.shapeTraces(e.traceShapes.Expired(now))); err != nil {
+				e.logger.Warn("Failed to send buffered traces", zap.Error(err))
+			}
+		}
+	}
+}
+
+// sendShapedTraces passes traces released outside of ConsumeTraces to the
+// next consumer.
+func (e *fingerprintProcessor) sendShapedTraces(ctx context.Context, td ptrace.Traces) error {
+	if td.SpanCount() == 0 || e.nextTraces == nil {
+		return nil
+	}
+	return e.nextTraces.ConsumeTraces(ctx, td)
+}
+
+func (e *fingerprintProcessor) recordTraceShapeError(err error) {
+	reason := "unknown"
+	switch {
+	case errors.Is(err, spantagger.OrphanedSpanError):
+		reason = "orphaned_span"
+	case errors.Is(err, spantagger.NoRootError):
+		reason = "no_root"
+	case errors.Is(err, spantagger.MultipleRootsError):
+		reason = "multiple_roots"
+	case errors.Is(err, spantagger.InconsistentTraceIDsError):
+		reason = "inconsistent_trace_ids"
+	}
+	telemetry.CounterAdd(e.traceShapeErrors, 1, metric.WithAttributes(
+		attribute.String("reason", reason)))
+}
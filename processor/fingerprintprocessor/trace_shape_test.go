@@ -0,0 +1,154 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/spantagger"
+)
+
+// shapeSpan is a span of trace, with parent 0 for the root.
+type shapeSpan struct {
+	trace   byte
+	span    byte
+	parent  byte
+	service string
+	name    string
+}
+
+func shapeTraces(spans ...shapeSpan) ptrace.Traces {
+	td := ptrace.NewTraces()
+	for _, s := range spans {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", s.service)
+		span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		span.SetTraceID(pcommon.TraceID([16]byte{s.trace}))
+		span.SetSpanID(pcommon.SpanID([8]byte{s.span}))
+		if s.parent != 0 {
+			span.SetParentSpanID(pcommon.SpanID([8]byte{s.parent}))
+		}
+		span.SetName(s.name)
+	}
+	return td
+}
+
+// spanAttrs returns the attributes of the spans sent to sink, by span ID.
+func spanAttrs(sink *consumertest.TracesSink) map[byte]map[string]any {
+	ret := map[byte]map[string]any{}
+	for _, td := range sink.AllTraces() {
+		for i := 0; i < td.ResourceSpans().Len(); i++ {
+			spans := td.ResourceSpans().At(i).ScopeSpans().At(0).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				id := spans.At(k).SpanID()
+				ret[id[0]] = spans.At(k).Attributes().AsRaw()
+			}
+		}
+	}
+	return ret
+}
+
+func TestTraceShapeProcessor(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesConfig.TraceShape = &TraceShapeConfig{Timeout: time.Hour}
+	require.NoError(t, cfg.Validate())
+	sink := new(consumertest.TracesSink)
+	p, err := NewFactory().CreateTraces(context.Background(), processortest.NewNopSettings(), cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), shapeTraces(
+		shapeSpan{trace: 1, span: 2, parent: 1, service: "cart", name: "GetCart"},
+		shapeSpan{trace: 2, span: 5, parent: 4, service: "cart", name: "GetCart"},
+	)))
+	assert.Equal(t, 0, sink.SpanCount())
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), shapeTraces(
+		shapeSpan{trace: 1, span: 1, service: "frontend", name: "GET /cart"},
+	)))
+	require.Equal(t, 2, sink.SpanCount())
+	attrs := spanAttrs(sink)
+	want, hasError, err := spantagger.Fingerprint(shapeTraces(
+		shapeSpan{trace: 1, span: 1, service: "frontend", name: "GET /cart"},
+		shapeSpan{trace: 1, span: 2, parent: 1, service: "cart", name: "GetCart"},
+	))
+	require.NoError(t, err)
+	assert.Equal(t, int64(want), attrs[1][cardinalFieldTraceFingerprint])
+	assert.Equal(t, int64(want), attrs[2][cardinalFieldTraceFingerprint])
+	assert.Equal(t, hasError, attrs[2][translate.CardinalFieldTraceHasError])
+	// Span fingerprints are still set.
+	assert.Contains(t, attrs[2], translate.CardinalFieldFingerprint)
+
+	// A span arriving after the root gets the trace's fingerprint.
+	require.NoError(t, p.ConsumeTraces(context.Background(), shapeTraces(
+		shapeSpan{trace: 1, span: 3, parent: 2, service: "db", name: "SELECT"},
+	)))
+	assert.Equal(t, int64(want), spanAttrs(sink)[3][cardinalFieldTraceFingerprint])
+
+	// A span whose parent is missing leaves the trace without a shape.
+	require.NoError(t, p.ConsumeTraces(context.Background(), shapeTraces(
+		shapeSpan{trace: 3, span: 6, service: "frontend", name: "GET /cart"},
+		shapeSpan{trace: 3, span: 7, parent: 8, service: "cart", name: "GetCart"},
+	)))
+	attrs = spanAttrs(sink)
+	assert.Equal(t, spantagger.OrphanedSpanError.Error(), attrs[7][translate.CardinalFieldFingerprintError])
+	assert.NotContains(t, attrs[7], cardinalFieldTraceFingerprint)
+
+	// The trace without its root is sent on shutdown, marked with the error.
+	require.NoError(t, p.Shutdown(context.Background()))
+	attrs = spanAttrs(sink)
+	assert.Equal(t, spantagger.NoRootError.Error(), attrs[5][translate.CardinalFieldFingerprintError])
+}
+
+func TestTraceShapeTimeout(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesConfig.TraceShape = &TraceShapeConfig{Timeout: 50 * time.Millisecond}
+	require.NoError(t, cfg.Validate())
+	sink := new(consumertest.TracesSink)
+	p, err := NewFactory().CreateTraces(context.Background(), processortest.NewNopSettings(), cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+
+	require.NoError(t, p.ConsumeTraces(context.Background(), shapeTraces(
+		shapeSpan{trace: 1, span: 1, parent: 9, service: "cart", name: "GetCart"},
+		shapeSpan{trace: 1, span: 2, parent: 9, service: "cart", name: "GetCart"},
+	)))
+	require.Eventually(t, func() bool { return sink.SpanCount() == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, p.Shutdown(context.Background()))
+
+	assert.Equal(t, spantagger.NoRootError.Error(), spanAttrs(sink)[1][translate.CardinalFieldFingerprintError])
+}
+
+func TestTraceShapeConfigValidate(t *testing.T) {
+	sc := &TraceShapeConfig{}
+	require.NoError(t, sc.Validate())
+	assert.Equal(t, defaultTraceShapeTimeout, sc.Timeout)
+	assert.Equal(t, defaultTraceShapeMaxTraces, sc.MaxTraces)
+
+	assert.Error(t, (&TraceShapeConfig{Timeout: -time.Second}).Validate())
+	assert.Error(t, (&TraceShapeConfig{MaxTraces: -1}).Validate())
+}
@@ -21,6 +21,7 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processorhelper"
 
 	"github.com/cardinalhq/oteltools/pkg/translate"
 )
@@ -39,43 +40,39 @@ func (e *fingerprintProcessor) ConsumeTraces(ctx context.Context, td ptrace.Trac
 			iss := rs.ScopeSpans().At(j)
 			for k := 0; k < iss.Spans().Len(); k++ {
 				sr := iss.Spans().At(k)
-				httpResource := e.getHttpResource(sr)
-				if httpResource != "" {
-					sr.Attributes().PutStr(translate.CardinalFieldResourceName, httpResource)
+				resource := e.getSpanResource(sr, serviceName)
+				if resource != "" {
+					sr.Attributes().PutStr(translate.CardinalFieldResourceName, resource)
 				}
-				spanFingerprint := calculateSpanFingerprint(sr, httpResource, serviceName)
-				isSlow := e.isSpanSlow(sr, uint64(spanFingerprint))
-				sr.Attributes().PutBool(translate.CardinalFieldSpanIsSlow, isSlow)
+				spanFingerprint := calculateSpanFingerprint(sr, resource, serviceName)
+				e.markSlowSpan(sr, serviceName, uint64(spanFingerprint))
 				sr.Attributes().PutInt(translate.CardinalFieldFingerprint, spanFingerprint)
 			}
 		}
 	}
 
+	if e.traceShapes != nil {
+		td = e.bufferTraces(td, time.Now())
+		if td.SpanCount() == 0 {
+			return td, processorhelper.ErrSkipProcessingData
+		}
+	}
 	return td, nil
 }
 
-func (c *fingerprintProcessor) isSpanSlow(span ptrace.Span, fingerprint uint64) bool {
-	spanDuration := span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()).Abs().Milliseconds()
-	return c.slowSpanPercentile(fingerprint, float64(spanDuration))
-}
-
-func (c *fingerprintProcessor) slowSpanPercentile(fingerprint uint64, duration float64) bool {
-	sketch := c.findSpanSketch(fingerprint)
-	sketch.Update(time.Now().UnixMilli(), duration)
-	return sketch.GreaterThanThreeStdDev(duration)
-}
-
-func (c *fingerprintProcessor) findSpanSketch(fingerprint uint64) *SlidingEstimatorStat {
-	sketch, ok := c.estimators[fingerprint]
-	if !ok {
-		estimator := NewSlidingEstimatorStat(c.estimatorWindowSize, c.estimatorInterval)
-		c.estimators[fingerprint] = estimator
-		return estimator
+// markSlowSpan records whether the span is slow, along with the strategy
+// and threshold used to decide.
+func (c *fingerprintProcessor) markSlowSpan(span ptrace.Span, serviceName string, fingerprint uint64) {
+	spanDuration := durationMs(span.EndTimestamp().AsTime().Sub(span.StartTimestamp().AsTime()).Abs())
+	threshold, found := c.slowSpans.threshold(fingerprint, serviceName, time.Now(), spanDuration)
+	span.Attributes().PutBool(translate.CardinalFieldSpanIsSlow, found && spanDuration >= threshold)
+	span.Attributes().PutStr(cardinalFieldSpanSlowStrategy, c.slowSpanStrategy)
+	if found {
+		span.Attributes().PutDouble(cardinalFieldSpanSlowThreshold, threshold)
 	}
-	return sketch
 }
 
-func (c *fingerprintProcessor) getHttpResource(span ptrace.Span) string {
+func (c *fingerprintProcessor) getHttpResource(span ptrace.Span, serviceName string) string {
 	attrs := span.Attributes()
 	var resourceKeys []string
 
@@ -88,10 +85,7 @@ func (c *fingerprintProcessor) getHttpResource(span ptrace.Span) string {
 		resourceKeys = append(resourceKeys, route.Str())
 	} else {
 		if urlPath, exists := attrs.Get(httpUrlPath); exists {
-			urlPathStr, _, err := c.traceFingerprinter.TokenizeInput(urlPath.Str())
-			if err == nil {
-				resourceKeys = append(resourceKeys, urlPathStr)
-			}
+			resourceKeys = append(resourceKeys, c.templateURLPath(urlPath.Str(), serviceName))
 		}
 	}
 
@@ -101,7 +95,23 @@ func (c *fingerprintProcessor) getHttpResource(span ptrace.Span) string {
 	return ""
 }
 
-func calculateSpanFingerprint(sr ptrace.Span, httpResource string, serviceName string) int64 {
+// templateURLPath returns the route a URL path was made from: the first
+// user-provided template it matches or, failing that, the path with its
+// IDs and the segments learned to vary replaced with placeholders.
+func (c *fingerprintProcessor) templateURLPath(path string, serviceName string) string {
+	for _, t := range c.urlTemplates {
+		if t.matches(path) {
+			return t.template
+		}
+	}
+	segments := templateURLPath(path, c.urlTokens)
+	if c.routes != nil {
+		segments = c.routes.learn(serviceName, segments)
+	}
+	return strings.Join(segments, "/")
+}
+
+func calculateSpanFingerprint(sr ptrace.Span, resource string, serviceName string) int64 {
 	attrs := sr.Attributes()
 	var fingerprintAttributes []string
 
@@ -110,7 +120,7 @@ func calculateSpanFingerprint(sr ptrace.Span, httpResource string, serviceName s
 		fingerprintAttributes = append(fingerprintAttributes, spanNameAttr.Str())
 	}
 	fingerprintAttributes = append(fingerprintAttributes, sr.Kind().String())
-	fingerprintAttributes = append(fingerprintAttributes, httpResource)
+	fingerprintAttributes = append(fingerprintAttributes, resource)
 
 	return int64(xxhash.Sum64String(strings.Join(fingerprintAttributes, "##")))
 }
@@ -41,7 +41,7 @@ func TestGetHttpResource(t *testing.T) {
 				httpMethod:  "POST",
 				httpUrlPath: "/api/v1/resource/123",
 			},
-			expectedResult: "POST /api/v1/resource/123",
+			expectedResult: "POST /api/v1/resource/<Number>",
 		},
 		{
 			name: "Only HTTP method",
@@ -62,7 +62,7 @@ func TestGetHttpResource(t *testing.T) {
 			attributes: map[string]interface{}{
 				httpUrlPath: "/api/v1/resource/123",
 			},
-			expectedResult: "/api/v1/resource/123",
+			expectedResult: "/api/v1/resource/<Number>",
 		},
 		{
 			name:           "No attributes",
@@ -82,29 +82,9 @@ func TestGetHttpResource(t *testing.T) {
 				}
 			}
 
-			p := &fingerprintProcessor{
-				traceFingerprinter: &mockTraceFingerprinter{},
-			}
-			result := p.getHttpResource(span)
+			p := &fingerprintProcessor{}
+			result := p.getHttpResource(span, "service")
 			assert.Equal(t, tt.expectedResult, result)
 		})
 	}
 }
-
-type mockTraceFingerprinter struct{}
-
-func (m *mockTraceFingerprinter) TokenizeInput(input string) (string, string, error) {
-	return input, input, nil
-}
-
-func (m *mockTraceFingerprinter) Tokenize(input string) (string, string, error) {
-	return input, input, nil
-}
-
-func (m *mockTraceFingerprinter) Fingerprint(input string) (int64, string, error) {
-	return 555, "foo", nil
-}
-
-func (m *mockTraceFingerprinter) IsWord(token string) bool {
-	return true
-}
@@ -0,0 +1,316 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
+)
+
+// learnedPlaceholder replaces a path segment whose position had more
+// distinct values than the route learning threshold.
+const learnedPlaceholder = "<Var>"
+
+// maxLearnedSegments is the most path segments the route learner follows.
+const maxLearnedSegments = 32
+
+var (
+	segmentUUID   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	segmentNumber = regexp.MustCompile(`^[0-9]+$`)
+	segmentHex    = regexp.MustCompile(`^[0-9a-fA-F]{8,}$`)
+)
+
+// templateSegment replaces a path or destination segment that looks like
+// an ID with a placeholder.
+func templateSegment(segment string) string {
+	switch {
+	case segmentNumber.MatchString(segment):
+		return "<Number>"
+	case segmentUUID.MatchString(segment):
+		return "<UUID>"
+	case segmentHex.MatchString(segment) && strings.ContainsAny(segment, "0123456789"):
+		return "<Hex>"
+	case isHighEntropy(segment):
+		return "<ID>"
+	}
+	return segment
+}
+
+// isHighEntropy returns true for random-looking segments, such as
+// generated IDs and tokens.  These mix digits and letters, switching
+// between upper case, lower case and digits far more often than names
+// such as "oauth2callback" do.
+func isHighEntropy(segment string) bool {
+	if len(segment) < 8 {
+		return false
+	}
+	var hasLetter, hasDigit bool
+	switches := 0
+	prev := byte(0)
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+		var class byte
+		switch {
+		case c >= 'a' && c <= 'z':
+			hasLetter, class = true, 'a'
+		case c >= 'A' && c <= 'Z':
+			hasLetter, class = true, 'A'
+		case c >= '0' && c <= '9':
+			hasDigit, class = true, '0'
+		default:
+			// Punctuation such as the dot of a file name.
+			return false
+		}
+		if prev != 0 && class != prev {
+			switches++
+		}
+		prev = class
+	}
+	return hasLetter && hasDigit && switches*3 >= len(segment)
+}
+
+// templateURLPath replaces the IDs in a URL path, and the text matched by
+// the custom token patterns, with placeholders.  Before-scan patterns can
+// span segments, so they are matched against the whole path, while
+// after-scan patterns must match a whole segment.
+func templateURLPath(path string, patterns []fingerprinter.TokenPattern) []string {
+	for _, p := range patterns {
+		if p.Stage == fingerprinter.BeforeScan {
+			path = fingerprinter.ReplaceTokens(path, p)
+		}
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		for _, p := range patterns {
+			if p.Stage == fingerprinter.AfterScan {
+				segment = fingerprinter.ReplaceTokens(segment, p)
+			}
+		}
+		segments[i] = templateSegment(segment)
+	}
+	return segments
+}
+
+// urlTemplate is a user-provided route template, such as
+// "/users/{id}/orders".  A {name} segment matches any one segment, and a
+// final * matches all the rest.
+type urlTemplate struct {
+	template string
+	segments []string
+}
+
+func newURLTemplate(template string) (urlTemplate, error) {
+	if !strings.HasPrefix(template, "/") {
+		return urlTemplate{}, fmt.Errorf("url_templates %q must start with /", template)
+	}
+	segments := strings.Split(strings.TrimSuffix(template, "/"), "/")
+	for i, segment := range segments {
+		if segment == "*" && i != len(segments)-1 {
+			return urlTemplate{}, fmt.Errorf("url_templates %q may only have * as its last segment", template)
+		}
+	}
+	return urlTemplate{template: template, segments: segments}, nil
+}
+
+func (t urlTemplate) matches(path string) bool {
+	segments := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	for i, want := range t.segments {
+		if want == "*" {
+			return len(segments) > i
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if strings.HasPrefix(want, "{") && strings.HasSuffix(want, "}") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segments[i] != want {
+			return false
+		}
+	}
+	return len(segments) == len(t.segments)
+}
+
+// routeLearner learns the routes of each service from the URL paths seen.
+// Once the segments at one position in a route have had more distinct
+// values than the threshold, they are all replaced by <Var>.  Until then
+// each value is kept as it is, so the resource for a path can change from
+// its own value to <Var> once enough others have been seen.
+//
+// At most maxNodes segments are remembered across all services.  To make
+// room, the routes of the least recently seen services are forgotten.  If
+// one service alone fills the learner, segments it has not seen before
+// are replaced by <Var>.
+type routeLearner struct {
+	sync.Mutex
+	threshold int
+	maxNodes  int
+	nodes     int
+	services  map[string]*list.Element
+	// lru holds *serviceRoutes, most recently used first.
+	lru *list.List
+}
+
+type serviceRoutes struct {
+	service string
+	root    *routeNode
+	// nodes counts the nodes below root.
+	nodes int
+}
+
+type routeNode struct {
+	children map[string]*routeNode
+	// collapsed replaces children once there are too many of them.
+	collapsed *routeNode
+}
+
+func newRouteLearner(threshold int, maxNodes int) *routeLearner {
+	return &routeLearner{
+		threshold: threshold,
+		maxNodes:  maxNodes,
+		services:  map[string]*list.Element{},
+		lru:       list.New(),
+	}
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{children: map[string]*routeNode{}}
+}
+
+// learn adds the templated path segments to the service's routes, and
+// returns them with the positions that have too many values collapsed.
+func (l *routeLearner) learn(service string, segments []string) []string {
+	l.Lock()
+	defer l.Unlock()
+
+	routes := l.routesFor(service)
+	node := routes.root
+	learned := segments[:min(len(segments), maxLearnedSegments)]
+	for i, segment := range learned {
+		if node.collapsed == nil {
+			child, found := node.children[segment]
+			if !found && len(node.children) >= l.threshold {
+				children := make([]*routeNode, 0, len(node.children))
+				for _, child := range node.children {
+					children = append(children, child)
+				}
+				node.collapsed = l.merge(children)
+				node.children = nil
+				l.recount(routes)
+			} else {
+				if !found {
+					if !l.reserve(routes) {
+						for j := i; j < len(learned); j++ {
+							learned[j] = learnedPlaceholder
+						}
+						break
+					}
+					child = newRouteNode()
+					node.children[segment] = child
+				}
+				node = child
+				continue
+			}
+		}
+		segments[i] = learnedPlaceholder
+		node = node.collapsed
+	}
+	return segments
+}
+
+// routesFor returns the routes learned for service, marking it the most
+// recently used.
+func (l *routeLearner) routesFor(service string) *serviceRoutes {
+	if elem, found := l.services[service]; found {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*serviceRoutes)
+	}
+	routes := &serviceRoutes{service: service, root: newRouteNode()}
+	l.services[service] = l.lru.PushFront(routes)
+	return routes
+}
+
+// reserve makes room for one more node in routes, forgetting the least
+// recently used services if needed.  It returns false if routes alone
+// fill the learner.
+func (l *routeLearner) reserve(routes *serviceRoutes) bool {
+	for l.nodes >= l.maxNodes {
+		oldest := l.lru.Back()
+		if oldest.Value.(*serviceRoutes) == routes {
+			return false
+		}
+		l.lru.Remove(oldest)
+		evicted := oldest.Value.(*serviceRoutes)
+		delete(l.services, evicted.service)
+		l.nodes -= evicted.nodes
+	}
+	l.nodes++
+	routes.nodes++
+	return true
+}
+
+// recount updates the node counts after part of routes was merged.
+func (l *routeLearner) recount(routes *serviceRoutes) {
+	n := countRouteNodes(routes.root) - 1
+	l.nodes += n - routes.nodes
+	routes.nodes = n
+}
+
+func countRouteNodes(node *routeNode) int {
+	n := 1
+	for _, child := range node.children {
+		n += countRouteNodes(child)
+	}
+	if node.collapsed != nil {
+		n += countRouteNodes(node.collapsed)
+	}
+	return n
+}
+
+// merge combines the routes under nodes into one node.
+func (l *routeLearner) merge(nodes []*routeNode) *routeNode {
+	merged := newRouteNode()
+	var collapsed []*routeNode
+	grouped := map[string][]*routeNode{}
+	for _, n := range nodes {
+		if n.collapsed != nil {
+			collapsed = append(collapsed, n.collapsed)
+		}
+		for segment, child := range n.children {
+			grouped[segment] = append(grouped[segment], child)
+		}
+	}
+
+	if len(collapsed) == 0 && len(grouped) <= l.threshold {
+		for segment, children := range grouped {
+			merged.children[segment] = l.merge(children)
+		}
+		return merged
+	}
+	for _, children := range grouped {
+		collapsed = append(collapsed, children...)
+	}
+	merged.children = nil
+	merged.collapsed = l.merge(collapsed)
+	return merged
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fingerprintprocessor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/fingerprinter"
+)
+
+func TestTemplateSegment(t *testing.T) {
+	tests := []struct {
+		segment string
+		want    string
+	}{
+		{"users", "users"},
+		{"v1", "v1"},
+		{"oauth2callback", "oauth2callback"},
+		{"12345", "<Number>"},
+		{"3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b", "<UUID>"},
+		{"5f1d7a9c3b2e", "<Hex>"},
+		{"deadbeef", "deadbeef"},
+		{"aZ3kP9qL2xW7", "<ID>"},
+		{"report-2024.pdf", "report-2024.pdf"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.segment, func(t *testing.T) {
+			assert.Equal(t, tt.want, templateSegment(tt.segment))
+		})
+	}
+}
+
+func TestTemplateURLPathCustomTokens(t *testing.T) {
+	orderID, err := fingerprinter.NewTokenPattern("OrderID", `ORD-[0-9]+`, fingerprinter.BeforeScan)
+	require.NoError(t, err)
+	region, err := fingerprinter.NewTokenPattern("Region", `^[a-z]{2}-[a-z]+-[0-9]$`, fingerprinter.AfterScan)
+	require.NoError(t, err)
+
+	segments := templateURLPath("/orders/ORD-42/regions/us-east-1/items/7", []fingerprinter.TokenPattern{orderID, region})
+	assert.Equal(t, "/orders/<OrderID>/regions/<Region>/items/<Number>", strings.Join(segments, "/"))
+}
+
+func TestURLTemplateMatches(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		want     bool
+	}{
+		{"/users/{id}", "/users/alice", true},
+		{"/users/{id}", "/users/alice/", true},
+		{"/users/{id}", "/users/", false},
+		{"/users/{id}", "/users/alice/orders", false},
+		{"/users/{id}/orders", "/users/alice/orders", true},
+		{"/users/{id}/orders", "/users/alice/carts", false},
+		{"/static/*", "/static/css/site.css", true},
+		{"/static/*", "/static", false},
+		{"/", "/", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template+" "+tt.path, func(t *testing.T) {
+			ut, err := newURLTemplate(tt.template)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, ut.matches(tt.path))
+		})
+	}
+}
+
+func TestRouteLearner(t *testing.T) {
+	l := newRouteLearner(3, 100)
+	learn := func(service, path string) string {
+		return strings.Join(l.learn(service, strings.Split(path, "/")), "/")
+	}
+
+	// Up to the threshold, each user is kept in the route.
+	for _, user := range []string{"alice", "bob", "carol"} {
+		assert.Equal(t, "/users/"+user+"/orders", learn("shop", "/users/"+user+"/orders"))
+	}
+	// A fourth user is more than the threshold, so the position collapses,
+	// keeping the routes learned below it.
+	assert.Equal(t, "/users/<Var>/orders", learn("shop", "/users/dave/orders"))
+	assert.Equal(t, "/users/<Var>/orders", learn("shop", "/users/alice/orders"))
+	assert.Equal(t, "/users/<Var>/carts", learn("shop", "/users/erin/carts"))
+
+	// Other services learn their own routes.
+	assert.Equal(t, "/users/dave/orders", learn("billing", "/users/dave/orders"))
+
+	// The fixed routes beside the users stay apart.
+	assert.Equal(t, "/health", learn("shop", "/health"))
+}
+
+func TestRouteLearnerMergesCollapsedChildren(t *testing.T) {
+	l := newRouteLearner(2, 100)
+	learn := func(path string) string {
+		return strings.Join(l.learn("shop", strings.Split(path, "/")), "/")
+	}
+
+	learn("/a/x/1")
+	learn("/a/x/2")
+	assert.Equal(t, "/a/x/<Var>", learn("/a/x/3"))
+	learn("/b/y/1")
+	// Collapsing the first segment merges a/x, whose last segment has
+	// collapsed, with b/y.
+	assert.Equal(t, "/<Var>/x/<Var>", learn("/c/x/4"))
+	assert.Equal(t, "/<Var>/y/5", learn("/d/y/5"))
+	assert.Equal(t, "/<Var>/y/<Var>", learn("/e/y/6"))
+}
+
+func TestRouteLearnerForgetsLeastRecentServices(t *testing.T) {
+	// Each path below adds three nodes: "", users and the user.
+	l := newRouteLearner(3, 9)
+	learn := func(service, path string) string {
+		return strings.Join(l.learn(service, strings.Split(path, "/")), "/")
+	}
+
+	learn("shop", "/users/alice")
+	learn("billing", "/users/bob")
+	learn("search", "/users/carol")
+	assert.Equal(t, 9, l.nodes)
+
+	// shop is seen again, so billing is the least recently used.
+	learn("shop", "/users/alice")
+	learn("cart", "/users/dave")
+	assert.Equal(t, 9, l.nodes)
+	assert.Len(t, l.services, 3)
+	assert.NotContains(t, l.services, "billing")
+	assert.Contains(t, l.services, "shop")
+
+	// One service filling the learner replaces what it cannot learn.
+	l = newRouteLearner(3, 3)
+	assert.Equal(t, "/users/alice", learn("shop", "/users/alice"))
+	assert.Equal(t, "/users/<Var>/<Var>", learn("shop", "/users/bob/orders"))
+	assert.Equal(t, "/users/alice", learn("shop", "/users/alice"))
+	assert.Equal(t, 3, l.nodes)
+}
+
+func TestRouteLearnerCountsMergedNodes(t *testing.T) {
+	l := newRouteLearner(2, 100)
+	for _, path := range []string{"/a/x", "/b/x"} {
+		l.learn("shop", strings.Split(path, "/"))
+	}
+	assert.Equal(t, 5, l.nodes)
+	// Collapsing a and b merges their x children into one node.
+	l.learn("shop", strings.Split("/c/x", "/"))
+	assert.Equal(t, 3, l.nodes)
+	assert.Equal(t, countRouteNodes(l.services["shop"].Value.(*serviceRoutes).root)-1, l.nodes)
+}
+
+func TestTemplateURLPathAppliesTokensOnce(t *testing.T) {
+	// Unanchored, an after-scan pattern would match the whole path if it
+	// were applied to it, and not only the segment.
+	region, err := fingerprinter.NewTokenPattern("Region", `[a-z]{2}-[a-z]+-[0-9]`, fingerprinter.AfterScan)
+	require.NoError(t, err)
+	segments := templateURLPath("/regions/us-east-1/items", []fingerprinter.TokenPattern{region})
+	assert.Equal(t, "/regions/<Region>/items", strings.Join(segments, "/"))
+}
+
+func TestHttpResourceTemplates(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesConfig.URLTemplates = []string{"/users/{id}/orders"}
+	cfg.TracesConfig.RouteLearningThreshold = 5
+	p, err := newPitbull(cfg, "traces", processortest.NewNopSettings())
+	require.NoError(t, err)
+
+	resource := func(path string) string {
+		return p.templateURLPath(path, "shop")
+	}
+	assert.Equal(t, "/users/{id}/orders", resource("/users/alice/orders"))
+	assert.Equal(t, "/orders/<UUID>", resource("/orders/3f2b8c1e-9a4d-4e6f-8b7a-1c2d3e4f5a6b"))
+	for i := range 5 {
+		assert.Equal(t, fmt.Sprintf("/products/item%c", 'a'+i), resource(fmt.Sprintf("/products/item%c", 'a'+i)))
+	}
+	assert.Equal(t, "/products/<Var>", resource("/products/itemf"))
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/multierr"
+)
+
+type Config struct {
+	// DecisionWait is how long a trace's spans are held for its root
+	// span, after the trace's last span arrived.
+	DecisionWait time.Duration `mapstructure:"decision_wait"`
+	// MaxTraces is the most traces held at once, and the most whose
+	// decision is remembered for spans arriving after it was made.
+	MaxTraces int `mapstructure:"max_traces"`
+	// TracesPerShape is how many traces of each shape are kept in each
+	// window, however common the shape is.
+	TracesPerShape int `mapstructure:"traces_per_shape"`
+	// Window is how long a shape's count of kept traces lasts.
+	Window time.Duration `mapstructure:"window"`
+	// SamplingRate is the fraction, between 0 and 1, of the remaining
+	// traces of a shape that are kept.
+	SamplingRate float64 `mapstructure:"sampling_rate"`
+}
+
+func (c *Config) Validate() error {
+	var errs error
+	if c.DecisionWait == 0 {
+		c.DecisionWait = defaultDecisionWait
+	}
+	if c.MaxTraces == 0 {
+		c.MaxTraces = defaultMaxTraces
+	}
+	if c.TracesPerShape == 0 {
+		c.TracesPerShape = defaultTracesPerShape
+	}
+	if c.Window == 0 {
+		c.Window = defaultWindow
+	}
+
+	if c.DecisionWait < 0 {
+		errs = multierr.Append(errs, errors.New("decision_wait must be positive"))
+	}
+	if c.MaxTraces < 0 {
+		errs = multierr.Append(errs, errors.New("max_traces must be positive"))
+	}
+	if c.TracesPerShape < 0 {
+		errs = multierr.Append(errs, errors.New("traces_per_shape must be positive"))
+	}
+	if c.Window < 0 {
+		errs = multierr.Append(errs, errors.New("window must be positive"))
+	}
+	if c.SamplingRate < 0 || c.SamplingRate > 1 {
+		errs = multierr.Append(errs, errors.New("sampling_rate must be between 0 and 1"))
+	}
+
+	return errs
+}
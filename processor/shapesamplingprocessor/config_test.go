@@ -0,0 +1,75 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/otelcol/otelcoltest"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor/internal/metadata"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	factories, err := otelcoltest.NopFactories()
+	assert.NoError(t, err)
+
+	factory := NewFactory()
+	factories.Processors[metadata.Type] = factory
+
+	cfg, err := otelcoltest.LoadConfig(filepath.Join("testdata", "default.yaml"), factories)
+	require.NoError(t, err)
+	require.NotNil(t, cfg)
+
+	e := cfg.Processors[component.MustNewID("shapesampling")].(*Config)
+	assert.NoError(t, e.Validate())
+	assert.Equal(t, createDefaultConfig(), e)
+
+	cfg, err = otelcoltest.LoadConfig(filepath.Join("testdata", "config.yaml"), factories)
+	require.NoError(t, err)
+	e = cfg.Processors[component.MustNewID("shapesampling")].(*Config)
+	assert.Equal(t, &Config{
+		DecisionWait:   10 * time.Second,
+		MaxTraces:      1000,
+		TracesPerShape: 5,
+		Window:         30 * time.Second,
+		SamplingRate:   0.5,
+	}, e)
+}
+
+func TestConfigValidate(t *testing.T) {
+	c := &Config{}
+	require.NoError(t, c.Validate())
+	assert.Equal(t, defaultDecisionWait, c.DecisionWait)
+	assert.Equal(t, defaultMaxTraces, c.MaxTraces)
+	assert.Equal(t, defaultTracesPerShape, c.TracesPerShape)
+	assert.Equal(t, defaultWindow, c.Window)
+	// A sampling rate of 0 keeps only the traces of each shape's quota.
+	assert.Equal(t, 0.0, c.SamplingRate)
+
+	assert.Error(t, (&Config{DecisionWait: -time.Second}).Validate())
+	assert.Error(t, (&Config{MaxTraces: -1}).Validate())
+	assert.Error(t, (&Config{TracesPerShape: -1}).Validate())
+	assert.Error(t, (&Config{Window: -time.Second}).Validate())
+	assert.Error(t, (&Config{SamplingRate: 1.5}).Validate())
+	assert.Error(t, (&Config{SamplingRate: -0.5}).Validate())
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor/internal/metadata"
+)
+
+const (
+	defaultDecisionWait   = 30 * time.Second
+	defaultMaxTraces      = 50_000
+	defaultTracesPerShape = 10
+	defaultWindow         = time.Minute
+	defaultSamplingRate   = 0.01
+)
+
+func NewFactory() processor.Factory {
+	return processor.NewFactory(
+		metadata.Type,
+		createDefaultConfig,
+		processor.WithTraces(createTracesProcessor, metadata.TracesStability),
+	)
+}
+
+func createDefaultConfig() component.Config {
+	return &Config{
+		DecisionWait:   defaultDecisionWait,
+		MaxTraces:      defaultMaxTraces,
+		TracesPerShape: defaultTracesPerShape,
+		Window:         defaultWindow,
+		SamplingRate:   defaultSamplingRate,
+	}
+}
+
+func createTracesProcessor(ctx context.Context, set processor.Settings, cfg component.Config, nextConsumer consumer.Traces) (processor.Traces, error) {
+	e, err := newShapeSampler(cfg.(*Config), set, nextConsumer)
+	if err != nil {
+		return nil, err
+	}
+	return processorhelper.NewTraces(
+		ctx, set, cfg, nextConsumer,
+		e.ConsumeTraces,
+		processorhelper.WithCapabilities(e.Capabilities()),
+		processorhelper.WithStart(e.Start),
+		processorhelper.WithShutdown(e.Shutdown))
+}
@@ -0,0 +1,136 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/processor"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.uber.org/zap"
+
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/spantagger"
+	"github.com/cardinalhq/cardinalhq-otel-collector/processor/shapesamplingprocessor/internal/metadata"
+)
+
+type shapeSampler struct {
+	config     *Config
+	logger     *zap.Logger
+	id         component.ID
+	nextTraces consumer.Traces
+
+	// buffer holds spans until their trace is complete, and remembers the
+	// reason each released trace was kept, or "" if it was dropped.
+	buffer *spantagger.TraceBuffer[string]
+
+	shapesMu sync.Mutex
+	// shapes counts the traces kept of each shape in its current window.
+	shapes map[uint64]*shapeWindow
+
+	stopFlush chan struct{}
+	flushDone chan struct{}
+
+	decisions *telemetry.DeferrableInt64Counter
+}
+
+func newShapeSampler(config *Config, set processor.Settings, nextConsumer consumer.Traces) (*shapeSampler, error) {
+	s := &shapeSampler{
+		config:     config,
+		logger:     set.Logger,
+		id:         set.ID,
+		nextTraces: nextConsumer,
+		buffer:     spantagger.NewTraceBuffer[string](config.MaxTraces, config.DecisionWait),
+		shapes:     map[uint64]*shapeWindow{},
+	}
+
+	attrset := attribute.NewSet(
+		attribute.String("processor", set.ID.String()),
+	)
+	if err := s.setupTelemetry(set, attrset); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *shapeSampler) setupTelemetry(set processor.Settings, attrset attribute.Set) error {
+	decisions, err := telemetry.NewDeferrableInt64Counter(metadata.Meter(set.TelemetrySettings),
+		"shapesampling_traces",
+		[]metric.Int64CounterOption{
+			metric.WithDescription("The number of traces sampled, by the reason they were kept, or dropped"),
+			metric.WithUnit("1"),
+		},
+		[]metric.AddOption{
+			metric.WithAttributeSet(attrset),
+		},
+	)
+	if err != nil {
+		return err
+	}
+	s.decisions = decisions
+
+	_, err = metadata.Meter(set.TelemetrySettings).Int64ObservableGauge(
+		"shapesampling_traces_buffered",
+		metric.WithDescription("The number of traces whose spans are held waiting for the root span"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(int64(s.buffer.Len()), metric.WithAttributeSet(attrset))
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = metadata.Meter(set.TelemetrySettings).Int64ObservableGauge(
+		"shapesampling_shapes",
+		metric.WithDescription("The number of trace shapes seen in the current window"),
+		metric.WithUnit("1"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			s.shapesMu.Lock()
+			defer s.shapesMu.Unlock()
+			o.Observe(int64(len(s.shapes)), metric.WithAttributeSet(attrset))
+			return nil
+		}),
+	)
+	return err
+}
+
+func (s *shapeSampler) Start(_ context.Context, _ component.Host) error {
+	s.stopFlush = make(chan struct{})
+	s.flushDone = make(chan struct{})
+	go s.flushTask()
+	return nil
+}
+
+// Shutdown decides on the traces still held, and sends on those kept.
+func (s *shapeSampler) Shutdown(ctx context.Context) error {
+	if s.stopFlush != nil {
+		close(s.stopFlush)
+		<-s.flushDone
+		s.stopFlush = nil
+	}
+	return s.sendKept(ctx, s.decide(s.buffer.Flush(), time.Now()))
+}
+
+func (s *shapeSampler) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: true}
+}
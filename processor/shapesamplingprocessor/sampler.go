@@ -0,0 +1,153 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"cmp"
+	"math"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/cardinalhq/oteltools/pkg/telemetry"
+	"github.com/cardinalhq/oteltools/pkg/translate"
+
+	"github.com/cardinalhq/cardinalhq-otel-collector/internal/spantagger"
+)
+
+// The reasons a trace is kept.
+const (
+	keptForError   = "error"
+	keptForSlow    = "slow"
+	keptForShape   = "shape"
+	keptForSampled = "sampled"
+)
+
+// cardinalFieldSamplingReason is set on the spans of kept traces to the
+// reason they were kept.
+var cardinalFieldSamplingReason = translate.CardinalFieldPrefixDot + "sampling_reason"
+
+// shapeWindow counts the traces kept of one shape since start.
+type shapeWindow struct {
+	start time.Time
+	kept  int
+}
+
+// decide chooses whether to keep each released trace, records the
+// decision for spans arriving later, and returns the spans of the traces
+// kept.
+func (s *shapeSampler) decide(released []*spantagger.BufferedTrace, now time.Time) ptrace.Traces {
+	out := ptrace.NewTraces()
+	for _, bt := range released {
+		reason := s.keepReason(bt, now)
+		s.buffer.Decide(bt.TraceID, reason)
+		telemetry.CounterAdd(s.decisions, 1, metric.WithAttributes(
+			attribute.String("decision", cmp.Or(reason, "dropped"))))
+		if reason == "" {
+			continue
+		}
+		stampDecision(bt.Traces, reason)
+		bt.Traces.ResourceSpans().MoveAndAppendTo(out.ResourceSpans())
+	}
+	return out
+}
+
+// keepReason returns why a trace is kept, or "" if it is dropped.  Traces
+// with an error or slow span are always kept, as are the first traces of
+// each shape in a window.  Others, including traces whose shape cannot be
+// fingerprinted, are sampled at the sampling rate.
+func (s *shapeSampler) keepReason(bt *spantagger.BufferedTrace, now time.Time) string {
+	fingerprint, hasError, err := spantagger.Fingerprint(bt.Traces)
+	switch {
+	case hasError:
+		return keptForError
+	case hasSlowSpan(bt.Traces):
+		return keptForSlow
+	case err == nil && s.keepShape(fingerprint, now):
+		return keptForShape
+	case sampled(bt.TraceID, s.config.SamplingRate):
+		return keptForSampled
+	}
+	return ""
+}
+
+// keepShape returns true if fewer than TracesPerShape traces of the shape
+// have been kept in its window, counting this one if so.
+func (s *shapeSampler) keepShape(fingerprint uint64, now time.Time) bool {
+	s.shapesMu.Lock()
+	defer s.shapesMu.Unlock()
+
+	w, found := s.shapes[fingerprint]
+	if !found || now.Sub(w.start) >= s.config.Window {
+		w = &shapeWindow{start: now}
+		s.shapes[fingerprint] = w
+	}
+	if w.kept >= s.config.TracesPerShape {
+		return false
+	}
+	w.kept++
+	return true
+}
+
+// expireShapes forgets the shapes whose window is over.
+func (s *shapeSampler) expireShapes(now time.Time) {
+	s.shapesMu.Lock()
+	defer s.shapesMu.Unlock()
+	for fingerprint, w := range s.shapes {
+		if now.Sub(w.start) >= s.config.Window {
+			delete(s.shapes, fingerprint)
+		}
+	}
+}
+
+// sampled returns true for the fraction rate of trace IDs.  The choice
+// depends only on the trace ID, so every collector makes the same one.
+func sampled(traceID pcommon.TraceID, rate float64) bool {
+	if rate >= 1 {
+		return true
+	}
+	return xxhash.Sum64(traceID[:]) < uint64(rate*math.MaxUint64)
+}
+
+func hasSlowSpan(td ptrace.Traces) bool {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			spans := rs.ScopeSpans().At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				if slow, found := spans.At(k).Attributes().Get(translate.CardinalFieldSpanIsSlow); found && slow.Bool() {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func stampDecision(td ptrace.Traces, reason string) {
+	for i := 0; i < td.ResourceSpans().Len(); i++ {
+		rs := td.ResourceSpans().At(i)
+		for j := 0; j < rs.ScopeSpans().Len(); j++ {
+			spans := rs.ScopeSpans().At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				spans.At(k).Attributes().PutStr(cardinalFieldSamplingReason, reason)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/component/componenttest"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processortest"
+
+	"github.com/cardinalhq/oteltools/pkg/translate"
+)
+
+// testSpan is a span of trace, with parent 0 for the root.
+type testSpan struct {
+	trace  uint64
+	span   byte
+	parent byte
+	name   string
+	error  bool
+	slow   bool
+}
+
+func makeTraces(spans ...testSpan) ptrace.Traces {
+	td := ptrace.NewTraces()
+	for _, s := range spans {
+		rs := td.ResourceSpans().AppendEmpty()
+		rs.Resource().Attributes().PutStr("service.name", "shop")
+		span := rs.ScopeSpans().AppendEmpty().Spans().AppendEmpty()
+		var traceID [16]byte
+		binary.BigEndian.PutUint64(traceID[8:], s.trace)
+		span.SetTraceID(traceID)
+		span.SetSpanID(pcommon.SpanID([8]byte{s.span}))
+		if s.parent != 0 {
+			span.SetParentSpanID(pcommon.SpanID([8]byte{s.parent}))
+		}
+		span.SetName(s.name)
+		span.Status().SetCode(ptrace.StatusCodeOk)
+		if s.error {
+			span.Status().SetCode(ptrace.StatusCodeError)
+		}
+		if s.slow {
+			span.Attributes().PutBool(translate.CardinalFieldSpanIsSlow, true)
+		}
+	}
+	return td
+}
+
+func newTestSampler(t *testing.T, cfg *Config, sink *consumertest.TracesSink) *shapeSampler {
+	require.NoError(t, cfg.Validate())
+	s, err := newShapeSampler(cfg, processortest.NewNopSettings(), sink)
+	require.NoError(t, err)
+	return s
+}
+
+// reasons returns the sampling reason of each trace sent to sink.
+func reasons(sink *consumertest.TracesSink) map[uint64]string {
+	ret := map[uint64]string{}
+	for _, td := range sink.AllTraces() {
+		for i := 0; i < td.ResourceSpans().Len(); i++ {
+			spans := td.ResourceSpans().At(i).ScopeSpans().At(0).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				traceID := spans.At(k).TraceID()
+				reason, _ := spans.At(k).Attributes().Get(cardinalFieldSamplingReason)
+				ret[binary.BigEndian.Uint64(traceID[8:])] = reason.Str()
+			}
+		}
+	}
+	return ret
+}
+
+func TestKeepReason(t *testing.T) {
+	s := newTestSampler(t, &Config{TracesPerShape: 2}, new(consumertest.TracesSink))
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		spans []testSpan
+		want  string
+	}{
+		{"first of shape", []testSpan{{trace: 1, span: 1, name: "checkout"}}, keptForShape},
+		{"second of shape", []testSpan{{trace: 2, span: 1, name: "checkout"}}, keptForShape},
+		{"common shape", []testSpan{{trace: 3, span: 1, name: "checkout"}}, ""},
+		{"other shape", []testSpan{{trace: 4, span: 1, name: "checkout"}, {trace: 4, span: 2, parent: 1, name: "pay"}}, keptForShape},
+		{"error", []testSpan{{trace: 5, span: 1, name: "checkout"}, {trace: 5, span: 2, parent: 1, name: "pay", error: true}}, keptForError},
+		{"slow", []testSpan{{trace: 6, span: 1, name: "checkout", slow: true}}, keptForSlow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			released, _ := s.buffer.Add(makeTraces(tt.spans...), now)
+			require.Len(t, released, 1)
+			assert.Equal(t, tt.want, s.keepReason(released[0], now))
+		})
+	}
+}
+
+func TestKeepShapeWindow(t *testing.T) {
+	s := newTestSampler(t, &Config{TracesPerShape: 1, Window: time.Minute}, new(consumertest.TracesSink))
+	now := time.Now()
+
+	assert.True(t, s.keepShape(1, now))
+	assert.False(t, s.keepShape(1, now.Add(30*time.Second)))
+	assert.True(t, s.keepShape(2, now.Add(30*time.Second)))
+	assert.True(t, s.keepShape(1, now.Add(time.Minute)))
+
+	s.expireShapes(now.Add(time.Minute))
+	assert.Len(t, s.shapes, 2)
+	s.expireShapes(now.Add(90 * time.Second))
+	assert.Len(t, s.shapes, 1)
+	s.expireShapes(now.Add(2 * time.Minute))
+	assert.Empty(t, s.shapes)
+}
+
+func TestSampled(t *testing.T) {
+	kept := 0
+	for i := uint64(0); i < 10_000; i++ {
+		var traceID [16]byte
+		binary.BigEndian.PutUint64(traceID[8:], i)
+		if sampled(traceID, 0.25) {
+			kept++
+		}
+		assert.True(t, sampled(traceID, 1))
+		assert.False(t, sampled(traceID, 0))
+	}
+	assert.InDelta(t, 2500, kept, 250)
+}
+
+func TestShapeSamplingProcessor(t *testing.T) {
+	cfg := createDefaultConfig().(*Config)
+	cfg.TracesPerShape = 1
+	cfg.SamplingRate = 0
+	cfg.DecisionWait = time.Hour
+	require.NoError(t, cfg.Validate())
+	sink := new(consumertest.TracesSink)
+	p, err := NewFactory().CreateTraces(context.Background(), processortest.NewNopSettings(), cfg, sink)
+	require.NoError(t, err)
+	require.NoError(t, p.Start(context.Background(), componenttest.NewNopHost()))
+
+	// Children arrive before their root, which completes the trace.
+	require.NoError(t, p.ConsumeTraces(context.Background(), makeTraces(
+		testSpan{trace: 1, span: 2, parent: 1, name: "pay"},
+		testSpan{trace: 2, span: 2, parent: 1, name: "pay"},
+	)))
+	assert.Equal(t, 0, sink.SpanCount())
+	require.NoError(t, p.ConsumeTraces(context.Background(), makeTraces(
+		testSpan{trace: 1, span: 1, name: "checkout"},
+		testSpan{trace: 2, span: 1, name: "checkout"},
+	)))
+	assert.Equal(t, map[uint64]string{1: keptForShape}, reasons(sink))
+	assert.Equal(t, 2, sink.SpanCount())
+
+	// Late spans follow the decision made for their trace.
+	require.NoError(t, p.ConsumeTraces(context.Background(), makeTraces(
+		testSpan{trace: 1, span: 3, parent: 2, name: "charge"},
+		testSpan{trace: 2, span: 3, parent: 2, name: "charge"},
+	)))
+	assert.Equal(t, 3, sink.SpanCount())
+
+	// A trace still waiting for its root is decided on shutdown.
+	require.NoError(t, p.ConsumeTraces(context.Background(), makeTraces(
+		testSpan{trace: 3, span: 2, parent: 1, name: "pay", error: true},
+	)))
+	require.NoError(t, p.Shutdown(context.Background()))
+	assert.Equal(t, map[uint64]string{1: keptForShape, 3: keptForError}, reasons(sink))
+}
+
+func TestShapeSamplingDecisionWait(t *testing.T) {
+	sink := new(consumertest.TracesSink)
+	s := newTestSampler(t, &Config{DecisionWait: 50 * time.Millisecond, SamplingRate: 1}, sink)
+	require.NoError(t, s.Start(context.Background(), componenttest.NewNopHost()))
+
+	_, err := s.ConsumeTraces(context.Background(), makeTraces(testSpan{trace: 1, span: 2, parent: 1, name: "pay"}))
+	require.Error(t, err)
+	require.Eventually(t, func() bool { return sink.SpanCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Shutdown(context.Background()))
+
+	// The trace has no root, so its shape is unknown and it is sampled.
+	assert.Equal(t, map[uint64]string{1: keptForSampled}, reasons(sink))
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 CardinalHQ, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shapesamplingprocessor
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/processor/processorhelper"
+	"go.uber.org/zap"
+)
+
+func (s *shapeSampler) ConsumeTraces(_ context.Context, td ptrace.Traces) (ptrace.Traces, error) {
+	now := time.Now()
+	released, late := s.buffer.Add(td, now)
+	out := s.decide(released, now)
+	for _, ls := range late {
+		if ls.Decision == "" {
+			continue
+		}
+		stampDecision(ls.Traces, ls.Decision)
+		ls.Traces.ResourceSpans().MoveAndAppendTo(out.ResourceSpans())
+	}
+	if out.SpanCount() == 0 {
+		return out, processorhelper.ErrSkipProcessingData
+	}
+	return out, nil
+}
+
+// flushTask decides on the traces whose root span has not arrived in the
+// decision wait, and forgets the counts of shapes whose window is over.
+func (s *shapeSampler) flushTask() {
+	defer close(s.flushDone)
+	ticker := time.NewTicker(max(s.config.DecisionWait/4, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopFlush:
+			return
+		case now := <-ticker.C:
+			if err := s.sendKept(context.Background(), s.decide(s.buffer.Expired(now), now)); err != nil {
+				s.logger.Warn("Failed to send sampled traces", zap.Error(err))
+			}
+			s.expireShapes(now)
+		}
+	}
+}
+
+// sendKept passes traces decided outside of ConsumeTraces to the next
+// consumer.
+func (s *shapeSampler) sendKept(ctx context.Context, td ptrace.Traces) error {
+	if td.SpanCount() == 0 {
+		return nil
+	}
+	return s.nextTraces.ConsumeTraces(ctx, td)
+}